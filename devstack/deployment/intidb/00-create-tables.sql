@@ -1,10 +1,13 @@
 CREATE TABLE IF NOT EXISTS `users`
 (
-    `email`      varchar(255) NOT NULL,
-    `password`   varchar(255) NOT NULL,
-    `first_name` varchar(255) NOT NULL,
-    `last_name`  varchar(255) NOT NULL,
-    PRIMARY KEY (`email`)
+    `email`          varchar(255) NOT NULL,
+    `password`       varchar(255) NOT NULL,
+    `first_name`     varchar(255) NOT NULL,
+    `last_name`      varchar(255) NOT NULL,
+    `deactivated_at` datetime(6)  NULL,
+    `delete_after`   datetime(6)  NULL,
+    PRIMARY KEY (`email`),
+    INDEX (`delete_after`)
 ) ENGINE = InnoDB
   DEFAULT CHARSET = utf8;
 
@@ -19,11 +22,13 @@ CREATE TABLE IF NOT EXISTS `admin_users`
 
 CREATE TABLE IF NOT EXISTS `regular_users`
 (
-    `email`        varchar(255) NOT NULL,
-    `birthdate`    date         NULL,
-    `sex`          char(1)      NULL,
-    `current_city` varchar(50)  NULL,
-    `hometown`     varchar(50)  NULL,
+    `email`             varchar(255) NOT NULL,
+    `birthdate`         date         NULL,
+    `sex`               char(1)      NULL,
+    `current_city`      varchar(50)  NULL,
+    `hometown`          varchar(50)  NULL,
+    `share_birthday`    boolean      NOT NULL DEFAULT TRUE,
+    `list_in_directory` boolean      NOT NULL DEFAULT FALSE,
     PRIMARY KEY (`email`),
     FOREIGN KEY (email) REFERENCES users (email) ON DELETE CASCADE
 ) ENGINE = InnoDB
@@ -34,10 +39,30 @@ CREATE TABLE IF NOT EXISTS `interests`
     `email`    varchar(255) NOT NULL,
     `interest` varchar(50)  NOT NULL,
     PRIMARY KEY (`email`, `interest`),
+    INDEX (`interest`),
     FOREIGN KEY (email) REFERENCES regular_users (email) ON DELETE CASCADE
 ) ENGINE = InnoDB
   DEFAULT CHARSET = utf8;
 
+CREATE TABLE IF NOT EXISTS `interest_tags`
+(
+    `slug`       varchar(50) NOT NULL,
+    `name`       varchar(50) NOT NULL,
+    `created_at` datetime(6) NOT NULL,
+    PRIMARY KEY (`slug`),
+    UNIQUE (`name`)
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8;
+
+CREATE TABLE IF NOT EXISTS `interest_tag_aliases`
+(
+    `alias` varchar(50) NOT NULL,
+    `slug`  varchar(50) NOT NULL,
+    PRIMARY KEY (`alias`),
+    FOREIGN KEY (slug) REFERENCES interest_tags (slug) ON DELETE CASCADE
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8;
+
 CREATE TABLE IF NOT EXISTS `school_types`
 (
     `type_name` varchar(50) NOT NULL,
@@ -59,7 +84,13 @@ CREATE TABLE IF NOT EXISTS `attends`
     `email`          varchar(255) NOT NULL,
     `school_name`    varchar(50)  NOT NULL,
     `year_graduated` int          NULL,
-    UNIQUE (`email`, `school_name`, `year_graduated`),
+    `degree`         varchar(50)  NULL,
+    `major`          varchar(50)  NULL,
+    `start_date`     date         NULL,
+    `end_date`       date         NULL,
+    `is_current`     boolean      NOT NULL DEFAULT FALSE,
+    -- NULL never collides in a unique key, the optional columns are compared as 0 and ''
+    UNIQUE (`email`, `school_name`, (COALESCE(`year_graduated`, 0)), (COALESCE(`degree`, ''))),
     FOREIGN KEY (email) REFERENCES regular_users (email) ON DELETE CASCADE,
     FOREIGN KEY (school_name) REFERENCES schools (school_name)
 ) ENGINE = InnoDB
@@ -77,20 +108,378 @@ CREATE TABLE IF NOT EXISTS `employments`
     `email`         varchar(255) NOT NULL,
     `employer_name` varchar(50)  NOT NULL,
     `job_title`     varchar(50)  NOT NULL,
-    UNIQUE (`email`, `employer_name`, `job_title`),
+    `start_date`    date         NULL,
+    `end_date`      date         NULL,
+    `is_current`    boolean      NOT NULL DEFAULT FALSE,
+    -- NULL never collides in a unique key, a missing start_date is compared as the minimum date
+    UNIQUE (`email`, `employer_name`, `job_title`, (COALESCE(`start_date`, DATE '1000-01-01'))),
     FOREIGN KEY (email) REFERENCES regular_users (email) ON DELETE CASCADE,
     FOREIGN KEY (employer_name) REFERENCES employers (employer_name)
 ) ENGINE = InnoDB
   DEFAULT CHARSET = utf8;
 
+CREATE TABLE IF NOT EXISTS `profile_versions`
+(
+    `email`         varchar(255) NOT NULL,
+    `version`       bigint       NOT NULL,
+    `actor_email`   varchar(255) NOT NULL,
+    `restored_from` bigint       NULL,
+    `profile`       json         NOT NULL,
+    `created_at`    datetime(6)  NOT NULL,
+    PRIMARY KEY (`email`, `version`),
+    FOREIGN KEY (email) REFERENCES regular_users (email) ON DELETE CASCADE
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8;
+
 CREATE TABLE IF NOT EXISTS `friendships`
 (
-    `email`          varchar(255) NOT NULL,
-    `friend_email`   varchar(255) NOT NULL,
-    `relationship`   varchar(50)  NULL,
-    `date_connected` datetime     NULL,
+    `email`               varchar(255) NOT NULL,
+    `friend_email`        varchar(255) NOT NULL,
+    `relationship`        varchar(50)  NULL,
+    `friend_relationship` varchar(50)  NULL,
+    `date_connected`      datetime     NULL,
+    `requested_at`        datetime     NOT NULL DEFAULT CURRENT_TIMESTAMP,
     PRIMARY KEY (`email`, `friend_email`),
+    INDEX (`friend_email`, `email`),
+    INDEX (`date_connected`, `requested_at`),
     FOREIGN KEY (email) REFERENCES regular_users (email) ON DELETE CASCADE,
     FOREIGN KEY (friend_email) REFERENCES regular_users (email) ON DELETE CASCADE
 ) ENGINE = InnoDB
   DEFAULT CHARSET = utf8;
+
+CREATE TABLE IF NOT EXISTS `relationship_types`
+(
+    `name` varchar(50) NOT NULL,
+    PRIMARY KEY (`name`)
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8;
+
+CREATE TABLE IF NOT EXISTS `friend_lists`
+(
+    `id`          bigint       NOT NULL AUTO_INCREMENT,
+    `owner_email` varchar(255) NOT NULL,
+    `name`        varchar(50)  NOT NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE (`owner_email`, `name`),
+    FOREIGN KEY (owner_email) REFERENCES regular_users (email) ON DELETE CASCADE
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8;
+
+CREATE TABLE IF NOT EXISTS `friend_list_members`
+(
+    `list_id`      bigint       NOT NULL,
+    `member_email` varchar(255) NOT NULL,
+    PRIMARY KEY (`list_id`, `member_email`),
+    FOREIGN KEY (list_id) REFERENCES friend_lists (id) ON DELETE CASCADE,
+    FOREIGN KEY (member_email) REFERENCES regular_users (email) ON DELETE CASCADE
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8;
+
+CREATE TABLE IF NOT EXISTS `notifications`
+(
+    `id`          bigint       NOT NULL AUTO_INCREMENT,
+    `email`       varchar(255) NOT NULL,
+    `type`        varchar(50)  NOT NULL,
+    `actor_email` varchar(255) NOT NULL,
+    `created_at`  datetime     NOT NULL,
+    `read_at`     datetime     NULL,
+    PRIMARY KEY (`id`),
+    INDEX (`email`, `id`),
+    INDEX (`email`, `read_at`),
+    FOREIGN KEY (email) REFERENCES users (email) ON DELETE CASCADE
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8;
+
+CREATE TABLE IF NOT EXISTS `notification_preferences`
+(
+    `email`               varchar(255) NOT NULL,
+    `events`              text         NOT NULL,
+    `quiet_hours_enabled` boolean      NOT NULL DEFAULT FALSE,
+    `quiet_hours_start`   char(5)      NOT NULL,
+    `quiet_hours_end`     char(5)      NOT NULL,
+    `time_zone`           varchar(64)  NOT NULL,
+    PRIMARY KEY (`email`),
+    FOREIGN KEY (email) REFERENCES users (email) ON DELETE CASCADE
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8;
+
+CREATE TABLE IF NOT EXISTS `conversations`
+(
+    `id`         bigint       NOT NULL AUTO_INCREMENT,
+    `direct_key` varchar(511) NULL,
+    `name`       varchar(100) NULL,
+    `created_at` datetime     NOT NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE (`direct_key`)
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8;
+
+CREATE TABLE IF NOT EXISTS `conversation_participants`
+(
+    `conversation_id`      bigint       NOT NULL,
+    `email`                varchar(255) NOT NULL,
+    `role`                 varchar(10)  NULL,
+    `joined_at`            datetime     NOT NULL,
+    `last_read_message_id` bigint       NOT NULL DEFAULT 0,
+    `cleared_message_id`   bigint       NOT NULL DEFAULT 0,
+    PRIMARY KEY (`conversation_id`, `email`),
+    INDEX (`email`),
+    FOREIGN KEY (conversation_id) REFERENCES conversations (id) ON DELETE CASCADE,
+    FOREIGN KEY (email) REFERENCES users (email) ON DELETE CASCADE
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8;
+
+CREATE TABLE IF NOT EXISTS `messages`
+(
+    `id`              bigint        NOT NULL AUTO_INCREMENT,
+    `conversation_id` bigint        NOT NULL,
+    `type`            varchar(20)   NOT NULL DEFAULT 'text',
+    `sender_email`    varchar(255)  NOT NULL,
+    `body`            varchar(2000) NOT NULL,
+    `created_at`      datetime(6)   NOT NULL,
+    PRIMARY KEY (`id`),
+    INDEX (`conversation_id`, `created_at`, `id`),
+    FOREIGN KEY (conversation_id) REFERENCES conversations (id) ON DELETE CASCADE,
+    FOREIGN KEY (sender_email) REFERENCES users (email) ON DELETE CASCADE
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8;
+
+CREATE TABLE IF NOT EXISTS `hidden_messages`
+(
+    `message_id` bigint       NOT NULL,
+    `email`      varchar(255) NOT NULL,
+    PRIMARY KEY (`message_id`, `email`),
+    FOREIGN KEY (message_id) REFERENCES messages (id) ON DELETE CASCADE,
+    FOREIGN KEY (email) REFERENCES users (email) ON DELETE CASCADE
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8;
+
+CREATE TABLE IF NOT EXISTS `webhooks`
+(
+    `id`         bigint        NOT NULL AUTO_INCREMENT,
+    `url`        varchar(2000) NOT NULL,
+    `secret`     varchar(64)   NOT NULL,
+    `events`     json          NOT NULL,
+    `active`     boolean       NOT NULL DEFAULT TRUE,
+    `created_at` datetime      NOT NULL,
+    PRIMARY KEY (`id`)
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8;
+
+CREATE TABLE IF NOT EXISTS `webhook_deliveries`
+(
+    `id`              bigint        NOT NULL AUTO_INCREMENT,
+    `webhook_id`      bigint        NOT NULL,
+    `event_id`        char(32)      NOT NULL,
+    `event`           varchar(100)  NOT NULL,
+    `payload`         mediumtext    NOT NULL,
+    `status`          varchar(10)   NOT NULL,
+    `attempts`        int           NOT NULL DEFAULT 0,
+    `next_attempt_at` datetime(6)   NOT NULL,
+    `response_status` int           NOT NULL DEFAULT 0,
+    `last_error`      varchar(1000) NOT NULL DEFAULT '',
+    `created_at`      datetime      NOT NULL,
+    `delivered_at`    datetime      NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE (`webhook_id`, `event_id`),
+    INDEX (`status`, `next_attempt_at`),
+    INDEX (`webhook_id`, `id`),
+    FOREIGN KEY (webhook_id) REFERENCES webhooks (id) ON DELETE CASCADE
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8;
+
+CREATE TABLE IF NOT EXISTS `webhook_dead_letters`
+(
+    `id`          bigint        NOT NULL AUTO_INCREMENT,
+    `delivery_id` bigint        NOT NULL,
+    `webhook_id`  bigint        NOT NULL,
+    `event`       varchar(100)  NOT NULL,
+    `payload`     mediumtext    NOT NULL,
+    `attempts`    int           NOT NULL,
+    `last_error`  varchar(1000) NOT NULL,
+    `created_at`  datetime      NOT NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE (`delivery_id`),
+    FOREIGN KEY (delivery_id) REFERENCES webhook_deliveries (id) ON DELETE CASCADE
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8;
+
+CREATE TABLE IF NOT EXISTS `outbox`
+(
+    `id`              bigint        NOT NULL AUTO_INCREMENT,
+    `name`            varchar(100)  NOT NULL,
+    `payload`         mediumtext    NOT NULL,
+    `attempts`        int           NOT NULL DEFAULT 0,
+    `next_attempt_at` datetime(6)   NOT NULL,
+    `last_error`      varchar(1000) NOT NULL DEFAULT '',
+    `created_at`      datetime(6)   NOT NULL,
+    `dispatched_at`   datetime(6)   NULL,
+    PRIMARY KEY (`id`),
+    INDEX (`dispatched_at`, `next_attempt_at`)
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8;
+
+CREATE TABLE IF NOT EXISTS `outbox_handlers`
+(
+    `event_id`   bigint       NOT NULL,
+    `subscriber` varchar(100) NOT NULL,
+    `handled_at` datetime     NOT NULL,
+    PRIMARY KEY (`event_id`, `subscriber`),
+    FOREIGN KEY (event_id) REFERENCES outbox (id) ON DELETE CASCADE
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8;
+
+CREATE TABLE IF NOT EXISTS `jobs`
+(
+    `id`           bigint        NOT NULL AUTO_INCREMENT,
+    `type`         varchar(100)  NOT NULL,
+    `payload`      mediumtext    NOT NULL,
+    `unique_key`   varchar(255)  NULL,
+    `status`       varchar(10)   NOT NULL,
+    `attempts`     int           NOT NULL DEFAULT 0,
+    `max_attempts` int           NOT NULL,
+    `run_at`       datetime(6)   NOT NULL,
+    `last_error`   varchar(1000) NOT NULL DEFAULT '',
+    `created_at`   datetime(6)   NOT NULL,
+    `finished_at`  datetime(6)   NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE (`unique_key`),
+    INDEX (`status`, `type`, `run_at`),
+    INDEX (`finished_at`)
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8;
+
+CREATE TABLE IF NOT EXISTS `exports`
+(
+    `id`         bigint       NOT NULL AUTO_INCREMENT,
+    `email`      varchar(255) NOT NULL,
+    `status`     varchar(10)  NOT NULL,
+    `created_at` datetime(6)  NOT NULL,
+    `expires_at` datetime(6)  NULL,
+    PRIMARY KEY (`id`),
+    INDEX (`email`, `created_at`),
+    INDEX (`status`, `expires_at`),
+    FOREIGN KEY (email) REFERENCES users (email) ON DELETE CASCADE
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8;
+
+CREATE TABLE IF NOT EXISTS `digest_subscriptions`
+(
+    `email`        varchar(255) NOT NULL,
+    `frequency`    varchar(10)  NOT NULL,
+    `last_sent_at` datetime(6)  NULL,
+    PRIMARY KEY (`email`),
+    INDEX (`frequency`),
+    FOREIGN KEY (email) REFERENCES users (email) ON DELETE CASCADE
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8;
+
+CREATE TABLE IF NOT EXISTS `digest_profile_snapshots`
+(
+    `email`        varchar(255) NOT NULL,
+    `current_city` varchar(50)  NOT NULL DEFAULT '',
+    `employers`    text         NOT NULL,
+    PRIMARY KEY (`email`),
+    FOREIGN KEY (email) REFERENCES users (email) ON DELETE CASCADE
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8;
+
+CREATE TABLE IF NOT EXISTS `digest_profile_changes`
+(
+    `id`         bigint       NOT NULL AUTO_INCREMENT,
+    `email`      varchar(255) NOT NULL,
+    `field`      varchar(20)  NOT NULL,
+    `old_value`  varchar(50)  NOT NULL DEFAULT '',
+    `new_value`  varchar(50)  NOT NULL,
+    `changed_at` datetime(6)  NOT NULL,
+    PRIMARY KEY (`id`),
+    INDEX (`email`, `changed_at`),
+    INDEX (`changed_at`),
+    FOREIGN KEY (email) REFERENCES users (email) ON DELETE CASCADE
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8;
+
+CREATE TABLE IF NOT EXISTS `meetups`
+(
+    `id`          bigint        NOT NULL AUTO_INCREMENT,
+    `host_email`  varchar(255)  NOT NULL,
+    `title`       varchar(100)  NOT NULL,
+    `description` varchar(2000) NOT NULL DEFAULT '',
+    `city`        varchar(50)   NOT NULL,
+    `starts_at`   datetime      NOT NULL,
+    `ends_at`     datetime      NOT NULL,
+    `visibility`  varchar(10)   NOT NULL,
+    `capacity`    int           NOT NULL DEFAULT 0,
+    `created_at`  datetime(6)   NOT NULL,
+    `updated_at`  datetime(6)   NOT NULL,
+    PRIMARY KEY (`id`),
+    INDEX (`host_email`, `ends_at`),
+    FOREIGN KEY (host_email) REFERENCES users (email) ON DELETE CASCADE
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8;
+
+CREATE TABLE IF NOT EXISTS `meetup_guests`
+(
+    `meetup_id`  bigint       NOT NULL,
+    `email`      varchar(255) NOT NULL,
+    `status`     varchar(10)  NOT NULL,
+    `updated_at` datetime(6)  NOT NULL,
+    PRIMARY KEY (`meetup_id`, `email`),
+    INDEX (`email`, `status`),
+    FOREIGN KEY (meetup_id) REFERENCES meetups (id) ON DELETE CASCADE,
+    FOREIGN KEY (email) REFERENCES users (email) ON DELETE CASCADE
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8;
+
+CREATE TABLE IF NOT EXISTS `community_groups`
+(
+    `id`          bigint        NOT NULL AUTO_INCREMENT,
+    `name`        varchar(100)  NOT NULL,
+    `topic`       varchar(50)   NOT NULL,
+    `topic_key`   varchar(50)   NOT NULL,
+    `description` varchar(2000) NOT NULL DEFAULT '',
+    `privacy`     varchar(10)   NOT NULL,
+    `created_at`  datetime(6)   NOT NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE (`name`),
+    INDEX (`topic_key`)
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8;
+
+CREATE TABLE IF NOT EXISTS `community_members`
+(
+    `group_id`  bigint       NOT NULL,
+    `email`     varchar(255) NOT NULL,
+    `role`      varchar(10)  NOT NULL,
+    `joined_at` datetime(6)  NOT NULL,
+    PRIMARY KEY (`group_id`, `email`),
+    INDEX (`email`),
+    FOREIGN KEY (group_id) REFERENCES community_groups (id) ON DELETE CASCADE,
+    FOREIGN KEY (email) REFERENCES users (email) ON DELETE CASCADE
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8;
+
+CREATE TABLE IF NOT EXISTS `community_join_requests`
+(
+    `group_id`     bigint       NOT NULL,
+    `email`        varchar(255) NOT NULL,
+    `requested_at` datetime(6)  NOT NULL,
+    PRIMARY KEY (`group_id`, `email`),
+    FOREIGN KEY (group_id) REFERENCES community_groups (id) ON DELETE CASCADE,
+    FOREIGN KEY (email) REFERENCES users (email) ON DELETE CASCADE
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8;
+
+CREATE TABLE IF NOT EXISTS `community_posts`
+(
+    `id`           bigint        NOT NULL AUTO_INCREMENT,
+    `group_id`     bigint        NOT NULL,
+    `author_email` varchar(255)  NOT NULL,
+    `body`         varchar(5000) NOT NULL,
+    `created_at`   datetime(6)   NOT NULL,
+    PRIMARY KEY (`id`),
+    INDEX (`group_id`, `id`),
+    FOREIGN KEY (group_id) REFERENCES community_groups (id) ON DELETE CASCADE,
+    FOREIGN KEY (author_email) REFERENCES users (email) ON DELETE CASCADE
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8;
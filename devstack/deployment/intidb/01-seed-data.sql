@@ -29,4 +29,14 @@ VALUES ('Apple'),
        ('Walmart'),
        ('Toyota Motor'),
        ('Volkswagen'),
-       ('Berkshire Hathaway');
\ No newline at end of file
+       ('Berkshire Hathaway');
+
+INSERT INTO relationship_types (name)
+VALUES ('Friend'),
+       ('Family'),
+       ('Classmate'),
+       ('Co-worker'),
+       ('Teammate'),
+       ('Neighbor'),
+       ('Mentor'),
+       ('Mentee');
@@ -3,38 +3,155 @@ package api
 import (
 	"fmt"
 	"net/http"
+	"net/mail"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/victornm/gtonline/internal/auth"
+	"github.com/victornm/gtonline/internal/community"
+	"github.com/victornm/gtonline/internal/conversation"
+	"github.com/victornm/gtonline/internal/digest"
+	"github.com/victornm/gtonline/internal/export"
 	"github.com/victornm/gtonline/internal/friend"
 	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/meetup"
+	"github.com/victornm/gtonline/internal/notification"
 	"github.com/victornm/gtonline/internal/profile"
+	"github.com/victornm/gtonline/internal/realtime"
+	"github.com/victornm/gtonline/internal/webhook"
 )
 
 type API struct {
-	Auth    *auth.Service
-	Profile *profile.Service
-	Friend  *friend.Service
+	Auth         *auth.Service
+	Profile      *profile.Service
+	Friend       *friend.Service
+	Notification *notification.Service
+	Realtime     *realtime.Hub
+	Conversation *conversation.Service
+	Webhook      *webhook.Service
+	Digest       *digest.Service
+	Meetup       *meetup.Service
+	Community    *community.Service
+	Export       *export.Service
 }
 
+const calendarContentType = "text/calendar; charset=utf-8"
+
 func (api *API) Route(e *gin.Engine) {
 	e.POST("/auth/register", api.register())
 	e.POST("/auth/login", api.login())
 
+	// Browsers can't set headers for EventSource and WebSocket, so these endpoints also accept the token in the query
+	e.GET("/events/stream", api.streamAuthMiddleware(), api.streamEvents())
+	e.GET("/events/ws", api.streamAuthMiddleware(), api.streamWebSocket())
+
+	// The unsubscribe links of the digest emails are authenticated by their signed token
+	e.GET("/digest/unsubscribe", api.getDigestUnsubscribe())
+	e.POST("/digest/unsubscribe", api.digestUnsubscribe())
+
+	// The calendar apps subscribe to the feed without headers, it is authenticated by its signed token
+	e.GET("/calendar.ics", api.calendarFeed())
+
+	// The export download links are authenticated by their signed token, which expires with the archive
+	e.GET("/users/export/:id", api.downloadExport())
+
 	// Auth endpoints
 	e.Use(api.authMiddleware())
 	e.GET("/schools", api.listSchools())
+	e.GET("/schools/:school_name/alumni", api.listAlumni())
 	e.GET("/employers", api.listEmployers())
+	e.GET("/employers/:employer_name/people", api.listPeople())
+	e.GET("/interests", api.listInterests())
+	e.POST("/interests/:slug/merge", api.adminMiddleware(), api.mergeInterests())
 	e.GET("/users", api.listUsers())
 	e.GET("/users/profile", api.getProfile())
+	e.DELETE("/users/me", api.deleteAccount())
+	e.POST("/users/me/deactivate", api.deactivateAccount())
+	e.GET("/users/:email/path", api.findPath())
 	e.PUT("/users/profile", api.updateProfile())
+	e.GET("/users/profile/history", api.listProfileHistory())
+	e.POST("/users/profile/history/:version/restore", api.restoreProfile())
+	e.GET("/users/:email/profile/history", api.adminMiddleware(), api.listProfileHistory())
+	e.POST("/users/:email/profile/history/:version/restore", api.adminMiddleware(), api.restoreProfile())
+	e.POST("/users/export", api.requestExport())
+	e.GET("/users/export", api.listExports())
+	e.GET("/users/settings/digest", api.getDigestSettings())
+	e.PUT("/users/settings/digest", api.updateDigestSettings())
+	e.GET("/users/settings/notifications", api.getNotificationSettings())
+	e.GET("/users/settings/birthday", api.getBirthdaySettings())
+	e.PUT("/users/settings/birthday", api.updateBirthdaySettings())
+	e.GET("/users/settings/directory", api.getDirectorySettings())
+	e.PUT("/users/settings/directory", api.updateDirectorySettings())
+	e.PUT("/users/settings/notifications", api.updateNotificationSettings())
 	e.GET("/friends", api.listFriends())
 	e.PUT("/friends/:friend_email", api.acceptFriendRequest())
 	e.GET("/friends/requests", api.listFriendRequests())
+	e.GET("/friends/birthdays", api.listBirthdays())
 	e.PUT("/friends/requests/:friend_email", api.createFriendRequest())
 	e.DELETE("/friends/requests/:friend_email", api.deleteFriendRequest())
+	e.PUT("/friends/:friend_email/relationship", api.updateRelationship())
+	e.GET("/friends/lists", api.listLists())
+	e.POST("/friends/lists", api.createList())
+	e.PUT("/friends/lists/:list_id", api.updateList())
+	e.DELETE("/friends/lists/:list_id", api.deleteList())
+	e.PUT("/friends/lists/:list_id/members/:friend_email", api.addListMember())
+	e.DELETE("/friends/lists/:list_id/members/:friend_email", api.removeListMember())
+	e.GET("/relationships", api.listRelationshipTypes())
+	e.POST("/relationships", api.adminMiddleware(), api.createRelationshipType())
+	e.DELETE("/relationships/:name", api.adminMiddleware(), api.deleteRelationshipType())
+	e.GET("/notifications", api.listNotifications())
+	e.GET("/notifications/unread-count", api.countUnreadNotifications())
+	e.POST("/notifications/read-all", api.markAllNotificationsRead())
+	e.POST("/notifications/:id/read", api.markNotificationRead())
+	// gin requires the same wildcard name at the same position, it is the friend email when sending a direct message
+	e.GET("/conversations", api.listConversations())
+	e.POST("/conversations/groups", api.createGroup())
+	e.PUT("/conversations/:conversation", api.renameGroup())
+	e.POST("/conversations/:conversation/leave", api.leaveGroup())
+	e.PUT("/conversations/:conversation/members/:member_email", api.addGroupMember())
+	e.DELETE("/conversations/:conversation/members/:member_email", api.removeGroupMember())
+	e.PUT("/conversations/:conversation/members/:member_email/role", api.updateGroupMemberRole())
+	e.POST("/conversations/:conversation/messages", api.sendMessage())
+	e.GET("/conversations/:conversation/messages", api.listMessages())
+	e.POST("/conversations/:conversation/read", api.markConversationRead())
+	e.DELETE("/conversations/:conversation", api.deleteConversation())
+	e.DELETE("/conversations/:conversation/messages/:message_id", api.deleteMessage())
+	e.POST("/events", api.createEvent())
+	e.GET("/events/upcoming", api.listUpcomingEvents())
+	e.GET("/events/feed", api.getCalendarFeed())
+	e.GET("/events/:event", api.getEvent())
+	e.PUT("/events/:event", api.updateEvent())
+	e.DELETE("/events/:event", api.deleteEvent())
+	e.GET("/events/:event/ics", api.exportEvent())
+	e.PUT("/events/:event/rsvp", api.rsvpEvent())
+	e.PUT("/events/:event/guests/:guest_email", api.inviteGuest())
+	e.DELETE("/events/:event/guests/:guest_email", api.uninviteGuest())
+	e.GET("/groups", api.listCommunityGroups())
+	e.POST("/groups", api.createCommunityGroup())
+	e.GET("/groups/suggested", api.suggestCommunityGroups())
+	e.GET("/groups/:group", api.getCommunityGroup())
+	e.PUT("/groups/:group", api.updateCommunityGroup())
+	e.DELETE("/groups/:group", api.deleteCommunityGroup())
+	e.POST("/groups/:group/join", api.joinCommunityGroup())
+	e.POST("/groups/:group/leave", api.leaveCommunityGroup())
+	e.GET("/groups/:group/members", api.listCommunityMembers())
+	e.DELETE("/groups/:group/members/:member_email", api.removeCommunityMember())
+	e.PUT("/groups/:group/members/:member_email/role", api.updateCommunityMemberRole())
+	e.GET("/groups/:group/requests", api.listJoinRequests())
+	e.PUT("/groups/:group/requests/:member_email", api.approveJoinRequest())
+	e.DELETE("/groups/:group/requests/:member_email", api.deleteJoinRequest())
+	e.GET("/groups/:group/posts", api.listGroupPosts())
+	e.POST("/groups/:group/posts", api.createGroupPost())
+	e.DELETE("/groups/:group/posts/:post_id", api.deleteGroupPost())
+	e.GET("/webhooks", api.adminMiddleware(), api.listWebhooks())
+	e.POST("/webhooks", api.adminMiddleware(), api.createWebhook())
+	e.PUT("/webhooks/:id", api.adminMiddleware(), api.updateWebhook())
+	e.DELETE("/webhooks/:id", api.adminMiddleware(), api.deleteWebhook())
+	e.GET("/webhooks/:id/deliveries", api.adminMiddleware(), api.listWebhookDeliveries())
+	e.GET("/webhooks/dead-letters", api.adminMiddleware(), api.listWebhookDeadLetters())
+	e.POST("/webhooks/dead-letters/:id/retry", api.adminMiddleware(), api.retryWebhookDeadLetter())
 
 	e.NoRoute(func(c *gin.Context) {
 		api.replyErr(c, gterr.New(gterr.NotFound, "not found path: "+c.Request.URL.Path))
@@ -74,6 +191,54 @@ func (api *API) login() gin.HandlerFunc {
 	}
 }
 
+func (api *API) deactivateAccount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("can't get User from gin.Context")))
+			return
+		}
+
+		var req auth.DeactivateAccountRequest
+		if err := api.bindJSON(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+		req.Email = u.Email
+
+		res, err := api.Auth.DeactivateAccount(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) deleteAccount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("can't get User from gin.Context")))
+			return
+		}
+
+		var req auth.DeleteAccountRequest
+		if err := api.bindJSON(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+		req.Email = u.Email
+
+		res, err := api.Auth.DeleteAccount(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 202, res)
+	}
+}
+
 func (api *API) authMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		header := c.GetHeader("Authorization")
@@ -82,15 +247,51 @@ func (api *API) authMiddleware() gin.HandlerFunc {
 			api.abort(c, gterr.New(gterr.Unauthenticated, ""))
 			return
 		}
-		u, err := api.Auth.Authenticate(c.Request.Context(), auth.Token{
+		api.authenticate(c, auth.Token{
 			AccessToken: tokens[1],
 			TokenType:   tokens[0],
 		})
-		if err != nil {
+	}
+}
+
+// streamAuthMiddleware is the same as authMiddleware, but fallback to the query access_token if there is no header.
+func (api *API) streamAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") != "" {
+			api.authMiddleware()(c)
+			return
+		}
+
+		api.authenticate(c, auth.Token{
+			AccessToken: c.Query("access_token"),
+			TokenType:   "Bearer",
+		})
+	}
+}
+
+func (api *API) authenticate(c *gin.Context, token auth.Token) {
+	u, err := api.Auth.Authenticate(c.Request.Context(), token)
+	if err != nil {
+		api.abort(c, err)
+		return
+	}
+	c.Set("user", u)
+	c.Next()
+}
+
+// adminMiddleware must be used after authMiddleware.
+func (api *API) adminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.abort(c, gterr.New(gterr.Internal, "", fmt.Errorf("context not contain user")))
+			return
+		}
+
+		if err := api.Auth.Authorize(c.Request.Context(), u); err != nil {
 			api.abort(c, err)
 			return
 		}
-		c.Set("user", u)
 		c.Next()
 	}
 }
@@ -117,6 +318,79 @@ func (api *API) listEmployers() gin.HandlerFunc {
 	}
 }
 
+func (api *API) listAlumni() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req profile.ListAlumniRequest
+		if err := api.bindQuery(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+		req.School = c.Param("school_name")
+
+		res, err := api.Profile.ListAlumni(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) listPeople() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req := profile.ListPeopleRequest{Employer: c.Param("employer_name")}
+
+		res, err := api.Profile.ListPeople(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) listInterests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req profile.ListInterestsRequest
+		if err := api.bindQuery(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+
+		res, err := api.Profile.ListInterests(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) mergeInterests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("can't get User from gin.Context")))
+			return
+		}
+
+		var req profile.MergeInterestsRequest
+		if err := api.bindJSON(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+		req.Slug = c.Param("slug")
+		req.Actor = u.Email
+
+		res, err := api.Profile.MergeInterests(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
 func (api *API) listUsers() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req friend.SearchFriendsRequest
@@ -125,7 +399,10 @@ func (api *API) listUsers() gin.HandlerFunc {
 			return
 		}
 
-		if req == (friend.SearchFriendsRequest{}) {
+		// The paging alone searches nothing
+		filter := req
+		filter.After, filter.Limit = "", 0
+		if filter == (friend.SearchFriendsRequest{}) {
 			api.replyErr(c, gterr.New(gterr.InvalidArgument, "Must provide at least 1 params"))
 			return
 		}
@@ -185,6 +462,139 @@ func (api *API) updateProfile() gin.HandlerFunc {
 	}
 }
 
+// listProfileHistory lists the history of the user, or of :email for the admin route.
+func (api *API) listProfileHistory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("can't get User from gin.Context")))
+			return
+		}
+
+		var req profile.ListProfileHistoryRequest
+		if err := api.bindQuery(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+		req.Email = u.Email
+		if email := c.Param("email"); email != "" {
+			req.Email = email
+		}
+
+		res, err := api.Profile.ListProfileHistory(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+// restoreProfile restores a version of the user, or of :email for the admin route.
+func (api *API) restoreProfile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("can't get User from gin.Context")))
+			return
+		}
+
+		version, err := api.int64Param(c, "version")
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+
+		req := profile.RestoreProfileRequest{Email: u.Email, Version: version, Actor: u.Email}
+		if email := c.Param("email"); email != "" {
+			req.Email = email
+		}
+
+		res, err := api.Profile.RestoreProfile(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) requestExport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("can't get User from gin.Context")))
+			return
+		}
+
+		res, err := api.Export.Request(c.Request.Context(), u.Email)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 202, res)
+	}
+}
+
+func (api *API) listExports() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("can't get User from gin.Context")))
+			return
+		}
+
+		res, err := api.Export.List(c.Request.Context(), u.Email)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) downloadExport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := api.int64Param(c, "id")
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+
+		res, err := api.Export.Download(c.Request.Context(), export.DownloadRequest{ID: id, Token: c.Query("token")})
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		c.FileAttachment(res.Path, res.Name)
+	}
+}
+
+func (api *API) findPath() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req friend.FindPathRequest
+		if err := api.bindQuery(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("context not contain user")))
+			return
+		}
+		req.Email, req.FriendEmail = u.Email, c.Param("email")
+
+		res, err := api.Friend.FindPath(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+
+		api.reply(c, 200, res)
+	}
+}
+
 func (api *API) listFriends() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		u, ok := api.userFromContext(c)
@@ -238,6 +648,30 @@ func (api *API) listFriendRequests() gin.HandlerFunc {
 	}
 }
 
+func (api *API) listBirthdays() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("context not contain user")))
+			return
+		}
+
+		var req friend.ListBirthdaysRequest
+		if err := api.bindQuery(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+		req.Email = u.Email
+
+		res, err := api.Friend.ListBirthdays(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
 func (api *API) createFriendRequest() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req friend.CreateFriendRequest
@@ -296,10 +730,1527 @@ func (api *API) deleteFriendRequest() gin.HandlerFunc {
 	}
 }
 
-func (api *API) unimplemented() gin.HandlerFunc {
+func (api *API) updateRelationship() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		api.replyErr(c, gterr.New(gterr.Unimplemented, ""))
-	}
+		var req friend.UpdateRelationshipRequest
+		if err := api.bindJSON(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("context not contain user")))
+			return
+		}
+
+		req.Email, req.FriendEmail = u.Email, c.Param("friend_email")
+
+		if err := api.Friend.UpdateRelationship(c.Request.Context(), req); err != nil {
+			api.replyErr(c, err)
+			return
+		}
+
+		api.reply(c, 200, nil)
+	}
+}
+
+func (api *API) listRelationshipTypes() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		res, err := api.Friend.ListRelationshipTypes(c.Request.Context())
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) createRelationshipType() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req friend.RelationshipType
+		if err := api.bindJSON(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+
+		if err := api.Friend.CreateRelationshipType(c.Request.Context(), req); err != nil {
+			api.replyErr(c, err)
+			return
+		}
+
+		api.reply(c, 200, nil)
+	}
+}
+
+func (api *API) deleteRelationshipType() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := api.Friend.DeleteRelationshipType(c.Request.Context(), c.Param("name")); err != nil {
+			api.replyErr(c, err)
+			return
+		}
+
+		api.reply(c, 200, nil)
+	}
+}
+
+func (api *API) listLists() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("context not contain user")))
+			return
+		}
+
+		res, err := api.Friend.ListLists(c.Request.Context(), u.Email)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) createList() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req friend.CreateListRequest
+		if err := api.bindJSON(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("context not contain user")))
+			return
+		}
+		req.Owner = u.Email
+
+		res, err := api.Friend.CreateList(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) updateList() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req friend.UpdateListRequest
+		if err := api.bindJSON(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("context not contain user")))
+			return
+		}
+		id, err := api.int64Param(c, "list_id")
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		req.Owner, req.ID = u.Email, id
+
+		res, err := api.Friend.UpdateList(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) deleteList() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("context not contain user")))
+			return
+		}
+		id, err := api.int64Param(c, "list_id")
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+
+		if err := api.Friend.DeleteList(c.Request.Context(), friend.DeleteListRequest{
+			Owner: u.Email,
+			ID:    id,
+		}); err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, nil)
+	}
+}
+
+func (api *API) addListMember() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, err := api.listMemberRequest(c)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+
+		if err := api.Friend.AddListMember(c.Request.Context(), req); err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, nil)
+	}
+}
+
+func (api *API) removeListMember() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, err := api.listMemberRequest(c)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+
+		if err := api.Friend.RemoveListMember(c.Request.Context(), req); err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, nil)
+	}
+}
+
+func (api *API) listMemberRequest(c *gin.Context) (friend.ListMemberRequest, error) {
+	u, ok := api.userFromContext(c)
+	if !ok {
+		return friend.ListMemberRequest{}, gterr.New(gterr.Internal, "", fmt.Errorf("context not contain user"))
+	}
+	id, err := api.int64Param(c, "list_id")
+	if err != nil {
+		return friend.ListMemberRequest{}, err
+	}
+
+	return friend.ListMemberRequest{
+		Owner:       u.Email,
+		ID:          id,
+		MemberEmail: c.Param("friend_email"),
+	}, nil
+}
+
+func (api *API) listNotifications() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req notification.ListRequest
+		if err := api.bindQuery(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("context not contain user")))
+			return
+		}
+		req.Email = u.Email
+
+		res, err := api.Notification.List(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) countUnreadNotifications() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("context not contain user")))
+			return
+		}
+
+		res, err := api.Notification.CountUnread(c.Request.Context(), u.Email)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) markNotificationRead() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("context not contain user")))
+			return
+		}
+		id, err := api.int64Param(c, "id")
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+
+		if err := api.Notification.MarkRead(c.Request.Context(), u.Email, id); err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, nil)
+	}
+}
+
+func (api *API) markAllNotificationsRead() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("context not contain user")))
+			return
+		}
+
+		if err := api.Notification.MarkAllRead(c.Request.Context(), u.Email); err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, nil)
+	}
+}
+
+func (api *API) listConversations() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("context not contain user")))
+			return
+		}
+
+		res, err := api.Conversation.ListConversations(c.Request.Context(), u.Email)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+// sendMessage sends to the conversation ID if the path has one, or a direct message if it has the friend email.
+func (api *API) sendMessage() gin.HandlerFunc {
+	direct := api.sendDirectMessage()
+	return func(c *gin.Context) {
+		id, friendEmail, err := api.conversationParam(c)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		if friendEmail != "" {
+			direct(c)
+			return
+		}
+
+		var req conversation.SendMessageRequest
+		if err := api.bindJSON(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("context not contain user")))
+			return
+		}
+		req.Email = u.Email
+		req.ConversationID = id
+
+		res, err := api.Conversation.SendMessage(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) sendDirectMessage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req conversation.SendDirectMessageRequest
+		if err := api.bindJSON(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("context not contain user")))
+			return
+		}
+		req.Email = u.Email
+		req.FriendEmail = c.Param("conversation")
+
+		res, err := api.Conversation.SendDirectMessage(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) listMessages() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req conversation.ListMessagesRequest
+		if err := api.bindQuery(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("context not contain user")))
+			return
+		}
+		id, err := api.int64Param(c, "conversation")
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		req.Email = u.Email
+		req.ConversationID = id
+
+		res, err := api.Conversation.ListMessages(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) markConversationRead() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("context not contain user")))
+			return
+		}
+		id, err := api.int64Param(c, "conversation")
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+
+		if err := api.Conversation.MarkRead(c.Request.Context(), u.Email, id); err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, nil)
+	}
+}
+
+func (api *API) deleteConversation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("context not contain user")))
+			return
+		}
+		id, err := api.int64Param(c, "conversation")
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+
+		if err := api.Conversation.DeleteConversation(c.Request.Context(), u.Email, id); err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, nil)
+	}
+}
+
+func (api *API) deleteMessage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("context not contain user")))
+			return
+		}
+		id, err := api.int64Param(c, "conversation")
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		messageID, err := api.int64Param(c, "message_id")
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+
+		req := conversation.DeleteMessageRequest{Email: u.Email, ConversationID: id, MessageID: messageID}
+		if err := api.Conversation.DeleteMessage(c.Request.Context(), req); err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, nil)
+	}
+}
+
+func (api *API) createGroup() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req conversation.CreateGroupRequest
+		if err := api.bindJSON(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("context not contain user")))
+			return
+		}
+		req.Email = u.Email
+
+		res, err := api.Conversation.CreateGroup(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) renameGroup() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req conversation.RenameGroupRequest
+		if err := api.bindJSON(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("context not contain user")))
+			return
+		}
+		id, err := api.int64Param(c, "conversation")
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		req.Email = u.Email
+		req.ConversationID = id
+
+		res, err := api.Conversation.RenameGroup(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) leaveGroup() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("context not contain user")))
+			return
+		}
+		id, err := api.int64Param(c, "conversation")
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+
+		if err := api.Conversation.LeaveGroup(c.Request.Context(), u.Email, id); err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, nil)
+	}
+}
+
+func (api *API) addGroupMember() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, err := api.groupMemberRequest(c)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+
+		if err := api.Conversation.AddGroupMember(c.Request.Context(), req); err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, nil)
+	}
+}
+
+func (api *API) removeGroupMember() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, err := api.groupMemberRequest(c)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+
+		if err := api.Conversation.RemoveGroupMember(c.Request.Context(), req); err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, nil)
+	}
+}
+
+func (api *API) updateGroupMemberRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req conversation.UpdateMemberRoleRequest
+		if err := api.bindJSON(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+		member, err := api.groupMemberRequest(c)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		req.Email = member.Email
+		req.ConversationID = member.ConversationID
+		req.MemberEmail = member.MemberEmail
+
+		if err := api.Conversation.UpdateMemberRole(c.Request.Context(), req); err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, nil)
+	}
+}
+
+func (api *API) groupMemberRequest(c *gin.Context) (conversation.GroupMemberRequest, error) {
+	u, ok := api.userFromContext(c)
+	if !ok {
+		return conversation.GroupMemberRequest{}, gterr.New(gterr.Internal, "", fmt.Errorf("context not contain user"))
+	}
+	id, err := api.int64Param(c, "conversation")
+	if err != nil {
+		return conversation.GroupMemberRequest{}, err
+	}
+
+	return conversation.GroupMemberRequest{
+		Email:          u.Email,
+		ConversationID: id,
+		MemberEmail:    c.Param("member_email"),
+	}, nil
+}
+
+func (api *API) listWebhooks() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		res, err := api.Webhook.ListWebhooks(c.Request.Context())
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) createWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req webhook.CreateWebhookRequest
+		if err := api.bindJSON(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+
+		res, err := api.Webhook.CreateWebhook(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) updateWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req webhook.UpdateWebhookRequest
+		if err := api.bindJSON(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+		id, err := api.int64Param(c, "id")
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		req.ID = id
+
+		res, err := api.Webhook.UpdateWebhook(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) deleteWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := api.int64Param(c, "id")
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+
+		if err := api.Webhook.DeleteWebhook(c.Request.Context(), id); err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, nil)
+	}
+}
+
+func (api *API) listWebhookDeliveries() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req webhook.ListDeliveriesRequest
+		if err := api.bindQuery(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+		id, err := api.int64Param(c, "id")
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		req.WebhookID = id
+
+		res, err := api.Webhook.ListDeliveries(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) listWebhookDeadLetters() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req webhook.ListDeadLettersRequest
+		if err := api.bindQuery(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+
+		res, err := api.Webhook.ListDeadLetters(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) retryWebhookDeadLetter() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := api.int64Param(c, "id")
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+
+		if err := api.Webhook.RetryDeadLetter(c.Request.Context(), id); err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, nil)
+	}
+}
+
+func (api *API) streamEvents() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("context not contain user")))
+			return
+		}
+
+		api.Realtime.ServeSSE(c.Writer, c.Request, u.Email)
+	}
+}
+
+func (api *API) streamWebSocket() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("context not contain user")))
+			return
+		}
+
+		// The response is already written by the upgrader if failed
+		if err := api.Realtime.ServeWebSocket(c.Writer, c.Request, u.Email); err != nil {
+			_ = c.Error(err)
+		}
+	}
+}
+
+func (api *API) unimplemented() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		api.replyErr(c, gterr.New(gterr.Unimplemented, ""))
+	}
+}
+
+func (api *API) getNotificationSettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("can't get User from gin.Context")))
+			return
+		}
+
+		res, err := api.Notification.GetPreferences(c.Request.Context(), u.Email)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) updateNotificationSettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("can't get User from gin.Context")))
+			return
+		}
+
+		var req notification.UpdatePreferencesRequest
+		if err := api.bindJSON(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+		req.Email = u.Email
+
+		res, err := api.Notification.UpdatePreferences(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) getBirthdaySettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("can't get User from gin.Context")))
+			return
+		}
+
+		res, err := api.Friend.GetBirthdaySettings(c.Request.Context(), u.Email)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) getDirectorySettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("can't get User from gin.Context")))
+			return
+		}
+
+		res, err := api.Profile.GetDirectorySettings(c.Request.Context(), u.Email)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) updateDirectorySettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("can't get User from gin.Context")))
+			return
+		}
+
+		var req profile.UpdateDirectorySettingsRequest
+		if err := api.bindJSON(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+		req.Email = u.Email
+
+		res, err := api.Profile.UpdateDirectorySettings(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) updateBirthdaySettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("can't get User from gin.Context")))
+			return
+		}
+
+		var req friend.UpdateBirthdaySettingsRequest
+		if err := api.bindJSON(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+		req.Email = u.Email
+
+		res, err := api.Friend.UpdateBirthdaySettings(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) getDigestSettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("can't get User from gin.Context")))
+			return
+		}
+
+		res, err := api.Digest.GetSubscription(c.Request.Context(), u.Email)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) updateDigestSettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("can't get User from gin.Context")))
+			return
+		}
+
+		var req digest.UpdateSubscriptionRequest
+		if err := api.bindJSON(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+		req.Email = u.Email
+
+		res, err := api.Digest.UpdateSubscription(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+// getDigestUnsubscribe only shows the subscription, so link scanners opening the URL don't unsubscribe the user.
+func (api *API) getDigestUnsubscribe() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		res, err := api.Digest.SubscriptionByToken(c.Request.Context(), c.Query("token"))
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) digestUnsubscribe() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := api.Digest.Unsubscribe(c.Request.Context(), c.Query("token")); err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, nil)
+	}
+}
+
+func (api *API) createEvent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("context not contain user")))
+			return
+		}
+
+		var req meetup.CreateEventRequest
+		if err := api.bindJSON(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+		req.Email = u.Email
+
+		res, err := api.Meetup.CreateEvent(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) listUpcomingEvents() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("context not contain user")))
+			return
+		}
+
+		var req meetup.ListUpcomingRequest
+		if err := api.bindQuery(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+		req.Email = u.Email
+
+		res, err := api.Meetup.ListUpcoming(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) getEvent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, err := api.eventRequest(c)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+
+		res, err := api.Meetup.GetEvent(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) updateEvent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req meetup.UpdateEventRequest
+		if err := api.bindJSON(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+		e, err := api.eventRequest(c)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		req.Email = e.Email
+		req.ID = e.ID
+
+		res, err := api.Meetup.UpdateEvent(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) deleteEvent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, err := api.eventRequest(c)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+
+		if err := api.Meetup.DeleteEvent(c.Request.Context(), req); err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, nil)
+	}
+}
+
+func (api *API) exportEvent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, err := api.eventRequest(c)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+
+		res, err := api.Meetup.ExportEvent(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="event-%d.ics"`, req.ID))
+		c.Data(200, calendarContentType, res)
+	}
+}
+
+func (api *API) rsvpEvent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req meetup.RSVPRequest
+		if err := api.bindJSON(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+		e, err := api.eventRequest(c)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		req.Email = e.Email
+		req.EventID = e.ID
+
+		res, err := api.Meetup.RSVP(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) inviteGuest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, err := api.guestRequest(c)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+
+		if err := api.Meetup.Invite(c.Request.Context(), req); err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, nil)
+	}
+}
+
+func (api *API) uninviteGuest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, err := api.guestRequest(c)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+
+		if err := api.Meetup.Uninvite(c.Request.Context(), req); err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, nil)
+	}
+}
+
+func (api *API) getCalendarFeed() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("context not contain user")))
+			return
+		}
+		api.reply(c, 200, api.Meetup.GetFeed(c.Request.Context(), u.Email))
+	}
+}
+
+func (api *API) calendarFeed() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		res, err := api.Meetup.Calendar(c.Request.Context(), c.Query("token"))
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		c.Data(200, calendarContentType, res)
+	}
+}
+
+func (api *API) listCommunityGroups() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("context not contain user")))
+			return
+		}
+
+		var req community.ListGroupsRequest
+		if err := api.bindQuery(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+		req.Email = u.Email
+
+		res, err := api.Community.ListGroups(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) suggestCommunityGroups() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("context not contain user")))
+			return
+		}
+
+		var req community.ListGroupsRequest
+		if err := api.bindQuery(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+		req.Email = u.Email
+
+		res, err := api.Community.SuggestGroups(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) createCommunityGroup() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := api.userFromContext(c)
+		if !ok {
+			api.replyErr(c, gterr.New(gterr.Internal, "", fmt.Errorf("context not contain user")))
+			return
+		}
+
+		var req community.CreateGroupRequest
+		if err := api.bindJSON(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+		req.Email = u.Email
+
+		res, err := api.Community.CreateGroup(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) getCommunityGroup() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, err := api.communityGroupRequest(c)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+
+		res, err := api.Community.GetGroup(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) updateCommunityGroup() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req community.UpdateGroupRequest
+		if err := api.bindJSON(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+		g, err := api.communityGroupRequest(c)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		req.Email = g.Email
+		req.ID = g.ID
+
+		res, err := api.Community.UpdateGroup(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) deleteCommunityGroup() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, err := api.communityGroupRequest(c)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+
+		if err := api.Community.DeleteGroup(c.Request.Context(), req); err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, nil)
+	}
+}
+
+func (api *API) joinCommunityGroup() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, err := api.communityGroupRequest(c)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+
+		res, err := api.Community.Join(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) leaveCommunityGroup() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, err := api.communityGroupRequest(c)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+
+		if err := api.Community.Leave(c.Request.Context(), req); err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, nil)
+	}
+}
+
+func (api *API) listCommunityMembers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, err := api.communityGroupRequest(c)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+
+		res, err := api.Community.ListMembers(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) removeCommunityMember() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, err := api.communityMemberRequest(c)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+
+		if err := api.Community.RemoveMember(c.Request.Context(), req); err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, nil)
+	}
+}
+
+func (api *API) updateCommunityMemberRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req community.UpdateMemberRoleRequest
+		if err := api.bindJSON(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+		m, err := api.communityMemberRequest(c)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		req.Email = m.Email
+		req.GroupID = m.GroupID
+		req.MemberEmail = m.MemberEmail
+
+		if err := api.Community.UpdateMemberRole(c.Request.Context(), req); err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, nil)
+	}
+}
+
+func (api *API) listJoinRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, err := api.communityGroupRequest(c)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+
+		res, err := api.Community.ListJoinRequests(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) approveJoinRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, err := api.communityMemberRequest(c)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+
+		if err := api.Community.ApproveJoinRequest(c.Request.Context(), req); err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, nil)
+	}
+}
+
+func (api *API) deleteJoinRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, err := api.communityMemberRequest(c)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+
+		if err := api.Community.DeleteJoinRequest(c.Request.Context(), req); err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, nil)
+	}
+}
+
+func (api *API) listGroupPosts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req community.ListPostsRequest
+		if err := api.bindQuery(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+		g, err := api.communityGroupRequest(c)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		req.Email = g.Email
+		req.GroupID = g.ID
+
+		res, err := api.Community.ListPosts(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) createGroupPost() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req community.CreatePostRequest
+		if err := api.bindJSON(c, &req); err != nil {
+			api.replyErr(c, gterr.New(gterr.InvalidArgument, err.Error(), err))
+			return
+		}
+		g, err := api.communityGroupRequest(c)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		req.Email = g.Email
+		req.GroupID = g.ID
+
+		res, err := api.Community.CreatePost(c.Request.Context(), req)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, res)
+	}
+}
+
+func (api *API) deleteGroupPost() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		g, err := api.communityGroupRequest(c)
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		postID, err := api.int64Param(c, "post_id")
+		if err != nil {
+			api.replyErr(c, err)
+			return
+		}
+
+		req := community.DeletePostRequest{Email: g.Email, GroupID: g.ID, PostID: postID}
+		if err := api.Community.DeletePost(c.Request.Context(), req); err != nil {
+			api.replyErr(c, err)
+			return
+		}
+		api.reply(c, 200, nil)
+	}
+}
+
+func (api *API) eventRequest(c *gin.Context) (meetup.GetEventRequest, error) {
+	u, ok := api.userFromContext(c)
+	if !ok {
+		return meetup.GetEventRequest{}, gterr.New(gterr.Internal, "", fmt.Errorf("context not contain user"))
+	}
+	id, err := api.int64Param(c, "event")
+	if err != nil {
+		return meetup.GetEventRequest{}, err
+	}
+	return meetup.GetEventRequest{Email: u.Email, ID: id}, nil
+}
+
+func (api *API) guestRequest(c *gin.Context) (meetup.GuestRequest, error) {
+	e, err := api.eventRequest(c)
+	if err != nil {
+		return meetup.GuestRequest{}, err
+	}
+	return meetup.GuestRequest{Email: e.Email, EventID: e.ID, GuestEmail: c.Param("guest_email")}, nil
+}
+
+func (api *API) communityGroupRequest(c *gin.Context) (community.GetGroupRequest, error) {
+	u, ok := api.userFromContext(c)
+	if !ok {
+		return community.GetGroupRequest{}, gterr.New(gterr.Internal, "", fmt.Errorf("context not contain user"))
+	}
+	id, err := api.int64Param(c, "group")
+	if err != nil {
+		return community.GetGroupRequest{}, err
+	}
+	return community.GetGroupRequest{Email: u.Email, ID: id}, nil
+}
+
+func (api *API) communityMemberRequest(c *gin.Context) (community.MemberRequest, error) {
+	g, err := api.communityGroupRequest(c)
+	if err != nil {
+		return community.MemberRequest{}, err
+	}
+	return community.MemberRequest{Email: g.Email, GroupID: g.ID, MemberEmail: c.Param("member_email")}, nil
 }
 
 func (api *API) userFromContext(c *gin.Context) (*auth.UserAuthDTO, bool) {
@@ -309,6 +2260,29 @@ func (api *API) userFromContext(c *gin.Context) (*auth.UserAuthDTO, bool) {
 	return u, ok
 }
 
+func (api *API) int64Param(c *gin.Context, name string) (int64, error) {
+	v, err := strconv.ParseInt(c.Param(name), 10, 64)
+	if err != nil {
+		return 0, gterr.New(gterr.InvalidArgument, fmt.Sprintf("invalid %s: %s", name, c.Param(name)), err)
+	}
+	return v, nil
+}
+
+// conversationParam returns the ID of the :conversation segment, or the friend email if it is an email address.
+// Anything else is rejected, so an ID is never taken for an email and the other way around.
+func (api *API) conversationParam(c *gin.Context) (id int64, friendEmail string, err error) {
+	v := c.Param("conversation")
+	if v != "" && strings.Trim(v, "0123456789") == "" {
+		id, err := api.int64Param(c, "conversation")
+		return id, "", err
+	}
+
+	if a, err := mail.ParseAddress(v); err == nil && a.Address == v {
+		return 0, v, nil
+	}
+	return 0, "", gterr.New(gterr.InvalidArgument, fmt.Sprintf("invalid conversation: %s, expect an ID or an email", v))
+}
+
 func (api *API) bindJSON(c *gin.Context, req interface{}) error {
 	return c.ShouldBindJSON(req)
 }
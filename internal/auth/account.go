@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+type (
+	DeactivateAccountRequest struct {
+		Email    string `json:"-"`
+		Password string `json:"password" binding:"required"`
+	}
+
+	DeactivateAccountResponse struct {
+		DeactivatedAt time.Time `json:"deactivated_at"`
+	}
+
+	DeleteAccountRequest struct {
+		Email    string `json:"-"`
+		Password string `json:"password" binding:"required"`
+	}
+
+	DeleteAccountResponse struct {
+		// DeleteAfter is when the account is purged, unless the user logs in before.
+		DeleteAfter time.Time `json:"delete_after"`
+	}
+)
+
+// DeactivateAccount hides the account from the other users and keeps all its data, until the user logs in again.
+// The password is asked again, so a stolen access token can't deactivate the account.
+func (s *Service) DeactivateAccount(ctx context.Context, req DeactivateAccountRequest) (*DeactivateAccountResponse, error) {
+	if err := s.checkPassword(ctx, req.Email, req.Password); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if err := s.deactivate(ctx, req.Email, now, nil); err != nil {
+		return nil, err
+	}
+	return &DeactivateAccountResponse{DeactivatedAt: now}, nil
+}
+
+// DeleteAccount deactivates the account and schedules its deletion after the grace period.
+// The password is asked again, so a stolen access token can't delete the account.
+func (s *Service) DeleteAccount(ctx context.Context, req DeleteAccountRequest) (*DeleteAccountResponse, error) {
+	if err := s.checkPassword(ctx, req.Email, req.Password); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	deleteAfter := now.Add(s.cfg.DeletionGracePeriod)
+	if err := s.deactivate(ctx, req.Email, now, &deleteAfter); err != nil {
+		return nil, err
+	}
+	return &DeleteAccountResponse{DeleteAfter: deleteAfter}, nil
+}
+
+func (s *Service) checkPassword(ctx context.Context, email, password string) error {
+	u, err := s.storage.FindUserByEmail(ctx, email)
+	if errors.Is(err, storage.ErrNotFound) {
+		return gterr.New(gterr.NotFound, "user not found", err)
+	}
+	if err != nil {
+		return gterr.New(gterr.Internal, "", err)
+	}
+
+	match, err := compareHash(u.HashedPassword, password)
+	if err != nil {
+		return gterr.New(gterr.Internal, "", err)
+	}
+	if !match {
+		return gterr.New(gterr.PermissionDenied, "Password do not matched.")
+	}
+	return nil
+}
+
+func (s *Service) deactivate(ctx context.Context, email string, at time.Time, deleteAfter *time.Time) error {
+	err := s.storage.DeactivateUser(ctx, email, at, deleteAfter)
+	if errors.Is(err, storage.ErrNotFound) {
+		return gterr.New(gterr.NotFound, "user not found", err)
+	}
+	if err != nil {
+		return gterr.New(gterr.Internal, "", fmt.Errorf("deactivate user: %v", err))
+	}
+	return nil
+}
+
+// PurgeDeletedAccounts deletes the accounts whose grace period ended and returns their number.
+// handOver runs for each of them before, e.g. to hand over the groups they own like when leaving them.
+func (s *Service) PurgeDeletedAccounts(ctx context.Context, now time.Time, handOver func(ctx context.Context, email string) error) (int64, error) {
+	if handOver != nil {
+		emails, err := s.storage.ListScheduledUsers(ctx, now)
+		if err != nil {
+			return 0, gterr.New(gterr.Internal, "", fmt.Errorf("list scheduled users: %v", err))
+		}
+
+		for _, email := range emails {
+			if err := handOver(ctx, email); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	n, err := s.storage.DeleteScheduledUsers(ctx, now)
+	if err != nil {
+		return n, gterr.New(gterr.Internal, "", fmt.Errorf("delete scheduled users: %v", err))
+	}
+	return n, nil
+}
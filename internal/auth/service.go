@@ -10,6 +10,7 @@ import (
 	"github.com/golang-jwt/jwt"
 	"golang.org/x/crypto/bcrypt"
 
+	"github.com/victornm/gtonline/internal/event"
 	"github.com/victornm/gtonline/internal/gterr"
 	"github.com/victornm/gtonline/internal/storage"
 )
@@ -18,11 +19,25 @@ type (
 	Service struct {
 		storage Storage
 		secret  []byte
+		cfg     Config
 	}
 
 	Storage interface {
 		FindUserByEmail(ctx context.Context, email string) (*User, error)
-		CreateRegularUser(ctx context.Context, u User) error
+		// CreateRegularUser creates u and appends the events to the outbox in the same transaction.
+		CreateRegularUser(ctx context.Context, u User, events ...event.Event) error
+		IsAdmin(ctx context.Context, email string) (bool, error)
+
+		// DeactivateUser hides the user from the other users since at, and schedules its deletion if deleteAfter is
+		// not nil. It returns storage.ErrNotFound if the user doesn't exist.
+		DeactivateUser(ctx context.Context, email string, at time.Time, deleteAfter *time.Time) error
+		// ReactivateUser cancels the deactivation and the scheduled deletion of the user.
+		ReactivateUser(ctx context.Context, email string) error
+		// ListScheduledUsers returns the users whose deletion is scheduled at or before now.
+		ListScheduledUsers(ctx context.Context, now time.Time) ([]string, error)
+		// DeleteScheduledUsers deletes the users whose deletion is scheduled at or before now, with all their data.
+		// The actors of the notifications and profile versions they caused are anonymized.
+		DeleteScheduledUsers(ctx context.Context, now time.Time) (int64, error)
 	}
 
 	User struct {
@@ -30,16 +45,58 @@ type (
 		HashedPassword string `db:"password"`
 		FirstName      string `db:"first_name"`
 		LastName       string `db:"last_name"`
+		// DeactivatedAt is set while the user is deactivated, DeleteAfter when its deletion is scheduled too.
+		DeactivatedAt *time.Time `db:"deactivated_at"`
+		DeleteAfter   *time.Time `db:"delete_after"`
+	}
+
+	Config struct {
+		Secret string `mapstructure:"secret"`
+		// DeletionGracePeriod is how long a deleted account can be restored by logging in before it is purged.
+		DeletionGracePeriod time.Duration `mapstructure:"deletion_grace_period"`
+		// PurgeInterval is how often the accounts past their grace period are purged.
+		PurgeInterval time.Duration `mapstructure:"purge_interval"`
 	}
 )
 
-func NewService(storage Storage, secret []byte) *Service {
+func NewService(storage Storage, cfg Config) *Service {
+	cfg = cfg.withDefaults()
 	return &Service{
 		storage: storage,
-		secret:  secret,
+		secret:  []byte(cfg.Secret),
+		cfg:     cfg,
+	}
+}
+
+func DefaultConfig() Config {
+	return Config{
+		DeletionGracePeriod: 30 * 24 * time.Hour,
+		PurgeInterval:       time.Hour,
 	}
 }
 
+func (c Config) withDefaults() Config {
+	d := DefaultConfig()
+	if c.DeletionGracePeriod <= 0 {
+		c.DeletionGracePeriod = d.DeletionGracePeriod
+	}
+	if c.PurgeInterval <= 0 {
+		c.PurgeInterval = d.PurgeInterval
+	}
+	return c
+}
+
+const EventUserRegistered = "user.registered"
+
+// UserRegistered is published after a user registered.
+type UserRegistered struct {
+	Email     string `json:"email"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+}
+
+func (UserRegistered) EventName() string { return EventUserRegistered }
+
 type (
 	RegisterRequest struct {
 		Email                string `json:"email" binding:"email,required"`
@@ -76,7 +133,7 @@ func (s *Service) Register(ctx context.Context, req RegisterRequest) (*RegisterR
 		FirstName:      req.FirstName,
 		LastName:       req.LastName,
 	}
-	err = s.storage.CreateRegularUser(ctx, u)
+	err = s.storage.CreateRegularUser(ctx, u, UserRegistered{Email: u.Email, FirstName: u.FirstName, LastName: u.LastName})
 
 	if err != nil {
 		return nil, gterr.New(gterr.Internal, "", err)
@@ -102,6 +159,8 @@ type (
 	// LoginResponse follow the convention described here: https://www.oauth.com/oauth2-servers/access-tokens/access-token-response/
 	LoginResponse struct {
 		Token
+		// Reactivated tells that the login reactivated the account, and canceled its deletion if it was scheduled.
+		Reactivated bool `json:"reactivated,omitempty"`
 	}
 )
 
@@ -111,6 +170,10 @@ func (s *Service) Login(ctx context.Context, req LoginRequest) (*LoginResponse,
 		return nil, gterr.New(gterr.Unauthenticated, "Email or password do not matched.", err)
 	}
 
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+
 	match, err := compareHash(u.HashedPassword, req.Password)
 	if err != nil {
 		return nil, gterr.New(gterr.Internal, "", err)
@@ -120,13 +183,21 @@ func (s *Service) Login(ctx context.Context, req LoginRequest) (*LoginResponse,
 		return nil, gterr.New(gterr.Unauthenticated, "Email or password do not matched.", err)
 	}
 
+	reactivated := u.DeactivatedAt != nil
+	if reactivated {
+		if err := s.storage.ReactivateUser(ctx, u.Email); err != nil {
+			return nil, gterr.New(gterr.Internal, "", fmt.Errorf("reactivate user: %v", err))
+		}
+	}
+
 	token, err := genToken(*u, s.secret)
 	if err != nil {
 		return nil, gterr.New(gterr.Internal, "", err)
 	}
 
 	return &LoginResponse{
-		Token: newBearerToken(token),
+		Token:       newBearerToken(token),
+		Reactivated: reactivated,
 	}, nil
 }
 
@@ -144,7 +215,8 @@ func newBearerToken(token string) Token {
 	}
 }
 
-func (s *Service) Authenticate(_ context.Context, req Token) (*UserAuthDTO, error) {
+// Authenticate returns the user of the token, the tokens of a deactivated user are rejected until it logs in again.
+func (s *Service) Authenticate(ctx context.Context, req Token) (*UserAuthDTO, error) {
 	if !strings.EqualFold(req.TokenType, "bearer") {
 		return nil, gterr.New(gterr.Unauthenticated, "Invalid access token", fmt.Errorf("token type not supported: %v", req.TokenType))
 	}
@@ -158,9 +230,36 @@ func (s *Service) Authenticate(_ context.Context, req Token) (*UserAuthDTO, erro
 		return nil, gterr.New(gterr.Unauthenticated, "Invalid access token")
 	}
 
+	user, err := s.storage.FindUserByEmail(ctx, u.Email)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, gterr.New(gterr.Unauthenticated, "Invalid access token", err)
+	}
+
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+
+	if user.DeactivatedAt != nil {
+		return nil, gterr.New(gterr.Unauthenticated, "Account deactivated, log in again to reactivate it")
+	}
+
 	return u, nil
 }
 
+// Authorize checks if u is an admin user.
+func (s *Service) Authorize(ctx context.Context, u *UserAuthDTO) error {
+	isAdmin, err := s.storage.IsAdmin(ctx, u.Email)
+	if err != nil {
+		return gterr.New(gterr.Internal, "", err)
+	}
+
+	if !isAdmin {
+		return gterr.New(gterr.PermissionDenied, "Admin only")
+	}
+
+	return nil
+}
+
 func hash(pass string) (string, error) {
 	hashed, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
 	if err != nil {
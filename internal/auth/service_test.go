@@ -0,0 +1,143 @@
+package auth_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/victornm/gtonline/internal/auth"
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/storage/memory"
+)
+
+const (
+	me       = "tony@stark.com"
+	password = "abc@123@XYZ"
+)
+
+func makeService(t *testing.T) (*auth.Service, *memory.Storage) {
+	t.Helper()
+
+	mock := memory.NewStorage()
+	cfg := auth.DefaultConfig()
+	cfg.Secret = "secret"
+	s := auth.NewService(mock, cfg)
+
+	_, err := s.Register(context.TODO(), auth.RegisterRequest{
+		Email:                me,
+		Password:             password,
+		PasswordConfirmation: password,
+		FirstName:            "Tony",
+		LastName:             "Stark",
+	})
+	require.NoError(t, err)
+	return s, mock
+}
+
+func login(t *testing.T, s *auth.Service) *auth.LoginResponse {
+	t.Helper()
+
+	res, err := s.Login(context.TODO(), auth.LoginRequest{Email: me, Password: password})
+	require.NoError(t, err)
+	return res
+}
+
+func TestService_DeleteAccount(t *testing.T) {
+	s, _ := makeService(t)
+	ctx := context.TODO()
+
+	token := login(t, s).Token
+	assert.False(t, login(t, s).Reactivated)
+
+	_, err := s.DeleteAccount(ctx, auth.DeleteAccountRequest{Email: me, Password: "wrong"})
+	assert.Equal(t, gterr.PermissionDenied, gterr.Code(err))
+
+	_, err = s.Authenticate(ctx, token)
+	require.NoError(t, err, "the account is still active")
+
+	before := time.Now()
+	res, err := s.DeleteAccount(ctx, auth.DeleteAccountRequest{Email: me, Password: password})
+	require.NoError(t, err)
+	grace := auth.DefaultConfig().DeletionGracePeriod
+	assert.WithinDuration(t, before.Add(grace), res.DeleteAfter, time.Minute)
+
+	_, err = s.Authenticate(ctx, token)
+	assert.Equal(t, gterr.Unauthenticated, gterr.Code(err), "the tokens of a deleted account are rejected")
+
+	t.Run("login cancels the deletion", func(t *testing.T) {
+		res := login(t, s)
+		assert.True(t, res.Reactivated)
+
+		_, err := s.Authenticate(ctx, res.Token)
+		require.NoError(t, err)
+
+		n, err := s.PurgeDeletedAccounts(ctx, time.Now().Add(2*grace), nil)
+		require.NoError(t, err)
+		assert.Zero(t, n)
+		assert.False(t, login(t, s).Reactivated)
+	})
+}
+
+func TestService_PurgeDeletedAccounts(t *testing.T) {
+	s, mock := makeService(t)
+	ctx := context.TODO()
+
+	res, err := s.DeleteAccount(ctx, auth.DeleteAccountRequest{Email: me, Password: password})
+	require.NoError(t, err)
+
+	var handedOver []string
+	handOver := func(_ context.Context, email string) error {
+		handedOver = append(handedOver, email)
+		return nil
+	}
+
+	n, err := s.PurgeDeletedAccounts(ctx, res.DeleteAfter.Add(-time.Second), handOver)
+	require.NoError(t, err)
+	assert.Zero(t, n, "still in the grace period")
+	assert.Empty(t, handedOver)
+
+	u, err := mock.FindUserByEmail(ctx, me)
+	require.NoError(t, err)
+	require.NotNil(t, u.DeleteAfter)
+
+	n, err = s.PurgeDeletedAccounts(ctx, res.DeleteAfter, handOver)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	assert.Equal(t, []string{me}, handedOver)
+
+	_, err = s.Login(ctx, auth.LoginRequest{Email: me, Password: password})
+	assert.Equal(t, gterr.Unauthenticated, gterr.Code(err), "the account is gone")
+}
+
+func TestService_DeactivateAccount(t *testing.T) {
+	s, mock := makeService(t)
+	ctx := context.TODO()
+
+	token := login(t, s).Token
+
+	_, err := s.DeactivateAccount(ctx, auth.DeactivateAccountRequest{Email: me, Password: "wrong"})
+	assert.Equal(t, gterr.PermissionDenied, gterr.Code(err))
+
+	res, err := s.DeactivateAccount(ctx, auth.DeactivateAccountRequest{Email: me, Password: password})
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), res.DeactivatedAt, time.Minute)
+
+	u, err := mock.FindUserByEmail(ctx, me)
+	require.NoError(t, err)
+	require.NotNil(t, u.DeactivatedAt)
+	assert.Nil(t, u.DeleteAfter, "nothing is deleted")
+
+	_, err = s.Authenticate(ctx, token)
+	assert.Equal(t, gterr.Unauthenticated, gterr.Code(err))
+
+	n, err := s.PurgeDeletedAccounts(ctx, time.Now().Add(365*24*time.Hour), nil)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	assert.True(t, login(t, s).Reactivated)
+	_, err = s.Authenticate(ctx, token)
+	assert.NoError(t, err, "the old tokens work again")
+}
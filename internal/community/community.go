@@ -0,0 +1,423 @@
+// Package community manages the groups users create around a topic, the group chats being in the package conversation.
+package community
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/profile"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+type (
+	Service struct {
+		storage  Storage
+		profiles Profiles
+	}
+
+	// Profiles gives the interests of the users to suggest them groups.
+	Profiles interface {
+		GetProfile(ctx context.Context, email string) (*profile.Profile, error)
+	}
+
+	Storage interface {
+		// InsertGroup inserts g with its owner and sets its ID.
+		// It returns storage.ErrAlreadyExist if there is a group with the same name.
+		InsertGroup(ctx context.Context, g *Group, owner *Member) error
+		// GetGroup returns the group with MemberCount counted.
+		GetGroup(ctx context.Context, id int64) (*Group, error)
+		// UpdateGroup returns storage.ErrAlreadyExist if there is another group with the same name.
+		UpdateGroup(ctx context.Context, g *Group) error
+		// DeleteGroup deletes the group with its members, join requests and posts.
+		DeleteGroup(ctx context.Context, id int64) error
+
+		// FindInterestTags returns the tags having one of the slugs as slug or alias, with their aliases.
+		FindInterestTags(ctx context.Context, slugs []string) ([]*profile.InterestTag, error)
+
+		// ListGroups returns the groups matching the filter, the most popular first, with MemberCount counted.
+		// Role is set if filter.Member is given.
+		ListGroups(ctx context.Context, filter GroupFilter) ([]*Group, error)
+
+		// GetGroupMember returns storage.ErrNotFound if email is not a member of the group.
+		GetGroupMember(ctx context.Context, id int64, email string) (*Member, error)
+		// ListGroupMembers returns the members of the group, the earliest first, with Deactivated set.
+		ListGroupMembers(ctx context.Context, id int64) ([]*Member, error)
+		// SaveGroupMember inserts or replaces the member of the group.
+		// It returns storage.ErrInvalidArgument if the group or the user doesn't exist.
+		SaveGroupMember(ctx context.Context, id int64, m *Member) error
+		DeleteGroupMember(ctx context.Context, id int64, email string) error
+
+		// InsertJoinRequest returns storage.ErrAlreadyExist if email already asked to join the group,
+		// and storage.ErrInvalidArgument if the group or the user doesn't exist.
+		InsertJoinRequest(ctx context.Context, id int64, r *JoinRequest) error
+		// ListJoinRequests returns the pending requests of the group, the oldest first, with Deactivated set.
+		ListJoinRequests(ctx context.Context, id int64) ([]*JoinRequest, error)
+		// DeleteJoinRequest returns storage.ErrNotFound if email has no pending request to the group.
+		DeleteJoinRequest(ctx context.Context, id int64, email string) error
+
+		// InsertGroupPost inserts p and sets its ID.
+		InsertGroupPost(ctx context.Context, p *Post) error
+		GetGroupPost(ctx context.Context, id int64) (*Post, error)
+		// ListGroupPosts returns the posts of req.GroupID with an ID smaller than req.Before if given, the newest first.
+		// The posts of the deactivated authors are hidden.
+		ListGroupPosts(ctx context.Context, req ListPostsRequest) ([]*Post, error)
+		DeleteGroupPost(ctx context.Context, id int64) error
+
+		// WithGroupLock runs f while no other WithGroupLock of the same group is running,
+		// so the members are changed atomically, e.g. the owner is handed over when leaving.
+		WithGroupLock(ctx context.Context, id int64, f func(ctx context.Context, tx Storage) error) error
+	}
+)
+
+func NewService(s Storage, p Profiles) *Service {
+	return &Service{storage: s, profiles: p}
+}
+
+// Privacy tells how users join the group.
+type Privacy string
+
+const (
+	// Public groups are joined freely, everyone sees their members and posts.
+	Public Privacy = "public"
+	// Private groups are joined on request, only the members see their members and posts.
+	Private Privacy = "private"
+)
+
+// Role is the role of a member. The owner can do everything,
+// moderators handle the join requests, remove members and delete posts.
+type Role string
+
+const (
+	RoleOwner     Role = "owner"
+	RoleModerator Role = "moderator"
+	RoleMember    Role = "member"
+)
+
+const (
+	defaultLimit = 20
+	maxLimit     = 100
+)
+
+type (
+	Group struct {
+		ID          int64   `json:"id"`
+		Name        string  `json:"name"`
+		Topic       string  `json:"topic"`
+		Description string  `json:"description"`
+		Privacy     Privacy `json:"privacy"`
+		MemberCount int     `json:"member_count"`
+		// Role is the role of the user who asked for the group, empty if not a member.
+		Role Role `json:"role,omitempty"`
+		// Requested tells whether the user who asked for the group has a pending join request.
+		Requested bool      `json:"requested,omitempty"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+
+	Member struct {
+		Email    string    `json:"email"`
+		Role     Role      `json:"role"`
+		JoinedAt time.Time `json:"joined_at"`
+		// Deactivated members keep their role but are hidden from the member list.
+		Deactivated bool `json:"-"`
+	}
+
+	// GroupFilter selects the groups of a member or about a topic.
+	GroupFilter struct {
+		Member string
+		// TopicKeys are the normalized topics, see TopicKey.
+		TopicKeys []string
+		// Exclude excludes the groups of the given member.
+		Exclude string
+		Limit   int
+	}
+
+	CreateGroupRequest struct {
+		Email       string  `json:"-"`
+		Name        string  `json:"name" binding:"required,max=100"`
+		Topic       string  `json:"topic" binding:"required,max=50"`
+		Description string  `json:"description" binding:"max=2000"`
+		Privacy     Privacy `json:"privacy" binding:"required,oneof=public private"`
+	}
+
+	UpdateGroupRequest struct {
+		ID int64 `json:"-"`
+		CreateGroupRequest
+	}
+
+	GetGroupRequest struct {
+		Email string
+		ID    int64
+	}
+
+	ListGroupsRequest struct {
+		Email string `form:"-"`
+		// Topic browses the groups about the topic instead of listing the groups of the user.
+		Topic string `form:"topic"`
+		Limit int    `form:"limit"`
+	}
+
+	ListGroupsResponse struct {
+		Groups []*Group `json:"groups"`
+	}
+)
+
+// TopicKey normalizes a topic or an interest to the slug of the interest catalog, see profile.Slug.
+func TopicKey(topic string) string {
+	return profile.Slug(topic)
+}
+
+// CreateGroup creates a group owned by req.Email.
+func (s *Service) CreateGroup(ctx context.Context, req CreateGroupRequest) (*Group, error) {
+	now := time.Now()
+	g := &Group{CreatedAt: now}
+	if err := g.apply(req); err != nil {
+		return nil, err
+	}
+
+	owner := &Member{Email: req.Email, Role: RoleOwner, JoinedAt: now}
+	err := s.storage.InsertGroup(ctx, g, owner)
+	if errors.Is(err, storage.ErrAlreadyExist) {
+		return nil, gterr.New(gterr.AlreadyExists, fmt.Sprintf("group %s already exists", g.Name), err)
+	}
+	if errors.Is(err, storage.ErrInvalidArgument) {
+		return nil, gterr.New(gterr.NotFound, "user not found", err)
+	}
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+
+	g.MemberCount = 1
+	g.Role = RoleOwner
+	return g, nil
+}
+
+func (g *Group) apply(req CreateGroupRequest) error {
+	name, topic := strings.TrimSpace(req.Name), strings.Join(strings.Fields(req.Topic), " ")
+	if name == "" {
+		return gterr.New(gterr.InvalidArgument, "empty group name")
+	}
+	if topic == "" {
+		return gterr.New(gterr.InvalidArgument, "empty topic")
+	}
+
+	switch req.Privacy {
+	case Public, Private:
+	default:
+		return gterr.New(gterr.InvalidArgument, fmt.Sprintf("invalid privacy: %s", req.Privacy))
+	}
+
+	g.Name = name
+	g.Topic = topic
+	g.Description = strings.TrimSpace(req.Description)
+	g.Privacy = req.Privacy
+	return nil
+}
+
+// GetGroup returns the group with the role of req.Email, every group can be seen so the users can ask to join.
+func (s *Service) GetGroup(ctx context.Context, req GetGroupRequest) (*Group, error) {
+	g, me, err := s.getGroup(ctx, s.storage, req.Email, req.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if me != nil {
+		return g, nil
+	}
+
+	requests, err := s.storage.ListJoinRequests(ctx, g.ID)
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", fmt.Errorf("list join requests: %v", err))
+	}
+	for _, r := range requests {
+		if strings.EqualFold(r.Email, req.Email) {
+			g.Requested = true
+		}
+	}
+	return g, nil
+}
+
+// UpdateGroup changes the details of the group, only its owner can.
+func (s *Service) UpdateGroup(ctx context.Context, req UpdateGroupRequest) (*Group, error) {
+	g, me, err := s.getGroup(ctx, s.storage, req.Email, req.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if me == nil || me.Role != RoleOwner {
+		return nil, gterr.New(gterr.PermissionDenied, "only the owner can change the group")
+	}
+
+	if err := g.apply(req.CreateGroupRequest); err != nil {
+		return nil, err
+	}
+
+	err = s.storage.UpdateGroup(ctx, g)
+	if errors.Is(err, storage.ErrAlreadyExist) {
+		return nil, gterr.New(gterr.AlreadyExists, fmt.Sprintf("group %s already exists", g.Name), err)
+	}
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+	return g, nil
+}
+
+// DeleteGroup deletes the group with its posts, only its owner can.
+func (s *Service) DeleteGroup(ctx context.Context, req GetGroupRequest) error {
+	_, me, err := s.getGroup(ctx, s.storage, req.Email, req.ID)
+	if err != nil {
+		return err
+	}
+
+	if me == nil || me.Role != RoleOwner {
+		return gterr.New(gterr.PermissionDenied, "only the owner can delete the group")
+	}
+
+	if err := s.storage.DeleteGroup(ctx, req.ID); err != nil {
+		return gterr.New(gterr.Internal, "", err)
+	}
+	return nil
+}
+
+// ListGroups returns the groups of req.Email, or the groups about req.Topic if given.
+func (s *Service) ListGroups(ctx context.Context, req ListGroupsRequest) (*ListGroupsResponse, error) {
+	filter := GroupFilter{Member: req.Email, Limit: limit(req.Limit)}
+	if req.Topic != "" {
+		keys, err := s.topicKeys(ctx, []string{req.Topic})
+		if err != nil {
+			return nil, err
+		}
+		if len(keys) == 0 {
+			return &ListGroupsResponse{Groups: []*Group{}}, nil
+		}
+		filter = GroupFilter{TopicKeys: keys, Limit: filter.Limit}
+	}
+
+	groups, err := s.storage.ListGroups(ctx, filter)
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", fmt.Errorf("list groups: %v", err))
+	}
+	if groups == nil {
+		groups = []*Group{}
+	}
+	return &ListGroupsResponse{Groups: groups}, nil
+}
+
+// SuggestGroups returns the groups whose topic is one of the interests of req.Email and which req.Email is not a member of,
+// the most popular first.
+func (s *Service) SuggestGroups(ctx context.Context, req ListGroupsRequest) (*ListGroupsResponse, error) {
+	p, err := s.profiles.GetProfile(ctx, req.Email)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, gterr.New(gterr.NotFound, "user not found", err)
+	}
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", fmt.Errorf("get profile: %v", err))
+	}
+
+	keys, err := s.topicKeys(ctx, p.Interests)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &ListGroupsResponse{Groups: []*Group{}}
+	if len(keys) == 0 {
+		return res, nil
+	}
+
+	groups, err := s.storage.ListGroups(ctx, GroupFilter{TopicKeys: keys, Exclude: req.Email, Limit: limit(req.Limit)})
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", fmt.Errorf("list groups: %v", err))
+	}
+	if groups != nil {
+		res.Groups = groups
+	}
+	return res, nil
+}
+
+// topicKeys returns the keys of the topics with the slugs and aliases of their interest tags,
+// so a topic matches the groups about any spelling of the interest catalog.
+func (s *Service) topicKeys(ctx context.Context, topics []string) ([]string, error) {
+	seen := make(map[string]bool, len(topics))
+	var keys []string
+	add := func(key string) {
+		if key != "" && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+
+	for _, t := range topics {
+		add(TopicKey(t))
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	tags, err := s.storage.FindInterestTags(ctx, keys)
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", fmt.Errorf("find interest tags: %v", err))
+	}
+	for _, t := range tags {
+		add(t.Slug)
+		for _, a := range t.Aliases {
+			add(a)
+		}
+	}
+	return keys, nil
+}
+
+// getGroup returns the group and the membership of email, nil if email is not a member.
+func (s *Service) getGroup(ctx context.Context, tx Storage, email string, id int64) (*Group, *Member, error) {
+	g, err := tx.GetGroup(ctx, id)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil, gterr.New(gterr.NotFound, fmt.Sprintf("group %d not found", id), err)
+	}
+	if err != nil {
+		return nil, nil, gterr.New(gterr.Internal, "", err)
+	}
+
+	me, err := tx.GetGroupMember(ctx, id, email)
+	if errors.Is(err, storage.ErrNotFound) {
+		return g, nil, nil
+	}
+	if err != nil {
+		return nil, nil, gterr.New(gterr.Internal, "", err)
+	}
+
+	g.Role = me.Role
+	return g, me, nil
+}
+
+// getMemberGroup is getGroup for the actions of the members only.
+func (s *Service) getMemberGroup(ctx context.Context, tx Storage, email string, id int64) (*Group, *Member, error) {
+	g, me, err := s.getGroup(ctx, tx, email, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if me == nil {
+		return nil, nil, gterr.New(gterr.PermissionDenied, "not a member of the group")
+	}
+	return g, me, nil
+}
+
+// lockErr converts the errors of the storage lock, the errors of the service pass through.
+func lockErr(err error) error {
+	if _, ok := gterr.FromError(err); err != nil && !ok {
+		return gterr.New(gterr.Internal, "", err)
+	}
+	return err
+}
+
+func limit(n int) int {
+	if n <= 0 {
+		return defaultLimit
+	}
+	if n > maxLimit {
+		return maxLimit
+	}
+	return n
+}
@@ -0,0 +1,451 @@
+package community_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/victornm/gtonline/internal/community"
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/profile"
+	"github.com/victornm/gtonline/internal/storage/memory"
+)
+
+const (
+	owner     = "owner@mock.com"
+	moderator = "moderator@mock.com"
+	member    = "member@mock.com"
+	stranger  = "stranger@mock.com"
+)
+
+func makeService(t *testing.T) (*community.Service, *memory.Storage) {
+	t.Helper()
+
+	mock := memory.NewStorage()
+	mock.InsertUsers([]memory.User{
+		{Email: owner},
+		{Email: moderator},
+		{Email: member},
+		{Email: stranger, Interests: []string{"Board  Games", "hiking", "board games"}},
+	})
+	return community.NewService(mock, mock), mock
+}
+
+func createGroup(t *testing.T, s *community.Service, name string, privacy community.Privacy) *community.Group {
+	t.Helper()
+
+	g, err := s.CreateGroup(context.TODO(), community.CreateGroupRequest{
+		Email:   owner,
+		Name:    name,
+		Topic:   "Board games",
+		Privacy: privacy,
+	})
+	require.NoError(t, err)
+	return g
+}
+
+// addMembers makes moderator a moderator and member a member of the group.
+func addMembers(t *testing.T, s *community.Service, g *community.Group) {
+	t.Helper()
+	ctx := context.TODO()
+
+	for _, email := range []string{moderator, member} {
+		if g.Privacy == community.Public {
+			_, err := s.Join(ctx, community.GetGroupRequest{Email: email, ID: g.ID})
+			require.NoError(t, err)
+			continue
+		}
+
+		_, err := s.Join(ctx, community.GetGroupRequest{Email: email, ID: g.ID})
+		require.NoError(t, err)
+		require.NoError(t, s.ApproveJoinRequest(ctx, community.MemberRequest{Email: owner, GroupID: g.ID, MemberEmail: email}))
+	}
+
+	err := s.UpdateMemberRole(ctx, community.UpdateMemberRoleRequest{Email: owner, GroupID: g.ID, MemberEmail: moderator, Role: community.RoleModerator})
+	require.NoError(t, err)
+}
+
+func TestService_CreateGroup(t *testing.T) {
+	s, _ := makeService(t)
+	ctx := context.TODO()
+	createGroup(t, s, "Chess club", community.Public)
+
+	tests := []struct {
+		name   string
+		modify func(req *community.CreateGroupRequest)
+		code   gterr.ErrorCode
+	}{
+		{name: "ok", modify: func(req *community.CreateGroupRequest) {}, code: gterr.OK},
+		{name: "same name", modify: func(req *community.CreateGroupRequest) { req.Name = "CHESS club" }, code: gterr.AlreadyExists},
+		{name: "blank name", modify: func(req *community.CreateGroupRequest) { req.Name = "  " }, code: gterr.InvalidArgument},
+		{name: "blank topic", modify: func(req *community.CreateGroupRequest) { req.Topic = "  " }, code: gterr.InvalidArgument},
+		{name: "unknown privacy", modify: func(req *community.CreateGroupRequest) { req.Privacy = "secret" }, code: gterr.InvalidArgument},
+		{name: "unknown owner", modify: func(req *community.CreateGroupRequest) { req.Email = "nobody@mock.com" }, code: gterr.NotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := community.CreateGroupRequest{Email: owner, Name: test.name, Topic: "Chess", Privacy: community.Public}
+			test.modify(&req)
+
+			g, err := s.CreateGroup(ctx, req)
+			assert.Equal(t, test.code, gterr.Code(err))
+			if err == nil {
+				assert.NotZero(t, g.ID)
+				assert.Equal(t, community.RoleOwner, g.Role)
+				assert.Equal(t, 1, g.MemberCount)
+			}
+		})
+	}
+}
+
+func TestService_Join(t *testing.T) {
+	s, _ := makeService(t)
+	ctx := context.TODO()
+
+	t.Run("public", func(t *testing.T) {
+		g := createGroup(t, s, "Public", community.Public)
+
+		res, err := s.Join(ctx, community.GetGroupRequest{Email: member, ID: g.ID})
+		require.NoError(t, err)
+		assert.Equal(t, community.Joined, res.Status)
+
+		_, err = s.Join(ctx, community.GetGroupRequest{Email: member, ID: g.ID})
+		assert.Equal(t, gterr.AlreadyExists, gterr.Code(err))
+
+		got, err := s.GetGroup(ctx, community.GetGroupRequest{Email: member, ID: g.ID})
+		require.NoError(t, err)
+		assert.Equal(t, community.RoleMember, got.Role)
+		assert.Equal(t, 2, got.MemberCount)
+	})
+
+	t.Run("private", func(t *testing.T) {
+		g := createGroup(t, s, "Private", community.Private)
+		req := community.GetGroupRequest{Email: member, ID: g.ID}
+
+		res, err := s.Join(ctx, req)
+		require.NoError(t, err)
+		assert.Equal(t, community.Requested, res.Status)
+
+		_, err = s.Join(ctx, req)
+		assert.Equal(t, gterr.AlreadyExists, gterr.Code(err))
+
+		got, err := s.GetGroup(ctx, req)
+		require.NoError(t, err)
+		assert.True(t, got.Requested)
+		assert.Empty(t, got.Role)
+
+		_, err = s.ListPosts(ctx, community.ListPostsRequest{Email: member, GroupID: g.ID})
+		assert.Equal(t, gterr.PermissionDenied, gterr.Code(err), "not a member yet")
+
+		// Only the owner and moderators can approve
+		err = s.ApproveJoinRequest(ctx, community.MemberRequest{Email: member, GroupID: g.ID, MemberEmail: member})
+		assert.Equal(t, gterr.PermissionDenied, gterr.Code(err))
+
+		err = s.ApproveJoinRequest(ctx, community.MemberRequest{Email: owner, GroupID: g.ID, MemberEmail: member})
+		require.NoError(t, err)
+		err = s.ApproveJoinRequest(ctx, community.MemberRequest{Email: owner, GroupID: g.ID, MemberEmail: member})
+		assert.Equal(t, gterr.NotFound, gterr.Code(err), "already approved")
+
+		_, err = s.ListPosts(ctx, community.ListPostsRequest{Email: member, GroupID: g.ID})
+		assert.NoError(t, err)
+	})
+
+	t.Run("reject and cancel", func(t *testing.T) {
+		g := createGroup(t, s, "Rejecting", community.Private)
+		for _, email := range []string{member, stranger} {
+			_, err := s.Join(ctx, community.GetGroupRequest{Email: email, ID: g.ID})
+			require.NoError(t, err)
+		}
+
+		err := s.DeleteJoinRequest(ctx, community.MemberRequest{Email: member, GroupID: g.ID, MemberEmail: stranger})
+		assert.Equal(t, gterr.PermissionDenied, gterr.Code(err), "only the requester can cancel")
+
+		require.NoError(t, s.DeleteJoinRequest(ctx, community.MemberRequest{Email: member, GroupID: g.ID, MemberEmail: member}))
+		require.NoError(t, s.DeleteJoinRequest(ctx, community.MemberRequest{Email: owner, GroupID: g.ID, MemberEmail: stranger}))
+
+		res, err := s.ListJoinRequests(ctx, community.GetGroupRequest{Email: owner, ID: g.ID})
+		require.NoError(t, err)
+		assert.Empty(t, res.Requests)
+	})
+}
+
+func TestService_Roles(t *testing.T) {
+	s, _ := makeService(t)
+	ctx := context.TODO()
+
+	g := createGroup(t, s, "Roles", community.Public)
+	addMembers(t, s, g)
+	_, err := s.Join(ctx, community.GetGroupRequest{Email: stranger, ID: g.ID})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		actor, target string
+		code          gterr.ErrorCode
+	}{
+		{name: "member removes member", actor: member, target: stranger, code: gterr.PermissionDenied},
+		{name: "moderator removes owner", actor: moderator, target: owner, code: gterr.PermissionDenied},
+		{name: "remove self", actor: moderator, target: moderator, code: gterr.InvalidArgument},
+		{name: "not a member", actor: moderator, target: "nobody@mock.com", code: gterr.NotFound},
+		{name: "moderator removes member", actor: moderator, target: stranger, code: gterr.OK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := s.RemoveMember(ctx, community.MemberRequest{Email: test.actor, GroupID: g.ID, MemberEmail: test.target})
+			assert.Equal(t, test.code, gterr.Code(err))
+		})
+	}
+
+	err = s.UpdateMemberRole(ctx, community.UpdateMemberRoleRequest{Email: moderator, GroupID: g.ID, MemberEmail: member, Role: community.RoleModerator})
+	assert.Equal(t, gterr.PermissionDenied, gterr.Code(err), "only the owner changes the roles")
+
+	err = s.UpdateMemberRole(ctx, community.UpdateMemberRoleRequest{Email: owner, GroupID: g.ID, MemberEmail: member, Role: community.RoleOwner})
+	assert.Equal(t, gterr.InvalidArgument, gterr.Code(err))
+
+	res, err := s.ListMembers(ctx, community.GetGroupRequest{Email: stranger, ID: g.ID})
+	require.NoError(t, err)
+	var roles []community.Role
+	for _, m := range res.Members {
+		roles = append(roles, m.Role)
+	}
+	assert.Equal(t, []community.Role{community.RoleOwner, community.RoleModerator, community.RoleMember}, roles)
+}
+
+func TestService_Leave(t *testing.T) {
+	s, _ := makeService(t)
+	ctx := context.TODO()
+
+	g := createGroup(t, s, "Leaving", community.Public)
+	addMembers(t, s, g)
+
+	// The moderator takes over, although the member joined earlier
+	require.NoError(t, s.Leave(ctx, community.GetGroupRequest{Email: owner, ID: g.ID}))
+	got, err := s.GetGroup(ctx, community.GetGroupRequest{Email: moderator, ID: g.ID})
+	require.NoError(t, err)
+	assert.Equal(t, community.RoleOwner, got.Role)
+
+	require.NoError(t, s.Leave(ctx, community.GetGroupRequest{Email: moderator, ID: g.ID}))
+	got, err = s.GetGroup(ctx, community.GetGroupRequest{Email: member, ID: g.ID})
+	require.NoError(t, err)
+	assert.Equal(t, community.RoleOwner, got.Role)
+
+	err = s.Leave(ctx, community.GetGroupRequest{Email: stranger, ID: g.ID})
+	assert.Equal(t, gterr.PermissionDenied, gterr.Code(err))
+
+	// The last member deletes the group
+	require.NoError(t, s.Leave(ctx, community.GetGroupRequest{Email: member, ID: g.ID}))
+	_, err = s.GetGroup(ctx, community.GetGroupRequest{Email: member, ID: g.ID})
+	assert.Equal(t, gterr.NotFound, gterr.Code(err))
+}
+
+func TestService_Leave_Concurrent(t *testing.T) {
+	s, _ := makeService(t)
+	ctx := context.TODO()
+
+	g := createGroup(t, s, "Leaving together", community.Public)
+	addMembers(t, s, g)
+
+	// The owner and its successor leave at the same time, the last member takes over
+	leave := func(emails ...string) {
+		var wg sync.WaitGroup
+		for _, email := range emails {
+			wg.Add(1)
+			go func(email string) {
+				defer wg.Done()
+				assert.NoError(t, s.Leave(ctx, community.GetGroupRequest{Email: email, ID: g.ID}))
+			}(email)
+		}
+		wg.Wait()
+	}
+	leave(owner, moderator)
+
+	res, err := s.ListMembers(ctx, community.GetGroupRequest{Email: member, ID: g.ID})
+	require.NoError(t, err)
+	require.Len(t, res.Members, 1)
+	assert.Equal(t, member, res.Members[0].Email)
+	assert.Equal(t, community.RoleOwner, res.Members[0].Role)
+
+	// The last 2 members leave at the same time, the group is deleted
+	_, err = s.Join(ctx, community.GetGroupRequest{Email: stranger, ID: g.ID})
+	require.NoError(t, err)
+	leave(member, stranger)
+
+	_, err = s.GetGroup(ctx, community.GetGroupRequest{Email: member, ID: g.ID})
+	assert.Equal(t, gterr.NotFound, gterr.Code(err))
+}
+
+func TestService_RemoveUser(t *testing.T) {
+	s, _ := makeService(t)
+	ctx := context.TODO()
+
+	shared := createGroup(t, s, "Shared", community.Private)
+	addMembers(t, s, shared)
+	alone := createGroup(t, s, "Alone", community.Public)
+
+	// The owner is removed before its account is purged, like leaving each group
+	require.NoError(t, s.RemoveUser(ctx, owner))
+
+	got, err := s.GetGroup(ctx, community.GetGroupRequest{Email: moderator, ID: shared.ID})
+	require.NoError(t, err)
+	assert.Equal(t, community.RoleOwner, got.Role)
+
+	_, err = s.GetGroup(ctx, community.GetGroupRequest{Email: member, ID: alone.ID})
+	assert.Equal(t, gterr.NotFound, gterr.Code(err), "the group is deleted with its last member")
+
+	res, err := s.ListGroups(ctx, community.ListGroupsRequest{Email: owner})
+	require.NoError(t, err)
+	assert.Empty(t, res.Groups)
+
+	require.NoError(t, s.RemoveUser(ctx, stranger), "no group to leave")
+}
+
+func TestService_DeactivatedMember(t *testing.T) {
+	s, mock := makeService(t)
+	ctx := context.TODO()
+
+	g := createGroup(t, s, "Resting", community.Public)
+	addMembers(t, s, g)
+	_, err := s.CreatePost(ctx, community.CreatePostRequest{Email: member, GroupID: g.ID, Body: "taking a break"})
+	require.NoError(t, err)
+
+	require.NoError(t, mock.DeactivateUser(ctx, member, time.Now(), nil))
+
+	res, err := s.ListMembers(ctx, community.GetGroupRequest{Email: owner, ID: g.ID})
+	require.NoError(t, err)
+	var got []string
+	for _, m := range res.Members {
+		got = append(got, m.Email)
+	}
+	assert.Equal(t, []string{owner, moderator}, got)
+
+	posts, err := s.ListPosts(ctx, community.ListPostsRequest{Email: owner, GroupID: g.ID, Limit: 10})
+	require.NoError(t, err)
+	assert.Empty(t, posts.Posts)
+
+	// The deactivated member is still a member, the group isn't deleted when the others leave
+	require.NoError(t, s.Leave(ctx, community.GetGroupRequest{Email: owner, ID: g.ID}))
+	require.NoError(t, s.Leave(ctx, community.GetGroupRequest{Email: moderator, ID: g.ID}))
+
+	require.NoError(t, mock.ReactivateUser(ctx, member))
+	got2, err := s.GetGroup(ctx, community.GetGroupRequest{Email: member, ID: g.ID})
+	require.NoError(t, err)
+	assert.Equal(t, community.RoleOwner, got2.Role)
+}
+
+func TestService_Posts(t *testing.T) {
+	s, _ := makeService(t)
+	ctx := context.TODO()
+
+	g := createGroup(t, s, "Posting", community.Public)
+	addMembers(t, s, g)
+
+	_, err := s.CreatePost(ctx, community.CreatePostRequest{Email: stranger, GroupID: g.ID, Body: "hi"})
+	assert.Equal(t, gterr.PermissionDenied, gterr.Code(err), "only the members post")
+
+	var posts []*community.Post
+	for _, email := range []string{owner, member, member, owner, member} {
+		p, err := s.CreatePost(ctx, community.CreatePostRequest{Email: email, GroupID: g.ID, Body: "hello from " + email})
+		require.NoError(t, err)
+		posts = append(posts, p)
+	}
+
+	// The strangers read the posts of a public group
+	res, err := s.ListPosts(ctx, community.ListPostsRequest{Email: stranger, GroupID: g.ID, Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, res.Posts, 2)
+	assert.Equal(t, posts[4].ID, res.Posts[0].ID)
+	assert.Equal(t, posts[3].ID, res.NextBefore)
+
+	res, err = s.ListPosts(ctx, community.ListPostsRequest{Email: stranger, GroupID: g.ID, Before: res.NextBefore, Limit: 3})
+	require.NoError(t, err)
+	assert.Len(t, res.Posts, 3)
+	assert.Zero(t, res.NextBefore)
+
+	tests := []struct {
+		name  string
+		actor string
+		post  *community.Post
+		code  gterr.ErrorCode
+	}{
+		{name: "member deletes other's post", actor: member, post: posts[0], code: gterr.PermissionDenied},
+		{name: "author", actor: member, post: posts[1], code: gterr.OK},
+		{name: "moderator", actor: moderator, post: posts[2], code: gterr.OK},
+		{name: "moderator deletes owner's post", actor: moderator, post: posts[3], code: gterr.OK},
+		{name: "deleted", actor: owner, post: posts[1], code: gterr.NotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := s.DeletePost(ctx, community.DeletePostRequest{Email: test.actor, GroupID: g.ID, PostID: test.post.ID})
+			assert.Equal(t, test.code, gterr.Code(err))
+		})
+	}
+
+	other := createGroup(t, s, "Other", community.Public)
+	err = s.DeletePost(ctx, community.DeletePostRequest{Email: owner, GroupID: other.ID, PostID: posts[0].ID})
+	assert.Equal(t, gterr.NotFound, gterr.Code(err), "post of another group")
+}
+
+func TestService_SuggestGroups(t *testing.T) {
+	s, _ := makeService(t)
+	ctx := context.TODO()
+
+	small := createGroup(t, s, "Small", community.Public)
+	popular := createGroup(t, s, "Popular", community.Private)
+	addMembers(t, s, popular)
+	joined := createGroup(t, s, "Joined", community.Public)
+	_, err := s.Join(ctx, community.GetGroupRequest{Email: stranger, ID: joined.ID})
+	require.NoError(t, err)
+	_, err = s.CreateGroup(ctx, community.CreateGroupRequest{Email: owner, Name: "Chess", Topic: "chess", Privacy: community.Public})
+	require.NoError(t, err)
+
+	res, err := s.SuggestGroups(ctx, community.ListGroupsRequest{Email: stranger})
+	require.NoError(t, err)
+	var ids []int64
+	for _, g := range res.Groups {
+		ids = append(ids, g.ID)
+	}
+	assert.Equal(t, []int64{popular.ID, small.ID}, ids, "matching topics the user is not in, the most popular first")
+
+	// No interest, no suggestion
+	res, err = s.SuggestGroups(ctx, community.ListGroupsRequest{Email: owner})
+	require.NoError(t, err)
+	assert.Empty(t, res.Groups)
+
+	res, err = s.ListGroups(ctx, community.ListGroupsRequest{Email: stranger})
+	require.NoError(t, err)
+	require.Len(t, res.Groups, 1)
+	assert.Equal(t, community.RoleMember, res.Groups[0].Role)
+
+	res, err = s.ListGroups(ctx, community.ListGroupsRequest{Email: stranger, Topic: "BOARD games"})
+	require.NoError(t, err)
+	assert.Len(t, res.Groups, 3)
+}
+
+func TestService_GroupTopics(t *testing.T) {
+	s, mock := makeService(t)
+	ctx := context.TODO()
+
+	for _, tag := range []*profile.InterestTag{{Slug: "golf", Name: "Golf"}, {Slug: "golfing", Name: "Golfing"}} {
+		require.NoError(t, mock.InsertInterestTag(ctx, tag))
+	}
+	golfing, err := s.CreateGroup(ctx, community.CreateGroupRequest{Email: owner, Name: "Golfers", Topic: "Golfing", Privacy: community.Public})
+	require.NoError(t, err)
+	require.NoError(t, mock.MergeInterestTags(ctx, "golfing", "golf", &profile.ProfileVersion{Actor: owner}))
+
+	g, err := s.GetGroup(ctx, community.GetGroupRequest{Email: owner, ID: golfing.ID})
+	require.NoError(t, err)
+	assert.Equal(t, "Golf", g.Topic, "merged with the tags")
+
+	_, err = s.CreateGroup(ctx, community.CreateGroupRequest{Email: owner, Name: "Weekend", Topic: "golfing!", Privacy: community.Public})
+	require.NoError(t, err)
+
+	for _, topic := range []string{"GOLF", "Golfing"} {
+		res, err := s.ListGroups(ctx, community.ListGroupsRequest{Email: stranger, Topic: topic})
+		require.NoError(t, err)
+		assert.Len(t, res.Groups, 2, "any spelling of the interest")
+	}
+}
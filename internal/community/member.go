@@ -0,0 +1,381 @@
+package community
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+type (
+	JoinRequest struct {
+		Email       string    `json:"email"`
+		RequestedAt time.Time `json:"requested_at"`
+		Deactivated bool      `json:"-"`
+	}
+
+	// JoinResponse tells whether the user joined the group or asked to.
+	JoinResponse struct {
+		Status JoinStatus `json:"status"`
+	}
+
+	JoinStatus string
+
+	MemberRequest struct {
+		Email       string
+		GroupID     int64
+		MemberEmail string
+	}
+
+	UpdateMemberRoleRequest struct {
+		Email       string `json:"-"`
+		GroupID     int64  `json:"-"`
+		MemberEmail string `json:"-"`
+		Role        Role   `json:"role" binding:"required,oneof=moderator member"`
+	}
+
+	ListMembersResponse struct {
+		Members []*Member `json:"members"`
+	}
+
+	ListJoinRequestsResponse struct {
+		Requests []*JoinRequest `json:"requests"`
+	}
+)
+
+const (
+	Joined    JoinStatus = "joined"
+	Requested JoinStatus = "requested"
+)
+
+// Join adds req.Email to a public group, or sends a join request to the moderators of a private group.
+func (s *Service) Join(ctx context.Context, req GetGroupRequest) (*JoinResponse, error) {
+	var res *JoinResponse
+	err := s.storage.WithGroupLock(ctx, req.ID, func(ctx context.Context, tx Storage) error {
+		g, me, err := s.getGroup(ctx, tx, req.Email, req.ID)
+		if err != nil {
+			return err
+		}
+
+		if me != nil {
+			return gterr.New(gterr.AlreadyExists, fmt.Sprintf("%s is already a member", req.Email))
+		}
+
+		now := time.Now()
+		if g.Privacy == Public {
+			if err := s.saveMember(ctx, tx, g.ID, &Member{Email: req.Email, Role: RoleMember, JoinedAt: now}); err != nil {
+				return err
+			}
+			res = &JoinResponse{Status: Joined}
+			return nil
+		}
+
+		err = tx.InsertJoinRequest(ctx, g.ID, &JoinRequest{Email: req.Email, RequestedAt: now})
+		if errors.Is(err, storage.ErrAlreadyExist) {
+			return gterr.New(gterr.AlreadyExists, fmt.Sprintf("%s already asked to join", req.Email), err)
+		}
+		if errors.Is(err, storage.ErrInvalidArgument) {
+			return gterr.New(gterr.NotFound, "user not found", err)
+		}
+		if err != nil {
+			return gterr.New(gterr.Internal, "", err)
+		}
+		res = &JoinResponse{Status: Requested}
+		return nil
+	})
+	if err != nil {
+		return nil, lockErr(err)
+	}
+	return res, nil
+}
+
+// Leave removes req.Email from the group. When the owner leaves, the earliest moderator becomes the owner,
+// or the earliest member if there is no moderator. The group is deleted with its last member.
+func (s *Service) Leave(ctx context.Context, req GetGroupRequest) error {
+	err := s.storage.WithGroupLock(ctx, req.ID, func(ctx context.Context, tx Storage) error {
+		g, me, err := s.getMemberGroup(ctx, tx, req.Email, req.ID)
+		if err != nil {
+			return err
+		}
+		return s.removeMember(ctx, tx, g.ID, me)
+	})
+	return lockErr(err)
+}
+
+// RemoveUser removes email from all its groups like Leave, before its account is deleted.
+func (s *Service) RemoveUser(ctx context.Context, email string) error {
+	for {
+		// Each group left is not listed again, so the groups are listed until none is left
+		groups, err := s.storage.ListGroups(ctx, GroupFilter{Member: email, Limit: maxLimit})
+		if err != nil {
+			return gterr.New(gterr.Internal, "", fmt.Errorf("list groups: %v", err))
+		}
+		if len(groups) == 0 {
+			return nil
+		}
+
+		for _, g := range groups {
+			err := s.storage.WithGroupLock(ctx, g.ID, func(ctx context.Context, tx Storage) error {
+				me, err := tx.GetGroupMember(ctx, g.ID, email)
+				if errors.Is(err, storage.ErrNotFound) {
+					// Left or removed in the meantime
+					return nil
+				}
+				if err != nil {
+					return gterr.New(gterr.Internal, "", err)
+				}
+				return s.removeMember(ctx, tx, g.ID, me)
+			})
+			if err != nil {
+				return lockErr(err)
+			}
+		}
+	}
+}
+
+// removeMember removes me from the group and hands the ownership over, it must run in WithGroupLock.
+func (s *Service) removeMember(ctx context.Context, tx Storage, id int64, me *Member) error {
+	members, err := tx.ListGroupMembers(ctx, id)
+	if err != nil {
+		return gterr.New(gterr.Internal, "", fmt.Errorf("list members: %v", err))
+	}
+
+	var others []*Member
+	for _, m := range members {
+		if !strings.EqualFold(m.Email, me.Email) {
+			others = append(others, m)
+		}
+	}
+
+	if len(others) == 0 {
+		if err := tx.DeleteGroup(ctx, id); err != nil {
+			return gterr.New(gterr.Internal, "", err)
+		}
+		return nil
+	}
+
+	if err := tx.DeleteGroupMember(ctx, id, me.Email); err != nil {
+		return gterr.New(gterr.Internal, "", err)
+	}
+
+	if me.Role == RoleOwner {
+		next := nextOwner(others)
+		next.Role = RoleOwner
+		return s.saveMember(ctx, tx, id, next)
+	}
+	return nil
+}
+
+// ListMembers returns the members of the group, only the members see the members of a private group.
+func (s *Service) ListMembers(ctx context.Context, req GetGroupRequest) (*ListMembersResponse, error) {
+	if _, err := s.getReadableGroup(ctx, req.Email, req.ID); err != nil {
+		return nil, err
+	}
+
+	members, err := s.storage.ListGroupMembers(ctx, req.ID)
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", fmt.Errorf("list members: %v", err))
+	}
+
+	visible := make([]*Member, 0, len(members))
+	for _, m := range members {
+		if !m.Deactivated {
+			visible = append(visible, m)
+		}
+	}
+	return &ListMembersResponse{Members: visible}, nil
+}
+
+// RemoveMember removes a member from the group. The owner can remove anyone, moderators only the members.
+func (s *Service) RemoveMember(ctx context.Context, req MemberRequest) error {
+	if strings.EqualFold(req.Email, req.MemberEmail) {
+		return gterr.New(gterr.InvalidArgument, "leave the group instead of removing yourself")
+	}
+
+	err := s.storage.WithGroupLock(ctx, req.GroupID, func(ctx context.Context, tx Storage) error {
+		g, me, err := s.getMemberGroup(ctx, tx, req.Email, req.GroupID)
+		if err != nil {
+			return err
+		}
+
+		target, err := s.getMember(ctx, tx, g.ID, req.MemberEmail)
+		if err != nil {
+			return err
+		}
+
+		if !canManage(me.Role, target.Role) {
+			return gterr.New(gterr.PermissionDenied, fmt.Sprintf("%s can not remove %s", me.Role, target.Role))
+		}
+
+		if err := tx.DeleteGroupMember(ctx, g.ID, target.Email); err != nil {
+			return gterr.New(gterr.Internal, "", err)
+		}
+		return nil
+	})
+	return lockErr(err)
+}
+
+// UpdateMemberRole promotes a member to moderator or demotes a moderator, only the owner can do it.
+func (s *Service) UpdateMemberRole(ctx context.Context, req UpdateMemberRoleRequest) error {
+	if req.Role != RoleModerator && req.Role != RoleMember {
+		return gterr.New(gterr.InvalidArgument, fmt.Sprintf("invalid role: %s", req.Role))
+	}
+
+	err := s.storage.WithGroupLock(ctx, req.GroupID, func(ctx context.Context, tx Storage) error {
+		g, me, err := s.getMemberGroup(ctx, tx, req.Email, req.GroupID)
+		if err != nil {
+			return err
+		}
+
+		if me.Role != RoleOwner {
+			return gterr.New(gterr.PermissionDenied, "only the owner can change the roles")
+		}
+
+		target, err := s.getMember(ctx, tx, g.ID, req.MemberEmail)
+		if err != nil {
+			return err
+		}
+
+		if target.Role == RoleOwner {
+			return gterr.New(gterr.InvalidArgument, "can not change the role of the owner")
+		}
+
+		target.Role = req.Role
+		return s.saveMember(ctx, tx, g.ID, target)
+	})
+	return lockErr(err)
+}
+
+// ListJoinRequests returns the pending join requests of the group to its owner and moderators.
+func (s *Service) ListJoinRequests(ctx context.Context, req GetGroupRequest) (*ListJoinRequestsResponse, error) {
+	g, me, err := s.getMemberGroup(ctx, s.storage, req.Email, req.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !canManage(me.Role, RoleMember) {
+		return nil, gterr.New(gterr.PermissionDenied, "only the owner and moderators see the join requests")
+	}
+
+	requests, err := s.storage.ListJoinRequests(ctx, g.ID)
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", fmt.Errorf("list join requests: %v", err))
+	}
+
+	visible := make([]*JoinRequest, 0, len(requests))
+	for _, r := range requests {
+		if !r.Deactivated {
+			visible = append(visible, r)
+		}
+	}
+	return &ListJoinRequestsResponse{Requests: visible}, nil
+}
+
+// ApproveJoinRequest makes the requester a member, only the owner and moderators can do it.
+func (s *Service) ApproveJoinRequest(ctx context.Context, req MemberRequest) error {
+	err := s.storage.WithGroupLock(ctx, req.GroupID, func(ctx context.Context, tx Storage) error {
+		g, me, err := s.getMemberGroup(ctx, tx, req.Email, req.GroupID)
+		if err != nil {
+			return err
+		}
+
+		if !canManage(me.Role, RoleMember) {
+			return gterr.New(gterr.PermissionDenied, "only the owner and moderators can approve the join requests")
+		}
+
+		if err := s.deleteJoinRequest(ctx, tx, g.ID, req.MemberEmail); err != nil {
+			return err
+		}
+		return s.saveMember(ctx, tx, g.ID, &Member{Email: req.MemberEmail, Role: RoleMember, JoinedAt: time.Now()})
+	})
+	return lockErr(err)
+}
+
+// DeleteJoinRequest rejects the join request, or cancels it if req.Email is the requester.
+func (s *Service) DeleteJoinRequest(ctx context.Context, req MemberRequest) error {
+	g, me, err := s.getGroup(ctx, s.storage, req.Email, req.GroupID)
+	if err != nil {
+		return err
+	}
+
+	own := strings.EqualFold(req.Email, req.MemberEmail)
+	if !own && (me == nil || !canManage(me.Role, RoleMember)) {
+		return gterr.New(gterr.PermissionDenied, "only the owner and moderators can reject the join requests")
+	}
+
+	return s.deleteJoinRequest(ctx, s.storage, g.ID, req.MemberEmail)
+}
+
+func (s *Service) deleteJoinRequest(ctx context.Context, tx Storage, id int64, email string) error {
+	err := tx.DeleteJoinRequest(ctx, id, email)
+	if errors.Is(err, storage.ErrNotFound) {
+		return gterr.New(gterr.NotFound, fmt.Sprintf("%s has no join request", email), err)
+	}
+	if err != nil {
+		return gterr.New(gterr.Internal, "", err)
+	}
+	return nil
+}
+
+// getReadableGroup returns the group if email can see its members and posts.
+func (s *Service) getReadableGroup(ctx context.Context, email string, id int64) (*Group, error) {
+	g, me, err := s.getGroup(ctx, s.storage, email, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if g.Privacy == Private && me == nil {
+		return nil, gterr.New(gterr.PermissionDenied, "only the members see the content of a private group")
+	}
+	return g, nil
+}
+
+func (s *Service) getMember(ctx context.Context, tx Storage, id int64, email string) (*Member, error) {
+	m, err := tx.GetGroupMember(ctx, id, email)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, gterr.New(gterr.NotFound, fmt.Sprintf("%s is not a member", email), err)
+	}
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+	return m, nil
+}
+
+func (s *Service) saveMember(ctx context.Context, tx Storage, id int64, m *Member) error {
+	err := tx.SaveGroupMember(ctx, id, m)
+	if errors.Is(err, storage.ErrInvalidArgument) {
+		return gterr.New(gterr.NotFound, "user not found", err)
+	}
+	if err != nil {
+		return gterr.New(gterr.Internal, "", err)
+	}
+	return nil
+}
+
+func canManage(actor, target Role) bool {
+	switch actor {
+	case RoleOwner:
+		return true
+	case RoleModerator:
+		return target == RoleMember
+	default:
+		return false
+	}
+}
+
+func nextOwner(members []*Member) *Member {
+	sorted := make([]*Member, len(members))
+	copy(sorted, members)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if (sorted[i].Role == RoleModerator) != (sorted[j].Role == RoleModerator) {
+			return sorted[i].Role == RoleModerator
+		}
+		return sorted[i].JoinedAt.Before(sorted[j].JoinedAt)
+	})
+	return sorted[0]
+}
@@ -0,0 +1,119 @@
+package community
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+type (
+	Post struct {
+		ID        int64     `json:"id"`
+		GroupID   int64     `json:"group_id"`
+		Author    string    `json:"author"`
+		Body      string    `json:"body"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+
+	CreatePostRequest struct {
+		Email   string `json:"-"`
+		GroupID int64  `json:"-"`
+		Body    string `json:"body" binding:"required,max=5000"`
+	}
+
+	ListPostsRequest struct {
+		Email   string `form:"-"`
+		GroupID int64  `form:"-"`
+		// Before is the cursor for pagination, only the posts with a smaller ID are returned.
+		Before int64 `form:"before"`
+		Limit  int   `form:"limit"`
+	}
+
+	ListPostsResponse struct {
+		Posts []*Post `json:"posts"`
+		// NextBefore is the Before of the next page, 0 if there is no more post.
+		NextBefore int64 `json:"next_before,omitempty"`
+	}
+
+	DeletePostRequest struct {
+		Email   string
+		GroupID int64
+		PostID  int64
+	}
+)
+
+// CreatePost posts to the group, only the members can.
+func (s *Service) CreatePost(ctx context.Context, req CreatePostRequest) (*Post, error) {
+	body := strings.TrimSpace(req.Body)
+	if body == "" {
+		return nil, gterr.New(gterr.InvalidArgument, "empty post")
+	}
+
+	g, me, err := s.getMemberGroup(ctx, s.storage, req.Email, req.GroupID)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Post{GroupID: g.ID, Author: me.Email, Body: body, CreatedAt: time.Now()}
+	if err := s.storage.InsertGroupPost(ctx, p); err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+	return p, nil
+}
+
+// ListPosts returns the posts of the group, the newest first. Only the members see the posts of a private group.
+func (s *Service) ListPosts(ctx context.Context, req ListPostsRequest) (*ListPostsResponse, error) {
+	if _, err := s.getReadableGroup(ctx, req.Email, req.GroupID); err != nil {
+		return nil, err
+	}
+
+	req.Limit = limit(req.Limit)
+	// Query one more to know if there is a next page
+	query := req
+	query.Limit++
+
+	posts, err := s.storage.ListGroupPosts(ctx, query)
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", fmt.Errorf("list posts: %v", err))
+	}
+
+	res := &ListPostsResponse{Posts: posts}
+	if len(posts) > req.Limit {
+		res.Posts = posts[:req.Limit]
+		res.NextBefore = res.Posts[req.Limit-1].ID
+	}
+	if res.Posts == nil {
+		res.Posts = []*Post{}
+	}
+	return res, nil
+}
+
+// DeletePost deletes a post of the group, its author and the owner and moderators can.
+func (s *Service) DeletePost(ctx context.Context, req DeletePostRequest) error {
+	g, me, err := s.getMemberGroup(ctx, s.storage, req.Email, req.GroupID)
+	if err != nil {
+		return err
+	}
+
+	p, err := s.storage.GetGroupPost(ctx, req.PostID)
+	if errors.Is(err, storage.ErrNotFound) || (err == nil && p.GroupID != g.ID) {
+		return gterr.New(gterr.NotFound, fmt.Sprintf("post %d not found", req.PostID))
+	}
+	if err != nil {
+		return gterr.New(gterr.Internal, "", err)
+	}
+
+	if !strings.EqualFold(p.Author, me.Email) && !canManage(me.Role, RoleMember) {
+		return gterr.New(gterr.PermissionDenied, "only the author, the owner and moderators can delete the post")
+	}
+
+	if err := s.storage.DeleteGroupPost(ctx, p.ID); err != nil {
+		return gterr.New(gterr.Internal, "", err)
+	}
+	return nil
+}
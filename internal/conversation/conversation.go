@@ -0,0 +1,497 @@
+package conversation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/victornm/gtonline/internal/friend"
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/realtime"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+type (
+	Service struct {
+		storage   Storage
+		friends   friend.Storage
+		publisher Publisher
+		cfg       Config
+	}
+
+	Config struct {
+		Group GroupConfig `mapstructure:"group"`
+	}
+
+	// Publisher pushes the new messages to the connected participants.
+	Publisher interface {
+		Publish(ctx context.Context, email string, e realtime.Event) error
+	}
+
+	Storage interface {
+		// GetDirectConversation returns storage.ErrNotFound if the 2 users of the key never talked.
+		GetDirectConversation(ctx context.Context, directKey string) (*Conversation, error)
+		// InsertConversation inserts c with its participants and sets its ID.
+		// It returns storage.ErrAlreadyExist if there is a direct conversation with the same key.
+		InsertConversation(ctx context.Context, c *Conversation) error
+		// GetConversation returns the conversation with its participants.
+		GetConversation(ctx context.Context, id int64) (*Conversation, error)
+		// ListConversations returns the conversations of email which have a visible message,
+		// with the participants, last message and unread count filled, the most recently active first.
+		ListConversations(ctx context.Context, email string) ([]*Conversation, error)
+		// ListGroupConversationIDs returns the IDs of all the group conversations of email, with or without messages.
+		ListGroupConversationIDs(ctx context.Context, email string) ([]int64, error)
+		UpdateConversation(ctx context.Context, c *Conversation) error
+		// InsertParticipant returns storage.ErrAlreadyExist if email is already a participant.
+		InsertParticipant(ctx context.Context, conversationID int64, p *Participant) error
+		DeleteParticipant(ctx context.Context, conversationID int64, email string) error
+		// InsertMessage inserts m and sets its ID.
+		InsertMessage(ctx context.Context, m *Message) error
+		GetMessage(ctx context.Context, id int64) (*Message, error)
+		// ListMessages returns the messages visible to req.Email, the newest first.
+		ListMessages(ctx context.Context, req ListMessagesRequest) ([]*Message, error)
+		UpdateParticipant(ctx context.Context, conversationID int64, p *Participant) error
+		// HideMessage hides the message from email only, the other participants still see it.
+		HideMessage(ctx context.Context, messageID int64, email string) error
+
+		// WithConversationLock runs f while no other WithConversationLock of the same conversation is running,
+		// so the members are checked and changed atomically.
+		WithConversationLock(ctx context.Context, id int64, f func(ctx context.Context, tx Storage) error) error
+	}
+)
+
+func NewService(s Storage, friends friend.Storage, p Publisher, cfg Config) *Service {
+	return &Service{storage: s, friends: friends, publisher: p, cfg: cfg.withDefaults()}
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Group: GroupConfig{
+			MaxMembers: 50,
+		},
+	}
+}
+
+func (c Config) withDefaults() Config {
+	d := DefaultConfig()
+	if c.Group.MaxMembers <= 0 {
+		c.Group.MaxMembers = d.Group.MaxMembers
+	}
+	return c
+}
+
+// EventMessageCreated is pushed to all the participants when a message is sent.
+const EventMessageCreated = "message.created"
+
+type MessageType string
+
+// System messages are sent by the service when the group changes, so the history shows who did what.
+const (
+	MessageText          MessageType = "text"
+	MessageGroupCreated  MessageType = "group.created"
+	MessageGroupRenamed  MessageType = "group.renamed"
+	MessageMemberAdded   MessageType = "member.added"
+	MessageMemberRemoved MessageType = "member.removed"
+	MessageMemberLeft    MessageType = "member.left"
+)
+
+const (
+	defaultLimit = 50
+	maxLimit     = 100
+)
+
+type (
+	Conversation struct {
+		ID int64 `json:"id"`
+		// DirectKey identifies the one-to-one conversation of 2 users, it is empty for groups.
+		DirectKey string `json:"-"`
+		// Name is only set for groups.
+		Name         string         `json:"name,omitempty"`
+		Participants []*Participant `json:"participants"`
+		LastMessage  *Message       `json:"last_message,omitempty"`
+		UnreadCount  int            `json:"unread_count"`
+		CreatedAt    time.Time      `json:"created_at"`
+	}
+
+	Participant struct {
+		Email string `json:"email"`
+		// Role is only set for groups.
+		Role     Role      `json:"role,omitempty"`
+		JoinedAt time.Time `json:"joined_at"`
+		// LastReadMessageID is the read receipt of the participant.
+		LastReadMessageID int64 `json:"last_read_message_id"`
+		// ClearedMessageID hides the messages up to it from the participant, it is set when the conversation is deleted.
+		ClearedMessageID int64 `json:"-"`
+	}
+
+	Message struct {
+		ID             int64       `json:"id"`
+		ConversationID int64       `json:"conversation_id"`
+		Type           MessageType `json:"type"`
+		// Sender is the actor of system messages.
+		Sender string `json:"sender"`
+		// Body is the target email of membership changes, the new name when the group is renamed.
+		Body      string    `json:"body"`
+		CreatedAt time.Time `json:"created_at"`
+		// ReadBy are the other participants who have read the message.
+		ReadBy []string `json:"read_by,omitempty"`
+	}
+
+	ListConversationsResponse struct {
+		Conversations []*Conversation `json:"conversations"`
+	}
+
+	SendMessageRequest struct {
+		Email          string `json:"-"`
+		ConversationID int64  `json:"-"`
+		Body           string `json:"body" binding:"required,max=2000"`
+	}
+
+	SendDirectMessageRequest struct {
+		Email       string `json:"-"`
+		FriendEmail string `json:"-"`
+		Body        string `json:"body" binding:"required,max=2000"`
+	}
+
+	ListMessagesRequest struct {
+		Email          string `form:"-"`
+		ConversationID int64  `form:"-"`
+		// Before is the cursor for pagination, only messages sent before the message with this ID are returned.
+		Before int64 `form:"before" binding:"gte=0"`
+		Limit  int   `form:"limit" binding:"gte=0,lte=100"`
+	}
+
+	ListMessagesResponse struct {
+		Messages     []*Message     `json:"messages"`
+		Participants []*Participant `json:"participants"`
+		// NextBefore is the cursor of the next page, 0 if there is no more message.
+		NextBefore int64 `json:"next_before,omitempty"`
+	}
+
+	DeleteMessageRequest struct {
+		Email          string
+		ConversationID int64
+		MessageID      int64
+	}
+)
+
+func (s *Service) ListConversations(ctx context.Context, email string) (*ListConversationsResponse, error) {
+	conversations, err := s.storage.ListConversations(ctx, email)
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+
+	for _, c := range conversations {
+		if c.LastMessage != nil {
+			c.LastMessage.ReadBy = readBy(c.LastMessage, c.Participants)
+		}
+	}
+
+	return &ListConversationsResponse{Conversations: conversations}, nil
+}
+
+// SendDirectMessage sends a message to a friend, the conversation is created on the first message.
+func (s *Service) SendDirectMessage(ctx context.Context, req SendDirectMessageRequest) (*Message, error) {
+	if strings.EqualFold(req.Email, req.FriendEmail) {
+		return nil, gterr.New(gterr.InvalidArgument, "can not send message to yourself")
+	}
+
+	if err := s.checkFriends(ctx, req.Email, req.FriendEmail); err != nil {
+		return nil, err
+	}
+
+	c, err := s.getOrCreateDirect(ctx, req.Email, req.FriendEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.send(ctx, c, req.Email, req.Body)
+}
+
+// SendMessage sends a message to an existing conversation, direct or group.
+func (s *Service) SendMessage(ctx context.Context, req SendMessageRequest) (*Message, error) {
+	c, _, err := s.getConversation(ctx, s.storage, req.Email, req.ConversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !c.IsGroup() {
+		for _, p := range c.Participants {
+			if strings.EqualFold(p.Email, req.Email) {
+				continue
+			}
+			if err := s.checkFriends(ctx, req.Email, p.Email); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return s.send(ctx, c, req.Email, req.Body)
+}
+
+func (s *Service) ListMessages(ctx context.Context, req ListMessagesRequest) (*ListMessagesResponse, error) {
+	c, me, err := s.getConversation(ctx, s.storage, req.Email, req.ConversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Limit <= 0 {
+		req.Limit = defaultLimit
+	}
+	if req.Limit > maxLimit {
+		req.Limit = maxLimit
+	}
+
+	// Query 1 more to know if there is a next page
+	limit := req.Limit
+	req.Limit++
+	req.Email = me.Email
+	messages, err := s.storage.ListMessages(ctx, req)
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+
+	res := &ListMessagesResponse{Messages: messages, Participants: c.Participants}
+	if len(messages) > limit {
+		res.Messages = messages[:limit]
+		res.NextBefore = messages[limit-1].ID
+	}
+
+	for _, m := range res.Messages {
+		m.ReadBy = readBy(m, c.Participants)
+	}
+
+	return res, nil
+}
+
+// MarkRead moves the read receipt of the user to the last message of the conversation.
+func (s *Service) MarkRead(ctx context.Context, email string, conversationID int64) error {
+	_, me, err := s.getConversation(ctx, s.storage, email, conversationID)
+	if err != nil {
+		return err
+	}
+
+	last, err := s.lastMessageID(ctx, me.Email, conversationID)
+	if err != nil {
+		return err
+	}
+
+	if last <= me.LastReadMessageID {
+		return nil
+	}
+
+	me.LastReadMessageID = last
+	if err := s.storage.UpdateParticipant(ctx, conversationID, me); err != nil {
+		return gterr.New(gterr.Internal, "", err)
+	}
+	return nil
+}
+
+// DeleteConversation hides the current history from the user only.
+// The conversation shows up again when a new message arrives.
+func (s *Service) DeleteConversation(ctx context.Context, email string, conversationID int64) error {
+	_, me, err := s.getConversation(ctx, s.storage, email, conversationID)
+	if err != nil {
+		return err
+	}
+
+	last, err := s.lastMessageID(ctx, me.Email, conversationID)
+	if err != nil {
+		return err
+	}
+
+	if last == 0 {
+		return nil
+	}
+
+	me.ClearedMessageID = last
+	if last > me.LastReadMessageID {
+		me.LastReadMessageID = last
+	}
+	if err := s.storage.UpdateParticipant(ctx, conversationID, me); err != nil {
+		return gterr.New(gterr.Internal, "", err)
+	}
+	return nil
+}
+
+// DeleteMessage hides the message from the user only.
+func (s *Service) DeleteMessage(ctx context.Context, req DeleteMessageRequest) error {
+	_, me, err := s.getConversation(ctx, s.storage, req.Email, req.ConversationID)
+	if err != nil {
+		return err
+	}
+
+	m, err := s.storage.GetMessage(ctx, req.MessageID)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return gterr.New(gterr.Internal, "", err)
+	}
+
+	if errors.Is(err, storage.ErrNotFound) || m.ConversationID != req.ConversationID || m.ID <= me.ClearedMessageID {
+		return gterr.New(gterr.NotFound, fmt.Sprintf("message %d not found", req.MessageID))
+	}
+
+	if err := s.storage.HideMessage(ctx, m.ID, me.Email); err != nil {
+		return gterr.New(gterr.Internal, "", err)
+	}
+	return nil
+}
+
+func (s *Service) checkFriends(ctx context.Context, email, friendEmail string) error {
+	ok, err := friend.AreFriends(ctx, s.friends, email, friendEmail)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return gterr.New(gterr.FailedPrecondition, fmt.Sprintf("%s is not a friend of %s", friendEmail, email))
+	}
+	return nil
+}
+
+func (s *Service) getOrCreateDirect(ctx context.Context, email, friendEmail string) (*Conversation, error) {
+	key := directKey(email, friendEmail)
+
+	c, err := s.storage.GetDirectConversation(ctx, key)
+	if err == nil {
+		return c, nil
+	}
+
+	if !errors.Is(err, storage.ErrNotFound) {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+
+	now := time.Now()
+	c = &Conversation{
+		DirectKey:    key,
+		Participants: []*Participant{{Email: email, JoinedAt: now}, {Email: friendEmail, JoinedAt: now}},
+		CreatedAt:    now,
+	}
+	err = s.storage.InsertConversation(ctx, c)
+
+	// The friend sent the first message at the same time
+	if errors.Is(err, storage.ErrAlreadyExist) {
+		c, err = s.storage.GetDirectConversation(ctx, key)
+	}
+
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+
+	return c, nil
+}
+
+func (s *Service) send(ctx context.Context, c *Conversation, sender, body string) (*Message, error) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return nil, gterr.New(gterr.InvalidArgument, "empty message")
+	}
+
+	return s.insertMessage(ctx, c, &Message{
+		ConversationID: c.ID,
+		Type:           MessageText,
+		Sender:         sender,
+		Body:           body,
+		CreatedAt:      time.Now(),
+	})
+}
+
+func (s *Service) insertMessage(ctx context.Context, c *Conversation, m *Message) (*Message, error) {
+	if err := s.storage.InsertMessage(ctx, m); err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+
+	// The sender has obviously read its own message
+	for _, p := range c.Participants {
+		if !strings.EqualFold(p.Email, m.Sender) {
+			continue
+		}
+
+		p.LastReadMessageID = m.ID
+		if err := s.storage.UpdateParticipant(ctx, c.ID, p); err != nil {
+			return nil, gterr.New(gterr.Internal, "", err)
+		}
+	}
+
+	s.publish(ctx, c, m)
+	return m, nil
+}
+
+func (s *Service) publish(ctx context.Context, c *Conversation, m *Message) {
+	if s.publisher == nil {
+		return
+	}
+
+	// The message is already stored, the participants will see it on next load
+	for _, p := range c.Participants {
+		if err := s.publisher.Publish(ctx, p.Email, realtime.Event{Type: EventMessageCreated, Data: m}); err != nil {
+			log.Printf("publish message %d to %s: %v", m.ID, p.Email, err)
+		}
+	}
+}
+
+// getConversation returns the conversation and the participant of email.
+// Conversations of other users are reported as not found, so their existence is not leaked.
+func (s *Service) getConversation(ctx context.Context, tx Storage, email string, id int64) (*Conversation, *Participant, error) {
+	c, err := tx.GetConversation(ctx, id)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, nil, gterr.New(gterr.Internal, "", err)
+	}
+
+	if err == nil {
+		if p := c.participant(email); p != nil {
+			return c, p, nil
+		}
+	}
+
+	return nil, nil, gterr.New(gterr.NotFound, fmt.Sprintf("conversation %d not found", id))
+}
+
+// lastMessageID returns the ID of the last message visible to email, 0 if there is none.
+func (s *Service) lastMessageID(ctx context.Context, email string, conversationID int64) (int64, error) {
+	messages, err := s.storage.ListMessages(ctx, ListMessagesRequest{
+		Email:          email,
+		ConversationID: conversationID,
+		Limit:          1,
+	})
+	if err != nil {
+		return 0, gterr.New(gterr.Internal, "", err)
+	}
+
+	if len(messages) == 0 {
+		return 0, nil
+	}
+	return messages[0].ID, nil
+}
+
+func (c *Conversation) IsGroup() bool {
+	return c.DirectKey == ""
+}
+
+func (c *Conversation) participant(email string) *Participant {
+	for _, p := range c.Participants {
+		if strings.EqualFold(p.Email, email) {
+			return p
+		}
+	}
+	return nil
+}
+
+func readBy(m *Message, participants []*Participant) []string {
+	var res []string
+	for _, p := range participants {
+		if !strings.EqualFold(p.Email, m.Sender) && p.LastReadMessageID >= m.ID {
+			res = append(res, p.Email)
+		}
+	}
+	return res
+}
+
+func directKey(email, friendEmail string) string {
+	emails := []string{strings.ToLower(email), strings.ToLower(friendEmail)}
+	sort.Strings(emails)
+	return strings.Join(emails, " ")
+}
@@ -0,0 +1,209 @@
+package conversation_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/victornm/gtonline/internal/conversation"
+	"github.com/victornm/gtonline/internal/friend"
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/storage/memory"
+)
+
+func TestService_SendDirectMessage(t *testing.T) {
+	s := makeService(t)
+
+	t.Run("only friends can talk", func(t *testing.T) {
+		_, err := s.SendDirectMessage(context.TODO(), conversation.SendDirectMessageRequest{
+			Email:       "foo@mock.com",
+			FriendEmail: "baz@mock.com",
+			Body:        "hi",
+		})
+		assert.Equal(t, gterr.FailedPrecondition, gterr.Code(err))
+
+		_, err = s.SendDirectMessage(context.TODO(), conversation.SendDirectMessageRequest{
+			Email:       "foo@mock.com",
+			FriendEmail: "foo@mock.com",
+			Body:        "hi",
+		})
+		assert.Equal(t, gterr.InvalidArgument, gterr.Code(err))
+	})
+
+	t.Run("both sides share the same conversation", func(t *testing.T) {
+		m1, err := s.SendDirectMessage(context.TODO(), conversation.SendDirectMessageRequest{
+			Email:       "foo@mock.com",
+			FriendEmail: "bar@mock.com",
+			Body:        "hi",
+		})
+		require.NoError(t, err)
+
+		m2, err := s.SendDirectMessage(context.TODO(), conversation.SendDirectMessageRequest{
+			Email:       "bar@mock.com",
+			FriendEmail: "foo@mock.com",
+			Body:        "hello",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, m1.ConversationID, m2.ConversationID)
+
+		res, err := s.ListConversations(context.TODO(), "foo@mock.com")
+		require.NoError(t, err)
+		require.Len(t, res.Conversations, 1)
+		assert.Equal(t, "hello", res.Conversations[0].LastMessage.Body)
+		assert.Equal(t, 1, res.Conversations[0].UnreadCount)
+
+		res, err = s.ListConversations(context.TODO(), "baz@mock.com")
+		require.NoError(t, err)
+		assert.Empty(t, res.Conversations)
+	})
+}
+
+func TestService_SendDirectMessage_Deactivated(t *testing.T) {
+	mock := memory.NewStorage()
+	mock.InsertUsers([]memory.User{{Email: "foo@mock.com"}, {Email: "bar@mock.com"}})
+	require.NoError(t, mock.InsertFriendship(context.TODO(), &friend.Friendship{
+		Email:         "bar@mock.com",
+		FriendEmail:   "foo@mock.com",
+		DateConnected: time.Now(),
+	}))
+	require.NoError(t, mock.DeactivateUser(context.TODO(), "bar@mock.com", time.Now(), nil))
+	s := conversation.NewService(mock, mock, nil, conversation.DefaultConfig())
+
+	_, err := s.SendDirectMessage(context.TODO(), conversation.SendDirectMessageRequest{
+		Email:       "foo@mock.com",
+		FriendEmail: "bar@mock.com",
+		Body:        "hi",
+	})
+	assert.Equal(t, gterr.FailedPrecondition, gterr.Code(err))
+}
+
+func TestService_ListMessages(t *testing.T) {
+	s := makeService(t)
+
+	var id int64
+	for i := 0; i < 5; i++ {
+		m, err := s.SendDirectMessage(context.TODO(), conversation.SendDirectMessageRequest{
+			Email:       "foo@mock.com",
+			FriendEmail: "bar@mock.com",
+			Body:        "hi",
+		})
+		require.NoError(t, err)
+		id = m.ConversationID
+	}
+
+	t.Run("paginate from the newest", func(t *testing.T) {
+		var ids []int64
+		req := conversation.ListMessagesRequest{Email: "bar@mock.com", ConversationID: id, Limit: 2}
+		for {
+			res, err := s.ListMessages(context.TODO(), req)
+			require.NoError(t, err)
+			for _, m := range res.Messages {
+				ids = append(ids, m.ID)
+			}
+			if res.NextBefore == 0 {
+				break
+			}
+			req.Before = res.NextBefore
+		}
+
+		require.Len(t, ids, 5)
+		for i := 1; i < len(ids); i++ {
+			assert.Greater(t, ids[i-1], ids[i])
+		}
+	})
+
+	t.Run("other users can not read the conversation", func(t *testing.T) {
+		_, err := s.ListMessages(context.TODO(), conversation.ListMessagesRequest{Email: "baz@mock.com", ConversationID: id})
+		assert.Equal(t, gterr.NotFound, gterr.Code(err))
+	})
+}
+
+func TestService_ReadReceipts(t *testing.T) {
+	s := makeService(t)
+
+	m, err := s.SendDirectMessage(context.TODO(), conversation.SendDirectMessageRequest{
+		Email:       "foo@mock.com",
+		FriendEmail: "bar@mock.com",
+		Body:        "hi",
+	})
+	require.NoError(t, err)
+
+	res, err := s.ListMessages(context.TODO(), conversation.ListMessagesRequest{Email: "foo@mock.com", ConversationID: m.ConversationID})
+	require.NoError(t, err)
+	assert.Empty(t, res.Messages[0].ReadBy)
+
+	require.NoError(t, s.MarkRead(context.TODO(), "bar@mock.com", m.ConversationID))
+
+	res, err = s.ListMessages(context.TODO(), conversation.ListMessagesRequest{Email: "foo@mock.com", ConversationID: m.ConversationID})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bar@mock.com"}, res.Messages[0].ReadBy)
+
+	list, err := s.ListConversations(context.TODO(), "bar@mock.com")
+	require.NoError(t, err)
+	assert.Equal(t, 0, list.Conversations[0].UnreadCount)
+}
+
+func TestService_Delete(t *testing.T) {
+	s := makeService(t)
+
+	send := func(from, to string) *conversation.Message {
+		m, err := s.SendDirectMessage(context.TODO(), conversation.SendDirectMessageRequest{Email: from, FriendEmail: to, Body: "hi"})
+		require.NoError(t, err)
+		return m
+	}
+
+	m1 := send("foo@mock.com", "bar@mock.com")
+	m2 := send("bar@mock.com", "foo@mock.com")
+
+	t.Run("delete message only hides it from the user", func(t *testing.T) {
+		err := s.DeleteMessage(context.TODO(), conversation.DeleteMessageRequest{Email: "foo@mock.com", ConversationID: m1.ConversationID, MessageID: m2.ID})
+		require.NoError(t, err)
+
+		res, err := s.ListMessages(context.TODO(), conversation.ListMessagesRequest{Email: "foo@mock.com", ConversationID: m1.ConversationID})
+		require.NoError(t, err)
+		require.Len(t, res.Messages, 1)
+		assert.Equal(t, m1.ID, res.Messages[0].ID)
+
+		res, err = s.ListMessages(context.TODO(), conversation.ListMessagesRequest{Email: "bar@mock.com", ConversationID: m1.ConversationID})
+		require.NoError(t, err)
+		assert.Len(t, res.Messages, 2)
+	})
+
+	t.Run("delete conversation hides the history until a new message", func(t *testing.T) {
+		require.NoError(t, s.DeleteConversation(context.TODO(), "foo@mock.com", m1.ConversationID))
+
+		list, err := s.ListConversations(context.TODO(), "foo@mock.com")
+		require.NoError(t, err)
+		assert.Empty(t, list.Conversations)
+
+		list, err = s.ListConversations(context.TODO(), "bar@mock.com")
+		require.NoError(t, err)
+		assert.Len(t, list.Conversations, 1)
+
+		m3 := send("bar@mock.com", "foo@mock.com")
+
+		res, err := s.ListMessages(context.TODO(), conversation.ListMessagesRequest{Email: "foo@mock.com", ConversationID: m1.ConversationID})
+		require.NoError(t, err)
+		require.Len(t, res.Messages, 1)
+		assert.Equal(t, m3.ID, res.Messages[0].ID)
+	})
+}
+
+func makeService(t *testing.T) *conversation.Service {
+	mock := memory.NewStorage()
+	mock.InsertUsers([]memory.User{
+		{Email: "foo@mock.com"},
+		{Email: "bar@mock.com"},
+		{Email: "baz@mock.com"},
+	})
+	require.NoError(t, mock.InsertFriendship(context.TODO(), &friend.Friendship{
+		Email:         "bar@mock.com",
+		FriendEmail:   "foo@mock.com",
+		DateConnected: time.Now(),
+	}))
+
+	return conversation.NewService(mock, mock, nil, conversation.DefaultConfig())
+}
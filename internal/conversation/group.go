@@ -0,0 +1,386 @@
+package conversation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+type (
+	GroupConfig struct {
+		// MaxMembers includes the owner.
+		MaxMembers int `mapstructure:"max_members"`
+	}
+
+	Role string
+
+	CreateGroupRequest struct {
+		Email   string   `json:"-"`
+		Name    string   `json:"name" binding:"required,max=100"`
+		Members []string `json:"members" binding:"required,min=1"`
+	}
+
+	RenameGroupRequest struct {
+		Email          string `json:"-"`
+		ConversationID int64  `json:"-"`
+		Name           string `json:"name" binding:"required,max=100"`
+	}
+
+	GroupMemberRequest struct {
+		Email          string
+		ConversationID int64
+		MemberEmail    string
+	}
+
+	UpdateMemberRoleRequest struct {
+		Email          string `json:"-"`
+		ConversationID int64  `json:"-"`
+		MemberEmail    string `json:"-"`
+		Role           Role   `json:"role" binding:"required,oneof=admin member"`
+	}
+)
+
+// The owner can do everything, admins can rename the group and manage the members, but not the other admins.
+const (
+	RoleOwner  Role = "owner"
+	RoleAdmin  Role = "admin"
+	RoleMember Role = "member"
+)
+
+// CreateGroup creates a group owned by req.Email, the members must be friends of the owner.
+func (s *Service) CreateGroup(ctx context.Context, req CreateGroupRequest) (*Conversation, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return nil, gterr.New(gterr.InvalidArgument, "empty group name")
+	}
+
+	now := time.Now()
+	c := &Conversation{
+		Name:         name,
+		Participants: []*Participant{{Email: req.Email, Role: RoleOwner, JoinedAt: now}},
+		CreatedAt:    now,
+	}
+
+	for _, email := range req.Members {
+		if c.participant(email) != nil {
+			continue
+		}
+
+		if err := s.checkFriends(ctx, req.Email, email); err != nil {
+			return nil, err
+		}
+		c.Participants = append(c.Participants, &Participant{Email: email, Role: RoleMember, JoinedAt: now})
+	}
+
+	if len(c.Participants) < 2 {
+		return nil, gterr.New(gterr.InvalidArgument, "a group needs at least 1 member other than the owner")
+	}
+
+	if len(c.Participants) > s.cfg.Group.MaxMembers {
+		return nil, gterr.New(gterr.FailedPrecondition, fmt.Sprintf("a group can have at most %d members", s.cfg.Group.MaxMembers))
+	}
+
+	if err := s.storage.InsertConversation(ctx, c); err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+
+	if _, err := s.systemMessage(ctx, c, MessageGroupCreated, req.Email, name); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (s *Service) RenameGroup(ctx context.Context, req RenameGroupRequest) (*Conversation, error) {
+	c, me, err := s.getGroup(ctx, s.storage, req.Email, req.ConversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	if me.Role != RoleOwner && me.Role != RoleAdmin {
+		return nil, gterr.New(gterr.PermissionDenied, "only the owner and admins can rename the group")
+	}
+
+	c.Name = strings.TrimSpace(req.Name)
+	if c.Name == "" {
+		return nil, gterr.New(gterr.InvalidArgument, "empty group name")
+	}
+
+	if err := s.storage.UpdateConversation(ctx, c); err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+
+	if _, err := s.systemMessage(ctx, c, MessageGroupRenamed, req.Email, c.Name); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// AddGroupMember adds a friend of the actor to the group.
+func (s *Service) AddGroupMember(ctx context.Context, req GroupMemberRequest) error {
+	var c *Conversation
+	err := s.storage.WithConversationLock(ctx, req.ConversationID, func(ctx context.Context, tx Storage) error {
+		var (
+			me  *Participant
+			err error
+		)
+		c, me, err = s.getGroup(ctx, tx, req.Email, req.ConversationID)
+		if err != nil {
+			return err
+		}
+
+		if me.Role != RoleOwner && me.Role != RoleAdmin {
+			return gterr.New(gterr.PermissionDenied, "only the owner and admins can add members")
+		}
+
+		if c.participant(req.MemberEmail) != nil {
+			return gterr.New(gterr.AlreadyExists, fmt.Sprintf("%s is already a member", req.MemberEmail))
+		}
+
+		if len(c.Participants) >= s.cfg.Group.MaxMembers {
+			return gterr.New(gterr.FailedPrecondition, fmt.Sprintf("a group can have at most %d members", s.cfg.Group.MaxMembers))
+		}
+
+		if err := s.checkFriends(ctx, req.Email, req.MemberEmail); err != nil {
+			return err
+		}
+
+		// The new member starts with the history read, only the messages from now on are unread
+		last, err := s.lastMessageID(ctx, me.Email, c.ID)
+		if err != nil {
+			return err
+		}
+
+		p := &Participant{Email: req.MemberEmail, Role: RoleMember, JoinedAt: time.Now(), LastReadMessageID: last}
+		err = tx.InsertParticipant(ctx, c.ID, p)
+		if errors.Is(err, storage.ErrAlreadyExist) {
+			return gterr.New(gterr.AlreadyExists, fmt.Sprintf("%s is already a member", req.MemberEmail), err)
+		}
+
+		if err != nil {
+			return gterr.New(gterr.Internal, "", err)
+		}
+
+		c.Participants = append(c.Participants, p)
+		return nil
+	})
+	if err != nil {
+		return lockErr(err)
+	}
+
+	// The message is sent once the member is added, so it is not pushed for a rolled back change
+	_, err = s.systemMessage(ctx, c, MessageMemberAdded, req.Email, req.MemberEmail)
+	return err
+}
+
+// RemoveGroupMember removes another member, the owner can remove anyone while admins can only remove members.
+func (s *Service) RemoveGroupMember(ctx context.Context, req GroupMemberRequest) error {
+	if strings.EqualFold(req.Email, req.MemberEmail) {
+		return gterr.New(gterr.InvalidArgument, "leave the group instead of removing yourself")
+	}
+
+	var (
+		c      *Conversation
+		target *Participant
+	)
+	err := s.storage.WithConversationLock(ctx, req.ConversationID, func(ctx context.Context, tx Storage) error {
+		var (
+			me  *Participant
+			err error
+		)
+		c, me, err = s.getGroup(ctx, tx, req.Email, req.ConversationID)
+		if err != nil {
+			return err
+		}
+
+		target = c.participant(req.MemberEmail)
+		if target == nil {
+			return gterr.New(gterr.NotFound, fmt.Sprintf("%s is not a member", req.MemberEmail))
+		}
+
+		if !canManage(me.Role, target.Role) {
+			return gterr.New(gterr.PermissionDenied, fmt.Sprintf("%s can not remove %s", me.Role, target.Role))
+		}
+
+		if err := tx.DeleteParticipant(ctx, c.ID, target.Email); err != nil {
+			return gterr.New(gterr.Internal, "", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return lockErr(err)
+	}
+
+	// c still has the removed member, so the system message is pushed to it too
+	_, err = s.systemMessage(ctx, c, MessageMemberRemoved, req.Email, target.Email)
+	return err
+}
+
+// UpdateMemberRole promotes a member to admin or demotes an admin, only the owner can do it.
+func (s *Service) UpdateMemberRole(ctx context.Context, req UpdateMemberRoleRequest) error {
+	err := s.storage.WithConversationLock(ctx, req.ConversationID, func(ctx context.Context, tx Storage) error {
+		c, me, err := s.getGroup(ctx, tx, req.Email, req.ConversationID)
+		if err != nil {
+			return err
+		}
+
+		if me.Role != RoleOwner {
+			return gterr.New(gterr.PermissionDenied, "only the owner can change the roles")
+		}
+
+		target := c.participant(req.MemberEmail)
+		if target == nil {
+			return gterr.New(gterr.NotFound, fmt.Sprintf("%s is not a member", req.MemberEmail))
+		}
+
+		if target == me {
+			return gterr.New(gterr.InvalidArgument, "can not change the role of the owner")
+		}
+
+		target.Role = req.Role
+		if err := tx.UpdateParticipant(ctx, c.ID, target); err != nil {
+			return gterr.New(gterr.Internal, "", err)
+		}
+		return nil
+	})
+	return lockErr(err)
+}
+
+// LeaveGroup removes the actor from the group.
+// When the owner leaves, the ownership goes to the earliest admin, or the earliest member if there is no admin.
+func (s *Service) LeaveGroup(ctx context.Context, email string, conversationID int64) error {
+	var (
+		c  *Conversation
+		me *Participant
+	)
+	err := s.storage.WithConversationLock(ctx, conversationID, func(ctx context.Context, tx Storage) error {
+		var err error
+		c, me, err = s.getGroup(ctx, tx, email, conversationID)
+		if err != nil {
+			return err
+		}
+		return s.removeParticipant(ctx, tx, c, me)
+	})
+	if err != nil {
+		return lockErr(err)
+	}
+
+	_, err = s.systemMessage(ctx, c, MessageMemberLeft, me.Email, me.Email)
+	return err
+}
+
+// RemoveUser removes email from all its group conversations like LeaveGroup, before its account is deleted.
+// No member left message is sent, the messages of the user are deleted with the account anyway.
+func (s *Service) RemoveUser(ctx context.Context, email string) error {
+	ids, err := s.storage.ListGroupConversationIDs(ctx, email)
+	if err != nil {
+		return gterr.New(gterr.Internal, "", fmt.Errorf("list group conversations: %v", err))
+	}
+
+	for _, id := range ids {
+		err := s.storage.WithConversationLock(ctx, id, func(ctx context.Context, tx Storage) error {
+			c, me, err := s.getGroup(ctx, tx, email, id)
+			if gterr.Code(err) == gterr.NotFound {
+				// Left or removed in the meantime
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			return s.removeParticipant(ctx, tx, c, me)
+		})
+		if err != nil {
+			return lockErr(err)
+		}
+	}
+	return nil
+}
+
+// removeParticipant removes me from c and hands the ownership over, it must run in WithConversationLock.
+// The successor is chosen among the participants read in the lock, so it can't have left in the meantime.
+func (s *Service) removeParticipant(ctx context.Context, tx Storage, c *Conversation, me *Participant) error {
+	if err := tx.DeleteParticipant(ctx, c.ID, me.Email); err != nil {
+		return gterr.New(gterr.Internal, "", err)
+	}
+
+	var others []*Participant
+	for _, p := range c.Participants {
+		if p != me {
+			others = append(others, p)
+		}
+	}
+	c.Participants = others
+
+	if me.Role == RoleOwner {
+		if next := nextOwner(others); next != nil {
+			next.Role = RoleOwner
+			if err := tx.UpdateParticipant(ctx, c.ID, next); err != nil {
+				return gterr.New(gterr.Internal, "", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Service) systemMessage(ctx context.Context, c *Conversation, t MessageType, actor, body string) (*Message, error) {
+	return s.insertMessage(ctx, c, &Message{
+		ConversationID: c.ID,
+		Type:           t,
+		Sender:         actor,
+		Body:           body,
+		CreatedAt:      time.Now(),
+	})
+}
+
+// getGroup is the same as getConversation, but fails if the conversation is not a group.
+func (s *Service) getGroup(ctx context.Context, tx Storage, email string, id int64) (*Conversation, *Participant, error) {
+	c, me, err := s.getConversation(ctx, tx, email, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !c.IsGroup() {
+		return nil, nil, gterr.New(gterr.FailedPrecondition, fmt.Sprintf("conversation %d is not a group", id))
+	}
+	return c, me, nil
+}
+
+// lockErr converts the errors of the storage lock, the errors of the service pass through.
+func lockErr(err error) error {
+	if _, ok := gterr.FromError(err); err != nil && !ok {
+		return gterr.New(gterr.Internal, "", err)
+	}
+	return err
+}
+
+func canManage(actor, target Role) bool {
+	switch actor {
+	case RoleOwner:
+		return true
+	case RoleAdmin:
+		return target == RoleMember
+	default:
+		return false
+	}
+}
+
+func nextOwner(participants []*Participant) *Participant {
+	if len(participants) == 0 {
+		return nil
+	}
+
+	sorted := make([]*Participant, len(participants))
+	copy(sorted, participants)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if (sorted[i].Role == RoleAdmin) != (sorted[j].Role == RoleAdmin) {
+			return sorted[i].Role == RoleAdmin
+		}
+		return sorted[i].JoinedAt.Before(sorted[j].JoinedAt)
+	})
+	return sorted[0]
+}
@@ -0,0 +1,216 @@
+package conversation_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/victornm/gtonline/internal/conversation"
+	"github.com/victornm/gtonline/internal/friend"
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/storage/memory"
+)
+
+// makeGroupService makes foo friends with everyone else, but no one else is friends with each other.
+func makeGroupService(t *testing.T, maxMembers int) *conversation.Service {
+	mock := memory.NewStorage()
+	mock.InsertUsers([]memory.User{
+		{Email: "foo@mock.com"},
+		{Email: "bar@mock.com"},
+		{Email: "baz@mock.com"},
+		{Email: "qux@mock.com"},
+	})
+	for _, email := range []string{"bar@mock.com", "baz@mock.com", "qux@mock.com"} {
+		require.NoError(t, mock.InsertFriendship(context.TODO(), &friend.Friendship{
+			Email:         "foo@mock.com",
+			FriendEmail:   email,
+			DateConnected: time.Now(),
+		}))
+	}
+
+	cfg := conversation.DefaultConfig()
+	cfg.Group.MaxMembers = maxMembers
+	return conversation.NewService(mock, mock, nil, cfg)
+}
+
+func TestService_CreateGroup(t *testing.T) {
+	s := makeGroupService(t, 3)
+
+	t.Run("members must be friends of the owner", func(t *testing.T) {
+		_, err := s.CreateGroup(context.TODO(), conversation.CreateGroupRequest{
+			Email:   "bar@mock.com",
+			Name:    "Avengers",
+			Members: []string{"baz@mock.com"},
+		})
+		assert.Equal(t, gterr.FailedPrecondition, gterr.Code(err))
+	})
+
+	t.Run("members are capped", func(t *testing.T) {
+		_, err := s.CreateGroup(context.TODO(), conversation.CreateGroupRequest{
+			Email:   "foo@mock.com",
+			Name:    "Avengers",
+			Members: []string{"bar@mock.com", "baz@mock.com", "qux@mock.com"},
+		})
+		assert.Equal(t, gterr.FailedPrecondition, gterr.Code(err))
+	})
+
+	t.Run("valid creation", func(t *testing.T) {
+		c, err := s.CreateGroup(context.TODO(), conversation.CreateGroupRequest{
+			Email:   "foo@mock.com",
+			Name:    "Avengers",
+			Members: []string{"bar@mock.com", "baz@mock.com"},
+		})
+		require.NoError(t, err)
+		require.Len(t, c.Participants, 3)
+		assert.Equal(t, conversation.RoleOwner, c.Participants[0].Role)
+
+		res, err := s.ListMessages(context.TODO(), conversation.ListMessagesRequest{Email: "bar@mock.com", ConversationID: c.ID})
+		require.NoError(t, err)
+		require.Len(t, res.Messages, 1)
+		assert.Equal(t, conversation.MessageGroupCreated, res.Messages[0].Type)
+	})
+}
+
+func TestService_GroupMembers(t *testing.T) {
+	s := makeGroupService(t, 10)
+
+	c, err := s.CreateGroup(context.TODO(), conversation.CreateGroupRequest{
+		Email:   "foo@mock.com",
+		Name:    "Avengers",
+		Members: []string{"bar@mock.com"},
+	})
+	require.NoError(t, err)
+
+	member := func(actor, target string) conversation.GroupMemberRequest {
+		return conversation.GroupMemberRequest{Email: actor, ConversationID: c.ID, MemberEmail: target}
+	}
+
+	t.Run("members can not manage the group", func(t *testing.T) {
+		err := s.AddGroupMember(context.TODO(), member("bar@mock.com", "foo@mock.com"))
+		assert.Equal(t, gterr.PermissionDenied, gterr.Code(err))
+
+		_, err = s.RenameGroup(context.TODO(), conversation.RenameGroupRequest{Email: "bar@mock.com", ConversationID: c.ID, Name: "X"})
+		assert.Equal(t, gterr.PermissionDenied, gterr.Code(err))
+	})
+
+	t.Run("admins can only add their own friends", func(t *testing.T) {
+		require.NoError(t, s.UpdateMemberRole(context.TODO(), conversation.UpdateMemberRoleRequest{
+			Email:          "foo@mock.com",
+			ConversationID: c.ID,
+			MemberEmail:    "bar@mock.com",
+			Role:           conversation.RoleAdmin,
+		}))
+
+		err := s.AddGroupMember(context.TODO(), member("bar@mock.com", "baz@mock.com"))
+		assert.Equal(t, gterr.FailedPrecondition, gterr.Code(err))
+
+		require.NoError(t, s.AddGroupMember(context.TODO(), member("foo@mock.com", "baz@mock.com")))
+		err = s.AddGroupMember(context.TODO(), member("foo@mock.com", "baz@mock.com"))
+		assert.Equal(t, gterr.AlreadyExists, gterr.Code(err))
+	})
+
+	t.Run("admins can not remove the owner", func(t *testing.T) {
+		err := s.RemoveGroupMember(context.TODO(), member("bar@mock.com", "foo@mock.com"))
+		assert.Equal(t, gterr.PermissionDenied, gterr.Code(err))
+
+		require.NoError(t, s.RemoveGroupMember(context.TODO(), member("bar@mock.com", "baz@mock.com")))
+
+		_, err = s.ListMessages(context.TODO(), conversation.ListMessagesRequest{Email: "baz@mock.com", ConversationID: c.ID})
+		assert.Equal(t, gterr.NotFound, gterr.Code(err))
+	})
+
+	t.Run("the ownership goes to an admin when the owner leaves", func(t *testing.T) {
+		require.NoError(t, s.LeaveGroup(context.TODO(), "foo@mock.com", c.ID))
+
+		res, err := s.ListMessages(context.TODO(), conversation.ListMessagesRequest{Email: "bar@mock.com", ConversationID: c.ID})
+		require.NoError(t, err)
+		require.Len(t, res.Participants, 1)
+		assert.Equal(t, conversation.RoleOwner, res.Participants[0].Role)
+
+		var types []conversation.MessageType
+		for _, m := range res.Messages {
+			types = append(types, m.Type)
+		}
+		assert.Equal(t, []conversation.MessageType{
+			conversation.MessageMemberLeft,
+			conversation.MessageMemberRemoved,
+			conversation.MessageMemberAdded,
+			conversation.MessageGroupCreated,
+		}, types)
+	})
+}
+
+func TestService_GroupMembers_Concurrent(t *testing.T) {
+	s := makeGroupService(t, 3)
+	ctx := context.TODO()
+
+	c, err := s.CreateGroup(ctx, conversation.CreateGroupRequest{Email: "foo@mock.com", Name: "Avengers", Members: []string{"bar@mock.com"}})
+	require.NoError(t, err)
+
+	// Only 1 of the concurrent adds fits in the group
+	errs := make(chan error, 2)
+	for _, email := range []string{"baz@mock.com", "qux@mock.com"} {
+		go func(email string) {
+			errs <- s.AddGroupMember(ctx, conversation.GroupMemberRequest{Email: "foo@mock.com", ConversationID: c.ID, MemberEmail: email})
+		}(email)
+	}
+	var codes []gterr.ErrorCode
+	for i := 0; i < 2; i++ {
+		codes = append(codes, gterr.Code(<-errs))
+	}
+	assert.ElementsMatch(t, []gterr.ErrorCode{gterr.OK, gterr.FailedPrecondition}, codes)
+
+	got, err := s.ListMessages(ctx, conversation.ListMessagesRequest{Email: "bar@mock.com", ConversationID: c.ID})
+	require.NoError(t, err)
+	assert.Len(t, got.Messages, 2, "the creation and the only added member")
+
+	// The owner and its successor leave together, the last member takes over
+	require.NoError(t, s.UpdateMemberRole(ctx, conversation.UpdateMemberRoleRequest{Email: "foo@mock.com", ConversationID: c.ID, MemberEmail: "bar@mock.com", Role: conversation.RoleAdmin}))
+	for _, email := range []string{"foo@mock.com", "bar@mock.com"} {
+		go func(email string) {
+			errs <- s.LeaveGroup(ctx, email, c.ID)
+		}(email)
+	}
+	for i := 0; i < 2; i++ {
+		require.NoError(t, <-errs)
+	}
+
+	res, err := s.ListConversations(ctx, "baz@mock.com")
+	if err == nil && len(res.Conversations) == 0 {
+		res, err = s.ListConversations(ctx, "qux@mock.com")
+	}
+	require.NoError(t, err)
+	require.Len(t, res.Conversations, 1)
+	require.Len(t, res.Conversations[0].Participants, 1)
+	assert.Equal(t, conversation.RoleOwner, res.Conversations[0].Participants[0].Role)
+}
+
+func TestService_RemoveUser(t *testing.T) {
+	s := makeGroupService(t, 10)
+	ctx := context.TODO()
+
+	c, err := s.CreateGroup(ctx, conversation.CreateGroupRequest{Email: "foo@mock.com", Name: "Avengers", Members: []string{"bar@mock.com", "baz@mock.com"}})
+	require.NoError(t, err)
+	_, err = s.SendDirectMessage(ctx, conversation.SendDirectMessageRequest{Email: "foo@mock.com", FriendEmail: "qux@mock.com", Body: "hi"})
+	require.NoError(t, err)
+
+	// The owner is removed before its account is purged, like leaving the group without a message
+	require.NoError(t, s.RemoveUser(ctx, "foo@mock.com"))
+
+	res, err := s.ListMessages(ctx, conversation.ListMessagesRequest{Email: "bar@mock.com", ConversationID: c.ID})
+	require.NoError(t, err)
+	roles := map[string]conversation.Role{}
+	for _, p := range res.Participants {
+		roles[p.Email] = p.Role
+	}
+	assert.Equal(t, map[string]conversation.Role{"bar@mock.com": conversation.RoleOwner, "baz@mock.com": conversation.RoleMember}, roles)
+	assert.Equal(t, conversation.MessageGroupCreated, res.Messages[0].Type)
+
+	direct, err := s.ListConversations(ctx, "qux@mock.com")
+	require.NoError(t, err)
+	require.Len(t, direct.Conversations, 1)
+	assert.Len(t, direct.Conversations[0].Participants, 2, "the direct conversations are left as they are")
+}
@@ -0,0 +1,319 @@
+package digest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/victornm/gtonline/internal/friend"
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/job"
+	"github.com/victornm/gtonline/internal/mail"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+type (
+	Service struct {
+		storage Storage
+		mailer  mail.Mailer
+		prefs   Preferences
+		cfg     Config
+		tmpl    *templates
+	}
+
+	// Preferences are the notification preferences of the users, the digest respects their quiet hours.
+	Preferences interface {
+		// QuietUntil returns the end of the quiet hours of email if they contain t, otherwise the zero time.
+		QuietUntil(ctx context.Context, email string, t time.Time) (time.Time, error)
+	}
+
+	Storage interface {
+		// GetDigestSubscription returns storage.ErrNotFound if email never changed its digest settings.
+		GetDigestSubscription(ctx context.Context, email string) (*Subscription, error)
+		// SaveDigestSubscription inserts or replaces the subscription of sub.Email.
+		SaveDigestSubscription(ctx context.Context, sub *Subscription) error
+		// ListDigestSubscriptions returns the subscriptions with a frequency other than off.
+		ListDigestSubscriptions(ctx context.Context) ([]*Subscription, error)
+
+		ListFriends(ctx context.Context, email string) ([]*friend.Friendship, error)
+		ListPendingFriendships(ctx context.Context, email string) ([]*friend.Friendship, error)
+
+		// GetProfileSnapshot returns storage.ErrNotFound if no profile update of email was seen yet.
+		GetProfileSnapshot(ctx context.Context, email string) (*Snapshot, error)
+		// SaveProfileSnapshot replaces the snapshot of snap.Email and inserts the changes atomically.
+		SaveProfileSnapshot(ctx context.Context, snap *Snapshot, changes []*Change) error
+		// ListProfileChanges returns the changes of the given emails made at or after since, the oldest first.
+		ListProfileChanges(ctx context.Context, emails []string, since time.Time) ([]*Change, error)
+		DeleteProfileChanges(ctx context.Context, before time.Time) (int64, error)
+	}
+
+	Config struct {
+		// Schedule is the cron spec of the digest run, the weekly digests go out on the first run 7 days after the last one.
+		Schedule string `mapstructure:"schedule"`
+		// UnsubscribeURL is the page opened by the unsubscribe link, it receives the token in the query.
+		UnsubscribeURL string `mapstructure:"unsubscribe_url"`
+		// Secret signs the unsubscribe tokens.
+		Secret string `mapstructure:"secret"`
+		// ChangeRetention is how long the profile changes of the users are kept for the digests.
+		ChangeRetention time.Duration `mapstructure:"change_retention"`
+	}
+)
+
+func NewService(s Storage, m mail.Mailer, p Preferences, cfg Config) *Service {
+	return &Service{storage: s, mailer: m, prefs: p, cfg: cfg.withDefaults(), tmpl: parseTemplates()}
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Schedule:        "0 8 * * *",
+		UnsubscribeURL:  "http://localhost:8080/digest/unsubscribe",
+		ChangeRetention: 30 * 24 * time.Hour,
+	}
+}
+
+func (c Config) withDefaults() Config {
+	d := DefaultConfig()
+	if c.Schedule == "" {
+		c.Schedule = d.Schedule
+	}
+	if c.UnsubscribeURL == "" {
+		c.UnsubscribeURL = d.UnsubscribeURL
+	}
+	// The changes must outlive the longest digest period
+	if c.ChangeRetention < Weekly.period() {
+		c.ChangeRetention = d.ChangeRetention
+	}
+	return c
+}
+
+type Frequency string
+
+const (
+	Off    Frequency = "off"
+	Daily  Frequency = "daily"
+	Weekly Frequency = "weekly"
+)
+
+// scheduleSlack tolerates the delay of the scheduled run, so a digest sent late yesterday doesn't skip today.
+const scheduleSlack = time.Hour
+
+func (f Frequency) period() time.Duration {
+	switch f {
+	case Daily:
+		return 24 * time.Hour
+	case Weekly:
+		return 7 * 24 * time.Hour
+	default:
+		return 0
+	}
+}
+
+type (
+	// Subscription is the digest settings of a user, the digest is opt-in so a user without subscription gets none.
+	Subscription struct {
+		Email      string     `json:"-"`
+		Frequency  Frequency  `json:"frequency"`
+		LastSentAt *time.Time `json:"last_sent_at,omitempty"`
+	}
+
+	UpdateSubscriptionRequest struct {
+		Email     string    `json:"-"`
+		Frequency Frequency `json:"frequency" binding:"required,oneof=off daily weekly"`
+	}
+
+	// Digest is the content of a digest email.
+	Digest struct {
+		Email     string
+		Frequency Frequency
+		Since     time.Time
+		// Requests are the pending requests sent to Email.
+		Requests   []*friend.Friendship
+		NewFriends []*friend.Friendship
+		// Changes are the employer and city changes of the friends of Email.
+		Changes []*Change
+	}
+)
+
+// Empty reports whether d has nothing worth an email.
+func (d *Digest) Empty() bool {
+	return len(d.Requests) == 0 && len(d.NewFriends) == 0 && len(d.Changes) == 0
+}
+
+func (s *Service) GetSubscription(ctx context.Context, email string) (*Subscription, error) {
+	sub, err := s.storage.GetDigestSubscription(ctx, email)
+	if errors.Is(err, storage.ErrNotFound) {
+		return &Subscription{Email: email, Frequency: Off}, nil
+	}
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+	return sub, nil
+}
+
+func (s *Service) UpdateSubscription(ctx context.Context, req UpdateSubscriptionRequest) (*Subscription, error) {
+	if req.Frequency.period() == 0 && req.Frequency != Off {
+		return nil, gterr.New(gterr.InvalidArgument, fmt.Sprintf("invalid frequency: %s", req.Frequency))
+	}
+
+	sub, err := s.GetSubscription(ctx, req.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	sub.Frequency = req.Frequency
+	if err := s.storage.SaveDigestSubscription(ctx, sub); err != nil {
+		return nil, saveErr(err)
+	}
+	return sub, nil
+}
+
+// Unsubscribe turns off the digest of the user the token was issued for.
+func (s *Service) Unsubscribe(ctx context.Context, token string) error {
+	email, err := s.verifyToken(token)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.UpdateSubscription(ctx, UpdateSubscriptionRequest{Email: email, Frequency: Off})
+	return err
+}
+
+// SubscriptionByToken returns the subscription of the user the token was issued for,
+// so the unsubscribe page can show it before confirming.
+func (s *Service) SubscriptionByToken(ctx context.Context, token string) (*Subscription, error) {
+	email, err := s.verifyToken(token)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetSubscription(ctx, email)
+}
+
+// Due returns the emails whose digest should be sent at now.
+func (s *Service) Due(ctx context.Context, now time.Time) ([]string, error) {
+	subs, err := s.storage.ListDigestSubscriptions(ctx)
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", fmt.Errorf("list subscriptions: %v", err))
+	}
+
+	var res []string
+	for _, sub := range subs {
+		if sub.due(now) {
+			res = append(res, sub.Email)
+		}
+	}
+	return res, nil
+}
+
+func (sub *Subscription) due(now time.Time) bool {
+	period := sub.Frequency.period()
+	if period == 0 {
+		return false
+	}
+	return sub.LastSentAt == nil || now.Sub(*sub.LastSentAt) >= period-scheduleSlack
+}
+
+// Send sends the digest of email if it is still due, nothing is sent if the digest is empty.
+// During the quiet hours of email, it returns a job.Defer error to the end of the quiet hours.
+func (s *Service) Send(ctx context.Context, email string, now time.Time) error {
+	sub, err := s.GetSubscription(ctx, email)
+	if err != nil {
+		return err
+	}
+
+	// The user may have unsubscribed or the job is retried after the digest went out
+	if !sub.due(now) {
+		return nil
+	}
+
+	until, err := s.prefs.QuietUntil(ctx, email, now)
+	if err != nil {
+		return err
+	}
+	if !until.IsZero() {
+		return job.Defer(until)
+	}
+
+	d, err := s.Build(ctx, sub, now)
+	if err != nil {
+		return err
+	}
+
+	if !d.Empty() {
+		m, err := s.render(d)
+		if err != nil {
+			return gterr.New(gterr.Internal, "", fmt.Errorf("render digest: %v", err))
+		}
+
+		if err := s.mailer.Send(ctx, m); err != nil {
+			return gterr.New(gterr.Internal, "", fmt.Errorf("send digest: %v", err))
+		}
+	}
+
+	// The empty digest also counts, so the next one starts from now
+	sub.LastSentAt = &now
+	if err := s.storage.SaveDigestSubscription(ctx, sub); err != nil {
+		return saveErr(err)
+	}
+	return nil
+}
+
+// Build collects the activity since the last digest of sub, or since one period ago for the first one.
+func (s *Service) Build(ctx context.Context, sub *Subscription, now time.Time) (*Digest, error) {
+	d := &Digest{Email: sub.Email, Frequency: sub.Frequency, Since: now.Add(-sub.Frequency.period())}
+	if sub.LastSentAt != nil {
+		d.Since = *sub.LastSentAt
+	}
+
+	pending, err := s.storage.ListPendingFriendships(ctx, sub.Email)
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", fmt.Errorf("list pending friendships: %v", err))
+	}
+	for _, f := range pending {
+		// The pending friendships also contain the requests sent by the user
+		if f.FriendEmail == sub.Email {
+			d.Requests = append(d.Requests, f)
+		}
+	}
+	sort.Slice(d.Requests, func(i, j int) bool {
+		return d.Requests[i].RequestedAt.After(d.Requests[j].RequestedAt)
+	})
+
+	friends, err := s.storage.ListFriends(ctx, sub.Email)
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", fmt.Errorf("list friends: %v", err))
+	}
+
+	emails := make([]string, 0, len(friends))
+	for _, f := range friends {
+		emails = append(emails, f.FriendEmail)
+		if !f.DateConnected.Before(d.Since) {
+			d.NewFriends = append(d.NewFriends, f)
+		}
+	}
+	sort.Slice(d.NewFriends, func(i, j int) bool {
+		return d.NewFriends[i].DateConnected.After(d.NewFriends[j].DateConnected)
+	})
+
+	if len(emails) > 0 {
+		d.Changes, err = s.storage.ListProfileChanges(ctx, emails, d.Since)
+		if err != nil {
+			return nil, gterr.New(gterr.Internal, "", fmt.Errorf("list profile changes: %v", err))
+		}
+	}
+
+	return d, nil
+}
+
+// DeleteExpiredChanges deletes the profile changes older than the retention.
+func (s *Service) DeleteExpiredChanges(ctx context.Context) (int64, error) {
+	return s.storage.DeleteProfileChanges(ctx, time.Now().Add(-s.cfg.ChangeRetention))
+}
+
+func saveErr(err error) error {
+	if errors.Is(err, storage.ErrInvalidArgument) {
+		return gterr.New(gterr.NotFound, "user not found", err)
+	}
+	return gterr.New(gterr.Internal, "", err)
+}
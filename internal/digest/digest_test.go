@@ -0,0 +1,211 @@
+package digest_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/victornm/gtonline/internal/digest"
+	"github.com/victornm/gtonline/internal/event"
+	"github.com/victornm/gtonline/internal/friend"
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/job"
+	"github.com/victornm/gtonline/internal/mail"
+	"github.com/victornm/gtonline/internal/notification"
+	"github.com/victornm/gtonline/internal/profile"
+	"github.com/victornm/gtonline/internal/storage/memory"
+)
+
+func makeService(t *testing.T) (*digest.Service, *memory.Storage, *mail.FileMailer) {
+	s, mock, mailer, _ := makeServiceWithPreferences(t)
+	return s, mock, mailer
+}
+
+func makeServiceWithPreferences(t *testing.T) (*digest.Service, *memory.Storage, *mail.FileMailer, *notification.Service) {
+	mock := memory.NewStorage()
+	mock.InsertUsers([]memory.User{
+		{Email: "foo@mock.com"},
+		{Email: "bar@mock.com"},
+		{Email: "baz@mock.com"},
+		{Email: "qux@mock.com"},
+	})
+
+	mailer := mail.NewFileMailer(t.TempDir(), "no-reply@gtonline.local")
+	cfg := digest.DefaultConfig()
+	cfg.Secret = "secret"
+	prefs := notification.NewService(mock, nil, nil)
+	return digest.NewService(mock, mailer, prefs, cfg), mock, mailer, prefs
+}
+
+func updateProfile(t *testing.T, s *digest.Service, p profile.Profile, at time.Time) {
+	r, err := event.NewRecord(profile.ProfileUpdated{Profile: p}, at)
+	require.NoError(t, err)
+	require.NoError(t, s.HandleProfileUpdated(context.TODO(), r))
+}
+
+func TestService_Subscription(t *testing.T) {
+	s, _, _ := makeService(t)
+	ctx := context.TODO()
+
+	sub, err := s.GetSubscription(ctx, "foo@mock.com")
+	require.NoError(t, err)
+	assert.Equal(t, digest.Off, sub.Frequency, "the digest is opt-in")
+
+	_, err = s.UpdateSubscription(ctx, digest.UpdateSubscriptionRequest{Email: "foo@mock.com", Frequency: "hourly"})
+	assert.Equal(t, gterr.InvalidArgument, gterr.Code(err))
+
+	_, err = s.UpdateSubscription(ctx, digest.UpdateSubscriptionRequest{Email: "tony@stark.com", Frequency: digest.Daily})
+	assert.Equal(t, gterr.NotFound, gterr.Code(err))
+
+	sub, err = s.UpdateSubscription(ctx, digest.UpdateSubscriptionRequest{Email: "foo@mock.com", Frequency: digest.Weekly})
+	require.NoError(t, err)
+	assert.Equal(t, digest.Weekly, sub.Frequency)
+
+	due, err := s.Due(ctx, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"foo@mock.com"}, due)
+}
+
+func TestService_Send(t *testing.T) {
+	s, mock, mailer := makeService(t)
+	ctx := context.TODO()
+	now := time.Now()
+
+	// bar sent a request to foo, foo sent one to baz, and qux accepted foo yesterday
+	require.NoError(t, mock.InsertFriendship(ctx, &friend.Friendship{
+		Email: "bar@mock.com", FriendEmail: "foo@mock.com", Relationship: "Colleague", RequestedAt: now.Add(-time.Hour),
+	}))
+	require.NoError(t, mock.InsertFriendship(ctx, &friend.Friendship{
+		Email: "foo@mock.com", FriendEmail: "baz@mock.com", RequestedAt: now.Add(-time.Hour),
+	}))
+	require.NoError(t, mock.InsertFriendship(ctx, &friend.Friendship{
+		Email: "qux@mock.com", FriendEmail: "foo@mock.com", RequestedAt: now.Add(-48 * time.Hour), DateConnected: now.Add(-12 * time.Hour),
+	}))
+
+	// The first update of qux is the baseline, only the second one is a change
+	updateProfile(t, s, profile.Profile{Email: "qux@mock.com", CurrentCity: "Hanoi", Professional: []profile.Employment{{Employer: "Stark Industries"}}}, now.Add(-10*24*time.Hour))
+	updateProfile(t, s, profile.Profile{Email: "qux@mock.com", CurrentCity: "Saigon", Professional: []profile.Employment{{Employer: "Stark Industries"}, {Employer: "Wayne Enterprises"}}}, now.Add(-time.Hour))
+	// baz is not a friend of foo
+	updateProfile(t, s, profile.Profile{Email: "baz@mock.com", CurrentCity: "Hanoi"}, now.Add(-10*24*time.Hour))
+	updateProfile(t, s, profile.Profile{Email: "baz@mock.com", CurrentCity: "Danang"}, now.Add(-time.Hour))
+
+	t.Run("not subscribed", func(t *testing.T) {
+		require.NoError(t, s.Send(ctx, "foo@mock.com", now))
+
+		files, err := mailer.Files()
+		require.NoError(t, err)
+		assert.Empty(t, files)
+	})
+
+	_, err := s.UpdateSubscription(ctx, digest.UpdateSubscriptionRequest{Email: "foo@mock.com", Frequency: digest.Daily})
+	require.NoError(t, err)
+
+	t.Run("build", func(t *testing.T) {
+		sub, err := s.GetSubscription(ctx, "foo@mock.com")
+		require.NoError(t, err)
+
+		d, err := s.Build(ctx, sub, now)
+		require.NoError(t, err)
+
+		require.Len(t, d.Requests, 1)
+		assert.Equal(t, "bar@mock.com", d.Requests[0].Email)
+		require.Len(t, d.NewFriends, 1)
+		assert.Equal(t, "qux@mock.com", d.NewFriends[0].FriendEmail)
+
+		var changes []string
+		for _, c := range d.Changes {
+			changes = append(changes, c.Email+" "+string(c.Field)+" "+c.OldValue+"->"+c.NewValue)
+		}
+		assert.Equal(t, []string{
+			"qux@mock.com current_city Hanoi->Saigon",
+			"qux@mock.com employer ->Wayne Enterprises",
+		}, changes)
+	})
+
+	t.Run("send once per period", func(t *testing.T) {
+		require.NoError(t, s.Send(ctx, "foo@mock.com", now))
+		require.NoError(t, s.Send(ctx, "foo@mock.com", now.Add(time.Minute)))
+
+		files, err := mailer.Files()
+		require.NoError(t, err)
+		require.Len(t, files, 1)
+
+		b, err := ioutil.ReadFile(files[0])
+		require.NoError(t, err)
+		for _, want := range []string{"bar@mock.com", "qux@mock.com", "Saigon", "Wayne Enterprises", "List-Unsubscribe"} {
+			assert.Contains(t, string(b), want)
+		}
+		assert.NotContains(t, string(b), "Danang")
+
+		due, err := s.Due(ctx, now.Add(time.Hour))
+		require.NoError(t, err)
+		assert.Empty(t, due)
+
+		due, err = s.Due(ctx, now.Add(24*time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"foo@mock.com"}, due)
+	})
+}
+
+func TestService_Unsubscribe(t *testing.T) {
+	s, _, _ := makeService(t)
+	ctx := context.TODO()
+
+	_, err := s.UpdateSubscription(ctx, digest.UpdateSubscriptionRequest{Email: "foo@mock.com", Frequency: digest.Daily})
+	require.NoError(t, err)
+
+	token := s.Token("foo@mock.com")
+	for _, invalid := range []string{"", "foo", token + "x", s.Token("bar@mock.com")[:10] + token[10:]} {
+		assert.Equal(t, gterr.InvalidArgument, gterr.Code(s.Unsubscribe(ctx, invalid)), invalid)
+	}
+
+	sub, err := s.SubscriptionByToken(ctx, url.QueryEscape(token))
+	require.NoError(t, err, "the token is URL safe")
+	assert.Equal(t, digest.Daily, sub.Frequency)
+
+	require.NoError(t, s.Unsubscribe(ctx, token))
+
+	sub, err = s.GetSubscription(ctx, "foo@mock.com")
+	require.NoError(t, err)
+	assert.Equal(t, digest.Off, sub.Frequency)
+}
+
+func TestService_Send_QuietHours(t *testing.T) {
+	s, mock, mailer, prefs := makeServiceWithPreferences(t)
+	ctx := context.TODO()
+
+	require.NoError(t, mock.InsertFriendship(ctx, &friend.Friendship{
+		Email: "bar@mock.com", FriendEmail: "foo@mock.com", RequestedAt: time.Now(),
+	}))
+	_, err := s.UpdateSubscription(ctx, digest.UpdateSubscriptionRequest{Email: "foo@mock.com", Frequency: digest.Daily})
+	require.NoError(t, err)
+
+	// 08:00 UTC is 15:00 in Ho Chi Minh City
+	_, err = prefs.UpdatePreferences(ctx, notification.UpdatePreferencesRequest{
+		Email:      "foo@mock.com",
+		QuietHours: &notification.QuietHours{Enabled: true, Start: "12:00", End: "18:00", TimeZone: "Asia/Ho_Chi_Minh"},
+	})
+	require.NoError(t, err)
+
+	now := time.Date(2021, 8, 2, 8, 0, 0, 0, time.UTC)
+	err = s.Send(ctx, "foo@mock.com", now)
+
+	var deferred *job.DeferError
+	require.True(t, errors.As(err, &deferred), "got %v", err)
+	assert.True(t, time.Date(2021, 8, 2, 11, 0, 0, 0, time.UTC).Equal(deferred.At))
+
+	files, err := mailer.Files()
+	require.NoError(t, err)
+	assert.Empty(t, files)
+
+	require.NoError(t, s.Send(ctx, "foo@mock.com", deferred.At))
+	files, err = mailer.Files()
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+}
@@ -0,0 +1,100 @@
+package digest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/victornm/gtonline/internal/event"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+type (
+	// Snapshot is the last seen city and employers of a user, the profile updates are compared to it.
+	Snapshot struct {
+		Email       string
+		CurrentCity string
+		Employers   []string
+	}
+
+	Field string
+
+	// Change is an employer or city change of a user.
+	Change struct {
+		ID        int64
+		Email     string
+		Field     Field
+		OldValue  string
+		NewValue  string
+		ChangedAt time.Time
+	}
+)
+
+const (
+	FieldCurrentCity Field = "current_city"
+	FieldEmployer    Field = "employer"
+)
+
+// HandleProfileUpdated records the city and employer changes of the profile.updated events.
+// The first update of a user only records the snapshot, as there is nothing to compare it to.
+func (s *Service) HandleProfileUpdated(ctx context.Context, r *event.Record) error {
+	// Profile can't decode its own JSON because of the birthdate layout, only the compared fields are decoded
+	var p struct {
+		Email        string `json:"email"`
+		CurrentCity  string `json:"current_city"`
+		Professional []struct {
+			Employer string `json:"employer"`
+		} `json:"professional"`
+	}
+	if err := r.Decode(&p); err != nil {
+		return err
+	}
+
+	snap := &Snapshot{Email: p.Email, CurrentCity: p.CurrentCity}
+	for _, e := range p.Professional {
+		snap.Employers = append(snap.Employers, e.Employer)
+	}
+
+	old, err := s.storage.GetProfileSnapshot(ctx, p.Email)
+	if errors.Is(err, storage.ErrNotFound) {
+		return s.storage.SaveProfileSnapshot(ctx, snap, nil)
+	}
+	if err != nil {
+		return fmt.Errorf("get profile snapshot: %v", err)
+	}
+
+	return s.storage.SaveProfileSnapshot(ctx, snap, diff(old, snap, r.CreatedAt))
+}
+
+// diff returns the city change and one change per new employer.
+func diff(old, cur *Snapshot, at time.Time) []*Change {
+	var res []*Change
+	if cur.CurrentCity != old.CurrentCity && cur.CurrentCity != "" {
+		res = append(res, &Change{
+			Email:     cur.Email,
+			Field:     FieldCurrentCity,
+			OldValue:  old.CurrentCity,
+			NewValue:  cur.CurrentCity,
+			ChangedAt: at,
+		})
+	}
+
+	known := make(map[string]bool, len(old.Employers))
+	for _, e := range old.Employers {
+		known[e] = true
+	}
+	for _, e := range cur.Employers {
+		if known[e] {
+			continue
+		}
+		known[e] = true
+		res = append(res, &Change{
+			Email:     cur.Email,
+			Field:     FieldEmployer,
+			NewValue:  e,
+			ChangedAt: at,
+		})
+	}
+	return res
+}
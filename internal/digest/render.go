@@ -0,0 +1,83 @@
+package digest
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	"net/url"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/victornm/gtonline/internal/mail"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+type (
+	templates struct {
+		html *htmltemplate.Template
+		text *texttemplate.Template
+	}
+
+	// view is the data given to the templates.
+	view struct {
+		*Digest
+		Title          string
+		UnsubscribeURL string
+	}
+)
+
+var funcs = map[string]interface{}{
+	"date": func(t time.Time) string { return t.Format("January 02, 2006") },
+}
+
+func parseTemplates() *templates {
+	return &templates{
+		html: htmltemplate.Must(htmltemplate.New("digest.html").Funcs(funcs).ParseFS(templateFS, "templates/digest.html")),
+		text: texttemplate.Must(texttemplate.New("digest.txt").Funcs(funcs).ParseFS(templateFS, "templates/digest.txt")),
+	}
+}
+
+func (s *Service) render(d *Digest) (mail.Message, error) {
+	v := view{
+		Digest:         d,
+		Title:          "Your daily GT Online digest",
+		UnsubscribeURL: s.unsubscribeURL(d.Email),
+	}
+	if d.Frequency == Weekly {
+		v.Title = "Your weekly GT Online digest"
+	}
+
+	var html, text bytes.Buffer
+	if err := s.tmpl.html.Execute(&html, v); err != nil {
+		return mail.Message{}, err
+	}
+	if err := s.tmpl.text.Execute(&text, v); err != nil {
+		return mail.Message{}, err
+	}
+
+	return mail.Message{
+		To:      d.Email,
+		Subject: v.Title,
+		Headers: map[string]string{
+			// Let the mail clients show their own unsubscribe button, see RFC 8058
+			"List-Unsubscribe":      "<" + v.UnsubscribeURL + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+		Text: text.String(),
+		HTML: html.String(),
+	}, nil
+}
+
+func (s *Service) unsubscribeURL(email string) string {
+	u, err := url.Parse(s.cfg.UnsubscribeURL)
+	if err != nil {
+		return s.cfg.UnsubscribeURL
+	}
+
+	q := u.Query()
+	q.Set("token", s.Token(email))
+	u.RawQuery = q.Encode()
+	return u.String()
+}
@@ -0,0 +1,23 @@
+package digest
+
+import (
+	"time"
+
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/signed"
+)
+
+const tokenPurpose = "digest.unsubscribe"
+
+// Token returns the unsubscribe token of email, it doesn't expire so the links of the old digests keep working.
+func (s *Service) Token(email string) string {
+	return signed.Token(s.cfg.Secret, tokenPurpose, email, time.Time{})
+}
+
+func (s *Service) verifyToken(token string) (string, error) {
+	email, err := signed.Verify(s.cfg.Secret, tokenPurpose, token, time.Now())
+	if err != nil {
+		return "", gterr.New(gterr.InvalidArgument, "invalid unsubscribe token", err)
+	}
+	return email, nil
+}
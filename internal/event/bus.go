@@ -0,0 +1,189 @@
+package event
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/victornm/gtonline/internal/retry"
+)
+
+type (
+	// Bus dispatches the events of the outbox to the in-process subscribers.
+	//
+	// The delivery is at least once: an event is dispatched until every subscriber handled it,
+	// and the subscribers which already handled it are skipped on the next attempts.
+	// A handler may still see an event twice if the process stops between handling it and recording it,
+	// so the handlers must be idempotent.
+	Bus struct {
+		storage     Storage
+		subscribers []subscriber
+		cfg         Config
+	}
+
+	// Handler handles an event, a returned error makes the event retried later.
+	Handler func(ctx context.Context, r *Record) error
+
+	Storage interface {
+		// ClaimEvents returns at most limit undispatched events with NextAttemptAt before now, the oldest first,
+		// and moves their NextAttemptAt to leaseUntil, so other replicas don't dispatch them at the same time.
+		ClaimEvents(ctx context.Context, now, leaseUntil time.Time, limit int) ([]*Record, error)
+		// UpdateEvent saves the attempts, the next attempt, the last error and the dispatch time of r.
+		UpdateEvent(ctx context.Context, r *Record) error
+		// DeleteDispatchedEvents deletes the events dispatched before the given time.
+		DeleteDispatchedEvents(ctx context.Context, before time.Time) (int64, error)
+
+		// ListEventHandlers returns the subscribers which handled the event.
+		ListEventHandlers(ctx context.Context, id int64) ([]string, error)
+		InsertEventHandler(ctx context.Context, id int64, subscriber string) error
+	}
+
+	Config struct {
+		// PollInterval is how often the outbox is relayed.
+		PollInterval time.Duration `mapstructure:"poll_interval"`
+		BatchSize    int           `mapstructure:"batch_size"`
+		// Lease is how long a claimed event is hidden from the other replicas, it must be longer than a dispatch.
+		Lease time.Duration `mapstructure:"lease"`
+		// InitialBackoff is the delay before the first retry, it doubles after every failed dispatch up to MaxBackoff.
+		InitialBackoff time.Duration `mapstructure:"initial_backoff"`
+		MaxBackoff     time.Duration `mapstructure:"max_backoff"`
+		// Retention is how long the dispatched events are kept.
+		Retention time.Duration `mapstructure:"retention"`
+		// SweepInterval is how often the dispatched events older than Retention are deleted.
+		SweepInterval time.Duration `mapstructure:"sweep_interval"`
+	}
+
+	subscriber struct {
+		name    string
+		event   string
+		handler Handler
+	}
+)
+
+// AllEvents subscribes to every event.
+const AllEvents = "*"
+
+func NewBus(s Storage, cfg Config) *Bus {
+	return &Bus{storage: s, cfg: cfg.withDefaults()}
+}
+
+func DefaultConfig() Config {
+	return Config{
+		PollInterval:   time.Second,
+		BatchSize:      100,
+		Lease:          time.Minute,
+		InitialBackoff: time.Second,
+		MaxBackoff:     10 * time.Minute,
+		Retention:      7 * 24 * time.Hour,
+		SweepInterval:  time.Hour,
+	}
+}
+
+func (c Config) withDefaults() Config {
+	d := DefaultConfig()
+	if c.BatchSize <= 0 {
+		c.BatchSize = d.BatchSize
+	}
+	if c.Lease <= 0 {
+		c.Lease = d.Lease
+	}
+	if c.InitialBackoff <= 0 {
+		c.InitialBackoff = d.InitialBackoff
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = d.MaxBackoff
+	}
+	if c.Retention <= 0 {
+		c.Retention = d.Retention
+	}
+	return c
+}
+
+// Subscribe registers h for the events with the given name, or every event with AllEvents.
+// The name of the subscriber identifies it in the outbox, so it must be unique and stable across releases.
+// All the subscribers must be registered before the relay starts.
+func (b *Bus) Subscribe(name, event string, h Handler) {
+	for _, s := range b.subscribers {
+		if s.name == name {
+			panic(fmt.Sprintf("event: subscriber %s registered twice", name))
+		}
+	}
+	b.subscribers = append(b.subscribers, subscriber{name: name, event: event, handler: h})
+}
+
+// Relay dispatches the due events of the outbox, it is meant to be run periodically in the background.
+// It keeps going until there is no more due event or ctx is done.
+func (b *Bus) Relay(ctx context.Context) error {
+	for ctx.Err() == nil {
+		now := time.Now()
+		records, err := b.storage.ClaimEvents(ctx, now, now.Add(b.cfg.Lease), b.cfg.BatchSize)
+		if err != nil {
+			return fmt.Errorf("claim events: %v", err)
+		}
+
+		for _, r := range records {
+			if err := b.dispatch(ctx, r); err != nil {
+				return err
+			}
+		}
+
+		if len(records) < b.cfg.BatchSize {
+			return nil
+		}
+	}
+	return nil
+}
+
+// DeleteDispatched deletes the dispatched events older than the retention, returns the number of deleted events.
+func (b *Bus) DeleteDispatched(ctx context.Context) (int64, error) {
+	n, err := b.storage.DeleteDispatchedEvents(ctx, time.Now().Add(-b.cfg.Retention))
+	if err != nil {
+		return 0, fmt.Errorf("delete dispatched events: %v", err)
+	}
+	return n, nil
+}
+
+// dispatch runs the subscribers of r which haven't handled it yet.
+// A failed handler doesn't stop the others, r is retried later for the failed ones only.
+func (b *Bus) dispatch(ctx context.Context, r *Record) error {
+	names, err := b.storage.ListEventHandlers(ctx, r.ID)
+	if err != nil {
+		return fmt.Errorf("list handlers of event %d: %v", r.ID, err)
+	}
+
+	handled := make(map[string]bool, len(names))
+	for _, n := range names {
+		handled[n] = true
+	}
+
+	var failures []string
+	for _, s := range b.subscribers {
+		if handled[s.name] || (s.event != AllEvents && s.event != r.Name) {
+			continue
+		}
+
+		if err := s.handler(ctx, r); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", s.name, err))
+			continue
+		}
+
+		if err := b.storage.InsertEventHandler(ctx, r.ID, s.name); err != nil {
+			return fmt.Errorf("insert handler %s of event %d: %v", s.name, r.ID, err)
+		}
+	}
+
+	now := time.Now()
+	if len(failures) == 0 {
+		r.LastError = ""
+		r.DispatchedAt = &now
+		return b.storage.UpdateEvent(ctx, r)
+	}
+
+	r.Attempts++
+	r.LastError = strings.Join(failures, "; ")
+	r.NextAttemptAt = now.Add(retry.Backoff(b.cfg.InitialBackoff, b.cfg.MaxBackoff, r.Attempts))
+	log.Printf("dispatch %s %d failed %d times: %s", r.Name, r.ID, r.Attempts, r.LastError)
+	return b.storage.UpdateEvent(ctx, r)
+}
@@ -0,0 +1,113 @@
+package event_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/victornm/gtonline/internal/event"
+	"github.com/victornm/gtonline/internal/storage/memory"
+)
+
+type userRegistered struct {
+	Email string `json:"email"`
+}
+
+func (userRegistered) EventName() string { return "user.registered" }
+
+type profileUpdated struct {
+	Email string `json:"email"`
+}
+
+func (profileUpdated) EventName() string { return "profile.updated" }
+
+func makeBus(_ *testing.T) (*event.Bus, *memory.Storage) {
+	mock := memory.NewStorage()
+	cfg := event.DefaultConfig()
+	cfg.InitialBackoff = time.Nanosecond
+	cfg.MaxBackoff = time.Nanosecond
+	return event.NewBus(mock, cfg), mock
+}
+
+func TestBus_Relay(t *testing.T) {
+	bus, mock := makeBus(t)
+
+	var registered, all []string
+	bus.Subscribe("registered", "user.registered", func(ctx context.Context, r *event.Record) error {
+		var e userRegistered
+		require.NoError(t, r.Decode(&e))
+		registered = append(registered, e.Email)
+		return nil
+	})
+	bus.Subscribe("all", event.AllEvents, func(ctx context.Context, r *event.Record) error {
+		all = append(all, r.Name)
+		return nil
+	})
+
+	require.NoError(t, mock.AppendEvents(context.TODO(), userRegistered{Email: "tony@stark.com"}, profileUpdated{Email: "tony@stark.com"}))
+	require.NoError(t, bus.Relay(context.TODO()))
+	require.NoError(t, bus.Relay(context.TODO()))
+
+	assert.Equal(t, []string{"tony@stark.com"}, registered)
+	assert.Equal(t, []string{"user.registered", "profile.updated"}, all, "the events are dispatched once, in order")
+
+	for _, r := range mock.Events() {
+		assert.NotNil(t, r.DispatchedAt)
+	}
+}
+
+func TestBus_Retry(t *testing.T) {
+	bus, mock := makeBus(t)
+
+	var succeeded, failed int
+	bus.Subscribe("ok", event.AllEvents, func(ctx context.Context, r *event.Record) error {
+		succeeded++
+		return nil
+	})
+	bus.Subscribe("flaky", event.AllEvents, func(ctx context.Context, r *event.Record) error {
+		failed++
+		if failed < 3 {
+			return errors.New("unavailable")
+		}
+		return nil
+	})
+
+	require.NoError(t, mock.AppendEvents(context.TODO(), userRegistered{Email: "tony@stark.com"}))
+	for i := 0; i < 3; i++ {
+		time.Sleep(time.Millisecond)
+		require.NoError(t, bus.Relay(context.TODO()))
+	}
+
+	assert.Equal(t, 1, succeeded, "the subscribers which handled the event are skipped on retry")
+	assert.Equal(t, 3, failed)
+
+	events := mock.Events()
+	require.Len(t, events, 1)
+	assert.Equal(t, 2, events[0].Attempts)
+	assert.NotNil(t, events[0].DispatchedAt)
+	assert.Empty(t, events[0].LastError)
+}
+
+func TestBus_DeleteDispatched(t *testing.T) {
+	mock := memory.NewStorage()
+	cfg := event.DefaultConfig()
+	cfg.Retention = time.Nanosecond
+	bus := event.NewBus(mock, cfg)
+
+	require.NoError(t, mock.AppendEvents(context.TODO(), userRegistered{Email: "tony@stark.com"}))
+	n, err := bus.DeleteDispatched(context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), n, "undispatched events are kept")
+
+	require.NoError(t, bus.Relay(context.TODO()))
+	time.Sleep(time.Millisecond)
+
+	n, err = bus.DeleteDispatched(context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	assert.Empty(t, mock.Events())
+}
@@ -0,0 +1,58 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type (
+	// Event is a domain event, its JSON encoding is the payload of the Record.
+	Event interface {
+		// EventName is the name the subscribers subscribe to, e.g. "user.registered".
+		EventName() string
+	}
+
+	// Outbox appends events to the outbox. The storages implement it so the events are written
+	// in the same transaction as the state change they describe.
+	Outbox interface {
+		AppendEvents(ctx context.Context, events ...Event) error
+	}
+
+	// Record is an event stored in the outbox.
+	Record struct {
+		ID      int64           `json:"id"`
+		Name    string          `json:"name"`
+		Payload json.RawMessage `json:"payload"`
+		// Attempts is the number of failed dispatches.
+		Attempts      int        `json:"attempts"`
+		NextAttemptAt time.Time  `json:"next_attempt_at"`
+		LastError     string     `json:"last_error,omitempty"`
+		CreatedAt     time.Time  `json:"created_at"`
+		DispatchedAt  *time.Time `json:"dispatched_at,omitempty"`
+	}
+)
+
+// NewRecord encodes e to a Record ready to be appended to the outbox.
+func NewRecord(e Event, now time.Time) (*Record, error) {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return nil, fmt.Errorf("marshal %s: %v", e.EventName(), err)
+	}
+
+	return &Record{
+		Name:          e.EventName(),
+		Payload:       payload,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}, nil
+}
+
+// Decode decodes the payload of r into v.
+func (r *Record) Decode(v interface{}) error {
+	if err := json.Unmarshal(r.Payload, v); err != nil {
+		return fmt.Errorf("decode %s %d: %v", r.Name, r.ID, err)
+	}
+	return nil
+}
@@ -0,0 +1,401 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/victornm/gtonline/internal/community"
+	"github.com/victornm/gtonline/internal/conversation"
+	"github.com/victornm/gtonline/internal/digest"
+	"github.com/victornm/gtonline/internal/friend"
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/meetup"
+	"github.com/victornm/gtonline/internal/notification"
+	"github.com/victornm/gtonline/internal/profile"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+const csvDateLayout = "2006-01-02"
+
+// pageSize is the page of the paged lists read for an archive.
+const pageSize = 100
+
+type (
+	account struct {
+		Email      string    `json:"email"`
+		FirstName  string    `json:"first_name"`
+		LastName   string    `json:"last_name"`
+		ExportedAt time.Time `json:"exported_at"`
+	}
+
+	friendRecord struct {
+		FriendEmail        string    `json:"friend_email"`
+		Relationship       string    `json:"relationship"`
+		FriendRelationship string    `json:"friend_relationship,omitempty"`
+		DateConnected      time.Time `json:"date_connected"`
+	}
+
+	// requestRecord is a pending friend request sent or received by the user.
+	requestRecord struct {
+		Direction    string    `json:"direction"`
+		Email        string    `json:"email"`
+		Relationship string    `json:"relationship"`
+		RequestedAt  time.Time `json:"requested_at"`
+	}
+
+	// settings are the notification and digest settings, the defaults if the user never changed them.
+	settings struct {
+		Notifications *notification.Preferences `json:"notifications"`
+		Digest        *digest.Subscription      `json:"digest"`
+	}
+)
+
+// archive returns the ZIP of the data of email, each kind of data is in a JSON file and the lists are also in CSV files.
+// The deactivated users the data refers to are kept, unlike in the lists of the API.
+func (s *Service) archive(ctx context.Context, email string, now time.Time) ([]byte, error) {
+	p, err := s.storage.GetProfile(ctx, email)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, gterr.New(gterr.NotFound, "user not found", err)
+	}
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", fmt.Errorf("get profile: %v", err))
+	}
+
+	interests := [][]string{{"interest"}}
+	for _, i := range p.Interests {
+		interests = append(interests, []string{i})
+	}
+
+	education := [][]string{{"school", "year_graduated", "degree", "major", "start_date", "end_date", "is_current"}}
+	for _, a := range p.Education {
+		education = append(education, []string{
+			a.School, formatYear(a.YearGraduated), a.Degree, a.Major,
+			formatDate(a.StartDate), formatDate(a.EndDate), strconv.FormatBool(a.IsCurrent),
+		})
+	}
+
+	employment := [][]string{{"employer", "job_title", "start_date", "end_date", "is_current"}}
+	for _, e := range p.Professional {
+		employment = append(employment, []string{
+			e.Employer, e.JobTitle, formatDate(e.StartDate), formatDate(e.EndDate), strconv.FormatBool(e.IsCurrent),
+		})
+	}
+
+	w := newArchiveWriter()
+	w.json("account.json", account{Email: p.Email, FirstName: p.FirstName, LastName: p.LastName, ExportedAt: now})
+	w.json("profile.json", p)
+	w.csv("interests.csv", interests)
+	w.csv("education.csv", education)
+	w.csv("employment.csv", employment)
+
+	for _, write := range []func(context.Context, *archiveWriter, string) error{
+		s.writeProfileVersions,
+		s.writeFriends,
+		s.writeMessages,
+		s.writeGroups,
+		s.writeEvents,
+		s.writeNotifications,
+		s.writeSettings,
+	} {
+		if err := write(ctx, w, email); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := w.close()
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", fmt.Errorf("write archive: %v", err))
+	}
+	return data, nil
+}
+
+func (s *Service) writeProfileVersions(ctx context.Context, w *archiveWriter, email string) error {
+	versions := make([]*profile.ProfileVersion, 0)
+	req := profile.ListProfileHistoryRequest{Email: email, Limit: pageSize}
+	for {
+		page, err := s.storage.ListProfileVersions(ctx, req)
+		if err != nil {
+			return gterr.New(gterr.Internal, "", fmt.Errorf("list profile versions: %v", err))
+		}
+		versions = append(versions, page...)
+		if len(page) < pageSize {
+			break
+		}
+		req.Before = page[len(page)-1].Version
+	}
+
+	w.json("profile_versions.json", versions)
+	return nil
+}
+
+func (s *Service) writeFriends(ctx context.Context, w *archiveWriter, email string) error {
+	friendships, err := s.storage.ListAllFriendships(ctx, email)
+	if err != nil {
+		return gterr.New(gterr.Internal, "", fmt.Errorf("list friendships: %v", err))
+	}
+
+	friends := make([]friendRecord, 0, len(friendships))
+	requests := make([]requestRecord, 0)
+	for _, f := range friendships {
+		if f.DateConnected.IsZero() {
+			r := requestRecord{Direction: "sent", Email: f.FriendEmail, Relationship: f.Relationship, RequestedAt: f.RequestedAt}
+			if f.FriendEmail == email {
+				r.Direction, r.Email = "received", f.Email
+			}
+			requests = append(requests, r)
+			continue
+		}
+
+		r := friendRecord{
+			FriendEmail:        f.FriendEmail,
+			Relationship:       f.Relationship,
+			FriendRelationship: f.FriendRelationship,
+			DateConnected:      f.DateConnected,
+		}
+		if f.FriendEmail == email {
+			r.FriendEmail, r.Relationship, r.FriendRelationship = f.Email, f.FriendRelationship, f.Relationship
+		}
+		friends = append(friends, r)
+	}
+
+	lists, err := s.storage.ListFriendLists(ctx, email)
+	if err != nil {
+		return gterr.New(gterr.Internal, "", fmt.Errorf("list friend lists: %v", err))
+	}
+	if lists == nil {
+		lists = []*friend.List{}
+	}
+
+	friendRows := [][]string{{"friend_email", "relationship", "friend_relationship", "date_connected"}}
+	for _, f := range friends {
+		friendRows = append(friendRows, []string{f.FriendEmail, f.Relationship, f.FriendRelationship, formatDate(f.DateConnected)})
+	}
+
+	requestRows := [][]string{{"direction", "email", "relationship", "requested_at"}}
+	for _, r := range requests {
+		requestRows = append(requestRows, []string{r.Direction, r.Email, r.Relationship, formatTime(r.RequestedAt)})
+	}
+
+	listRows := [][]string{{"list", "member_email"}}
+	for _, l := range lists {
+		for _, m := range l.Members {
+			listRows = append(listRows, []string{l.Name, m})
+		}
+	}
+
+	w.json("friends.json", friends)
+	w.json("friend_requests.json", requests)
+	w.json("friend_lists.json", lists)
+	w.csv("friends.csv", friendRows)
+	w.csv("friend_requests.csv", requestRows)
+	w.csv("friend_lists.csv", listRows)
+	return nil
+}
+
+func (s *Service) writeMessages(ctx context.Context, w *archiveWriter, email string) error {
+	messages, err := s.storage.ListSentMessages(ctx, email)
+	if err != nil {
+		return gterr.New(gterr.Internal, "", fmt.Errorf("list messages: %v", err))
+	}
+	if messages == nil {
+		messages = []*conversation.Message{}
+	}
+
+	rows := [][]string{{"id", "conversation_id", "type", "body", "created_at"}}
+	for _, m := range messages {
+		rows = append(rows, []string{
+			strconv.FormatInt(m.ID, 10), strconv.FormatInt(m.ConversationID, 10), string(m.Type), m.Body, formatTime(m.CreatedAt),
+		})
+	}
+
+	w.json("messages.json", messages)
+	w.csv("messages.csv", rows)
+	return nil
+}
+
+func (s *Service) writeGroups(ctx context.Context, w *archiveWriter, email string) error {
+	groups, err := s.storage.ListMemberGroups(ctx, email)
+	if err != nil {
+		return gterr.New(gterr.Internal, "", fmt.Errorf("list groups: %v", err))
+	}
+	if groups == nil {
+		groups = []*community.Group{}
+	}
+
+	posts, err := s.storage.ListAuthoredPosts(ctx, email)
+	if err != nil {
+		return gterr.New(gterr.Internal, "", fmt.Errorf("list posts: %v", err))
+	}
+	if posts == nil {
+		posts = []*community.Post{}
+	}
+
+	groupRows := [][]string{{"id", "name", "topic", "privacy", "role"}}
+	for _, g := range groups {
+		groupRows = append(groupRows, []string{strconv.FormatInt(g.ID, 10), g.Name, g.Topic, string(g.Privacy), string(g.Role)})
+	}
+
+	postRows := [][]string{{"id", "group_id", "body", "created_at"}}
+	for _, p := range posts {
+		postRows = append(postRows, []string{strconv.FormatInt(p.ID, 10), strconv.FormatInt(p.GroupID, 10), p.Body, formatTime(p.CreatedAt)})
+	}
+
+	w.json("groups.json", groups)
+	w.json("group_posts.json", posts)
+	w.csv("groups.csv", groupRows)
+	w.csv("group_posts.csv", postRows)
+	return nil
+}
+
+func (s *Service) writeEvents(ctx context.Context, w *archiveWriter, email string) error {
+	events, err := s.storage.ListUserMeetups(ctx, email)
+	if err != nil {
+		return gterr.New(gterr.Internal, "", fmt.Errorf("list events: %v", err))
+	}
+	if events == nil {
+		events = []*meetup.Event{}
+	}
+
+	rows := [][]string{{"id", "host", "title", "city", "starts_at", "ends_at", "rsvp"}}
+	for _, e := range events {
+		rows = append(rows, []string{
+			strconv.FormatInt(e.ID, 10), e.Host, e.Title, e.City, formatTime(e.StartsAt), formatTime(e.EndsAt), string(e.RSVP),
+		})
+	}
+
+	w.json("events.json", events)
+	w.csv("events.csv", rows)
+	return nil
+}
+
+func (s *Service) writeNotifications(ctx context.Context, w *archiveWriter, email string) error {
+	notifications := make([]*notification.Notification, 0)
+	req := notification.ListRequest{Email: email, Limit: pageSize}
+	for {
+		page, err := s.storage.ListNotifications(ctx, req)
+		if err != nil {
+			return gterr.New(gterr.Internal, "", fmt.Errorf("list notifications: %v", err))
+		}
+		notifications = append(notifications, page...)
+		if len(page) < pageSize {
+			break
+		}
+		req.Before = page[len(page)-1].ID
+	}
+
+	rows := [][]string{{"id", "type", "actor", "read", "created_at"}}
+	for _, n := range notifications {
+		rows = append(rows, []string{
+			strconv.FormatInt(n.ID, 10), string(n.Type), n.Actor, strconv.FormatBool(n.Read), formatTime(n.CreatedAt),
+		})
+	}
+
+	w.json("notifications.json", notifications)
+	w.csv("notifications.csv", rows)
+	return nil
+}
+
+func (s *Service) writeSettings(ctx context.Context, w *archiveWriter, email string) error {
+	prefs, err := s.storage.GetNotificationPreferences(ctx, email)
+	if errors.Is(err, storage.ErrNotFound) {
+		prefs, err = notification.DefaultPreferences(email), nil
+	}
+	if err != nil {
+		return gterr.New(gterr.Internal, "", fmt.Errorf("get notification preferences: %v", err))
+	}
+
+	sub, err := s.storage.GetDigestSubscription(ctx, email)
+	if errors.Is(err, storage.ErrNotFound) {
+		sub, err = &digest.Subscription{Email: email, Frequency: digest.Off}, nil
+	}
+	if err != nil {
+		return gterr.New(gterr.Internal, "", fmt.Errorf("get digest subscription: %v", err))
+	}
+
+	w.json("settings.json", settings{Notifications: prefs, Digest: sub})
+	return nil
+}
+
+// archiveWriter writes the files of a ZIP, keeping the first error.
+type archiveWriter struct {
+	buf bytes.Buffer
+	zw  *zip.Writer
+	err error
+}
+
+func newArchiveWriter() *archiveWriter {
+	w := &archiveWriter{}
+	w.zw = zip.NewWriter(&w.buf)
+	return w
+}
+
+func (w *archiveWriter) json(name string, v interface{}) {
+	if w.err != nil {
+		return
+	}
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		w.err = fmt.Errorf("encode %s: %v", name, err)
+		return
+	}
+
+	f, err := w.zw.Create(name)
+	if err != nil {
+		w.err = err
+		return
+	}
+	_, w.err = f.Write(data)
+}
+
+func (w *archiveWriter) csv(name string, rows [][]string) {
+	if w.err != nil {
+		return
+	}
+
+	f, err := w.zw.Create(name)
+	if err != nil {
+		w.err = err
+		return
+	}
+
+	cw := csv.NewWriter(f)
+	if err := cw.WriteAll(rows); err != nil {
+		w.err = fmt.Errorf("encode %s: %v", name, err)
+	}
+}
+
+func (w *archiveWriter) close() ([]byte, error) {
+	if w.err != nil {
+		return nil, w.err
+	}
+	if err := w.zw.Close(); err != nil {
+		return nil, err
+	}
+	return w.buf.Bytes(), nil
+}
+
+func formatYear(year int) string {
+	if year == 0 {
+		return ""
+	}
+	return strconv.Itoa(year)
+}
+
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(csvDateLayout)
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
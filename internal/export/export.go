@@ -0,0 +1,380 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/victornm/gtonline/internal/community"
+	"github.com/victornm/gtonline/internal/conversation"
+	"github.com/victornm/gtonline/internal/digest"
+	"github.com/victornm/gtonline/internal/friend"
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/job"
+	"github.com/victornm/gtonline/internal/meetup"
+	"github.com/victornm/gtonline/internal/notification"
+	"github.com/victornm/gtonline/internal/profile"
+	"github.com/victornm/gtonline/internal/signed"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+// JobBuild is the job type building an export, its payload is the export ID.
+const JobBuild = "export.build"
+
+const maxListedExports = 10
+
+const tokenPurpose = "export.download"
+
+type (
+	Service struct {
+		storage Storage
+		queue   Queue
+		cfg     Config
+	}
+
+	// Queue runs the exports in the background.
+	Queue interface {
+		Enqueue(ctx context.Context, req job.EnqueueRequest) (*job.Job, error)
+	}
+
+	Storage interface {
+		GetProfile(ctx context.Context, email string) (*profile.Profile, error)
+		// ListProfileVersions returns the versions of req.Email lower than req.Before if given, the newest first.
+		ListProfileVersions(ctx context.Context, req profile.ListProfileHistoryRequest) ([]*profile.ProfileVersion, error)
+		// ListAllFriendships returns the friendships and the pending requests of email as they are stored,
+		// with the deactivated users, who are hidden by ListFriends.
+		ListAllFriendships(ctx context.Context, email string) ([]*friend.Friendship, error)
+		ListFriendLists(ctx context.Context, owner string) ([]*friend.List, error)
+		// ListSentMessages returns the messages sent by email in all its conversations, the oldest first.
+		ListSentMessages(ctx context.Context, email string) ([]*conversation.Message, error)
+		// ListMemberGroups returns all the groups of email with its role, the oldest membership first.
+		ListMemberGroups(ctx context.Context, email string) ([]*community.Group, error)
+		// ListAuthoredPosts returns the community posts written by email, the oldest first.
+		ListAuthoredPosts(ctx context.Context, email string) ([]*community.Post, error)
+		// ListUserMeetups returns the events hosted by email or it was invited to, with its RSVP, the earliest first.
+		ListUserMeetups(ctx context.Context, email string) ([]*meetup.Event, error)
+		// ListNotifications returns the notifications of req.Email with an ID smaller than req.Before if given,
+		// the newest first.
+		ListNotifications(ctx context.Context, req notification.ListRequest) ([]*notification.Notification, error)
+		// GetNotificationPreferences returns storage.ErrNotFound if email never changed its preferences.
+		GetNotificationPreferences(ctx context.Context, email string) (*notification.Preferences, error)
+		// GetDigestSubscription returns storage.ErrNotFound if email never changed its digest settings.
+		GetDigestSubscription(ctx context.Context, email string) (*digest.Subscription, error)
+
+		// InsertExport inserts e and sets its ID, unless e.Email has an export created after since which didn't fail,
+		// then it returns storage.ErrAlreadyExist.
+		InsertExport(ctx context.Context, e *Export, since time.Time) error
+		// GetExport returns storage.ErrNotFound if the export doesn't exist.
+		GetExport(ctx context.Context, id int64) (*Export, error)
+		// ListExports returns the latest exports of email first.
+		ListExports(ctx context.Context, email string, limit int) ([]*Export, error)
+		// UpdateExport saves the status and the expiry of e.
+		UpdateExport(ctx context.Context, e *Export) error
+		// ListExpiredExports returns the ready exports which expired at or before now.
+		ListExpiredExports(ctx context.Context, now time.Time) ([]*Export, error)
+	}
+
+	Config struct {
+		// Dir is where the archives are written, the replicas must share it.
+		Dir string `mapstructure:"dir"`
+		// DownloadURL is the base of the download links, the export ID and the token are appended to it.
+		DownloadURL string `mapstructure:"download_url"`
+		// Secret signs the download links.
+		Secret string `mapstructure:"secret"`
+		// TTL is how long an archive can be downloaded after it is built.
+		TTL time.Duration `mapstructure:"ttl"`
+		// Cooldown is the minimum time between two export requests of a user.
+		Cooldown time.Duration `mapstructure:"cooldown"`
+		// SweepInterval is how often the expired archives are deleted.
+		SweepInterval time.Duration `mapstructure:"sweep_interval"`
+	}
+)
+
+func NewService(s Storage, q Queue, cfg Config) *Service {
+	return &Service{storage: s, queue: q, cfg: cfg.withDefaults()}
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Dir:           "tmp/exports",
+		DownloadURL:   "http://localhost:8080/users/export",
+		TTL:           72 * time.Hour,
+		Cooldown:      24 * time.Hour,
+		SweepInterval: time.Hour,
+	}
+}
+
+func (c Config) withDefaults() Config {
+	d := DefaultConfig()
+	if c.Dir == "" {
+		c.Dir = d.Dir
+	}
+	if c.DownloadURL == "" {
+		c.DownloadURL = d.DownloadURL
+	}
+	if c.TTL <= 0 {
+		c.TTL = d.TTL
+	}
+	if c.Cooldown < 0 {
+		c.Cooldown = 0
+	}
+	return c
+}
+
+type Status string
+
+const (
+	StatusPending Status = "pending"
+	StatusReady   Status = "ready"
+	// StatusFailed is an export whose build failed all its attempts.
+	StatusFailed  Status = "failed"
+	StatusExpired Status = "expired"
+)
+
+type (
+	// Export is an archive of the data of a user.
+	Export struct {
+		ID        int64      `json:"id"`
+		Email     string     `json:"-"`
+		Status    Status     `json:"status"`
+		CreatedAt time.Time  `json:"created_at"`
+		ExpiresAt *time.Time `json:"expires_at,omitempty"`
+		// URL is the signed download link of a ready export.
+		URL string `json:"url,omitempty"`
+	}
+
+	ListResponse struct {
+		Exports []*Export `json:"exports"`
+	}
+
+	DownloadRequest struct {
+		ID    int64
+		Token string
+	}
+
+	// File is the archive of an export.
+	File struct {
+		Name string
+		Path string
+	}
+)
+
+// Request queues an export of the data of email, at most one per Cooldown.
+func (s *Service) Request(ctx context.Context, email string) (*Export, error) {
+	now := time.Now()
+	e := &Export{Email: email, Status: StatusPending, CreatedAt: now}
+
+	err := s.storage.InsertExport(ctx, e, now.Add(-s.cfg.Cooldown))
+	if errors.Is(err, storage.ErrAlreadyExist) {
+		return nil, gterr.New(gterr.ResourceExhausted, fmt.Sprintf("an export was already requested in the last %s", s.cfg.Cooldown), err)
+	}
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", fmt.Errorf("insert export: %v", err))
+	}
+
+	if _, err := s.queue.Enqueue(ctx, job.EnqueueRequest{
+		Type:    JobBuild,
+		Payload: e.ID,
+		Key:     fmt.Sprintf("export:%d", e.ID),
+	}); err != nil {
+		// The export would never be built, so it doesn't count for the cooldown
+		if err := s.Fail(ctx, e.ID); err != nil {
+			return nil, err
+		}
+		return nil, err
+	}
+	return e, nil
+}
+
+// List returns the latest exports of email, with the download links of the ready ones.
+func (s *Service) List(ctx context.Context, email string) (*ListResponse, error) {
+	exports, err := s.storage.ListExports(ctx, email, maxListedExports)
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", fmt.Errorf("list exports: %v", err))
+	}
+
+	for _, e := range exports {
+		if e.Status == StatusReady {
+			e.URL = s.downloadURL(e)
+		}
+	}
+	return &ListResponse{Exports: exports}, nil
+}
+
+// Build writes the archive of the export and makes it downloadable for TTL.
+// It does nothing if the export is not pending, so a retried job doesn't build it again.
+func (s *Service) Build(ctx context.Context, id int64, now time.Time) error {
+	e, err := s.getExport(ctx, id)
+	if err != nil {
+		return err
+	}
+	if e.Status != StatusPending {
+		return nil
+	}
+
+	data, err := s.archive(ctx, e.Email, now)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(s.cfg.Dir, 0o755); err != nil {
+		return gterr.New(gterr.Internal, "", fmt.Errorf("create export dir: %v", err))
+	}
+
+	// The archive appears complete or not at all
+	path := s.path(id)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0o600); err != nil {
+		return gterr.New(gterr.Internal, "", fmt.Errorf("write archive: %v", err))
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return gterr.New(gterr.Internal, "", fmt.Errorf("rename archive: %v", err))
+	}
+
+	expiresAt := now.Add(s.cfg.TTL)
+	e.Status = StatusReady
+	e.ExpiresAt = &expiresAt
+	if err := s.storage.UpdateExport(ctx, e); err != nil {
+		return gterr.New(gterr.Internal, "", fmt.Errorf("update export: %v", err))
+	}
+	return nil
+}
+
+// Fail marks the export as failed, it is called when the build runs out of attempts.
+func (s *Service) Fail(ctx context.Context, id int64) error {
+	e, err := s.getExport(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	e.Status = StatusFailed
+	if err := s.storage.UpdateExport(ctx, e); err != nil {
+		return gterr.New(gterr.Internal, "", fmt.Errorf("update export: %v", err))
+	}
+	return nil
+}
+
+// Download returns the archive of the export if the token of its download link is valid and not expired.
+func (s *Service) Download(ctx context.Context, req DownloadRequest) (*File, error) {
+	if err := s.verifyToken(req.ID, req.Token, time.Now()); err != nil {
+		return nil, err
+	}
+
+	e, err := s.getExport(ctx, req.ID)
+	if err != nil {
+		return nil, err
+	}
+	if e.Status != StatusReady {
+		return nil, gterr.New(gterr.NotFound, fmt.Sprintf("export %d is %s", e.ID, e.Status))
+	}
+
+	return &File{
+		Name: fmt.Sprintf("gtonline-export-%s.zip", e.CreatedAt.UTC().Format("20060102")),
+		Path: s.path(e.ID),
+	}, nil
+}
+
+// DeleteExpired deletes the archives of the expired exports and returns their number.
+func (s *Service) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	exports, err := s.storage.ListExpiredExports(ctx, now)
+	if err != nil {
+		return 0, gterr.New(gterr.Internal, "", fmt.Errorf("list expired exports: %v", err))
+	}
+
+	for i, e := range exports {
+		if err := os.Remove(s.path(e.ID)); err != nil && !os.IsNotExist(err) {
+			return i, gterr.New(gterr.Internal, "", fmt.Errorf("delete archive of export %d: %v", e.ID, err))
+		}
+
+		e.Status = StatusExpired
+		if err := s.storage.UpdateExport(ctx, e); err != nil {
+			return i, gterr.New(gterr.Internal, "", fmt.Errorf("update export %d: %v", e.ID, err))
+		}
+	}
+	return len(exports), nil
+}
+
+// DeleteOrphans deletes the archives whose export doesn't exist any more, e.g. the exports of the purged accounts,
+// and returns their number.
+func (s *Service) DeleteOrphans(ctx context.Context) (int, error) {
+	files, err := ioutil.ReadDir(s.cfg.Dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, gterr.New(gterr.Internal, "", fmt.Errorf("read export dir: %v", err))
+	}
+
+	n := 0
+	for _, f := range files {
+		id, err := strconv.ParseInt(strings.TrimSuffix(f.Name(), ".zip"), 10, 64)
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".zip") || err != nil {
+			continue
+		}
+
+		_, err = s.storage.GetExport(ctx, id)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			return n, gterr.New(gterr.Internal, "", fmt.Errorf("get export %d: %v", id, err))
+		}
+
+		if err := os.Remove(s.path(id)); err != nil && !os.IsNotExist(err) {
+			return n, gterr.New(gterr.Internal, "", fmt.Errorf("delete archive of export %d: %v", id, err))
+		}
+		n++
+	}
+	return n, nil
+}
+
+func (s *Service) getExport(ctx context.Context, id int64) (*Export, error) {
+	e, err := s.storage.GetExport(ctx, id)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, gterr.New(gterr.NotFound, fmt.Sprintf("export %d not found", id), err)
+	}
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", fmt.Errorf("get export: %v", err))
+	}
+	return e, nil
+}
+
+func (s *Service) path(id int64) string {
+	return filepath.Join(s.cfg.Dir, fmt.Sprintf("%d.zip", id))
+}
+
+func (s *Service) downloadURL(e *Export) string {
+	token := s.Token(e.ID, *e.ExpiresAt)
+
+	u, err := url.Parse(strings.TrimSuffix(s.cfg.DownloadURL, "/") + "/" + strconv.FormatInt(e.ID, 10))
+	if err != nil {
+		return ""
+	}
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// Token returns the download token of the export, valid until expiresAt.
+func (s *Service) Token(id int64, expiresAt time.Time) string {
+	return signed.Token(s.cfg.Secret, tokenPurpose, strconv.FormatInt(id, 10), expiresAt)
+}
+
+func (s *Service) verifyToken(id int64, token string, now time.Time) error {
+	subject, err := signed.Verify(s.cfg.Secret, tokenPurpose, token, now)
+	if errors.Is(err, signed.ErrExpired) {
+		return gterr.New(gterr.PermissionDenied, "download link expired", err)
+	}
+	if err != nil || subject != strconv.FormatInt(id, 10) {
+		return gterr.New(gterr.InvalidArgument, "invalid download token", err)
+	}
+	return nil
+}
@@ -0,0 +1,287 @@
+package export_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/victornm/gtonline/internal/community"
+	"github.com/victornm/gtonline/internal/conversation"
+	"github.com/victornm/gtonline/internal/digest"
+	"github.com/victornm/gtonline/internal/export"
+	"github.com/victornm/gtonline/internal/friend"
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/job"
+	"github.com/victornm/gtonline/internal/meetup"
+	"github.com/victornm/gtonline/internal/notification"
+	"github.com/victornm/gtonline/internal/profile"
+	"github.com/victornm/gtonline/internal/storage/memory"
+)
+
+const me = "tony@stark.com"
+
+func makeService(t *testing.T) (*export.Service, *memory.Storage) {
+	t.Helper()
+
+	mock := memory.NewStorage()
+	mock.InsertUsers([]memory.User{
+		{
+			Email: me, FirstName: "Tony", LastName: "Stark",
+			Interests:    []string{"Technology"},
+			Education:    []profile.Attend{{School: "MIT", YearGraduated: 1987, Degree: "BSc"}},
+			Professional: []profile.Employment{{Employer: "Stark Industries", JobTitle: "CEO", IsCurrent: true}},
+		},
+		{Email: "pepper@stark.com"},
+		{Email: "bruce@wayne.com"},
+		{Email: "clark@kent.com"},
+	})
+
+	ctx := context.TODO()
+	now := time.Now()
+	for _, f := range []friend.Friendship{
+		{Email: me, FriendEmail: "pepper@stark.com", Relationship: "Partner", DateConnected: now, RequestedAt: now},
+		{Email: me, FriendEmail: "bruce@wayne.com", Relationship: "Rival", RequestedAt: now},
+		{Email: "clark@kent.com", FriendEmail: me, Relationship: "Colleague", RequestedAt: now},
+	} {
+		f := f
+		require.NoError(t, mock.InsertFriendship(ctx, &f))
+	}
+
+	runner := job.NewRunner(mock, job.DefaultConfig())
+	runner.Register(export.JobBuild, func(context.Context, *job.Job) error { return nil }, job.HandlerConfig{})
+
+	cfg := export.Config{Dir: t.TempDir(), Secret: "secret", TTL: time.Hour, Cooldown: 24 * time.Hour}
+	return export.NewService(mock, runner, cfg), mock
+}
+
+func readArchive(t *testing.T, path string) map[string][]byte {
+	t.Helper()
+
+	r, err := zip.OpenReader(path)
+	require.NoError(t, err)
+	defer r.Close()
+
+	files := make(map[string][]byte)
+	for _, f := range r.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(rc)
+		require.NoError(t, err)
+		rc.Close()
+		files[f.Name] = data
+	}
+	return files
+}
+
+func TestService_Export(t *testing.T) {
+	s, mock := makeService(t)
+	ctx := context.TODO()
+
+	e, err := s.Request(ctx, me)
+	require.NoError(t, err)
+	assert.Equal(t, export.StatusPending, e.Status)
+
+	jobs := mock.Jobs()
+	require.Len(t, jobs, 1)
+	assert.Equal(t, export.JobBuild, jobs[0].Type)
+
+	_, err = s.Request(ctx, me)
+	assert.Equal(t, gterr.ResourceExhausted, gterr.Code(err), "an export per cooldown")
+
+	list, err := s.List(ctx, me)
+	require.NoError(t, err)
+	require.Len(t, list.Exports, 1)
+	assert.Empty(t, list.Exports[0].URL, "no link before the archive is built")
+
+	now := time.Now()
+	require.NoError(t, s.Build(ctx, e.ID, now))
+	require.NoError(t, s.Build(ctx, e.ID, now), "a retried build does nothing")
+
+	list, err = s.List(ctx, me)
+	require.NoError(t, err)
+	require.Equal(t, export.StatusReady, list.Exports[0].Status)
+	u, err := url.Parse(list.Exports[0].URL)
+	require.NoError(t, err)
+	token := u.Query().Get("token")
+
+	f, err := s.Download(ctx, export.DownloadRequest{ID: e.ID, Token: token})
+	require.NoError(t, err)
+
+	files := readArchive(t, f.Path)
+	assert.Len(t, files, 23)
+
+	var p profile.Profile
+	require.NoError(t, json.Unmarshal(files["profile.json"], &p))
+	assert.Equal(t, "Tony", p.FirstName)
+	assert.Equal(t, "BSc", p.Education[0].Degree)
+
+	var requests []map[string]interface{}
+	require.NoError(t, json.Unmarshal(files["friend_requests.json"], &requests))
+	require.Len(t, requests, 2)
+
+	rows, err := csv.NewReader(bytes.NewReader(files["friends.csv"])).ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"friend_email", "relationship", "friend_relationship", "date_connected"}, rows[0])
+	require.Len(t, rows, 2)
+	assert.Equal(t, "pepper@stark.com", rows[1][0])
+
+	rows, err = csv.NewReader(bytes.NewReader(files["friend_requests.csv"])).ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sent", "bruce@wayne.com", "Rival"}, rows[1][:3])
+	assert.Equal(t, []string{"received", "clark@kent.com", "Colleague"}, rows[2][:3])
+
+	_, err = s.Download(ctx, export.DownloadRequest{ID: e.ID, Token: token + "x"})
+	assert.Equal(t, gterr.InvalidArgument, gterr.Code(err))
+
+	_, err = s.Download(ctx, export.DownloadRequest{ID: e.ID + 1, Token: token})
+	assert.Equal(t, gterr.InvalidArgument, gterr.Code(err), "the token is bound to its export")
+
+	_, err = s.Download(ctx, export.DownloadRequest{ID: e.ID, Token: s.Token(e.ID, now.Add(-time.Second))})
+	assert.Equal(t, gterr.PermissionDenied, gterr.Code(err))
+
+	n, err := s.DeleteExpired(ctx, now.Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.NoFileExists(t, f.Path)
+
+	_, err = s.Download(ctx, export.DownloadRequest{ID: e.ID, Token: s.Token(e.ID, now.Add(time.Hour))})
+	assert.Equal(t, gterr.NotFound, gterr.Code(err), "the archive is gone")
+}
+
+func TestService_Export_Datasets(t *testing.T) {
+	s, mock := makeService(t)
+	ctx := context.TODO()
+	now := time.Now()
+
+	// The deactivated friends are hidden by the API, not from the archive
+	require.NoError(t, mock.DeactivateUser(ctx, "pepper@stark.com", now, nil))
+
+	require.NoError(t, mock.InsertFriendList(ctx, &friend.List{Owner: me, Name: "Avengers", Members: []string{"pepper@stark.com"}}))
+
+	c := &conversation.Conversation{Name: "Stark", CreatedAt: now, Participants: []*conversation.Participant{
+		{Email: me, Role: conversation.RoleOwner, JoinedAt: now},
+		{Email: "pepper@stark.com", Role: conversation.RoleMember, JoinedAt: now},
+	}}
+	require.NoError(t, mock.InsertConversation(ctx, c))
+	for _, m := range []*conversation.Message{
+		{ConversationID: c.ID, Type: conversation.MessageText, Sender: me, Body: "I am Iron Man", CreatedAt: now},
+		{ConversationID: c.ID, Type: conversation.MessageText, Sender: "pepper@stark.com", Body: "not mine", CreatedAt: now},
+	} {
+		require.NoError(t, mock.InsertMessage(ctx, m))
+	}
+
+	g := &community.Group{Name: "Inventors", Topic: "Technology", Privacy: community.Public, CreatedAt: now}
+	require.NoError(t, mock.InsertGroup(ctx, g, &community.Member{Email: me, Role: community.RoleOwner, JoinedAt: now}))
+	require.NoError(t, mock.InsertGroupPost(ctx, &community.Post{GroupID: g.ID, Author: me, Body: "Suits", CreatedAt: now}))
+
+	hosted := &meetup.Event{Host: me, Title: "Expo", City: "New York", StartsAt: now, EndsAt: now.Add(time.Hour), Visibility: meetup.Public}
+	invited := &meetup.Event{Host: "bruce@wayne.com", Title: "Gala", City: "Gotham", StartsAt: now.Add(-time.Hour), EndsAt: now, Visibility: meetup.Private}
+	require.NoError(t, mock.InsertMeetup(ctx, hosted))
+	require.NoError(t, mock.InsertMeetup(ctx, invited))
+	require.NoError(t, mock.SaveGuest(ctx, invited.ID, &meetup.Guest{Email: me, Status: meetup.Yes, UpdatedAt: now}))
+
+	require.NoError(t, mock.InsertNotification(ctx, &notification.Notification{Email: me, Type: notification.FriendRequestCreated, Actor: "clark@kent.com", CreatedAt: now}))
+	require.NoError(t, mock.SaveDigestSubscription(ctx, &digest.Subscription{Email: me, Frequency: digest.Weekly}))
+
+	e, err := s.Request(ctx, me)
+	require.NoError(t, err)
+	require.NoError(t, s.Build(ctx, e.ID, now))
+	f, err := s.Download(ctx, export.DownloadRequest{ID: e.ID, Token: s.Token(e.ID, now.Add(time.Hour))})
+	require.NoError(t, err)
+	files := readArchive(t, f.Path)
+
+	rows, err := csv.NewReader(bytes.NewReader(files["friends.csv"])).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	assert.Equal(t, "pepper@stark.com", rows[1][0])
+
+	rows, err = csv.NewReader(bytes.NewReader(files["friend_lists.csv"])).ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"list", "member_email"}, {"Avengers", "pepper@stark.com"}}, rows)
+
+	var messages []conversation.Message
+	require.NoError(t, json.Unmarshal(files["messages.json"], &messages))
+	require.Len(t, messages, 1, "only the messages sent by the user")
+	assert.Equal(t, "I am Iron Man", messages[0].Body)
+
+	var groups []community.Group
+	require.NoError(t, json.Unmarshal(files["groups.json"], &groups))
+	require.Len(t, groups, 1)
+	assert.Equal(t, community.RoleOwner, groups[0].Role)
+
+	var posts []community.Post
+	require.NoError(t, json.Unmarshal(files["group_posts.json"], &posts))
+	require.Len(t, posts, 1)
+
+	var events []meetup.Event
+	require.NoError(t, json.Unmarshal(files["events.json"], &events))
+	require.Len(t, events, 2)
+	assert.Equal(t, "Gala", events[0].Title)
+	assert.Equal(t, meetup.Yes, events[0].RSVP)
+	assert.Equal(t, "Expo", events[1].Title)
+
+	var notifications []notification.Notification
+	require.NoError(t, json.Unmarshal(files["notifications.json"], &notifications))
+	require.Len(t, notifications, 1)
+	assert.Equal(t, "clark@kent.com", notifications[0].Actor)
+
+	var got struct {
+		Notifications notification.Preferences `json:"notifications"`
+		Digest        digest.Subscription      `json:"digest"`
+	}
+	require.NoError(t, json.Unmarshal(files["settings.json"], &got))
+	assert.Equal(t, digest.Weekly, got.Digest.Frequency)
+	assert.NotEmpty(t, got.Notifications.Events, "the default preferences")
+
+	assert.JSONEq(t, "[]", string(files["profile_versions.json"]))
+}
+
+func TestService_Request_Failed(t *testing.T) {
+	s, _ := makeService(t)
+	ctx := context.TODO()
+
+	e, err := s.Request(ctx, me)
+	require.NoError(t, err)
+	require.NoError(t, s.Fail(ctx, e.ID))
+
+	_, err = s.Request(ctx, me)
+	assert.NoError(t, err, "a failed export doesn't count for the cooldown")
+}
+
+func TestService_DeleteOrphans(t *testing.T) {
+	s, mock := makeService(t)
+	ctx := context.TODO()
+
+	e, err := s.Request(ctx, me)
+	require.NoError(t, err)
+	require.NoError(t, s.Build(ctx, e.ID, time.Now()))
+	other, err := s.Request(ctx, "pepper@stark.com")
+	require.NoError(t, err)
+	require.NoError(t, s.Build(ctx, other.ID, time.Now()))
+
+	n, err := s.DeleteOrphans(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	deleteAfter := time.Now()
+	require.NoError(t, mock.DeactivateUser(ctx, me, deleteAfter, &deleteAfter))
+	_, err = mock.DeleteScheduledUsers(ctx, deleteAfter)
+	require.NoError(t, err)
+
+	f, err := s.Download(ctx, export.DownloadRequest{ID: other.ID, Token: s.Token(other.ID, time.Now().Add(time.Hour))})
+	require.NoError(t, err)
+
+	n, err = s.DeleteOrphans(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n, "the archive of the purged account")
+	assert.FileExists(t, f.Path)
+}
@@ -0,0 +1,185 @@
+package friend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/notification"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+const (
+	defaultBirthdayWithin = 30
+	maxBirthdayWithin     = 366
+)
+
+type (
+	BirthdayConfig struct {
+		// Schedule is the cron spec of the daily reminders.
+		Schedule string `mapstructure:"schedule"`
+	}
+
+	// Birthday is the upcoming birthday of a friend, the birth year is not returned.
+	Birthday struct {
+		Email     string    `json:"email"`
+		FirstName string    `json:"first_name"`
+		LastName  string    `json:"last_name"`
+		Birthdate time.Time `json:"-"`
+		// Next is the date of the next birthday, at or after the day of the request.
+		Next      time.Time `json:"-"`
+		DaysUntil int       `json:"days_until"`
+	}
+
+	ListBirthdaysRequest struct {
+		Email string `form:"-"`
+		// Within is the number of days to look ahead, e.g. 30d.
+		Within string `form:"within"`
+	}
+
+	ListBirthdaysResponse struct {
+		Birthdays []*Birthday `json:"birthdays"`
+	}
+
+	BirthdaySettings struct {
+		// Share tells whether the friends see the birthday of the user and get reminded of it.
+		Share bool `json:"share"`
+	}
+
+	UpdateBirthdaySettingsRequest struct {
+		Email string `json:"-"`
+		Share *bool  `json:"share" binding:"required"`
+	}
+
+	// BirthdayReminder tells Email that today is the birthday of FriendEmail.
+	BirthdayReminder struct {
+		Email       string
+		FriendEmail string
+	}
+)
+
+func (b Birthday) MarshalJSON() ([]byte, error) {
+	type alias Birthday
+
+	return json.Marshal(struct {
+		alias
+		Birthday string `json:"birthday"`
+		Next     string `json:"next"`
+	}{
+		alias:    alias(b),
+		Birthday: b.Birthdate.Format("January 02"),
+		Next:     b.Next.Format("2006-01-02"),
+	})
+}
+
+// NextBirthday returns the first birthday of birthdate at or after the day of from, at midnight UTC.
+// Feb 29 birthdays are on Feb 28 in the common years, the same as DATE_ADD of MySQL.
+func NextBirthday(birthdate, from time.Time) time.Time {
+	day := Date(from)
+	for year := day.Year(); ; year++ {
+		if b := anniversary(birthdate, year); !b.Before(day) {
+			return b
+		}
+	}
+}
+
+// Date returns the day of t at midnight UTC.
+func Date(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func anniversary(birthdate time.Time, year int) time.Time {
+	month, day := birthdate.Month(), birthdate.Day()
+	// time.Date normalizes Feb 29 of a common year to Mar 1
+	if month == time.February && day == 29 && time.Date(year, time.March, 0, 0, 0, 0, 0, time.UTC).Day() != 29 {
+		day = 28
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func parseWithin(s string) (int, error) {
+	if s == "" {
+		return defaultBirthdayWithin, nil
+	}
+
+	days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+	if err != nil || !strings.HasSuffix(s, "d") || days < 1 || days > maxBirthdayWithin {
+		return 0, fmt.Errorf("invalid within %q, expect 1d to %dd", s, maxBirthdayWithin)
+	}
+	return days, nil
+}
+
+// ListBirthdays returns the friends of req.Email whose birthday is in the next req.Within days, today included.
+func (s *Service) ListBirthdays(ctx context.Context, req ListBirthdaysRequest) (*ListBirthdaysResponse, error) {
+	days, err := parseWithin(req.Within)
+	if err != nil {
+		return nil, gterr.New(gterr.InvalidArgument, err.Error(), err)
+	}
+
+	today := Date(time.Now())
+	birthdays, err := s.storage.ListFriendBirthdays(ctx, req.Email, today, days)
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", fmt.Errorf("list friend birthdays: %v", err))
+	}
+
+	for _, b := range birthdays {
+		b.DaysUntil = int(b.Next.Sub(today).Hours() / 24)
+	}
+	return &ListBirthdaysResponse{Birthdays: birthdays}, nil
+}
+
+func (s *Service) GetBirthdaySettings(ctx context.Context, email string) (*BirthdaySettings, error) {
+	share, err := s.storage.GetBirthdaySharing(ctx, email)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, gterr.New(gterr.NotFound, "user not found", err)
+	}
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+	return &BirthdaySettings{Share: share}, nil
+}
+
+func (s *Service) UpdateBirthdaySettings(ctx context.Context, req UpdateBirthdaySettingsRequest) (*BirthdaySettings, error) {
+	if req.Share == nil {
+		return nil, gterr.New(gterr.InvalidArgument, "share is required")
+	}
+
+	err := s.storage.UpdateBirthdaySharing(ctx, req.Email, *req.Share)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, gterr.New(gterr.NotFound, "user not found", err)
+	}
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+	return &BirthdaySettings{Share: *req.Share}, nil
+}
+
+// RemindBirthdays notifies the users whose friends have their birthday on the day of now, returns the number of reminders.
+// A failed reminder is logged and skipped, retrying the run would notify the others again.
+func (s *Service) RemindBirthdays(ctx context.Context, n Notifier, now time.Time) (int, error) {
+	reminders, err := s.storage.ListBirthdayReminders(ctx, Date(now))
+	if err != nil {
+		return 0, gterr.New(gterr.Internal, "", fmt.Errorf("list birthday reminders: %v", err))
+	}
+
+	sent := 0
+	for _, r := range reminders {
+		err := n.Notify(ctx, notification.Notification{
+			Email: r.Email,
+			Type:  notification.FriendBirthday,
+			Actor: r.FriendEmail,
+		})
+		if err != nil {
+			log.Printf("remind %s of the birthday of %s: %v", r.Email, r.FriendEmail, err)
+			continue
+		}
+		sent++
+	}
+	return sent, nil
+}
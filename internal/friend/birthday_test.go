@@ -0,0 +1,120 @@
+package friend_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/victornm/gtonline/internal/friend"
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/notification"
+	"github.com/victornm/gtonline/internal/storage/memory"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestNextBirthday(t *testing.T) {
+	tests := []struct {
+		name      string
+		birthdate time.Time
+		from      time.Time
+		want      time.Time
+	}{
+		{name: "later this year", birthdate: date(1990, 8, 15), from: date(2021, 8, 2), want: date(2021, 8, 15)},
+		{name: "today", birthdate: date(1990, 8, 2), from: time.Date(2021, 8, 2, 23, 59, 0, 0, time.UTC), want: date(2021, 8, 2)},
+		{name: "wrap to next year", birthdate: date(1990, 1, 5), from: date(2021, 12, 20), want: date(2022, 1, 5)},
+		{name: "feb 29 in a common year", birthdate: date(1992, 2, 29), from: date(2021, 2, 1), want: date(2021, 2, 28)},
+		{name: "feb 29 in a leap year", birthdate: date(1992, 2, 29), from: date(2024, 2, 1), want: date(2024, 2, 29)},
+		{name: "feb 29 past in a common year", birthdate: date(1992, 2, 29), from: date(2023, 3, 1), want: date(2024, 2, 29)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, friend.NextBirthday(test.birthdate, test.from))
+		})
+	}
+}
+
+func TestService_ListBirthdays(t *testing.T) {
+	today := friend.Date(time.Now())
+	// The birth years don't matter, only the next birthday
+	users := []memory.User{
+		{Email: "foo@mock.com"},
+		{Email: "bar@mock.com", Birthdate: today.AddDate(-30, 0, 10)},
+		{Email: "baz@mock.com", Birthdate: today.AddDate(-28, 0, 0)},
+		{Email: "qux@mock.com", Birthdate: today.AddDate(-20, 0, 40)},
+		{Email: "hidden@mock.com", Birthdate: today.AddDate(-20, 0, 1)},
+		{Email: "pending@mock.com", Birthdate: today.AddDate(-20, 0, 1)},
+	}
+
+	mock := memory.NewStorage()
+	mock.InsertUsers(users)
+	s := makeService(t, mock)
+	ctx := context.TODO()
+
+	for _, email := range []string{"bar@mock.com", "baz@mock.com", "qux@mock.com", "hidden@mock.com"} {
+		require.NoError(t, mock.InsertFriendship(ctx, &friend.Friendship{Email: email, FriendEmail: "foo@mock.com", DateConnected: today}))
+	}
+	require.NoError(t, mock.InsertFriendship(ctx, &friend.Friendship{Email: "pending@mock.com", FriendEmail: "foo@mock.com"}))
+
+	_, err := s.UpdateBirthdaySettings(ctx, friend.UpdateBirthdaySettingsRequest{Email: "hidden@mock.com", Share: new(bool)})
+	require.NoError(t, err)
+
+	birthdays := func(t *testing.T, within string) []string {
+		res, err := s.ListBirthdays(ctx, friend.ListBirthdaysRequest{Email: "foo@mock.com", Within: within})
+		require.NoError(t, err)
+
+		var got []string
+		for _, b := range res.Birthdays {
+			got = append(got, b.Email)
+		}
+		return got
+	}
+
+	assert.Equal(t, []string{"baz@mock.com", "bar@mock.com"}, birthdays(t, ""))
+	assert.Equal(t, []string{"baz@mock.com", "bar@mock.com", "qux@mock.com"}, birthdays(t, "60d"))
+
+	res, err := s.ListBirthdays(ctx, friend.ListBirthdaysRequest{Email: "foo@mock.com", Within: "30d"})
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.Birthdays[0].DaysUntil)
+	assert.Equal(t, 10, res.Birthdays[1].DaysUntil)
+
+	for _, within := range []string{"30", "0d", "400d", "1w"} {
+		_, err := s.ListBirthdays(ctx, friend.ListBirthdaysRequest{Email: "foo@mock.com", Within: within})
+		assert.Equal(t, gterr.InvalidArgument, gterr.Code(err), within)
+	}
+}
+
+func TestService_RemindBirthdays(t *testing.T) {
+	mock := memory.NewStorage()
+	mock.InsertUsers([]memory.User{
+		{Email: "foo@mock.com", Birthdate: date(1992, 2, 29)},
+		{Email: "bar@mock.com"},
+		{Email: "baz@mock.com", Birthdate: date(1990, 3, 1)},
+	})
+	s := makeService(t, mock)
+	notifications := notification.NewService(mock, nil, nil)
+	ctx := context.TODO()
+
+	require.NoError(t, mock.InsertFriendship(ctx, &friend.Friendship{Email: "foo@mock.com", FriendEmail: "bar@mock.com", DateConnected: time.Now()}))
+	require.NoError(t, mock.InsertFriendship(ctx, &friend.Friendship{Email: "baz@mock.com", FriendEmail: "bar@mock.com", DateConnected: time.Now()}))
+
+	// Feb 29 is celebrated on Feb 28 in 2021
+	n, err := s.RemindBirthdays(ctx, notifications, time.Date(2021, 2, 28, 7, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	res, err := notifications.List(ctx, notification.ListRequest{Email: "bar@mock.com"})
+	require.NoError(t, err)
+	require.Len(t, res.Notifications, 1)
+	assert.Equal(t, notification.FriendBirthday, res.Notifications[0].Type)
+	assert.Equal(t, "foo@mock.com", res.Notifications[0].Actor)
+
+	n, err = s.RemindBirthdays(ctx, notifications, time.Date(2021, 3, 1, 7, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n, "only baz, the birthday of foo was yesterday")
+}
@@ -0,0 +1,64 @@
+package friend
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/victornm/gtonline/internal/event"
+	"github.com/victornm/gtonline/internal/notification"
+)
+
+const (
+	EventFriendshipCreated  = "friendship.created"
+	EventFriendshipAccepted = "friendship.accepted"
+	EventFriendshipRejected = "friendship.rejected"
+)
+
+type (
+	// FriendshipEvent is the payload of the friendship events, Email is the user who sent the request.
+	FriendshipEvent struct {
+		Email       string `json:"email"`
+		FriendEmail string `json:"friend_email"`
+	}
+
+	// FriendshipCreated is published when a friend request is sent.
+	FriendshipCreated FriendshipEvent
+	// FriendshipAccepted is published when 2 users become friends.
+	FriendshipAccepted FriendshipEvent
+	// FriendshipRejected is published when a pending request is rejected.
+	FriendshipRejected FriendshipEvent
+
+	// Notifier delivers the notifications about friend requests.
+	Notifier interface {
+		Notify(ctx context.Context, n notification.Notification) error
+	}
+)
+
+func (FriendshipCreated) EventName() string  { return EventFriendshipCreated }
+func (FriendshipAccepted) EventName() string { return EventFriendshipAccepted }
+func (FriendshipRejected) EventName() string { return EventFriendshipRejected }
+
+// NotificationHandler notifies the other side of the friendship events:
+// the receiver of a new request, and the sender of an accepted or rejected request.
+func NotificationHandler(n Notifier) event.Handler {
+	return func(ctx context.Context, r *event.Record) error {
+		var e FriendshipEvent
+		if err := r.Decode(&e); err != nil {
+			return err
+		}
+
+		notice := notification.Notification{Email: e.Email, Actor: e.FriendEmail}
+		switch r.Name {
+		case EventFriendshipCreated:
+			notice = notification.Notification{Email: e.FriendEmail, Type: notification.FriendRequestCreated, Actor: e.Email}
+		case EventFriendshipAccepted:
+			notice.Type = notification.FriendRequestAccepted
+		case EventFriendshipRejected:
+			notice.Type = notification.FriendRequestRejected
+		default:
+			return fmt.Errorf("unexpected event %s", r.Name)
+		}
+
+		return n.Notify(ctx, notice)
+	}
+}
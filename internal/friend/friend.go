@@ -8,6 +8,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/victornm/gtonline/internal/event"
 	"github.com/victornm/gtonline/internal/gterr"
 	"github.com/victornm/gtonline/internal/storage"
 )
@@ -15,40 +16,175 @@ import (
 type (
 	Service struct {
 		storage Storage
+		cfg     Config
+	}
+
+	Config struct {
+		Path     PathConfig     `mapstructure:"path"`
+		Request  RequestConfig  `mapstructure:"request"`
+		Birthday BirthdayConfig `mapstructure:"birthday"`
+	}
+
+	RequestConfig struct {
+		// TTL is how long a pending friend request lives before expired.
+		TTL time.Duration `mapstructure:"ttl"`
+		// SweepInterval is how often the expired requests are deleted.
+		SweepInterval time.Duration `mapstructure:"sweep_interval"`
 	}
 
 	Storage interface {
+		// SearchUsers returns at most req.Limit users matching req with an email greater than req.After, by email,
+		// with the count and the facets of all the matched users.
 		SearchUsers(ctx context.Context, req SearchFriendsRequest) (*SearchFriendsResponse, error)
+		// IsUserDeactivated returns false if the user doesn't exist.
+		IsUserDeactivated(ctx context.Context, email string) (bool, error)
+		// ListFriends returns the accepted friendships of email, seen from the email side
+		// no matter who sent the request.
 		ListFriends(ctx context.Context, email string) ([]*Friendship, error)
 		ListPendingFriendships(ctx context.Context, email string) ([]*Friendship, error)
+		// GetFriendship returns storage.ErrNotFound if one of the users is deactivated.
 		GetFriendship(ctx context.Context, email, friendEmail string) (*Friendship, error)
 		InsertFriendship(ctx context.Context, f *Friendship) error
 		UpdateFriendship(ctx context.Context, f *Friendship) error
 		DeleteFriendRequest(ctx context.Context, email, friendEmail string) error
+		// DeleteExpiredFriendRequests deletes the pending requests created before the given time.
+		DeleteExpiredFriendRequests(ctx context.Context, before time.Time) (int64, error)
+
+		// ListConnections returns at most limit connections of the given emails,
+		// ordered by (Email, FriendEmail) and starting right after the given connection.
+		ListConnections(ctx context.Context, emails []string, after Connection, limit int) ([]Connection, error)
+
+		// WithFriendshipLock runs f while no other WithFriendshipLock between the same 2 users is running.
+		// The changes made through tx are applied atomically if the storage supports it.
+		WithFriendshipLock(ctx context.Context, email, friendEmail string, f func(ctx context.Context, tx Storage) error) error
+		// The events appended through the tx of WithFriendshipLock are written with the other changes of tx.
+		event.Outbox
+
+		ListRelationshipTypes(ctx context.Context) ([]RelationshipType, error)
+		InsertRelationshipType(ctx context.Context, t RelationshipType) error
+		DeleteRelationshipType(ctx context.Context, name string) error
+
+		ListFriendLists(ctx context.Context, owner string) ([]*List, error)
+		GetFriendList(ctx context.Context, id int64) (*List, error)
+		// InsertFriendList inserts l and sets its ID.
+		InsertFriendList(ctx context.Context, l *List) error
+		UpdateFriendList(ctx context.Context, l *List) error
+		DeleteFriendList(ctx context.Context, id int64) error
+		InsertFriendListMember(ctx context.Context, id int64, email string) error
+		DeleteFriendListMember(ctx context.Context, id int64, email string) error
+		IsFriendListMember(ctx context.Context, owner string, ids []int64, email string) (bool, error)
+
+		// ListFriendBirthdays returns the shared birthdays of the friends of email in the given days from the day of from,
+		// ordered by their next birthday, with Next set.
+		ListFriendBirthdays(ctx context.Context, email string, from time.Time, days int) ([]*Birthday, error)
+		// ListBirthdayReminders returns the friends with a shared birthday on the given day.
+		ListBirthdayReminders(ctx context.Context, on time.Time) ([]BirthdayReminder, error)
+		// GetBirthdaySharing returns storage.ErrNotFound if email is not a regular user.
+		GetBirthdaySharing(ctx context.Context, email string) (bool, error)
+		// UpdateBirthdaySharing returns storage.ErrNotFound if email is not a regular user.
+		UpdateBirthdaySharing(ctx context.Context, email string, share bool) error
 	}
 )
 
-func NewService(s Storage) *Service {
-	return &Service{storage: s}
+func NewService(s Storage, cfg Config) *Service {
+	return &Service{storage: s, cfg: cfg.withDefaults()}
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Path: DefaultPathConfig(),
+		Request: RequestConfig{
+			TTL:           30 * 24 * time.Hour,
+			SweepInterval: time.Hour,
+		},
+		Birthday: BirthdayConfig{
+			Schedule: "0 7 * * *",
+		},
+	}
+}
+
+// withDefaults fills the zero fields of c with the value from DefaultConfig.
+func (c Config) withDefaults() Config {
+	d := DefaultConfig()
+	if c.Path.MaxDepth <= 0 {
+		c.Path.MaxDepth = d.Path.MaxDepth
+	}
+	if c.Path.MaxVisited <= 0 {
+		c.Path.MaxVisited = d.Path.MaxVisited
+	}
+	if c.Path.PageSize <= 0 {
+		c.Path.PageSize = d.Path.PageSize
+	}
+	if c.Path.Timeout <= 0 {
+		c.Path.Timeout = d.Path.Timeout
+	}
+	if c.Request.TTL <= 0 {
+		c.Request.TTL = d.Request.TTL
+	}
+	if c.Request.SweepInterval <= 0 {
+		c.Request.SweepInterval = d.Request.SweepInterval
+	}
+	if c.Birthday.Schedule == "" {
+		c.Birthday.Schedule = d.Birthday.Schedule
+	}
+	return c
 }
 
 type (
 	Friendship struct {
-		Email         string    `json:"-"`
-		FriendEmail   string    `json:"friend_email"`
-		Relationship  string    `json:"relationship"`
-		DateConnected time.Time `json:"date_connected"`
+		Email       string `json:"-"`
+		FriendEmail string `json:"friend_email"`
+		// Relationship is the label given by Email to FriendEmail.
+		Relationship string `json:"relationship"`
+		// FriendRelationship is the label given by FriendEmail to Email.
+		FriendRelationship string    `json:"friend_relationship,omitempty"`
+		DateConnected      time.Time `json:"date_connected"`
+		RequestedAt        time.Time `json:"-"`
 	}
 
+	// SearchFriendsRequest matches the users by Email, Name or Hometown, and narrows them down by the other fields.
 	SearchFriendsRequest struct {
 		Email    string `form:"email"`
 		Name     string `form:"name"`
 		Hometown string `form:"hometown"`
+
+		CurrentCity string `form:"current_city"`
+		School      string `form:"school"`
+		// YearFrom and YearTo bound the graduation year, at School if given.
+		YearFrom int    `form:"year_from"`
+		YearTo   int    `form:"year_to"`
+		Employer string `form:"employer"`
+		// JobTitle is matched in the same employment as Employer if given.
+		JobTitle string `form:"job_title"`
+		// Interest is matched with its spellings of the interest catalog.
+		Interest string `form:"interest"`
+
+		// After is the cursor for pagination, only the users with a greater email are returned.
+		After string `form:"after"`
+		Limit int    `form:"limit" binding:"gte=0,lte=100"`
 	}
 
+	// SearchFriendsResponse is a page of the matched users, by email. Count and Facets are of all the matched users.
 	SearchFriendsResponse struct {
-		Count int
-		Users []User
+		Count  int          `json:"count"`
+		Users  []User       `json:"users"`
+		Facets SearchFacets `json:"facets"`
+		// NextAfter is the After of the next page, empty if there is no more user.
+		NextAfter string `json:"next_after,omitempty"`
+	}
+
+	// SearchFacets count the matched users by school, employer, interest and city, at most FacetSize values each,
+	// the most users first.
+	SearchFacets struct {
+		Schools       []FacetCount `json:"schools"`
+		Employers     []FacetCount `json:"employers"`
+		Interests     []FacetCount `json:"interests"`
+		CurrentCities []FacetCount `json:"current_cities"`
+	}
+
+	FacetCount struct {
+		Value string `json:"value" db:"value"`
+		Count int    `json:"count" db:"count"`
 	}
 
 	User struct {
@@ -70,14 +206,16 @@ type (
 	}
 
 	Request struct {
-		Email        string `json:"email"`
-		Relationship string `json:"relationship"`
+		Email        string    `json:"email"`
+		Relationship string    `json:"relationship"`
+		RequestedAt  time.Time `json:"requested_at"`
+		ExpiresAt    time.Time `json:"expires_at"`
 	}
 
 	CreateFriendRequest struct {
 		Email        string
 		FriendEmail  string
-		Relationship string `json:"relationship"`
+		Relationship string `json:"relationship" binding:"max=50"`
 	}
 
 	AcceptFriendRequest struct {
@@ -107,12 +245,49 @@ func (f Friendship) MarshalJSON() ([]byte, error) {
 	return json.Marshal(data)
 }
 
+// FacetSize is the number of values of each search facet.
+const FacetSize = 10
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+func searchLimit(n int) int {
+	if n <= 0 {
+		return defaultSearchLimit
+	}
+	if n > maxSearchLimit {
+		return maxSearchLimit
+	}
+	return n
+}
+
 func (s *Service) SearchFriends(ctx context.Context, req SearchFriendsRequest) (*SearchFriendsResponse, error) {
-	res, err := s.storage.SearchUsers(ctx, req)
+	if req.YearFrom < 0 || req.YearTo < 0 {
+		return nil, gterr.New(gterr.InvalidArgument, "negative year")
+	}
+	if req.YearTo != 0 && req.YearFrom > req.YearTo {
+		return nil, gterr.New(gterr.InvalidArgument, "year_from after year_to")
+	}
+
+	req.Limit = searchLimit(req.Limit)
+	// Query one more to know if there is a next page
+	query := req
+	query.Limit++
+
+	res, err := s.storage.SearchUsers(ctx, query)
 	if err != nil {
 		return nil, gterr.New(gterr.Internal, "", err)
 	}
 
+	if len(res.Users) > req.Limit {
+		res.Users = res.Users[:req.Limit]
+		res.NextAfter = res.Users[req.Limit-1].Email
+	}
+	if res.Users == nil {
+		res.Users = []User{}
+	}
 	return res, nil
 }
 
@@ -125,9 +300,10 @@ func (s *Service) ListFriend(ctx context.Context, email string) (*ListFriendsRes
 	res := new(ListFriendsResponse)
 	for _, f := range friendships {
 		res.Friends = append(res.Friends, Friendship{
-			FriendEmail:   f.FriendEmail,
-			Relationship:  f.Relationship,
-			DateConnected: f.DateConnected,
+			FriendEmail:        f.FriendEmail,
+			Relationship:       f.Relationship,
+			FriendRelationship: f.FriendRelationship,
+			DateConnected:      f.DateConnected,
 		})
 	}
 
@@ -142,16 +318,24 @@ func (s *Service) ListFriendRequests(ctx context.Context, email string) (*ListFr
 
 	res := new(ListFriendRequestResponse)
 	for _, f := range friendships {
+		if s.isExpired(f) {
+			continue
+		}
+
 		if strings.EqualFold(email, f.Email) {
 			res.RequestTo = append(res.RequestTo, Request{
 				Email:        f.FriendEmail,
 				Relationship: f.Relationship,
+				RequestedAt:  f.RequestedAt,
+				ExpiresAt:    s.expiresAt(f),
 			})
 		}
 		if strings.EqualFold(email, f.FriendEmail) {
 			res.RequestFrom = append(res.RequestFrom, Request{
 				Email:        f.Email,
 				Relationship: f.Relationship,
+				RequestedAt:  f.RequestedAt,
+				ExpiresAt:    s.expiresAt(f),
 			})
 		}
 	}
@@ -159,36 +343,96 @@ func (s *Service) ListFriendRequests(ctx context.Context, email string) (*ListFr
 	return res, nil
 }
 
+// CreateFriend sends a friend request from req.Email to req.FriendEmail.
+// If req.FriendEmail already sent a pending request to req.Email, they become friends immediately.
 func (s *Service) CreateFriend(ctx context.Context, req CreateFriendRequest) error {
 	if strings.EqualFold(req.Email, req.FriendEmail) {
 		return gterr.New(gterr.InvalidArgument, "can't be friend with yourself")
 	}
 
-	f, err := s.storage.GetFriendship(ctx, req.Email, req.FriendEmail)
+	err := s.storage.WithFriendshipLock(ctx, req.Email, req.FriendEmail, func(ctx context.Context, tx Storage) error {
+		e, err := s.createFriend(ctx, tx, req)
+		if err != nil || e == nil {
+			return err
+		}
+		return tx.AppendEvents(ctx, e)
+	})
+	if _, ok := gterr.FromError(err); err != nil && !ok {
+		return gterr.New(gterr.Internal, "", err)
+	}
+	return err
+}
+
+// createFriend returns the event of the change, nil if a pending request is only renewed.
+func (s *Service) createFriend(ctx context.Context, tx Storage, req CreateFriendRequest) (event.Event, error) {
+	// The deactivated users are hidden, so they can't be asked either
+	deactivated, err := tx.IsUserDeactivated(ctx, req.FriendEmail)
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", fmt.Errorf("check deactivated user: %v", err))
+	}
+	if deactivated {
+		msg := fmt.Sprintf("the requested email is not found: email=%s friend_email=%s", req.Email, req.FriendEmail)
+		return nil, gterr.New(gterr.NotFound, msg)
+	}
+
+	reverse, err := tx.GetFriendship(ctx, req.FriendEmail, req.Email)
 	if err != nil && !errors.Is(err, storage.ErrNotFound) {
-		return gterr.New(gterr.Internal, "", fmt.Errorf("get friendship: %v", err))
+		return nil, gterr.New(gterr.Internal, "", fmt.Errorf("get reverse friendship: %v", err))
+	}
+
+	if err == nil && !reverse.DateConnected.IsZero() {
+		return nil, gterr.New(gterr.AlreadyExists, fmt.Sprintf("%s and %s already friends", req.Email, req.FriendEmail))
+	}
+
+	if err == nil && !s.isExpired(reverse) {
+		e := FriendshipAccepted{Email: req.FriendEmail, FriendEmail: req.Email}
+		return e, s.connectCrossingRequests(ctx, tx, reverse, req)
+	}
+
+	f, err := tx.GetFriendship(ctx, req.Email, req.FriendEmail)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, gterr.New(gterr.Internal, "", fmt.Errorf("get friendship: %v", err))
 	}
 
 	if errors.Is(err, storage.ErrNotFound) {
-		return s.insertFriendship(ctx, req)
+		e := FriendshipCreated{Email: req.Email, FriendEmail: req.FriendEmail}
+		return e, s.insertFriendship(ctx, tx, req)
 	}
 
 	if !f.DateConnected.IsZero() {
-		return gterr.New(gterr.AlreadyExists, fmt.Sprintf("%s and %s already friends", req.Email, req.FriendEmail))
+		return nil, gterr.New(gterr.AlreadyExists, fmt.Sprintf("%s and %s already friends", req.Email, req.FriendEmail))
 	}
 
+	// Re-sending a request renews it
 	f.Relationship = req.Relationship
-	if err := s.storage.UpdateFriendship(ctx, f); err != nil {
+	f.RequestedAt = time.Now()
+	if err := tx.UpdateFriendship(ctx, f); err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+	return nil, nil
+}
+
+// connectCrossingRequests accepts the pending request reverse, which was sent from req.FriendEmail to req.Email.
+// The request from req.Email, if any, is merged into reverse, each side keeps its own relationship.
+func (s *Service) connectCrossingRequests(ctx context.Context, tx Storage, reverse *Friendship, req CreateFriendRequest) error {
+	if err := tx.DeleteFriendRequest(ctx, req.Email, req.FriendEmail); err != nil {
+		return gterr.New(gterr.Internal, "", fmt.Errorf("delete crossing request: %v", err))
+	}
+
+	reverse.FriendRelationship = req.Relationship
+	reverse.DateConnected = time.Now()
+	if err := tx.UpdateFriendship(ctx, reverse); err != nil {
 		return gterr.New(gterr.Internal, "", err)
 	}
 	return nil
 }
 
-func (s *Service) insertFriendship(ctx context.Context, req CreateFriendRequest) error {
-	err := s.storage.InsertFriendship(ctx, &Friendship{
+func (s *Service) insertFriendship(ctx context.Context, tx Storage, req CreateFriendRequest) error {
+	err := tx.InsertFriendship(ctx, &Friendship{
 		Email:        req.Email,
 		FriendEmail:  req.FriendEmail,
 		Relationship: req.Relationship,
+		RequestedAt:  time.Now(),
 	})
 	if errors.Is(err, storage.ErrInvalidArgument) {
 		msg := fmt.Sprintf("the requested email is not found: email=%s friend_email=%s", req.Email, req.FriendEmail)
@@ -207,23 +451,42 @@ func (s *Service) AcceptFriendRequest(ctx context.Context, req AcceptFriendReque
 		return gterr.New(gterr.InvalidArgument, "2 email must be different")
 	}
 
-	f, err := s.storage.GetFriendship(ctx, req.EmailRequest, req.Email)
+	err := s.storage.WithFriendshipLock(ctx, req.EmailRequest, req.Email, func(ctx context.Context, tx Storage) error {
+		return s.acceptFriendRequest(ctx, tx, req)
+	})
+	if _, ok := gterr.FromError(err); err != nil && !ok {
+		return gterr.New(gterr.Internal, "", err)
+	}
+	return err
+}
+
+func (s *Service) acceptFriendRequest(ctx context.Context, tx Storage, req AcceptFriendRequest) error {
+	f, err := tx.GetFriendship(ctx, req.EmailRequest, req.Email)
 	if storage.IsErrNotFound(err) {
 		msg := fmt.Sprintf("the friend request from %s to %s is not exist", req.EmailRequest, req.Email)
 		return gterr.New(gterr.FailedPrecondition, msg, err)
 	}
 
+	if err != nil {
+		return gterr.New(gterr.Internal, "", fmt.Errorf("get friendship: %v", err))
+	}
+
 	if !f.DateConnected.IsZero() {
 		msg := fmt.Sprintf("%s already accept the request from %s", req.Email, req.EmailRequest)
 		return gterr.New(gterr.AlreadyExists, msg)
 	}
 
+	if s.isExpired(f) {
+		msg := fmt.Sprintf("the friend request from %s to %s is expired", req.EmailRequest, req.Email)
+		return gterr.New(gterr.FailedPrecondition, msg)
+	}
+
 	f.DateConnected = time.Now()
-	if err := s.storage.UpdateFriendship(ctx, f); err != nil {
+	if err := tx.UpdateFriendship(ctx, f); err != nil {
 		return gterr.New(gterr.Internal, "", err)
 	}
 
-	return nil
+	return tx.AppendEvents(ctx, FriendshipAccepted{Email: req.EmailRequest, FriendEmail: req.Email})
 }
 
 func (s *Service) CancelFriendRequest(ctx context.Context, req DeleteFriendRequest) error {
@@ -234,8 +497,41 @@ func (s *Service) CancelFriendRequest(ctx context.Context, req DeleteFriendReque
 }
 
 func (s *Service) RejectFriendRequest(ctx context.Context, req DeleteFriendRequest) error {
-	if err := s.storage.DeleteFriendRequest(ctx, req.FriendEmail, req.Email); err != nil {
-		return gterr.New(gterr.Internal, "failed to delete friend request", err)
+	err := s.storage.WithFriendshipLock(ctx, req.FriendEmail, req.Email, func(ctx context.Context, tx Storage) error {
+		f, err := tx.GetFriendship(ctx, req.FriendEmail, req.Email)
+		if storage.IsErrNotFound(err) || (err == nil && !f.DateConnected.IsZero()) {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("get friendship: %v", err)
+		}
+
+		if err := tx.DeleteFriendRequest(ctx, req.FriendEmail, req.Email); err != nil {
+			return gterr.New(gterr.Internal, "failed to delete friend request", err)
+		}
+
+		return tx.AppendEvents(ctx, FriendshipRejected{Email: req.FriendEmail, FriendEmail: req.Email})
+	})
+	if _, ok := gterr.FromError(err); err != nil && !ok {
+		return gterr.New(gterr.Internal, "", err)
 	}
-	return nil
+	return err
+}
+
+// DeleteExpiredRequests deletes all the pending requests which are expired, returns the number of deleted requests.
+func (s *Service) DeleteExpiredRequests(ctx context.Context) (int64, error) {
+	n, err := s.storage.DeleteExpiredFriendRequests(ctx, time.Now().Add(-s.cfg.Request.TTL))
+	if err != nil {
+		return 0, gterr.New(gterr.Internal, "failed to delete expired friend requests", err)
+	}
+	return n, nil
+}
+
+func (s *Service) expiresAt(f *Friendship) time.Time {
+	return f.RequestedAt.Add(s.cfg.Request.TTL)
+}
+
+func (s *Service) isExpired(f *Friendship) bool {
+	return f.DateConnected.IsZero() && time.Now().After(s.expiresAt(f))
 }
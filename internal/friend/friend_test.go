@@ -8,8 +8,11 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/victornm/gtonline/internal/event"
 	"github.com/victornm/gtonline/internal/friend"
 	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/notification"
+	"github.com/victornm/gtonline/internal/profile"
 	"github.com/victornm/gtonline/internal/storage/memory"
 )
 
@@ -113,6 +116,599 @@ func TestService_CreateFriend(t *testing.T) {
 	})
 }
 
+func TestService_CreateFriend_Crossing(t *testing.T) {
+	users := []memory.User{
+		{
+			Email: "foo@mock.com",
+		},
+		{
+			Email: "bar@mock.com",
+		},
+	}
+
+	t.Run("crossing request should connect both users", func(t *testing.T) {
+		mock := memory.NewStorage()
+		mock.InsertUsers(users)
+
+		s := makeService(t, mock)
+		err := s.CreateFriend(context.TODO(), friend.CreateFriendRequest{
+			Email:        "foo@mock.com",
+			FriendEmail:  "bar@mock.com",
+			Relationship: "Co-worker",
+		})
+		require.NoError(t, err)
+
+		err = s.CreateFriend(context.TODO(), friend.CreateFriendRequest{
+			Email:        "bar@mock.com",
+			FriendEmail:  "foo@mock.com",
+			Relationship: "Classmate",
+		})
+		require.NoError(t, err)
+
+		res, err := s.ListFriendRequests(context.TODO(), "bar@mock.com")
+		require.NoError(t, err)
+		assert.Empty(t, res.RequestTo)
+		assert.Empty(t, res.RequestFrom)
+
+		friends, err := s.ListFriend(context.TODO(), "foo@mock.com")
+		require.NoError(t, err)
+		require.Len(t, friends.Friends, 1)
+		assert.Equal(t, "bar@mock.com", friends.Friends[0].FriendEmail)
+		assert.Equal(t, "Co-worker", friends.Friends[0].Relationship)
+		assert.Equal(t, "Classmate", friends.Friends[0].FriendRelationship)
+		assert.False(t, friends.Friends[0].DateConnected.IsZero())
+
+		friends, err = s.ListFriend(context.TODO(), "bar@mock.com")
+		require.NoError(t, err)
+		require.Len(t, friends.Friends, 1)
+		assert.Equal(t, "foo@mock.com", friends.Friends[0].FriendEmail)
+		assert.Equal(t, "Classmate", friends.Friends[0].Relationship)
+		assert.Equal(t, "Co-worker", friends.Friends[0].FriendRelationship)
+	})
+
+	t.Run("concurrent crossing requests should connect both users", func(t *testing.T) {
+		mock := memory.NewStorage()
+		mock.InsertUsers(users)
+		s := makeService(t, mock)
+
+		errs := make(chan error, 2)
+		for _, req := range []friend.CreateFriendRequest{
+			{Email: "foo@mock.com", FriendEmail: "bar@mock.com"},
+			{Email: "bar@mock.com", FriendEmail: "foo@mock.com"},
+		} {
+			go func(req friend.CreateFriendRequest) {
+				errs <- s.CreateFriend(context.TODO(), req)
+			}(req)
+		}
+		require.NoError(t, <-errs)
+		require.NoError(t, <-errs)
+
+		res, err := s.ListFriendRequests(context.TODO(), "foo@mock.com")
+		require.NoError(t, err)
+		assert.Empty(t, res.RequestTo)
+		assert.Empty(t, res.RequestFrom)
+
+		for _, email := range []string{"foo@mock.com", "bar@mock.com"} {
+			friends, err := s.ListFriend(context.TODO(), email)
+			require.NoError(t, err)
+			assert.Len(t, friends.Friends, 1)
+		}
+	})
+}
+
+func TestService_UpdateRelationship(t *testing.T) {
+	users := []memory.User{
+		{Email: "foo@mock.com"},
+		{Email: "bar@mock.com"},
+		{Email: "baz@mock.com"},
+	}
+
+	makeStorage := func(t *testing.T) *memory.Storage {
+		mock := memory.NewStorage()
+		mock.InsertUsers(users)
+		for _, f := range []friend.Friendship{
+			{Email: "foo@mock.com", FriendEmail: "bar@mock.com", Relationship: "Friend", DateConnected: time.Now()},
+			{Email: "foo@mock.com", FriendEmail: "baz@mock.com", Relationship: "Friend", RequestedAt: time.Now()},
+		} {
+			f := f
+			require.NoError(t, mock.InsertFriendship(context.TODO(), &f))
+		}
+		return mock
+	}
+
+	t.Run("each side keeps its own label", func(t *testing.T) {
+		s := makeService(t, makeStorage(t))
+		for _, req := range []friend.UpdateRelationshipRequest{
+			{Email: "foo@mock.com", FriendEmail: "bar@mock.com", Relationship: "Mentor"},
+			{Email: "bar@mock.com", FriendEmail: "foo@mock.com", Relationship: "Mentee"},
+		} {
+			require.NoError(t, s.UpdateRelationship(context.TODO(), req))
+		}
+
+		res, err := s.ListFriend(context.TODO(), "foo@mock.com")
+		require.NoError(t, err)
+		require.Len(t, res.Friends, 1)
+		assert.Equal(t, "Mentor", res.Friends[0].Relationship)
+		assert.Equal(t, "Mentee", res.Friends[0].FriendRelationship)
+
+		res, err = s.ListFriend(context.TODO(), "bar@mock.com")
+		require.NoError(t, err)
+		require.Len(t, res.Friends, 1)
+		assert.Equal(t, "Mentee", res.Friends[0].Relationship)
+		assert.Equal(t, "Mentor", res.Friends[0].FriendRelationship)
+	})
+
+	t.Run("update pending friendship should failed", func(t *testing.T) {
+		s := makeService(t, makeStorage(t))
+		err := s.UpdateRelationship(context.TODO(), friend.UpdateRelationshipRequest{
+			Email:        "baz@mock.com",
+			FriendEmail:  "foo@mock.com",
+			Relationship: "Mentor",
+		})
+		assert.Equal(t, gterr.NotFound, gterr.Code(err))
+	})
+}
+
+func TestService_RelationshipTypes(t *testing.T) {
+	s := makeService(t, memory.NewStorage())
+
+	require.NoError(t, s.CreateRelationshipType(context.TODO(), friend.RelationshipType{Name: "Mentor"}))
+	require.NoError(t, s.CreateRelationshipType(context.TODO(), friend.RelationshipType{Name: "Classmate"}))
+
+	err := s.CreateRelationshipType(context.TODO(), friend.RelationshipType{Name: "Mentor"})
+	assert.Equal(t, gterr.AlreadyExists, gterr.Code(err))
+
+	require.NoError(t, s.DeleteRelationshipType(context.TODO(), "Mentor"))
+	err = s.DeleteRelationshipType(context.TODO(), "Mentor")
+	assert.Equal(t, gterr.NotFound, gterr.Code(err))
+
+	res, err := s.ListRelationshipTypes(context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, []friend.RelationshipType{{Name: "Classmate"}}, res.Relationships)
+}
+
+func TestService_Lists(t *testing.T) {
+	users := []memory.User{
+		{Email: "foo@mock.com"},
+		{Email: "bar@mock.com"},
+		{Email: "baz@mock.com"},
+	}
+
+	mock := memory.NewStorage()
+	mock.InsertUsers(users)
+	require.NoError(t, mock.InsertFriendship(context.TODO(), &friend.Friendship{
+		Email:         "bar@mock.com",
+		FriendEmail:   "foo@mock.com",
+		DateConnected: time.Now(),
+	}))
+	s := makeService(t, mock)
+
+	l, err := s.CreateList(context.TODO(), friend.CreateListRequest{Owner: "foo@mock.com", Name: "Close friends"})
+	require.NoError(t, err)
+
+	_, err = s.CreateList(context.TODO(), friend.CreateListRequest{Owner: "foo@mock.com", Name: "Close friends"})
+	assert.Equal(t, gterr.AlreadyExists, gterr.Code(err))
+
+	t.Run("only accepted friends can be members", func(t *testing.T) {
+		err := s.AddListMember(context.TODO(), friend.ListMemberRequest{Owner: "foo@mock.com", ID: l.ID, MemberEmail: "baz@mock.com"})
+		assert.Equal(t, gterr.FailedPrecondition, gterr.Code(err))
+
+		err = s.AddListMember(context.TODO(), friend.ListMemberRequest{Owner: "foo@mock.com", ID: l.ID, MemberEmail: "bar@mock.com"})
+		require.NoError(t, err)
+
+		res, err := s.ListLists(context.TODO(), "foo@mock.com")
+		require.NoError(t, err)
+		require.Len(t, res.Lists, 1)
+		assert.Equal(t, []string{"bar@mock.com"}, res.Lists[0].Members)
+	})
+
+	t.Run("other users can't change the list", func(t *testing.T) {
+		_, err := s.UpdateList(context.TODO(), friend.UpdateListRequest{Owner: "bar@mock.com", ID: l.ID, Name: "Hacked"})
+		assert.Equal(t, gterr.NotFound, gterr.Code(err))
+
+		err = s.DeleteList(context.TODO(), friend.DeleteListRequest{Owner: "bar@mock.com", ID: l.ID})
+		assert.Equal(t, gterr.NotFound, gterr.Code(err))
+	})
+
+	t.Run("check audience", func(t *testing.T) {
+		ok, err := s.IsInLists(context.TODO(), "foo@mock.com", []int64{l.ID}, "bar@mock.com")
+		require.NoError(t, err)
+		assert.True(t, ok)
+
+		ok, err = s.IsInLists(context.TODO(), "bar@mock.com", []int64{l.ID}, "bar@mock.com")
+		require.NoError(t, err)
+		assert.False(t, ok, "list of another owner")
+
+		err = s.RemoveListMember(context.TODO(), friend.ListMemberRequest{Owner: "foo@mock.com", ID: l.ID, MemberEmail: "bar@mock.com"})
+		require.NoError(t, err)
+
+		ok, err = s.IsInLists(context.TODO(), "foo@mock.com", []int64{l.ID}, "bar@mock.com")
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+}
+
+func TestService_FriendRequestExpiry(t *testing.T) {
+	users := []memory.User{
+		{Email: "foo@mock.com"},
+		{Email: "bar@mock.com"},
+		{Email: "baz@mock.com"},
+	}
+
+	cfg := friend.DefaultConfig()
+	cfg.Request.TTL = 24 * time.Hour
+
+	makeStorage := func(t *testing.T) *memory.Storage {
+		mock := memory.NewStorage()
+		mock.InsertUsers(users)
+		for _, f := range []friend.Friendship{
+			{Email: "foo@mock.com", FriendEmail: "bar@mock.com", RequestedAt: time.Now().Add(-time.Hour)},
+			{Email: "baz@mock.com", FriendEmail: "foo@mock.com", RequestedAt: time.Now().Add(-48 * time.Hour)},
+		} {
+			f := f
+			require.NoError(t, mock.InsertFriendship(context.TODO(), &f))
+		}
+		return mock
+	}
+
+	t.Run("list requests should hide expired requests", func(t *testing.T) {
+		s := friend.NewService(makeStorage(t), cfg)
+		res, err := s.ListFriendRequests(context.TODO(), "foo@mock.com")
+		require.NoError(t, err)
+		assert.Empty(t, res.RequestFrom)
+		require.Len(t, res.RequestTo, 1)
+
+		r := res.RequestTo[0]
+		assert.Equal(t, "bar@mock.com", r.Email)
+		assert.Equal(t, r.RequestedAt.Add(cfg.Request.TTL), r.ExpiresAt)
+	})
+
+	t.Run("accept expired request should failed", func(t *testing.T) {
+		s := friend.NewService(makeStorage(t), cfg)
+		err := s.AcceptFriendRequest(context.TODO(), friend.AcceptFriendRequest{
+			Email:        "foo@mock.com",
+			EmailRequest: "baz@mock.com",
+		})
+		assert.Equal(t, gterr.FailedPrecondition, gterr.Code(err))
+	})
+
+	t.Run("delete expired requests", func(t *testing.T) {
+		mock := makeStorage(t)
+		s := friend.NewService(mock, cfg)
+		n, err := s.DeleteExpiredRequests(context.TODO())
+		require.NoError(t, err)
+		assert.EqualValues(t, 1, n)
+
+		pending, err := mock.ListPendingFriendships(context.TODO(), "foo@mock.com")
+		require.NoError(t, err)
+		require.Len(t, pending, 1)
+		assert.Equal(t, "bar@mock.com", pending[0].FriendEmail)
+	})
+}
+
+func TestService_Notifications(t *testing.T) {
+	users := []memory.User{
+		{Email: "foo@mock.com"},
+		{Email: "bar@mock.com"},
+	}
+
+	mock := memory.NewStorage()
+	mock.InsertUsers(users)
+	notifications := notification.NewService(mock, nil, nil)
+	s := friend.NewService(mock, friend.DefaultConfig())
+
+	bus := event.NewBus(mock, event.DefaultConfig())
+	for _, e := range []string{friend.EventFriendshipCreated, friend.EventFriendshipAccepted, friend.EventFriendshipRejected} {
+		bus.Subscribe("notification."+e, e, friend.NotificationHandler(notifications))
+	}
+
+	inbox := func(t *testing.T, email string) []notification.Type {
+		require.NoError(t, bus.Relay(context.TODO()))
+		res, err := notifications.List(context.TODO(), notification.ListRequest{Email: email})
+		require.NoError(t, err)
+
+		var types []notification.Type
+		for _, n := range res.Notifications {
+			types = append(types, n.Type)
+		}
+		return types
+	}
+
+	require.NoError(t, s.CreateFriend(context.TODO(), friend.CreateFriendRequest{Email: "foo@mock.com", FriendEmail: "bar@mock.com"}))
+	assert.Equal(t, []notification.Type{notification.FriendRequestCreated}, inbox(t, "bar@mock.com"))
+
+	require.NoError(t, s.RejectFriendRequest(context.TODO(), friend.DeleteFriendRequest{Email: "bar@mock.com", FriendEmail: "foo@mock.com"}))
+	assert.Equal(t, []notification.Type{notification.FriendRequestRejected}, inbox(t, "foo@mock.com"))
+
+	require.NoError(t, s.CreateFriend(context.TODO(), friend.CreateFriendRequest{Email: "foo@mock.com", FriendEmail: "bar@mock.com"}))
+	require.NoError(t, s.AcceptFriendRequest(context.TODO(), friend.AcceptFriendRequest{Email: "bar@mock.com", EmailRequest: "foo@mock.com"}))
+	assert.Equal(t, []notification.Type{notification.FriendRequestAccepted, notification.FriendRequestRejected}, inbox(t, "foo@mock.com"))
+}
+
+func TestService_FindPath(t *testing.T) {
+	users := []memory.User{
+		{Email: "a@mock.com"},
+		{Email: "b@mock.com"},
+		{Email: "c@mock.com"},
+		{Email: "d@mock.com"},
+		{Email: "e@mock.com"},
+		{Email: "f@mock.com"},
+	}
+
+	// a - b - c - d - e, a - f - d, f is still pending with d
+	makeStorage := func(t *testing.T) *memory.Storage {
+		mock := memory.NewStorage()
+		mock.InsertUsers(users)
+		for _, f := range []friend.Friendship{
+			{Email: "a@mock.com", FriendEmail: "b@mock.com", DateConnected: time.Now()},
+			{Email: "c@mock.com", FriendEmail: "b@mock.com", DateConnected: time.Now()},
+			{Email: "c@mock.com", FriendEmail: "d@mock.com", DateConnected: time.Now()},
+			{Email: "d@mock.com", FriendEmail: "e@mock.com", DateConnected: time.Now()},
+			{Email: "a@mock.com", FriendEmail: "f@mock.com", DateConnected: time.Now()},
+			{Email: "f@mock.com", FriendEmail: "d@mock.com"},
+		} {
+			f := f
+			require.NoError(t, mock.InsertFriendship(context.TODO(), &f))
+		}
+		return mock
+	}
+
+	t.Run("direct friends", func(t *testing.T) {
+		s := makeService(t, makeStorage(t))
+		res, err := s.FindPath(context.TODO(), friend.FindPathRequest{
+			Email:       "b@mock.com",
+			FriendEmail: "a@mock.com",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 1, res.Degrees)
+		assert.Equal(t, []string{"b@mock.com", "a@mock.com"}, res.Path)
+	})
+
+	t.Run("shortest path ignore pending requests", func(t *testing.T) {
+		s := makeService(t, makeStorage(t))
+		res, err := s.FindPath(context.TODO(), friend.FindPathRequest{
+			Email:       "a@mock.com",
+			FriendEmail: "e@mock.com",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 4, res.Degrees)
+		assert.Equal(t, []string{"a@mock.com", "b@mock.com", "c@mock.com", "d@mock.com", "e@mock.com"}, res.Path)
+	})
+
+	t.Run("path longer than max depth should not found", func(t *testing.T) {
+		s := makeService(t, makeStorage(t))
+		_, err := s.FindPath(context.TODO(), friend.FindPathRequest{
+			Email:       "a@mock.com",
+			FriendEmail: "e@mock.com",
+			MaxDepth:    3,
+		})
+		assert.Equal(t, gterr.NotFound, gterr.Code(err))
+	})
+
+	t.Run("path to yourself should failed", func(t *testing.T) {
+		s := makeService(t, makeStorage(t))
+		_, err := s.FindPath(context.TODO(), friend.FindPathRequest{
+			Email:       "a@mock.com",
+			FriendEmail: "a@mock.com",
+		})
+		assert.Equal(t, gterr.InvalidArgument, gterr.Code(err))
+	})
+
+	t.Run("exceed visited budget should failed", func(t *testing.T) {
+		cfg := friend.DefaultConfig()
+		cfg.Path.MaxVisited = 3
+		cfg.Path.PageSize = 1
+
+		s := friend.NewService(makeStorage(t), cfg)
+		_, err := s.FindPath(context.TODO(), friend.FindPathRequest{
+			Email:       "a@mock.com",
+			FriendEmail: "e@mock.com",
+		})
+		assert.Equal(t, gterr.ResourceExhausted, gterr.Code(err))
+	})
+}
+
+func TestService_SearchFriends(t *testing.T) {
+	mock := memory.NewStorage()
+	mock.InsertUsers([]memory.User{
+		{
+			Email: "tony@stark.com", FirstName: "Tony", LastName: "Stark", Hometown: "New York", CurrentCity: "Malibu",
+			Interests:    []string{"Golf", "Technology"},
+			Education:    []profile.Attend{{School: "MIT", YearGraduated: 1987}},
+			Professional: []profile.Employment{{Employer: "Stark Industries", JobTitle: "CEO"}},
+		},
+		{
+			Email: "pepper@stark.com", FirstName: "Pepper", LastName: "Potts", Hometown: "Denver", CurrentCity: "Malibu",
+			Interests:    []string{"golf"},
+			Education:    []profile.Attend{{School: "MIT", YearGraduated: 1995}},
+			Professional: []profile.Employment{{Employer: "Stark Industries", JobTitle: "CEO"}, {Employer: "Alphabet", JobTitle: "Assistant"}},
+		},
+		{
+			Email: "bruce@wayne.com", FirstName: "Bruce", LastName: "Wayne", Hometown: "Gotham", CurrentCity: "Gotham",
+			Education:    []profile.Attend{{School: "Princeton", YearGraduated: 1995}},
+			Professional: []profile.Employment{{Employer: "Wayne Enterprises", JobTitle: "Chairman"}},
+		},
+	})
+	require.NoError(t, mock.InsertInterestTag(context.TODO(), &profile.InterestTag{Slug: "golf", Name: "Golf"}))
+	s := makeService(t, mock)
+
+	tests := []struct {
+		name string
+		req  friend.SearchFriendsRequest
+		want []string
+		code gterr.ErrorCode
+	}{
+		{name: "name or hometown", req: friend.SearchFriendsRequest{Name: "bruce", Hometown: "denver"}, want: []string{"bruce@wayne.com", "pepper@stark.com"}, code: gterr.OK},
+		{name: "narrowed by school", req: friend.SearchFriendsRequest{Name: "bruce", Hometown: "denver", School: "MIT"}, want: []string{"pepper@stark.com"}, code: gterr.OK},
+		{name: "school and years", req: friend.SearchFriendsRequest{School: "mit", YearFrom: 1990, YearTo: 2000}, want: []string{"pepper@stark.com"}, code: gterr.OK},
+		{name: "years at any school", req: friend.SearchFriendsRequest{YearFrom: 1995}, want: []string{"bruce@wayne.com", "pepper@stark.com"}, code: gterr.OK},
+		{name: "employer", req: friend.SearchFriendsRequest{Employer: "Stark Industries"}, want: []string{"pepper@stark.com", "tony@stark.com"}, code: gterr.OK},
+		{name: "job title at the employer", req: friend.SearchFriendsRequest{Employer: "Alphabet", JobTitle: "ceo"}, code: gterr.OK},
+		{name: "interest with its spellings", req: friend.SearchFriendsRequest{Interest: "GOLF!"}, want: []string{"pepper@stark.com", "tony@stark.com"}, code: gterr.OK},
+		{name: "current city", req: friend.SearchFriendsRequest{CurrentCity: "malibu", Interest: "technology"}, want: []string{"tony@stark.com"}, code: gterr.OK},
+		{name: "years reversed", req: friend.SearchFriendsRequest{YearFrom: 2000, YearTo: 1990}, code: gterr.InvalidArgument},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := s.SearchFriends(context.TODO(), test.req)
+			assert.Equal(t, test.code, gterr.Code(err))
+			if err != nil {
+				return
+			}
+
+			var got []string
+			for _, u := range res.Users {
+				got = append(got, u.Email)
+			}
+			assert.Equal(t, test.want, got)
+			assert.Equal(t, len(test.want), res.Count)
+		})
+	}
+
+	// The facets count all the matched users, not only the page
+	res, err := s.SearchFriends(context.TODO(), friend.SearchFriendsRequest{Name: "r", Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, 3, res.Count)
+	require.Len(t, res.Users, 1)
+	assert.Equal(t, "bruce@wayne.com", res.Users[0].Email)
+	assert.Equal(t, "bruce@wayne.com", res.NextAfter)
+	assert.Equal(t, []friend.FacetCount{{Value: "Stark Industries", Count: 2}, {Value: "Alphabet", Count: 1}, {Value: "Wayne Enterprises", Count: 1}}, res.Facets.Employers)
+	assert.Equal(t, []friend.FacetCount{{Value: "MIT", Count: 2}, {Value: "Princeton", Count: 1}}, res.Facets.Schools)
+	assert.Equal(t, []friend.FacetCount{{Value: "Malibu", Count: 2}, {Value: "Gotham", Count: 1}}, res.Facets.CurrentCities)
+
+	var pages []string
+	for req := (friend.SearchFriendsRequest{Name: "r", Limit: 1}); ; req.After = res.NextAfter {
+		res, err = s.SearchFriends(context.TODO(), req)
+		require.NoError(t, err)
+		for _, u := range res.Users {
+			pages = append(pages, u.Email)
+		}
+		if res.NextAfter == "" {
+			break
+		}
+	}
+	assert.Equal(t, []string{"bruce@wayne.com", "pepper@stark.com", "tony@stark.com"}, pages)
+}
+
+func TestService_DeactivatedUsers(t *testing.T) {
+	mock := memory.NewStorage()
+	mock.InsertUsers([]memory.User{
+		{Email: "foo@mock.com", FirstName: "Foo"},
+		{Email: "bar@mock.com", FirstName: "Bar"},
+	})
+	require.NoError(t, mock.InsertFriendship(context.TODO(), &friend.Friendship{
+		Email:         "foo@mock.com",
+		FriendEmail:   "bar@mock.com",
+		DateConnected: time.Now(),
+	}))
+	s := makeService(t, mock)
+
+	now := time.Now()
+	deleteAfter := now.Add(time.Hour)
+	require.NoError(t, mock.DeactivateUser(context.TODO(), "bar@mock.com", now, &deleteAfter))
+
+	t.Run("hidden from search and friend lists", func(t *testing.T) {
+		res, err := s.SearchFriends(context.TODO(), friend.SearchFriendsRequest{Name: "bar"})
+		require.NoError(t, err)
+		assert.Empty(t, res.Users)
+
+		friends, err := s.ListFriend(context.TODO(), "foo@mock.com")
+		require.NoError(t, err)
+		assert.Empty(t, friends.Friends)
+	})
+
+	t.Run("visible again after reactivation", func(t *testing.T) {
+		require.NoError(t, mock.ReactivateUser(context.TODO(), "bar@mock.com"))
+
+		friends, err := s.ListFriend(context.TODO(), "foo@mock.com")
+		require.NoError(t, err)
+		require.Len(t, friends.Friends, 1)
+		assert.Equal(t, "bar@mock.com", friends.Friends[0].FriendEmail)
+	})
+
+	t.Run("deleted after the grace period", func(t *testing.T) {
+		require.NoError(t, mock.DeactivateUser(context.TODO(), "bar@mock.com", now, &deleteAfter))
+
+		n, err := mock.DeleteScheduledUsers(context.TODO(), now)
+		require.NoError(t, err)
+		assert.Zero(t, n)
+
+		n, err = mock.DeleteScheduledUsers(context.TODO(), deleteAfter)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), n)
+
+		require.NoError(t, mock.ReactivateUser(context.TODO(), "bar@mock.com"))
+		friends, err := s.ListFriend(context.TODO(), "foo@mock.com")
+		require.NoError(t, err)
+		assert.Empty(t, friends.Friends)
+	})
+}
+
+func TestService_CreateFriend_Deactivated(t *testing.T) {
+	mock := memory.NewStorage()
+	mock.InsertUsers([]memory.User{
+		{Email: "foo@mock.com"},
+		{Email: "bar@mock.com"},
+		{Email: "baz@mock.com"},
+	})
+	require.NoError(t, mock.InsertFriendship(context.TODO(), &friend.Friendship{
+		Email:         "foo@mock.com",
+		FriendEmail:   "bar@mock.com",
+		DateConnected: time.Now(),
+	}))
+	require.NoError(t, mock.InsertFriendship(context.TODO(), &friend.Friendship{
+		Email:         "bar@mock.com",
+		FriendEmail:   "baz@mock.com",
+		DateConnected: time.Now(),
+	}))
+	require.NoError(t, mock.InsertFriendship(context.TODO(), &friend.Friendship{
+		Email:       "baz@mock.com",
+		FriendEmail: "foo@mock.com",
+		RequestedAt: time.Now(),
+	}))
+	s := makeService(t, mock)
+
+	require.NoError(t, mock.DeactivateUser(context.TODO(), "baz@mock.com", time.Now(), nil))
+
+	t.Run("requests to them are blocked", func(t *testing.T) {
+		err := s.CreateFriend(context.TODO(), friend.CreateFriendRequest{Email: "foo@mock.com", FriendEmail: "baz@mock.com"})
+		assert.Equal(t, gterr.NotFound, gterr.Code(err))
+	})
+
+	t.Run("their requests are hidden", func(t *testing.T) {
+		res, err := s.ListFriendRequests(context.TODO(), "foo@mock.com")
+		require.NoError(t, err)
+		assert.Empty(t, res.RequestFrom)
+	})
+
+	t.Run("no path to them", func(t *testing.T) {
+		_, err := s.FindPath(context.TODO(), friend.FindPathRequest{Email: "foo@mock.com", FriendEmail: "baz@mock.com"})
+		assert.Equal(t, gterr.NotFound, gterr.Code(err))
+	})
+
+	t.Run("their requests can't be accepted", func(t *testing.T) {
+		err := s.AcceptFriendRequest(context.TODO(), friend.AcceptFriendRequest{Email: "foo@mock.com", EmailRequest: "baz@mock.com"})
+		assert.Equal(t, gterr.FailedPrecondition, gterr.Code(err))
+	})
+
+	t.Run("not friends while deactivated", func(t *testing.T) {
+		ok, err := friend.AreFriends(context.TODO(), mock, "bar@mock.com", "baz@mock.com")
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("reactivated", func(t *testing.T) {
+		require.NoError(t, mock.ReactivateUser(context.TODO(), "baz@mock.com"))
+
+		res, err := s.FindPath(context.TODO(), friend.FindPathRequest{Email: "foo@mock.com", FriendEmail: "baz@mock.com"})
+		require.NoError(t, err)
+		assert.Equal(t, 2, res.Degrees)
+
+		err = s.CreateFriend(context.TODO(), friend.CreateFriendRequest{Email: "foo@mock.com", FriendEmail: "baz@mock.com"})
+		require.NoError(t, err)
+	})
+}
+
 func makeService(_ *testing.T, s friend.Storage) *friend.Service {
-	return friend.NewService(s)
+	return friend.NewService(s, friend.DefaultConfig())
 }
@@ -0,0 +1,185 @@
+package friend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+type (
+	// List is a named group of friends, e.g. "Close friends", used as an audience when sharing.
+	List struct {
+		ID      int64    `json:"id"`
+		Owner   string   `json:"-"`
+		Name    string   `json:"name"`
+		Members []string `json:"members"`
+	}
+
+	ListListsResponse struct {
+		Lists []*List `json:"lists"`
+	}
+
+	CreateListRequest struct {
+		Owner string `json:"-"`
+		Name  string `json:"name" binding:"required,max=50"`
+	}
+
+	UpdateListRequest struct {
+		Owner string `json:"-"`
+		ID    int64  `json:"-"`
+		Name  string `json:"name" binding:"required,max=50"`
+	}
+
+	DeleteListRequest struct {
+		Owner string
+		ID    int64
+	}
+
+	ListMemberRequest struct {
+		Owner       string
+		ID          int64
+		MemberEmail string
+	}
+)
+
+func (s *Service) ListLists(ctx context.Context, owner string) (*ListListsResponse, error) {
+	lists, err := s.storage.ListFriendLists(ctx, owner)
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+
+	return &ListListsResponse{Lists: lists}, nil
+}
+
+func (s *Service) CreateList(ctx context.Context, req CreateListRequest) (*List, error) {
+	l := &List{
+		Owner: req.Owner,
+		Name:  strings.TrimSpace(req.Name),
+	}
+	if l.Name == "" {
+		return nil, gterr.New(gterr.InvalidArgument, "empty list name")
+	}
+
+	err := s.storage.InsertFriendList(ctx, l)
+	if errors.Is(err, storage.ErrAlreadyExist) {
+		return nil, gterr.New(gterr.AlreadyExists, fmt.Sprintf("list %s already exists", l.Name), err)
+	}
+
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+
+	return l, nil
+}
+
+func (s *Service) UpdateList(ctx context.Context, req UpdateListRequest) (*List, error) {
+	l, err := s.getList(ctx, req.Owner, req.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	l.Name = strings.TrimSpace(req.Name)
+	if l.Name == "" {
+		return nil, gterr.New(gterr.InvalidArgument, "empty list name")
+	}
+
+	err = s.storage.UpdateFriendList(ctx, l)
+	if errors.Is(err, storage.ErrAlreadyExist) {
+		return nil, gterr.New(gterr.AlreadyExists, fmt.Sprintf("list %s already exists", l.Name), err)
+	}
+
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+
+	return l, nil
+}
+
+func (s *Service) DeleteList(ctx context.Context, req DeleteListRequest) error {
+	if _, err := s.getList(ctx, req.Owner, req.ID); err != nil {
+		return err
+	}
+
+	if err := s.storage.DeleteFriendList(ctx, req.ID); err != nil {
+		return gterr.New(gterr.Internal, "", err)
+	}
+	return nil
+}
+
+// AddListMember adds a friend to the list, only accepted friends of the owner can be added.
+func (s *Service) AddListMember(ctx context.Context, req ListMemberRequest) error {
+	if _, err := s.getList(ctx, req.Owner, req.ID); err != nil {
+		return err
+	}
+
+	friendships, err := s.storage.ListFriends(ctx, req.Owner)
+	if err != nil {
+		return gterr.New(gterr.Internal, "", err)
+	}
+
+	isFriend := false
+	for _, f := range friendships {
+		if strings.EqualFold(f.FriendEmail, req.MemberEmail) {
+			isFriend = true
+			break
+		}
+	}
+	if !isFriend {
+		msg := fmt.Sprintf("%s is not a friend of %s", req.MemberEmail, req.Owner)
+		return gterr.New(gterr.FailedPrecondition, msg)
+	}
+
+	err = s.storage.InsertFriendListMember(ctx, req.ID, req.MemberEmail)
+	if errors.Is(err, storage.ErrAlreadyExist) {
+		return nil
+	}
+
+	if err != nil {
+		return gterr.New(gterr.Internal, "", err)
+	}
+	return nil
+}
+
+func (s *Service) RemoveListMember(ctx context.Context, req ListMemberRequest) error {
+	if _, err := s.getList(ctx, req.Owner, req.ID); err != nil {
+		return err
+	}
+
+	if err := s.storage.DeleteFriendListMember(ctx, req.ID, req.MemberEmail); err != nil {
+		return gterr.New(gterr.Internal, "", err)
+	}
+	return nil
+}
+
+// IsInLists reports whether viewer is a member of any of the owner's lists.
+// It is meant to be used by other modules to check the audience of a shared item.
+func (s *Service) IsInLists(ctx context.Context, owner string, listIDs []int64, viewer string) (bool, error) {
+	if len(listIDs) == 0 {
+		return false, nil
+	}
+
+	ok, err := s.storage.IsFriendListMember(ctx, owner, listIDs, viewer)
+	if err != nil {
+		return false, gterr.New(gterr.Internal, "", err)
+	}
+	return ok, nil
+}
+
+// getList returns the list if it is owned by owner.
+// Lists of other users are reported as not found, so their existence is not leaked.
+func (s *Service) getList(ctx context.Context, owner string, id int64) (*List, error) {
+	l, err := s.storage.GetFriendList(ctx, id)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+
+	if errors.Is(err, storage.ErrNotFound) || !strings.EqualFold(l.Owner, owner) {
+		return nil, gterr.New(gterr.NotFound, fmt.Sprintf("list %d not found", id))
+	}
+
+	return l, nil
+}
@@ -0,0 +1,229 @@
+package friend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/victornm/gtonline/internal/gterr"
+)
+
+type (
+	// Connection is an accepted friendship seen from the Email side.
+	// Friendships are undirected, so each accepted row yields 2 connections.
+	Connection struct {
+		Email       string
+		FriendEmail string
+	}
+
+	PathConfig struct {
+		// MaxDepth is the maximum number of friendships allowed in a path.
+		MaxDepth int `mapstructure:"max_depth"`
+		// MaxVisited limits how many users a single search can visit.
+		MaxVisited int `mapstructure:"max_visited"`
+		// PageSize is the number of connections loaded from the storage per call.
+		PageSize int `mapstructure:"page_size"`
+		// Timeout limits how long a single search can run.
+		Timeout time.Duration `mapstructure:"timeout"`
+	}
+
+	FindPathRequest struct {
+		Email       string
+		FriendEmail string
+		MaxDepth    int `form:"max_depth" binding:"gte=0"`
+	}
+
+	FindPathResponse struct {
+		// Degrees is the number of friendships between the 2 users.
+		Degrees int      `json:"degrees"`
+		Path    []string `json:"path"`
+	}
+)
+
+func DefaultPathConfig() PathConfig {
+	return PathConfig{
+		MaxDepth:   6,
+		MaxVisited: 10000,
+		PageSize:   500,
+		Timeout:    3 * time.Second,
+	}
+}
+
+// FindPath returns the shortest chain of accepted friendships from req.Email to req.FriendEmail.
+// It runs a bidirectional BFS, always expanding the smaller frontier first.
+func (s *Service) FindPath(ctx context.Context, req FindPathRequest) (*FindPathResponse, error) {
+	if strings.EqualFold(req.Email, req.FriendEmail) {
+		return nil, gterr.New(gterr.InvalidArgument, "can't find path to yourself")
+	}
+
+	cfg := s.cfg.Path
+	maxDepth := cfg.MaxDepth
+	if req.MaxDepth > 0 && req.MaxDepth < maxDepth {
+		maxDepth = req.MaxDepth
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+
+	search := &pathSearch{
+		storage:  s.storage,
+		pageSize: cfg.PageSize,
+		budget:   cfg.MaxVisited,
+	}
+	path, err := search.run(ctx, req.Email, req.FriendEmail, maxDepth)
+	if errors.Is(err, errBudgetExceeded) {
+		return nil, gterr.New(gterr.ResourceExhausted, "the friendship graph is too large to search", err)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, gterr.New(gterr.DeadlineExceeded, "searching for path took too long", err)
+	}
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+
+	if path == nil {
+		msg := fmt.Sprintf("no path from %s to %s within %d degrees", req.Email, req.FriendEmail, maxDepth)
+		return nil, gterr.New(gterr.NotFound, msg)
+	}
+
+	return &FindPathResponse{
+		Degrees: len(path) - 1,
+		Path:    path,
+	}, nil
+}
+
+var errBudgetExceeded = errors.New("visited node budget exceeded")
+
+type (
+	pathSearch struct {
+		storage  Storage
+		pageSize int
+		budget   int
+		visited  int
+	}
+
+	// pathNode records how a user was reached from one side of the search.
+	pathNode struct {
+		parent string
+		depth  int
+	}
+
+	pathSide struct {
+		frontier []string
+		nodes    map[string]pathNode
+	}
+)
+
+func newPathSide(email string) *pathSide {
+	return &pathSide{
+		frontier: []string{email},
+		nodes:    map[string]pathNode{email: {}},
+	}
+}
+
+func (ps *pathSearch) run(ctx context.Context, from, to string, maxDepth int) ([]string, error) {
+	forward, backward := newPathSide(from), newPathSide(to)
+	ps.visited = 2
+
+	for depth := 0; depth < maxDepth; depth++ {
+		if len(forward.frontier) == 0 || len(backward.frontier) == 0 {
+			return nil, nil
+		}
+
+		side, other := forward, backward
+		if len(backward.frontier) < len(forward.frontier) {
+			side, other = backward, forward
+		}
+
+		meet, err := ps.expand(ctx, side, other)
+		if err != nil {
+			return nil, err
+		}
+
+		if meet != "" {
+			return joinPath(forward, backward, meet), nil
+		}
+	}
+
+	return nil, nil
+}
+
+// expand moves side one level further and returns the closest user also reached by other, if any.
+func (ps *pathSearch) expand(ctx context.Context, side, other *pathSide) (string, error) {
+	var (
+		next []string
+		meet string
+	)
+
+	for start := 0; start < len(side.frontier); start += ps.pageSize {
+		end := start + ps.pageSize
+		if end > len(side.frontier) {
+			end = len(side.frontier)
+		}
+
+		err := ps.eachConnection(ctx, side.frontier[start:end], func(c Connection) error {
+			if _, ok := side.nodes[c.FriendEmail]; ok {
+				return nil
+			}
+
+			ps.visited++
+			if ps.visited > ps.budget {
+				return errBudgetExceeded
+			}
+
+			side.nodes[c.FriendEmail] = pathNode{parent: c.Email, depth: side.nodes[c.Email].depth + 1}
+			next = append(next, c.FriendEmail)
+
+			if n, ok := other.nodes[c.FriendEmail]; ok && (meet == "" || n.depth < other.nodes[meet].depth) {
+				meet = c.FriendEmail
+			}
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	side.frontier = next
+	return meet, nil
+}
+
+func (ps *pathSearch) eachConnection(ctx context.Context, emails []string, f func(c Connection) error) error {
+	var after Connection
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		conns, err := ps.storage.ListConnections(ctx, emails, after, ps.pageSize)
+		if err != nil {
+			return fmt.Errorf("list connections: %w", err)
+		}
+
+		for _, c := range conns {
+			if err := f(c); err != nil {
+				return err
+			}
+		}
+
+		if len(conns) < ps.pageSize {
+			return nil
+		}
+		after = conns[len(conns)-1]
+	}
+}
+
+func joinPath(forward, backward *pathSide, meet string) []string {
+	var path []string
+	for email := meet; email != ""; email = forward.nodes[email].parent {
+		path = append([]string{email}, path...)
+	}
+
+	for email := backward.nodes[meet].parent; email != ""; email = backward.nodes[email].parent {
+		path = append(path, email)
+	}
+
+	return path
+}
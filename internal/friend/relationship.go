@@ -0,0 +1,130 @@
+package friend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+type (
+	// RelationshipType is a suggested value for Friendship.Relationship, managed by admins.
+	RelationshipType struct {
+		Name string `json:"name" db:"name" binding:"required,max=50"`
+	}
+
+	ListRelationshipTypesResponse struct {
+		Relationships []RelationshipType `json:"relationships"`
+	}
+
+	UpdateRelationshipRequest struct {
+		Email        string `json:"-"`
+		FriendEmail  string `json:"-"`
+		Relationship string `json:"relationship" binding:"max=50"`
+	}
+)
+
+// UpdateRelationship sets the label given by req.Email to req.FriendEmail.
+// Both sides of an accepted friendship can set their own label, without changing the other side.
+func (s *Service) UpdateRelationship(ctx context.Context, req UpdateRelationshipRequest) error {
+	if strings.EqualFold(req.Email, req.FriendEmail) {
+		return gterr.New(gterr.InvalidArgument, "2 email must be different")
+	}
+
+	err := s.storage.WithFriendshipLock(ctx, req.Email, req.FriendEmail, func(ctx context.Context, tx Storage) error {
+		f, err := getAcceptedFriendship(ctx, tx, req.Email, req.FriendEmail)
+		if err != nil {
+			return err
+		}
+
+		if f.Email == req.Email {
+			f.Relationship = req.Relationship
+		} else {
+			f.FriendRelationship = req.Relationship
+		}
+
+		if err := tx.UpdateFriendship(ctx, f); err != nil {
+			return gterr.New(gterr.Internal, "", err)
+		}
+		return nil
+	})
+	if _, ok := gterr.FromError(err); err != nil && !ok {
+		return gterr.New(gterr.Internal, "", err)
+	}
+	return err
+}
+
+// getAcceptedFriendship returns the accepted friendship between the 2 emails, no matter who sent the request.
+func getAcceptedFriendship(ctx context.Context, s Storage, email, friendEmail string) (*Friendship, error) {
+	for _, pair := range [][2]string{{email, friendEmail}, {friendEmail, email}} {
+		f, err := s.GetFriendship(ctx, pair[0], pair[1])
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return nil, gterr.New(gterr.Internal, "", fmt.Errorf("get friendship: %v", err))
+		}
+
+		if !f.DateConnected.IsZero() {
+			return f, nil
+		}
+	}
+
+	return nil, gterr.New(gterr.NotFound, fmt.Sprintf("%s and %s are not friends", email, friendEmail))
+}
+
+func (s *Service) ListRelationshipTypes(ctx context.Context) (*ListRelationshipTypesResponse, error) {
+	types, err := s.storage.ListRelationshipTypes(ctx)
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+
+	return &ListRelationshipTypesResponse{Relationships: types}, nil
+}
+
+func (s *Service) CreateRelationshipType(ctx context.Context, t RelationshipType) error {
+	t.Name = strings.TrimSpace(t.Name)
+	if t.Name == "" {
+		return gterr.New(gterr.InvalidArgument, "empty relationship name")
+	}
+
+	err := s.storage.InsertRelationshipType(ctx, t)
+	if errors.Is(err, storage.ErrAlreadyExist) {
+		return gterr.New(gterr.AlreadyExists, fmt.Sprintf("relationship %s already exists", t.Name), err)
+	}
+
+	if err != nil {
+		return gterr.New(gterr.Internal, "", err)
+	}
+	return nil
+}
+
+func (s *Service) DeleteRelationshipType(ctx context.Context, name string) error {
+	err := s.storage.DeleteRelationshipType(ctx, name)
+	if errors.Is(err, storage.ErrNotFound) {
+		return gterr.New(gterr.NotFound, fmt.Sprintf("relationship %s not found", name), err)
+	}
+
+	if err != nil {
+		return gterr.New(gterr.Internal, "", err)
+	}
+	return nil
+}
+
+// AreFriends reports whether the 2 users are accepted friends, no matter who sent the request.
+// It is meant to be used by other modules to restrict features to friends.
+func AreFriends(ctx context.Context, s Storage, email, friendEmail string) (bool, error) {
+	_, err := getAcceptedFriendship(ctx, s, email, friendEmail)
+	if err == nil {
+		return true, nil
+	}
+
+	if gterr.Code(err) == gterr.NotFound {
+		return false, nil
+	}
+	return false, err
+}
@@ -0,0 +1,85 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type (
+	Status string
+
+	Job struct {
+		ID      int64           `json:"id"`
+		Type    string          `json:"type"`
+		Payload json.RawMessage `json:"payload"`
+		// Key dedupes the jobs, a job is not enqueued while another job with the same key exists.
+		Key    string `json:"key,omitempty"`
+		Status Status `json:"status"`
+		// Attempts is the number of started attempts.
+		Attempts    int `json:"attempts"`
+		MaxAttempts int `json:"max_attempts"`
+		// RunAt is when the job is due, or the end of the lease while it is running.
+		RunAt      time.Time  `json:"run_at"`
+		LastError  string     `json:"last_error,omitempty"`
+		CreatedAt  time.Time  `json:"created_at"`
+		FinishedAt *time.Time `json:"finished_at,omitempty"`
+	}
+
+	// Handler runs a job, a returned error makes the job retried until it runs out of attempts.
+	// A job may run more than once if its worker stops in the middle, so the handlers must be idempotent.
+	Handler func(ctx context.Context, j *Job) error
+
+	// Storage is the persistent queue of the jobs.
+	Storage interface {
+		// InsertJob inserts j and sets its ID, it returns storage.ErrAlreadyExist if a job with the same key exists.
+		InsertJob(ctx context.Context, j *Job) error
+		// ClaimJobs returns at most limit pending jobs of the given type with RunAt before now,
+		// and moves their RunAt to leaseUntil, so other workers don't run them at the same time.
+		// A job whose worker stopped is claimed again when its lease ends.
+		ClaimJobs(ctx context.Context, jobType string, now, leaseUntil time.Time, limit int) ([]*Job, error)
+		// UpdateJob saves the status, the attempts, the run time, the last error and the finish time of j.
+		UpdateJob(ctx context.Context, j *Job) error
+		// DeleteFinishedJobs deletes the succeeded and failed jobs finished before the given time.
+		DeleteFinishedJobs(ctx context.Context, before time.Time) (int64, error)
+	}
+
+	EnqueueRequest struct {
+		Type    string
+		Payload interface{}
+		// RunAt delays the job, it runs as soon as possible if zero.
+		RunAt time.Time
+		Key   string
+	}
+)
+
+const (
+	StatusPending   Status = "pending"
+	StatusSucceeded Status = "succeeded"
+	// StatusFailed is a job which failed all its attempts.
+	StatusFailed Status = "failed"
+)
+
+// DeferError is returned by a handler to run its job again at At, see Defer.
+type DeferError struct {
+	At time.Time
+}
+
+func (e *DeferError) Error() string {
+	return fmt.Sprintf("deferred to %s", e.At.Format(time.RFC3339))
+}
+
+// Defer returns the error which makes the runner run the job again at t, without counting the attempt.
+// It is meant for a job which can't run yet, e.g. an email during the quiet hours of its recipient.
+func Defer(t time.Time) error {
+	return &DeferError{At: t}
+}
+
+// Decode decodes the payload of j into v.
+func (j *Job) Decode(v interface{}) error {
+	if err := json.Unmarshal(j.Payload, v); err != nil {
+		return fmt.Errorf("decode job %s %d: %v", j.Type, j.ID, err)
+	}
+	return nil
+}
@@ -0,0 +1,351 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/retry"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+type (
+	// Runner runs the jobs of the queue with a pool of workers per job type.
+	// Every replica can run a Runner on the same Storage, a job is only run by one of them at a time.
+	Runner struct {
+		storage   Storage
+		cfg       Config
+		workers   []*worker
+		schedules []*schedule
+	}
+
+	Config struct {
+		// PollInterval is how often the queue is polled for due jobs and the schedules are checked.
+		PollInterval time.Duration `mapstructure:"poll_interval"`
+		// Concurrency is the number of jobs of a type run at the same time, unless the HandlerConfig sets it.
+		Concurrency int `mapstructure:"concurrency"`
+		// MaxAttempts is the number of attempts before a job fails, unless the HandlerConfig sets it.
+		MaxAttempts int `mapstructure:"max_attempts"`
+		// Timeout limits an attempt, unless the HandlerConfig sets it.
+		Timeout time.Duration `mapstructure:"timeout"`
+		// InitialBackoff is the delay before the first retry, it doubles after every failed attempt up to MaxBackoff.
+		InitialBackoff time.Duration `mapstructure:"initial_backoff"`
+		MaxBackoff     time.Duration `mapstructure:"max_backoff"`
+		// ShutdownTimeout is how long the running jobs are waited for on shutdown before being canceled.
+		ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
+		// Retention is how long the finished jobs are kept.
+		Retention time.Duration `mapstructure:"retention"`
+		// SweepInterval is how often the finished jobs older than Retention are deleted.
+		SweepInterval time.Duration `mapstructure:"sweep_interval"`
+	}
+
+	// HandlerConfig overrides the Config of the Runner for a job type, the zero fields keep the Runner values.
+	HandlerConfig struct {
+		Concurrency int
+		MaxAttempts int
+		Timeout     time.Duration
+		// OnFail is called with the last error when a job fails for good, also when its last attempts stopped
+		// before finishing and the handler could not see it. It may be called again for the same job.
+		OnFail func(ctx context.Context, j *Job, err error)
+	}
+
+	worker struct {
+		jobType string
+		handler Handler
+		cfg     HandlerConfig
+	}
+)
+
+func NewRunner(s Storage, cfg Config) *Runner {
+	return &Runner{storage: s, cfg: cfg.withDefaults()}
+}
+
+func DefaultConfig() Config {
+	return Config{
+		PollInterval:    time.Second,
+		Concurrency:     4,
+		MaxAttempts:     5,
+		Timeout:         5 * time.Minute,
+		InitialBackoff:  10 * time.Second,
+		MaxBackoff:      time.Hour,
+		ShutdownTimeout: 30 * time.Second,
+		Retention:       7 * 24 * time.Hour,
+		SweepInterval:   time.Hour,
+	}
+}
+
+func (c Config) withDefaults() Config {
+	d := DefaultConfig()
+	if c.PollInterval <= 0 {
+		c.PollInterval = d.PollInterval
+	}
+	if c.Concurrency <= 0 {
+		c.Concurrency = d.Concurrency
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = d.MaxAttempts
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = d.Timeout
+	}
+	if c.InitialBackoff <= 0 {
+		c.InitialBackoff = d.InitialBackoff
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = d.MaxBackoff
+	}
+	if c.ShutdownTimeout <= 0 {
+		c.ShutdownTimeout = d.ShutdownTimeout
+	}
+	if c.Retention <= 0 {
+		c.Retention = d.Retention
+	}
+	if c.SweepInterval <= 0 {
+		c.SweepInterval = d.SweepInterval
+	}
+	return c
+}
+
+// Register sets the handler of a job type, it must be called before Run.
+func (r *Runner) Register(jobType string, h Handler, cfg HandlerConfig) {
+	if r.worker(jobType) != nil {
+		panic(fmt.Sprintf("job: type %s registered twice", jobType))
+	}
+
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = r.cfg.Concurrency
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = r.cfg.MaxAttempts
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = r.cfg.Timeout
+	}
+	r.workers = append(r.workers, &worker{jobType: jobType, handler: h, cfg: cfg})
+}
+
+// Enqueue adds a job to the queue, the type must be registered.
+func (r *Runner) Enqueue(ctx context.Context, req EnqueueRequest) (*Job, error) {
+	w := r.worker(req.Type)
+	if w == nil {
+		return nil, gterr.New(gterr.InvalidArgument, fmt.Sprintf("unknown job type %s", req.Type))
+	}
+
+	payload, err := json.Marshal(req.Payload)
+	if err != nil {
+		return nil, gterr.New(gterr.InvalidArgument, "", fmt.Errorf("marshal payload of %s: %v", req.Type, err))
+	}
+
+	now := time.Now()
+	j := &Job{
+		Type:        req.Type,
+		Payload:     payload,
+		Key:         req.Key,
+		Status:      StatusPending,
+		MaxAttempts: w.cfg.MaxAttempts,
+		RunAt:       req.RunAt,
+		CreatedAt:   now,
+	}
+	if j.RunAt.IsZero() {
+		j.RunAt = now
+	}
+
+	err = r.storage.InsertJob(ctx, j)
+	if errors.Is(err, storage.ErrAlreadyExist) {
+		return nil, gterr.New(gterr.AlreadyExists, fmt.Sprintf("job %s already exists", req.Key), err)
+	}
+
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+	return j, nil
+}
+
+// Run runs the workers and the schedules until ctx is done, then waits for the running jobs.
+// The jobs still running after the ShutdownTimeout are canceled, they are retried when their lease ends.
+func (r *Runner) Run(ctx context.Context) error {
+	// The jobs get their own context, so they can finish after ctx is done
+	jobCtx, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
+
+	var (
+		running sync.WaitGroup
+		loops   sync.WaitGroup
+	)
+	for _, w := range r.workers {
+		loops.Add(1)
+		go func(w *worker) {
+			defer loops.Done()
+			r.poll(ctx, jobCtx, w, &running)
+		}(w)
+	}
+
+	loops.Add(2)
+	go func() {
+		defer loops.Done()
+		r.runSchedules(ctx)
+	}()
+	go func() {
+		defer loops.Done()
+		r.sweep(ctx)
+	}()
+
+	loops.Wait()
+
+	drained := make(chan struct{})
+	go func() {
+		running.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-time.After(r.cfg.ShutdownTimeout):
+		cancelJobs()
+		<-drained
+		return fmt.Errorf("running jobs canceled after %v", r.cfg.ShutdownTimeout)
+	}
+}
+
+// poll claims the due jobs of w while it has a free worker, until ctx is done.
+func (r *Runner) poll(ctx, jobCtx context.Context, w *worker, running *sync.WaitGroup) {
+	// slots limits the running jobs to the concurrency of w
+	slots := make(chan struct{}, w.cfg.Concurrency)
+	ticker := time.NewTicker(r.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Only this goroutine takes the slots, so the free slots can only grow until the claim
+		if free := cap(slots) - len(slots); free > 0 {
+			now := time.Now()
+			// The lease must be longer than an attempt, so the job is not claimed again while running
+			jobs, err := r.storage.ClaimJobs(ctx, w.jobType, now, now.Add(2*w.cfg.Timeout), free)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("claim %s jobs: %v", w.jobType, err)
+			}
+
+			for _, j := range jobs {
+				slots <- struct{}{}
+				running.Add(1)
+				go func(j *Job) {
+					defer func() {
+						<-slots
+						running.Done()
+					}()
+					r.run(jobCtx, w, j)
+				}(j)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// run runs an attempt of j, which is already counted by the claim, and saves the result.
+func (r *Runner) run(ctx context.Context, w *worker, j *Job) {
+	var err error
+	if j.Attempts > j.MaxAttempts {
+		// The previous attempts stopped without saving their result
+		err = fmt.Errorf("the attempts stopped before finishing")
+	} else {
+		err = r.call(ctx, w, j)
+	}
+
+	now := time.Now()
+	var deferred *DeferError
+	switch {
+	case err == nil:
+		j.Status = StatusSucceeded
+		j.LastError = ""
+		j.FinishedAt = &now
+	case errors.As(err, &deferred):
+		j.Attempts--
+		j.RunAt = deferred.At
+	case j.Attempts < j.MaxAttempts:
+		j.LastError = err.Error()
+		j.RunAt = now.Add(retry.Backoff(r.cfg.InitialBackoff, r.cfg.MaxBackoff, j.Attempts))
+	default:
+		log.Printf("job %s %d failed after %d attempts: %v", j.Type, j.ID, j.Attempts, err)
+		j.Status = StatusFailed
+		j.LastError = err.Error()
+		j.FinishedAt = &now
+		// Before saving the result, so a stop in between makes it run on the next claim instead of never
+		r.fail(w, j, err)
+	}
+
+	// ctx may be canceled by the shutdown, the result must be saved anyway
+	if err := r.storage.UpdateJob(context.Background(), j); err != nil {
+		log.Printf("update job %s %d: %v", j.Type, j.ID, err)
+	}
+}
+
+// call runs the handler of w with the timeout, a panic is returned as an error.
+func (r *Runner) call(ctx context.Context, w *worker, j *Job) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, w.cfg.Timeout)
+	defer cancel()
+
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+
+	return w.handler(ctx, j)
+}
+
+// fail calls the OnFail of w with its own context, the job context may be canceled by the shutdown.
+func (r *Runner) fail(w *worker, j *Job, err error) {
+	if w.cfg.OnFail == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), w.cfg.Timeout)
+	defer cancel()
+
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("on fail of job %s %d: panic: %v", j.Type, j.ID, p)
+		}
+	}()
+
+	w.cfg.OnFail(ctx, j, err)
+}
+
+func (r *Runner) sweep(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.SweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := r.storage.DeleteFinishedJobs(ctx, time.Now().Add(-r.cfg.Retention))
+			if err != nil && ctx.Err() == nil {
+				log.Printf("delete finished jobs: %v", err)
+			}
+			if n > 0 {
+				log.Printf("deleted %d finished job(s)", n)
+			}
+		}
+	}
+}
+
+func (r *Runner) worker(jobType string) *worker {
+	for _, w := range r.workers {
+		if w.jobType == jobType {
+			return w
+		}
+	}
+	return nil
+}
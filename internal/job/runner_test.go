@@ -0,0 +1,325 @@
+package job_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/job"
+	"github.com/victornm/gtonline/internal/storage/memory"
+)
+
+func makeRunner(_ *testing.T) (*job.Runner, *memory.Storage) {
+	mock := memory.NewStorage()
+	cfg := job.DefaultConfig()
+	cfg.PollInterval = 5 * time.Millisecond
+	cfg.InitialBackoff = time.Millisecond
+	cfg.MaxBackoff = time.Millisecond
+	cfg.ShutdownTimeout = time.Second
+	return job.NewRunner(mock, cfg), mock
+}
+
+// start runs r in the background, the returned function stops it and returns the result of Run.
+func start(r *job.Runner) func() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- r.Run(ctx) }()
+
+	return func() error {
+		cancel()
+		return <-done
+	}
+}
+
+func statuses(mock *memory.Storage) []job.Status {
+	var res []job.Status
+	for _, j := range mock.Jobs() {
+		res = append(res, j.Status)
+	}
+	return res
+}
+
+func finished(mock *memory.Storage) func() bool {
+	return func() bool {
+		for _, j := range mock.Jobs() {
+			if j.Status == job.StatusPending {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func TestRunner_Run(t *testing.T) {
+	r, mock := makeRunner(t)
+
+	var (
+		mu   sync.Mutex
+		sent []string
+	)
+	r.Register("email.send", func(ctx context.Context, j *job.Job) error {
+		var to string
+		require.NoError(t, j.Decode(&to))
+
+		mu.Lock()
+		sent = append(sent, to)
+		mu.Unlock()
+		return nil
+	}, job.HandlerConfig{})
+
+	for _, to := range []string{"tony@stark.com", "steve@rogers.com"} {
+		_, err := r.Enqueue(context.TODO(), job.EnqueueRequest{Type: "email.send", Payload: to})
+		require.NoError(t, err)
+	}
+
+	stop := start(r)
+	require.Eventually(t, finished(mock), time.Second, time.Millisecond)
+	require.NoError(t, stop())
+
+	assert.ElementsMatch(t, []string{"tony@stark.com", "steve@rogers.com"}, sent)
+	assert.Equal(t, []job.Status{job.StatusSucceeded, job.StatusSucceeded}, statuses(mock))
+}
+
+func TestRunner_Enqueue(t *testing.T) {
+	r, _ := makeRunner(t)
+	r.Register("email.send", func(ctx context.Context, j *job.Job) error { return nil }, job.HandlerConfig{})
+
+	_, err := r.Enqueue(context.TODO(), job.EnqueueRequest{Type: "image.resize"})
+	assert.Equal(t, gterr.InvalidArgument, gterr.Code(err))
+
+	_, err = r.Enqueue(context.TODO(), job.EnqueueRequest{Type: "email.send", Key: "digest@2021-08-01"})
+	require.NoError(t, err)
+
+	_, err = r.Enqueue(context.TODO(), job.EnqueueRequest{Type: "email.send", Key: "digest@2021-08-01"})
+	assert.Equal(t, gterr.AlreadyExists, gterr.Code(err))
+}
+
+func TestRunner_Retry(t *testing.T) {
+	r, mock := makeRunner(t)
+
+	var flaky int
+	r.Register("flaky", func(ctx context.Context, j *job.Job) error {
+		flaky++
+		if flaky < 3 {
+			return errors.New("unavailable")
+		}
+		return nil
+	}, job.HandlerConfig{MaxAttempts: 3})
+	r.Register("broken", func(ctx context.Context, j *job.Job) error {
+		panic("broken")
+	}, job.HandlerConfig{MaxAttempts: 2})
+
+	_, err := r.Enqueue(context.TODO(), job.EnqueueRequest{Type: "flaky"})
+	require.NoError(t, err)
+	_, err = r.Enqueue(context.TODO(), job.EnqueueRequest{Type: "broken"})
+	require.NoError(t, err)
+
+	stop := start(r)
+	require.Eventually(t, finished(mock), time.Second, time.Millisecond)
+	require.NoError(t, stop())
+
+	jobs := mock.Jobs()
+	require.Len(t, jobs, 2)
+
+	assert.Equal(t, job.StatusSucceeded, jobs[0].Status)
+	assert.Equal(t, 3, jobs[0].Attempts)
+
+	assert.Equal(t, job.StatusFailed, jobs[1].Status)
+	assert.Equal(t, 2, jobs[1].Attempts)
+	assert.Contains(t, jobs[1].LastError, "panic: broken")
+}
+
+func TestRunner_OnFail(t *testing.T) {
+	r, mock := makeRunner(t)
+	ctx := context.TODO()
+
+	var (
+		mu     sync.Mutex
+		calls  int
+		failed []string
+	)
+	r.Register("export.build", func(ctx context.Context, j *job.Job) error {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		return errors.New("disk full")
+	}, job.HandlerConfig{
+		MaxAttempts: 1,
+		OnFail: func(ctx context.Context, j *job.Job, err error) {
+			var key string
+			require.NoError(t, j.Decode(&key))
+
+			mu.Lock()
+			defer mu.Unlock()
+			failed = append(failed, key+": "+err.Error())
+		},
+	})
+
+	for _, key := range []string{"dead", "broken"} {
+		_, err := r.Enqueue(ctx, job.EnqueueRequest{Type: "export.build", Payload: key})
+		require.NoError(t, err)
+	}
+
+	// The worker of the first job died during its only attempt, the lease is already over
+	now := time.Now()
+	claimed, err := mock.ClaimJobs(ctx, "export.build", now, now, 1)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+
+	stop := start(r)
+	require.Eventually(t, finished(mock), time.Second, time.Millisecond)
+	require.NoError(t, stop())
+
+	assert.Equal(t, []job.Status{job.StatusFailed, job.StatusFailed}, statuses(mock))
+	assert.Equal(t, 1, calls, "the handler only runs the attempt of the second job")
+	assert.ElementsMatch(t, []string{"dead: the attempts stopped before finishing", "broken: disk full"}, failed)
+}
+
+func TestRunner_Defer(t *testing.T) {
+	r, mock := makeRunner(t)
+
+	var (
+		mu   sync.Mutex
+		runs int
+	)
+	r.Register("email.send", func(ctx context.Context, j *job.Job) error {
+		mu.Lock()
+		defer mu.Unlock()
+		runs++
+		if runs < 3 {
+			return job.Defer(time.Now().Add(10 * time.Millisecond))
+		}
+		return nil
+	}, job.HandlerConfig{MaxAttempts: 1})
+
+	_, err := r.Enqueue(context.TODO(), job.EnqueueRequest{Type: "email.send"})
+	require.NoError(t, err)
+
+	stop := start(r)
+	require.Eventually(t, finished(mock), time.Second, time.Millisecond)
+	require.NoError(t, stop())
+
+	jobs := mock.Jobs()
+	require.Len(t, jobs, 1)
+	assert.Equal(t, job.StatusSucceeded, jobs[0].Status, "the deferred runs are not counted as attempts")
+	assert.Equal(t, 1, jobs[0].Attempts)
+}
+
+func TestRunner_Concurrency(t *testing.T) {
+	r, mock := makeRunner(t)
+
+	var (
+		mu            sync.Mutex
+		running, peak int
+	)
+	r.Register("image.resize", func(ctx context.Context, j *job.Job) error {
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	}, job.HandlerConfig{Concurrency: 2})
+
+	for i := 0; i < 6; i++ {
+		_, err := r.Enqueue(context.TODO(), job.EnqueueRequest{Type: "image.resize"})
+		require.NoError(t, err)
+	}
+
+	stop := start(r)
+	require.Eventually(t, finished(mock), time.Second, time.Millisecond)
+	require.NoError(t, stop())
+
+	assert.Equal(t, 2, peak)
+}
+
+func TestRunner_Drain(t *testing.T) {
+	t.Run("wait for the running jobs", func(t *testing.T) {
+		r, mock := makeRunner(t)
+
+		started := make(chan struct{})
+		r.Register("slow", func(ctx context.Context, j *job.Job) error {
+			close(started)
+			time.Sleep(50 * time.Millisecond)
+			return ctx.Err()
+		}, job.HandlerConfig{})
+
+		_, err := r.Enqueue(context.TODO(), job.EnqueueRequest{Type: "slow"})
+		require.NoError(t, err)
+
+		stop := start(r)
+		<-started
+		require.NoError(t, stop())
+		assert.Equal(t, []job.Status{job.StatusSucceeded}, statuses(mock))
+	})
+
+	t.Run("cancel after the shutdown timeout", func(t *testing.T) {
+		mock := memory.NewStorage()
+		cfg := job.DefaultConfig()
+		cfg.PollInterval = 5 * time.Millisecond
+		cfg.ShutdownTimeout = 10 * time.Millisecond
+		r := job.NewRunner(mock, cfg)
+
+		started := make(chan struct{})
+		r.Register("stuck", func(ctx context.Context, j *job.Job) error {
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		}, job.HandlerConfig{})
+
+		_, err := r.Enqueue(context.TODO(), job.EnqueueRequest{Type: "stuck"})
+		require.NoError(t, err)
+
+		stop := start(r)
+		<-started
+		assert.Error(t, stop())
+
+		jobs := mock.Jobs()
+		require.Len(t, jobs, 1)
+		assert.Equal(t, job.StatusPending, jobs[0].Status, "the canceled job is retried later")
+		assert.NotEmpty(t, jobs[0].LastError)
+	})
+}
+
+func TestRunner_Schedule(t *testing.T) {
+	r, mock := makeRunner(t)
+
+	var (
+		mu  sync.Mutex
+		ran int
+	)
+	r.Register("digest", func(ctx context.Context, j *job.Job) error {
+		mu.Lock()
+		ran++
+		mu.Unlock()
+		return nil
+	}, job.HandlerConfig{})
+	r.Schedule("daily digest", job.Every(20*time.Millisecond), "digest", nil)
+
+	stop := start(r)
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return ran >= 2
+	}, time.Second, time.Millisecond)
+	require.NoError(t, stop())
+
+	keys := make(map[string]bool)
+	for _, j := range mock.Jobs() {
+		assert.False(t, keys[j.Key], "an activation is enqueued once")
+		keys[j.Key] = true
+	}
+}
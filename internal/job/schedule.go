@@ -0,0 +1,225 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/victornm/gtonline/internal/gterr"
+)
+
+type (
+	// Schedule returns the next activation time strictly after t, or the zero time if there is none.
+	Schedule interface {
+		Next(t time.Time) time.Time
+	}
+
+	schedule struct {
+		name     string
+		schedule Schedule
+		jobType  string
+		payload  interface{}
+		next     time.Time
+	}
+
+	every time.Duration
+
+	// cron is a parsed cron expression, each field is the set of allowed values.
+	cron struct {
+		minute, hour, dom, month, dow [64]bool
+		// anyDom and anyDow are true when the field starts with "*",
+		// when both are restricted a day matches if any of them matches.
+		anyDom, anyDow bool
+	}
+)
+
+// Schedule enqueues a job of the given type at every activation of s, it must be called before Run.
+// The name dedupes the activation between the replicas, so it must be unique.
+func (r *Runner) Schedule(name string, s Schedule, jobType string, payload interface{}) {
+	for _, sc := range r.schedules {
+		if sc.name == name {
+			panic(fmt.Sprintf("job: schedule %s registered twice", name))
+		}
+	}
+	r.schedules = append(r.schedules, &schedule{name: name, schedule: s, jobType: jobType, payload: payload})
+}
+
+func (r *Runner) runSchedules(ctx context.Context) {
+	if len(r.schedules) == 0 {
+		return
+	}
+
+	now := time.Now()
+	for _, sc := range r.schedules {
+		sc.next = sc.schedule.Next(now)
+	}
+
+	ticker := time.NewTicker(r.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, sc := range r.schedules {
+				if sc.next.IsZero() || now.Before(sc.next) {
+					continue
+				}
+
+				r.activate(ctx, sc)
+				sc.next = sc.schedule.Next(now)
+			}
+		}
+	}
+}
+
+// activate enqueues the job of the current activation of sc, unless another replica already did.
+func (r *Runner) activate(ctx context.Context, sc *schedule) {
+	_, err := r.Enqueue(ctx, EnqueueRequest{
+		Type:    sc.jobType,
+		Payload: sc.payload,
+		RunAt:   sc.next,
+		Key:     fmt.Sprintf("%s@%d", sc.name, sc.next.Unix()),
+	})
+	if err != nil && gterr.Code(err) != gterr.AlreadyExists {
+		log.Printf("schedule %s: %v", sc.name, err)
+	}
+}
+
+// Every activates at every multiple of d since the Unix epoch, so all the replicas agree on the activations.
+func Every(d time.Duration) Schedule {
+	if d <= 0 {
+		panic("job: non-positive interval")
+	}
+	return every(d)
+}
+
+func (e every) Next(t time.Time) time.Time {
+	return t.Truncate(time.Duration(e)).Add(time.Duration(e))
+}
+
+// ParseCron parses a standard 5 fields cron expression: minute, hour, day of month, month and day of week.
+// A field is "*", a value, a range "a-b", a step "*/n" or "a-b/n", or a list of them separated by commas.
+// The days of week are 0 to 6 from Sunday, 7 is also Sunday. The activations are in the location of the given time.
+func ParseCron(spec string) (Schedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron %q: expected 5 fields, got %d", spec, len(fields))
+	}
+
+	var c cron
+	bounds := []struct {
+		set      *[64]bool
+		min, max int
+	}{
+		{&c.minute, 0, 59},
+		{&c.hour, 0, 23},
+		{&c.dom, 1, 31},
+		{&c.month, 1, 12},
+		{&c.dow, 0, 7},
+	}
+	for i, b := range bounds {
+		if err := parseField(fields[i], b.min, b.max, b.set); err != nil {
+			return nil, fmt.Errorf("cron %q: %v", spec, err)
+		}
+	}
+
+	if c.dow[7] {
+		c.dow[0] = true
+	}
+	c.anyDom = strings.HasPrefix(fields[2], "*")
+	c.anyDow = strings.HasPrefix(fields[4], "*")
+
+	// 2000 is a leap year, so the 29th of February is found
+	if c.Next(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)).IsZero() {
+		return nil, fmt.Errorf("cron %q: never activates", spec)
+	}
+	return &c, nil
+}
+
+func parseField(field string, min, max int, set *[64]bool) error {
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid step in %q", part)
+			}
+			rng, step = part[:i], n
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			bounds := strings.SplitN(rng, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return fmt.Errorf("invalid value in %q", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return fmt.Errorf("invalid value in %q", part)
+				}
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return fmt.Errorf("%q out of range [%d, %d]", part, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return nil
+}
+
+func (c *cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// Every valid expression activates at least once in 5 years, including the 29th of February
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if !c.month[t.Month()] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !c.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !c.hour[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !c.minute[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	// The expression never activates, e.g. the 31st of February
+	return time.Time{}
+}
+
+func (c *cron) matchDay(t time.Time) bool {
+	dom, dow := c.dom[t.Day()], c.dow[t.Weekday()]
+	switch {
+	case c.anyDom && c.anyDow:
+		return true
+	case c.anyDom:
+		return dow
+	case c.anyDow:
+		return dom
+	default:
+		return dom || dow
+	}
+}
@@ -0,0 +1,64 @@
+package job_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/victornm/gtonline/internal/job"
+)
+
+func TestParseCron(t *testing.T) {
+	// 2021-08-02 is a Monday
+	from := time.Date(2021, 8, 2, 10, 30, 15, 0, time.UTC)
+
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{spec: "* * * * *", want: time.Date(2021, 8, 2, 10, 31, 0, 0, time.UTC)},
+		{spec: "*/15 * * * *", want: time.Date(2021, 8, 2, 10, 45, 0, 0, time.UTC)},
+		{spec: "0 8 * * *", want: time.Date(2021, 8, 3, 8, 0, 0, 0, time.UTC)},
+		{spec: "0 8 * * 0", want: time.Date(2021, 8, 8, 8, 0, 0, 0, time.UTC)},
+		{spec: "0 8 * * 7", want: time.Date(2021, 8, 8, 8, 0, 0, 0, time.UTC)},
+		{spec: "0 9-17/4 * * 1-5", want: time.Date(2021, 8, 2, 13, 0, 0, 0, time.UTC)},
+		{spec: "0 0 1 1,7 *", want: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 29 2 *", want: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// Both days restricted, any of them matches
+		{spec: "0 0 15 * 3", want: time.Date(2021, 8, 4, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		t.Run(test.spec, func(t *testing.T) {
+			s, err := job.ParseCron(test.spec)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, s.Next(from))
+		})
+	}
+}
+
+func TestParseCron_Invalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"0 0 31 2 *",
+	} {
+		_, err := job.ParseCron(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestEvery(t *testing.T) {
+	s := job.Every(time.Hour)
+	from := time.Date(2021, 8, 2, 10, 30, 0, 0, time.UTC)
+
+	assert.Equal(t, time.Date(2021, 8, 2, 11, 0, 0, 0, time.UTC), s.Next(from))
+	assert.Equal(t, time.Date(2021, 8, 2, 12, 0, 0, 0, time.UTC), s.Next(s.Next(from)))
+}
@@ -0,0 +1,61 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileMailer writes each email to a .eml file of its directory, it is meant for development and tests.
+type FileMailer struct {
+	dir  string
+	from string
+
+	mu  sync.Mutex
+	seq int
+}
+
+func NewFileMailer(dir, from string) *FileMailer {
+	return &FileMailer{dir: dir, from: from}
+}
+
+func (f *FileMailer) Send(_ context.Context, m Message) error {
+	b, err := m.Bytes(f.from)
+	if err != nil {
+		return fmt.Errorf("encode message: %v", err)
+	}
+
+	if err := os.MkdirAll(f.dir, 0o755); err != nil {
+		return fmt.Errorf("create mail dir: %v", err)
+	}
+
+	f.mu.Lock()
+	f.seq++
+	name := fmt.Sprintf("%d-%04d-%s.eml", time.Now().UnixNano(), f.seq, sanitize(m.To))
+	f.mu.Unlock()
+
+	return ioutil.WriteFile(filepath.Join(f.dir, name), b, 0o644)
+}
+
+// Files returns the path of the written emails, the oldest first.
+func (f *FileMailer) Files() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(f.dir, "*.eml"))
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '@' || r == '.' || r == '-' || r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,127 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"sort"
+	"time"
+)
+
+type (
+	// Mailer sends the emails.
+	Mailer interface {
+		Send(ctx context.Context, m Message) error
+	}
+
+	// Message is a multipart/alternative email with a plain text and an HTML body.
+	Message struct {
+		From    string
+		To      string
+		Subject string
+		// Headers are the extra headers, e.g. List-Unsubscribe.
+		Headers map[string]string
+		Text    string
+		HTML    string
+	}
+
+	Config struct {
+		From string `mapstructure:"from"`
+		// Dir is where the FileMailer writes the emails when SMTP is not configured.
+		Dir  string     `mapstructure:"dir"`
+		SMTP SMTPConfig `mapstructure:"smtp"`
+	}
+)
+
+func DefaultConfig() Config {
+	return Config{
+		From: "GT Online <no-reply@gtonline.local>",
+		Dir:  "tmp/mail",
+	}
+}
+
+// New returns an SMTPMailer if SMTP is configured, otherwise a FileMailer.
+func New(cfg Config) Mailer {
+	if cfg.SMTP.Addr != "" {
+		return NewSMTPMailer(cfg.SMTP, cfg.From)
+	}
+	return NewFileMailer(cfg.Dir, cfg.From)
+}
+
+// Bytes encodes m as an RFC 5322 message, From is set to from if m has none.
+func (m Message) Bytes(from string) ([]byte, error) {
+	if m.From == "" {
+		m.From = from
+	}
+
+	var buf bytes.Buffer
+	body := multipart.NewWriter(&buf)
+
+	headers := map[string]string{
+		"From":         m.From,
+		"To":           m.To,
+		"Subject":      mime.QEncoding.Encode("utf-8", m.Subject),
+		"Date":         time.Now().Format(time.RFC1123Z),
+		"Message-ID":   messageID(),
+		"MIME-Version": "1.0",
+		"Content-Type": fmt.Sprintf("multipart/alternative; boundary=%s", body.Boundary()),
+	}
+	for k, v := range m.Headers {
+		headers[k] = v
+	}
+
+	var head bytes.Buffer
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&head, "%s: %s\r\n", k, headers[k])
+	}
+	head.WriteString("\r\n")
+
+	// The plain text goes first, clients show the last alternative they support
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", m.Text},
+		{"text/html; charset=utf-8", m.HTML},
+	} {
+		w, err := body.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if err := writeQuotedPrintable(w, part.content); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+	return append(head.Bytes(), buf.Bytes()...), nil
+}
+
+func writeQuotedPrintable(w io.Writer, s string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := io.WriteString(qp, s); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func messageID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("<%s@gtonline>", hex.EncodeToString(b))
+}
@@ -0,0 +1,63 @@
+package mail_test
+
+import (
+	"context"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	netmail "net/mail"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/victornm/gtonline/internal/mail"
+)
+
+func TestFileMailer_Send(t *testing.T) {
+	m := mail.NewFileMailer(t.TempDir(), "GT Online <no-reply@gtonline.local>")
+
+	require.NoError(t, m.Send(context.TODO(), mail.Message{
+		To:      "foo@mock.com",
+		Subject: "Your daily digest",
+		Headers: map[string]string{"List-Unsubscribe": "<http://localhost/unsubscribe>"},
+		Text:    "Hello foo",
+		HTML:    "<p>Hello <strong>foo</strong></p>",
+	}))
+
+	files, err := m.Files()
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	f, err := os.Open(files[0])
+	require.NoError(t, err)
+	defer f.Close()
+
+	msg, err := netmail.ReadMessage(f)
+	require.NoError(t, err)
+	assert.Equal(t, "GT Online <no-reply@gtonline.local>", msg.Header.Get("From"))
+	assert.Equal(t, "foo@mock.com", msg.Header.Get("To"))
+	assert.Equal(t, "<http://localhost/unsubscribe>", msg.Header.Get("List-Unsubscribe"))
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/alternative", mediaType)
+
+	var parts []string
+	r := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := r.NextRawPart()
+		if err != nil {
+			break
+		}
+		b, err := ioutil.ReadAll(quotedprintable.NewReader(p))
+		require.NoError(t, err)
+		parts = append(parts, p.Header.Get("Content-Type")+": "+string(b))
+	}
+	assert.Equal(t, []string{
+		"text/plain; charset=utf-8: Hello foo",
+		"text/html; charset=utf-8: <p>Hello <strong>foo</strong></p>",
+	}, parts)
+}
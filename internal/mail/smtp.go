@@ -0,0 +1,59 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+)
+
+type (
+	SMTPConfig struct {
+		// Addr is host:port of the server, the SMTPMailer is disabled if empty.
+		Addr     string `mapstructure:"addr"`
+		Username string `mapstructure:"username"`
+		Password string `mapstructure:"password"`
+	}
+
+	// SMTPMailer sends the emails through an SMTP server, with STARTTLS if the server supports it.
+	SMTPMailer struct {
+		cfg  SMTPConfig
+		from string
+	}
+)
+
+func NewSMTPMailer(cfg SMTPConfig, from string) *SMTPMailer {
+	return &SMTPMailer{cfg: cfg, from: from}
+}
+
+func (s *SMTPMailer) Send(_ context.Context, m Message) error {
+	b, err := m.Bytes(s.from)
+	if err != nil {
+		return fmt.Errorf("encode message: %v", err)
+	}
+
+	from, err := mail.ParseAddress(s.from)
+	if m.From != "" {
+		from, err = mail.ParseAddress(m.From)
+	}
+	if err != nil {
+		return fmt.Errorf("parse from: %v", err)
+	}
+
+	to, err := mail.ParseAddress(m.To)
+	if err != nil {
+		return fmt.Errorf("parse to: %v", err)
+	}
+
+	var auth smtp.Auth
+	if s.cfg.Username != "" {
+		host, _, _ := net.SplitHostPort(s.cfg.Addr)
+		auth = smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, host)
+	}
+
+	if err := smtp.SendMail(s.cfg.Addr, auth, from.Address, []string{to.Address}, b); err != nil {
+		return fmt.Errorf("send mail to %s: %v", to.Address, err)
+	}
+	return nil
+}
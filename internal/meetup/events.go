@@ -0,0 +1,58 @@
+package meetup
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/victornm/gtonline/internal/event"
+	"github.com/victornm/gtonline/internal/notification"
+)
+
+const (
+	EventGuestInvited  = "meetup.guest_invited"
+	EventGuestPromoted = "meetup.guest_promoted"
+)
+
+type (
+	// GuestEvent is the payload of the guest events, Email is the guest.
+	GuestEvent struct {
+		EventID int64  `json:"event_id"`
+		Host    string `json:"host"`
+		Email   string `json:"email"`
+	}
+
+	// GuestInvited is published when the host invites a friend.
+	GuestInvited GuestEvent
+	// GuestPromoted is published when a guest of the waitlist gets a spot.
+	GuestPromoted GuestEvent
+
+	// Notifier delivers the notifications to the guests.
+	Notifier interface {
+		Notify(ctx context.Context, n notification.Notification) error
+	}
+)
+
+func (GuestInvited) EventName() string  { return EventGuestInvited }
+func (GuestPromoted) EventName() string { return EventGuestPromoted }
+
+// NotificationHandler notifies the guests who are invited or get a spot from the waitlist.
+func NotificationHandler(n Notifier) event.Handler {
+	return func(ctx context.Context, r *event.Record) error {
+		var e GuestEvent
+		if err := r.Decode(&e); err != nil {
+			return err
+		}
+
+		notice := notification.Notification{Email: e.Email, Actor: e.Host}
+		switch r.Name {
+		case EventGuestInvited:
+			notice.Type = notification.EventInvited
+		case EventGuestPromoted:
+			notice.Type = notification.EventPromoted
+		default:
+			return fmt.Errorf("unexpected event %s", r.Name)
+		}
+
+		return n.Notify(ctx, notice)
+	}
+}
@@ -0,0 +1,68 @@
+package meetup
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/signed"
+)
+
+const feedTokenPurpose = "meetup.feed"
+
+// Feed is the calendar feed of a user, the calendar apps subscribe to URL without the access token of the user.
+type Feed struct {
+	URL string `json:"url"`
+}
+
+// ExportEvent returns the event as an iCalendar file if req.Email can see it.
+func (s *Service) ExportEvent(ctx context.Context, req GetEventRequest) ([]byte, error) {
+	e, err := s.getVisible(ctx, s.storage, req.Email, req.ID)
+	if err != nil {
+		return nil, err
+	}
+	return Calendar("", e), nil
+}
+
+// GetFeed returns the calendar feed of email.
+func (s *Service) GetFeed(_ context.Context, email string) *Feed {
+	u, err := url.Parse(s.cfg.FeedURL)
+	if err != nil {
+		return &Feed{URL: s.cfg.FeedURL}
+	}
+
+	q := u.Query()
+	q.Set("token", s.FeedToken(email))
+	u.RawQuery = q.Encode()
+	return &Feed{URL: u.String()}
+}
+
+// Calendar returns the iCalendar feed of the user the token was issued for,
+// with the upcoming events and the events ended in the last FeedPast.
+func (s *Service) Calendar(ctx context.Context, token string) ([]byte, error) {
+	email, err := s.verifyToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := s.storage.ListUpcomingMeetups(ctx, email, time.Now().Add(-s.cfg.FeedPast), maxFeedEvents)
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", fmt.Errorf("list events: %v", err))
+	}
+	return Calendar("GT Online", events...), nil
+}
+
+// FeedToken returns the calendar feed token of email, it doesn't expire so the subscribed calendars keep working.
+func (s *Service) FeedToken(email string) string {
+	return signed.Token(s.cfg.Secret, feedTokenPurpose, email, time.Time{})
+}
+
+func (s *Service) verifyToken(token string) (string, error) {
+	email, err := signed.Verify(s.cfg.Secret, feedTokenPurpose, token, time.Now())
+	if err != nil {
+		return "", gterr.New(gterr.InvalidArgument, "invalid calendar token", err)
+	}
+	return email, nil
+}
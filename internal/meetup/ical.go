@@ -0,0 +1,84 @@
+package meetup
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	icalTimeLayout = "20060102T150405Z"
+	// icalLineLength is the max length of a content line in octets, the longer lines are folded.
+	icalLineLength = 75
+)
+
+var icalEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+
+// Calendar encodes the events as an iCalendar object, see RFC 5545.
+// The events the user didn't answer yes to are tentative.
+func Calendar(name string, events ...*Event) []byte {
+	var b bytes.Buffer
+	line := func(name, value string) {
+		writeLine(&b, name+":"+value)
+	}
+
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", "-//GT Online//Events//EN")
+	line("CALSCALE", "GREGORIAN")
+	line("METHOD", "PUBLISH")
+	if name != "" {
+		line("X-WR-CALNAME", icalEscaper.Replace(name))
+	}
+
+	for _, e := range events {
+		status := "CONFIRMED"
+		if e.RSVP != "" && e.RSVP != Yes {
+			status = "TENTATIVE"
+		}
+
+		line("BEGIN", "VEVENT")
+		line("UID", fmt.Sprintf("event-%d@gtonline", e.ID))
+		line("DTSTAMP", icalTime(e.UpdatedAt))
+		line("DTSTART", icalTime(e.StartsAt))
+		line("DTEND", icalTime(e.EndsAt))
+		line("LAST-MODIFIED", icalTime(e.UpdatedAt))
+		line("SUMMARY", icalEscaper.Replace(e.Title))
+		if e.Description != "" {
+			line("DESCRIPTION", icalEscaper.Replace(e.Description))
+		}
+		line("LOCATION", icalEscaper.Replace(e.City))
+		line("ORGANIZER", "mailto:"+e.Host)
+		line("STATUS", status)
+		line("END", "VEVENT")
+	}
+
+	line("END", "VCALENDAR")
+	return b.Bytes()
+}
+
+func icalTime(t time.Time) string {
+	return t.UTC().Format(icalTimeLayout)
+}
+
+// writeLine writes the content line ended by CRLF, folded so no line is longer than icalLineLength octets.
+func writeLine(b *bytes.Buffer, s string) {
+	limit := icalLineLength
+	for len(s) > limit {
+		// Don't split a multi-byte character
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+
+		b.WriteString(s[:cut])
+		b.WriteString("\r\n ")
+		s = s[cut:]
+		// The continuation lines start with a space
+		limit = icalLineLength - 1
+	}
+	b.WriteString(s)
+	b.WriteString("\r\n")
+}
@@ -0,0 +1,380 @@
+// Package meetup organizes the events where the users get together, the package event being the domain events.
+package meetup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/victornm/gtonline/internal/event"
+	"github.com/victornm/gtonline/internal/friend"
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+type (
+	Service struct {
+		storage Storage
+		friends friend.Storage
+		cfg     Config
+	}
+
+	Config struct {
+		// FeedURL is the calendar feed given to the users, it receives the token in the query.
+		FeedURL string `mapstructure:"feed_url"`
+		// Secret signs the calendar feed tokens.
+		Secret string `mapstructure:"secret"`
+		// FeedPast is how long the ended events stay in the calendar feed.
+		FeedPast time.Duration `mapstructure:"feed_past"`
+	}
+
+	Storage interface {
+		// InsertMeetup inserts e and sets its ID.
+		InsertMeetup(ctx context.Context, e *Event) error
+		// GetMeetup returns the event with Going and Waitlisted counted.
+		GetMeetup(ctx context.Context, id int64) (*Event, error)
+		UpdateMeetup(ctx context.Context, e *Event) error
+		// DeleteMeetup deletes the event with its guests.
+		DeleteMeetup(ctx context.Context, id int64) error
+		// ListUpcomingMeetups returns at most limit events ending after from which email hosts or didn't decline,
+		// the soonest first, with Going and Waitlisted counted and RSVP set to the status of email.
+		ListUpcomingMeetups(ctx context.Context, email string, from time.Time, limit int) ([]*Event, error)
+
+		// ListGuests returns the guests of the event, the least recently updated first, with Deactivated set.
+		ListGuests(ctx context.Context, id int64) ([]*Guest, error)
+		// GetGuest returns storage.ErrNotFound if email is not a guest of the event.
+		GetGuest(ctx context.Context, id int64, email string) (*Guest, error)
+		// SaveGuest inserts or replaces the guest of the event.
+		// It returns storage.ErrInvalidArgument if the event or the user doesn't exist.
+		SaveGuest(ctx context.Context, id int64, g *Guest) error
+		DeleteGuest(ctx context.Context, id int64, email string) error
+
+		// WithMeetupLock runs f while no other WithMeetupLock of the same event is running,
+		// so the capacity is checked and the guests are changed atomically.
+		WithMeetupLock(ctx context.Context, id int64, f func(ctx context.Context, tx Storage) error) error
+		// The events appended through the tx of WithMeetupLock are written with the other changes of tx.
+		event.Outbox
+	}
+)
+
+func NewService(s Storage, friends friend.Storage, cfg Config) *Service {
+	return &Service{storage: s, friends: friends, cfg: cfg.withDefaults()}
+}
+
+func DefaultConfig() Config {
+	return Config{
+		FeedURL:  "http://localhost:8080/calendar.ics",
+		FeedPast: 30 * 24 * time.Hour,
+	}
+}
+
+func (c Config) withDefaults() Config {
+	d := DefaultConfig()
+	if c.FeedURL == "" {
+		c.FeedURL = d.FeedURL
+	}
+	if c.FeedPast <= 0 {
+		c.FeedPast = d.FeedPast
+	}
+	return c
+}
+
+// Visibility tells who sees the event besides its host and guests.
+type Visibility string
+
+const (
+	Public Visibility = "public"
+	// Friends events are seen by the friends of the host.
+	Friends Visibility = "friends"
+	// Private events are only seen by the guests.
+	Private Visibility = "private"
+)
+
+// Status is the answer of a guest, the host always attends.
+type Status string
+
+const (
+	Invited Status = "invited"
+	Yes     Status = "yes"
+	No      Status = "no"
+	Maybe   Status = "maybe"
+	// Waitlisted guests answered yes to a full event, they go in order when a spot opens up.
+	Waitlisted Status = "waitlisted"
+)
+
+const (
+	defaultLimit = 20
+	maxLimit     = 100
+	// maxFeedEvents bounds the calendar feed of a user.
+	maxFeedEvents = 500
+)
+
+type (
+	Event struct {
+		ID          int64      `json:"id"`
+		Host        string     `json:"host"`
+		Title       string     `json:"title"`
+		Description string     `json:"description"`
+		City        string     `json:"city"`
+		StartsAt    time.Time  `json:"starts_at"`
+		EndsAt      time.Time  `json:"ends_at"`
+		Visibility  Visibility `json:"visibility"`
+		// Capacity is the number of guests who can go, the host excluded, 0 is unlimited.
+		Capacity   int       `json:"capacity"`
+		Going      int       `json:"going"`
+		Waitlisted int       `json:"waitlisted"`
+		CreatedAt  time.Time `json:"created_at"`
+		UpdatedAt  time.Time `json:"updated_at"`
+		// RSVP is the status of the user who asked for the event, empty for the host and the users not invited.
+		RSVP Status `json:"rsvp,omitempty"`
+	}
+
+	Guest struct {
+		Email  string `json:"email"`
+		Status Status `json:"status"`
+		// UpdatedAt orders the waitlist.
+		UpdatedAt time.Time `json:"updated_at"`
+		// Deactivated guests keep their spot but are hidden from the guest list.
+		Deactivated bool `json:"-"`
+	}
+
+	CreateEventRequest struct {
+		Email       string     `json:"-"`
+		Title       string     `json:"title" binding:"required,max=100"`
+		Description string     `json:"description" binding:"max=2000"`
+		City        string     `json:"city" binding:"required,max=50"`
+		StartsAt    time.Time  `json:"starts_at" binding:"required"`
+		EndsAt      time.Time  `json:"ends_at" binding:"required"`
+		Visibility  Visibility `json:"visibility" binding:"required,oneof=public friends private"`
+		Capacity    int        `json:"capacity" binding:"min=0"`
+	}
+
+	// UpdateEventRequest replaces the details of the event, lowering the capacity keeps the guests already going.
+	UpdateEventRequest struct {
+		ID int64 `json:"-"`
+		CreateEventRequest
+	}
+
+	GetEventRequest struct {
+		Email string
+		ID    int64
+	}
+
+	GetEventResponse struct {
+		Event  *Event   `json:"event"`
+		Guests []*Guest `json:"guests"`
+	}
+
+	ListUpcomingRequest struct {
+		Email string `form:"-"`
+		Limit int    `form:"limit"`
+	}
+
+	ListEventsResponse struct {
+		Events []*Event `json:"events"`
+	}
+)
+
+// CreateEvent creates an event hosted by req.Email.
+func (s *Service) CreateEvent(ctx context.Context, req CreateEventRequest) (*Event, error) {
+	now := time.Now()
+	e := &Event{Host: req.Email, CreatedAt: now}
+	if err := e.apply(req, now); err != nil {
+		return nil, err
+	}
+
+	err := s.storage.InsertMeetup(ctx, e)
+	if errors.Is(err, storage.ErrInvalidArgument) {
+		return nil, gterr.New(gterr.NotFound, "user not found", err)
+	}
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+	return e, nil
+}
+
+func (e *Event) apply(req CreateEventRequest, now time.Time) error {
+	title, city := strings.TrimSpace(req.Title), strings.TrimSpace(req.City)
+	if title == "" {
+		return gterr.New(gterr.InvalidArgument, "empty title")
+	}
+	if city == "" {
+		return gterr.New(gterr.InvalidArgument, "empty city")
+	}
+
+	switch req.Visibility {
+	case Public, Friends, Private:
+	default:
+		return gterr.New(gterr.InvalidArgument, fmt.Sprintf("invalid visibility: %s", req.Visibility))
+	}
+
+	if req.Capacity < 0 {
+		return gterr.New(gterr.InvalidArgument, "negative capacity")
+	}
+
+	// The calendars have a precision of a second
+	startsAt, endsAt := req.StartsAt.UTC().Truncate(time.Second), req.EndsAt.UTC().Truncate(time.Second)
+	if !endsAt.After(startsAt) {
+		return gterr.New(gterr.InvalidArgument, "the event must end after it starts")
+	}
+	if !endsAt.After(now) {
+		return gterr.New(gterr.InvalidArgument, "the event must end in the future")
+	}
+
+	e.Title = title
+	e.Description = strings.TrimSpace(req.Description)
+	e.City = city
+	e.StartsAt = startsAt
+	e.EndsAt = endsAt
+	e.Visibility = req.Visibility
+	e.Capacity = req.Capacity
+	e.UpdatedAt = now
+	return nil
+}
+
+// GetEvent returns the event with its guests if req.Email can see it.
+func (s *Service) GetEvent(ctx context.Context, req GetEventRequest) (*GetEventResponse, error) {
+	e, err := s.getVisible(ctx, s.storage, req.Email, req.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	guests, err := s.storage.ListGuests(ctx, req.ID)
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", fmt.Errorf("list guests: %v", err))
+	}
+
+	visible := make([]*Guest, 0, len(guests))
+	for _, g := range guests {
+		if !g.Deactivated {
+			visible = append(visible, g)
+		}
+	}
+	return &GetEventResponse{Event: e, Guests: visible}, nil
+}
+
+// UpdateEvent changes the details of the event, the guests on the waitlist go if the capacity is raised.
+func (s *Service) UpdateEvent(ctx context.Context, req UpdateEventRequest) (*Event, error) {
+	var res *Event
+
+	err := s.storage.WithMeetupLock(ctx, req.ID, func(ctx context.Context, tx Storage) error {
+		e, err := s.getHosted(ctx, tx, req.Email, req.ID)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if err := e.apply(req.CreateEventRequest, now); err != nil {
+			return err
+		}
+
+		if err := tx.UpdateMeetup(ctx, e); err != nil {
+			return gterr.New(gterr.Internal, "", err)
+		}
+
+		if err := s.promote(ctx, tx, e, now); err != nil {
+			return err
+		}
+
+		res, err = s.getVisible(ctx, tx, req.Email, req.ID)
+		return err
+	})
+	if err != nil {
+		return nil, lockErr(err)
+	}
+	return res, nil
+}
+
+// DeleteEvent cancels the event, only its host can.
+func (s *Service) DeleteEvent(ctx context.Context, req GetEventRequest) error {
+	err := s.storage.WithMeetupLock(ctx, req.ID, func(ctx context.Context, tx Storage) error {
+		if _, err := s.getHosted(ctx, tx, req.Email, req.ID); err != nil {
+			return err
+		}
+
+		if err := tx.DeleteMeetup(ctx, req.ID); err != nil {
+			return gterr.New(gterr.Internal, "", err)
+		}
+		return nil
+	})
+	return lockErr(err)
+}
+
+// ListUpcoming returns the events req.Email hosts or is invited to and didn't decline, which are not over yet.
+func (s *Service) ListUpcoming(ctx context.Context, req ListUpcomingRequest) (*ListEventsResponse, error) {
+	if req.Limit <= 0 {
+		req.Limit = defaultLimit
+	}
+	if req.Limit > maxLimit {
+		req.Limit = maxLimit
+	}
+
+	events, err := s.storage.ListUpcomingMeetups(ctx, req.Email, time.Now(), req.Limit)
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", fmt.Errorf("list upcoming events: %v", err))
+	}
+	return &ListEventsResponse{Events: events}, nil
+}
+
+// getVisible returns the event with the RSVP of email, the events email can't see are not found.
+func (s *Service) getVisible(ctx context.Context, tx Storage, email string, id int64) (*Event, error) {
+	notFound := gterr.New(gterr.NotFound, fmt.Sprintf("event %d not found", id))
+
+	e, err := tx.GetMeetup(ctx, id)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, notFound
+	}
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+
+	if strings.EqualFold(e.Host, email) {
+		return e, nil
+	}
+
+	g, err := tx.GetGuest(ctx, id, email)
+	if err == nil {
+		e.RSVP = g.Status
+		return e, nil
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+
+	switch e.Visibility {
+	case Public:
+		return e, nil
+	case Friends:
+		ok, err := friend.AreFriends(ctx, s.friends, e.Host, email)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return e, nil
+		}
+	}
+	return nil, notFound
+}
+
+// getHosted returns the event if email is its host.
+func (s *Service) getHosted(ctx context.Context, tx Storage, email string, id int64) (*Event, error) {
+	e, err := s.getVisible(ctx, tx, email, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.EqualFold(e.Host, email) {
+		return nil, gterr.New(gterr.PermissionDenied, "only the host can change the event")
+	}
+	return e, nil
+}
+
+// lockErr converts the errors of the storage lock, the errors of the service pass through.
+func lockErr(err error) error {
+	if _, ok := gterr.FromError(err); err != nil && !ok {
+		return gterr.New(gterr.Internal, "", err)
+	}
+	return err
+}
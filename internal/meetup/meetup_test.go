@@ -0,0 +1,319 @@
+package meetup_test
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/victornm/gtonline/internal/friend"
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/meetup"
+	"github.com/victornm/gtonline/internal/storage/memory"
+)
+
+const (
+	host     = "host@mock.com"
+	stranger = "stranger@mock.com"
+)
+
+var friends = []string{"foo@mock.com", "bar@mock.com", "baz@mock.com"}
+
+func makeService(t *testing.T) (*meetup.Service, *memory.Storage) {
+	t.Helper()
+
+	mock := memory.NewStorage()
+	users := []memory.User{{Email: host}, {Email: stranger}}
+	for _, email := range friends {
+		users = append(users, memory.User{Email: email})
+	}
+	mock.InsertUsers(users)
+
+	for _, email := range friends {
+		err := mock.InsertFriendship(context.TODO(), &friend.Friendship{Email: host, FriendEmail: email, DateConnected: time.Now()})
+		require.NoError(t, err)
+	}
+
+	return meetup.NewService(mock, mock, meetup.Config{Secret: "secret"}), mock
+}
+
+func createRequest(visibility meetup.Visibility, capacity int) meetup.CreateEventRequest {
+	startsAt := time.Now().Add(24 * time.Hour)
+	return meetup.CreateEventRequest{
+		Email:      host,
+		Title:      "Board games",
+		City:       "Atlanta",
+		StartsAt:   startsAt,
+		EndsAt:     startsAt.Add(3 * time.Hour),
+		Visibility: visibility,
+		Capacity:   capacity,
+	}
+}
+
+func TestService_CreateEvent(t *testing.T) {
+	s, _ := makeService(t)
+	ctx := context.TODO()
+
+	tests := []struct {
+		name   string
+		modify func(req *meetup.CreateEventRequest)
+		code   gterr.ErrorCode
+	}{
+		{name: "ok", modify: func(req *meetup.CreateEventRequest) {}, code: gterr.OK},
+		{name: "blank title", modify: func(req *meetup.CreateEventRequest) { req.Title = "  " }, code: gterr.InvalidArgument},
+		{name: "ends before start", modify: func(req *meetup.CreateEventRequest) { req.EndsAt = req.StartsAt }, code: gterr.InvalidArgument},
+		{name: "over", modify: func(req *meetup.CreateEventRequest) {
+			req.StartsAt, req.EndsAt = time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour)
+		}, code: gterr.InvalidArgument},
+		{name: "unknown visibility", modify: func(req *meetup.CreateEventRequest) { req.Visibility = "secret" }, code: gterr.InvalidArgument},
+		{name: "unknown host", modify: func(req *meetup.CreateEventRequest) { req.Email = "nobody@mock.com" }, code: gterr.NotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := createRequest(meetup.Public, 0)
+			test.modify(&req)
+
+			e, err := s.CreateEvent(ctx, req)
+			assert.Equal(t, test.code, gterr.Code(err))
+			if err == nil {
+				assert.NotZero(t, e.ID)
+				assert.Equal(t, req.StartsAt.UTC().Truncate(time.Second), e.StartsAt)
+			}
+		})
+	}
+}
+
+func TestService_GetEvent_Visibility(t *testing.T) {
+	s, _ := makeService(t)
+	ctx := context.TODO()
+
+	tests := []struct {
+		visibility meetup.Visibility
+		// visible tells whether the friend, the guest and the stranger see the event
+		friend, guest, stranger bool
+	}{
+		{visibility: meetup.Public, friend: true, guest: true, stranger: true},
+		{visibility: meetup.Friends, friend: true, guest: true, stranger: false},
+		{visibility: meetup.Private, friend: false, guest: true, stranger: false},
+	}
+	for _, test := range tests {
+		t.Run(string(test.visibility), func(t *testing.T) {
+			e, err := s.CreateEvent(ctx, createRequest(test.visibility, 0))
+			require.NoError(t, err)
+			require.NoError(t, s.Invite(ctx, meetup.GuestRequest{Email: host, EventID: e.ID, GuestEmail: friends[0]}))
+
+			visible := func(email string) bool {
+				_, err := s.GetEvent(ctx, meetup.GetEventRequest{Email: email, ID: e.ID})
+				if err != nil {
+					assert.Equal(t, gterr.NotFound, gterr.Code(err))
+				}
+				return err == nil
+			}
+
+			assert.True(t, visible(host))
+			assert.Equal(t, test.guest, visible(friends[0]))
+			assert.Equal(t, test.friend, visible(friends[1]))
+			assert.Equal(t, test.stranger, visible(stranger))
+		})
+	}
+}
+
+func TestService_Invite(t *testing.T) {
+	s, mock := makeService(t)
+	ctx := context.TODO()
+
+	e, err := s.CreateEvent(ctx, createRequest(meetup.Private, 0))
+	require.NoError(t, err)
+
+	invite := func(email, guest string) error {
+		return s.Invite(ctx, meetup.GuestRequest{Email: email, EventID: e.ID, GuestEmail: guest})
+	}
+
+	require.NoError(t, invite(host, friends[0]))
+	assert.Equal(t, gterr.AlreadyExists, gterr.Code(invite(host, friends[0])))
+	assert.Equal(t, gterr.FailedPrecondition, gterr.Code(invite(host, stranger)))
+	assert.Equal(t, gterr.InvalidArgument, gterr.Code(invite(host, host)))
+	// The guests see the event, but only the host invites
+	assert.Equal(t, gterr.PermissionDenied, gterr.Code(invite(friends[0], friends[1])))
+
+	events := mock.Events()
+	require.Len(t, events, 1)
+	assert.Equal(t, meetup.EventGuestInvited, events[0].Name)
+
+	res, err := s.GetEvent(ctx, meetup.GetEventRequest{Email: friends[0], ID: e.ID})
+	require.NoError(t, err)
+	assert.Equal(t, meetup.Invited, res.Event.RSVP)
+	require.Len(t, res.Guests, 1)
+	assert.Equal(t, friends[0], res.Guests[0].Email)
+
+	err = s.Uninvite(ctx, meetup.GuestRequest{Email: host, EventID: e.ID, GuestEmail: friends[0]})
+	require.NoError(t, err)
+	_, err = s.GetEvent(ctx, meetup.GetEventRequest{Email: friends[0], ID: e.ID})
+	assert.Equal(t, gterr.NotFound, gterr.Code(err))
+}
+
+func TestService_Invite_Deactivated(t *testing.T) {
+	s, mock := makeService(t)
+	ctx := context.TODO()
+
+	e, err := s.CreateEvent(ctx, createRequest(meetup.Private, 0))
+	require.NoError(t, err)
+	for _, email := range friends[:2] {
+		require.NoError(t, s.Invite(ctx, meetup.GuestRequest{Email: host, EventID: e.ID, GuestEmail: email}))
+	}
+
+	require.NoError(t, mock.DeactivateUser(ctx, friends[1], time.Now(), nil))
+	require.NoError(t, mock.DeactivateUser(ctx, friends[2], time.Now(), nil))
+
+	err = s.Invite(ctx, meetup.GuestRequest{Email: host, EventID: e.ID, GuestEmail: friends[2]})
+	assert.Equal(t, gterr.FailedPrecondition, gterr.Code(err), "not a friend while deactivated")
+
+	res, err := s.GetEvent(ctx, meetup.GetEventRequest{Email: host, ID: e.ID})
+	require.NoError(t, err)
+	require.Len(t, res.Guests, 1)
+	assert.Equal(t, friends[0], res.Guests[0].Email)
+}
+
+func TestService_RSVP_Waitlist(t *testing.T) {
+	s, mock := makeService(t)
+	ctx := context.TODO()
+
+	e, err := s.CreateEvent(ctx, createRequest(meetup.Friends, 1))
+	require.NoError(t, err)
+
+	rsvp := func(email string, response meetup.Status) meetup.Status {
+		t.Helper()
+		g, err := s.RSVP(ctx, meetup.RSVPRequest{Email: email, EventID: e.ID, Response: response})
+		require.NoError(t, err)
+		return g.Status
+	}
+
+	assert.Equal(t, meetup.Yes, rsvp(friends[0], meetup.Yes))
+	assert.Equal(t, meetup.Waitlisted, rsvp(friends[1], meetup.Yes))
+	time.Sleep(time.Millisecond)
+	assert.Equal(t, meetup.Waitlisted, rsvp(friends[2], meetup.Yes))
+	// Answering yes again keeps the place in the waitlist
+	assert.Equal(t, meetup.Waitlisted, rsvp(friends[1], meetup.Yes))
+	assert.Equal(t, meetup.Yes, rsvp(friends[0], meetup.Yes))
+
+	// The first on the waitlist gets the spot
+	assert.Equal(t, meetup.Maybe, rsvp(friends[0], meetup.Maybe))
+	res, err := s.GetEvent(ctx, meetup.GetEventRequest{Email: friends[1], ID: e.ID})
+	require.NoError(t, err)
+	assert.Equal(t, meetup.Yes, res.Event.RSVP)
+	assert.Equal(t, 1, res.Event.Going)
+	assert.Equal(t, 1, res.Event.Waitlisted)
+
+	events := mock.Events()
+	require.Len(t, events, 1)
+	assert.Equal(t, meetup.EventGuestPromoted, events[0].Name)
+	assert.Contains(t, string(events[0].Payload), friends[1])
+
+	// Raising the capacity lets the rest of the waitlist go
+	req := meetup.UpdateEventRequest{ID: e.ID, CreateEventRequest: createRequest(meetup.Friends, 5)}
+	updated, err := s.UpdateEvent(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, 2, updated.Going)
+	assert.Equal(t, 0, updated.Waitlisted)
+
+	_, err = s.RSVP(ctx, meetup.RSVPRequest{Email: host, EventID: e.ID, Response: meetup.No})
+	assert.Equal(t, gterr.InvalidArgument, gterr.Code(err))
+	_, err = s.RSVP(ctx, meetup.RSVPRequest{Email: stranger, EventID: e.ID, Response: meetup.Yes})
+	assert.Equal(t, gterr.NotFound, gterr.Code(err))
+}
+
+func TestService_ListUpcoming(t *testing.T) {
+	s, _ := makeService(t)
+	ctx := context.TODO()
+
+	later := createRequest(meetup.Public, 0)
+	later.StartsAt, later.EndsAt = later.StartsAt.Add(48*time.Hour), later.EndsAt.Add(48*time.Hour)
+	declined := createRequest(meetup.Public, 0)
+	invited := createRequest(meetup.Private, 0)
+
+	var ids []int64
+	for _, req := range []meetup.CreateEventRequest{later, declined, invited} {
+		e, err := s.CreateEvent(ctx, req)
+		require.NoError(t, err)
+		ids = append(ids, e.ID)
+	}
+
+	_, err := s.RSVP(ctx, meetup.RSVPRequest{Email: friends[0], EventID: ids[0], Response: meetup.Maybe})
+	require.NoError(t, err)
+	_, err = s.RSVP(ctx, meetup.RSVPRequest{Email: friends[0], EventID: ids[1], Response: meetup.No})
+	require.NoError(t, err)
+	require.NoError(t, s.Invite(ctx, meetup.GuestRequest{Email: host, EventID: ids[2], GuestEmail: friends[0]}))
+
+	res, err := s.ListUpcoming(ctx, meetup.ListUpcomingRequest{Email: friends[0]})
+	require.NoError(t, err)
+	require.Len(t, res.Events, 2)
+	assert.Equal(t, ids[2], res.Events[0].ID)
+	assert.Equal(t, meetup.Invited, res.Events[0].RSVP)
+	assert.Equal(t, ids[0], res.Events[1].ID)
+	assert.Equal(t, meetup.Maybe, res.Events[1].RSVP)
+
+	res, err = s.ListUpcoming(ctx, meetup.ListUpcomingRequest{Email: host, Limit: 2})
+	require.NoError(t, err)
+	assert.Len(t, res.Events, 2)
+}
+
+func TestService_Calendar(t *testing.T) {
+	s, _ := makeService(t)
+	ctx := context.TODO()
+
+	e, err := s.CreateEvent(ctx, createRequest(meetup.Public, 0))
+	require.NoError(t, err)
+	_, err = s.RSVP(ctx, meetup.RSVPRequest{Email: friends[0], EventID: e.ID, Response: meetup.Maybe})
+	require.NoError(t, err)
+
+	feed := s.GetFeed(ctx, friends[0])
+	u, err := url.Parse(feed.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "/calendar.ics", u.Path)
+
+	ics, err := s.Calendar(ctx, u.Query().Get("token"))
+	require.NoError(t, err)
+	assert.Contains(t, string(ics), "SUMMARY:Board games\r\n")
+	assert.Contains(t, string(ics), "STATUS:TENTATIVE\r\n")
+
+	_, err = s.Calendar(ctx, s.FeedToken(friends[0])+"x")
+	assert.Equal(t, gterr.InvalidArgument, gterr.Code(err))
+
+	_, err = s.ExportEvent(ctx, meetup.GetEventRequest{Email: stranger, ID: e.ID})
+	assert.NoError(t, err)
+}
+
+func TestCalendar(t *testing.T) {
+	at := time.Date(2021, 8, 2, 18, 30, 0, 0, time.UTC)
+	e := &meetup.Event{
+		ID:          7,
+		Host:        host,
+		Title:       "Dinner, drinks; fun",
+		Description: "Line one\nLine two with a long text " + strings.Repeat("é", 40),
+		City:        "Atlanta",
+		StartsAt:    at,
+		EndsAt:      at.Add(2 * time.Hour),
+		UpdatedAt:   at.Add(-time.Hour),
+	}
+
+	ics := string(meetup.Calendar("GT Online", e))
+	assert.True(t, strings.HasPrefix(ics, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n"))
+	assert.True(t, strings.HasSuffix(ics, "END:VEVENT\r\nEND:VCALENDAR\r\n"))
+	assert.Contains(t, ics, "UID:event-7@gtonline\r\n")
+	assert.Contains(t, ics, "DTSTART:20210802T183000Z\r\nDTEND:20210802T203000Z\r\n")
+	assert.Contains(t, ics, `SUMMARY:Dinner\, drinks\; fun`+"\r\n")
+	assert.Contains(t, ics, "ORGANIZER:mailto:host@mock.com\r\n")
+	assert.Contains(t, ics, "STATUS:CONFIRMED\r\n")
+
+	for _, line := range strings.Split(strings.TrimSuffix(ics, "\r\n"), "\r\n") {
+		assert.LessOrEqual(t, len(line), 75, line)
+	}
+
+	// Unfolding restores the escaped description
+	unfolded := strings.ReplaceAll(ics, "\r\n ", "")
+	assert.Contains(t, unfolded, `DESCRIPTION:Line one\nLine two with a long text `+strings.Repeat("é", 40)+"\r\n")
+}
@@ -0,0 +1,207 @@
+package meetup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/victornm/gtonline/internal/event"
+	"github.com/victornm/gtonline/internal/friend"
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+type (
+	GuestRequest struct {
+		Email      string
+		EventID    int64
+		GuestEmail string
+	}
+
+	RSVPRequest struct {
+		Email    string `json:"-"`
+		EventID  int64  `json:"-"`
+		Response Status `json:"response" binding:"required,oneof=yes no maybe"`
+	}
+)
+
+// Invite invites a friend of the host to the event.
+func (s *Service) Invite(ctx context.Context, req GuestRequest) error {
+	if strings.EqualFold(req.Email, req.GuestEmail) {
+		return gterr.New(gterr.InvalidArgument, "the host can't invite themselves")
+	}
+
+	err := s.storage.WithMeetupLock(ctx, req.EventID, func(ctx context.Context, tx Storage) error {
+		e, err := s.getHosted(ctx, tx, req.Email, req.EventID)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if !e.EndsAt.After(now) {
+			return gterr.New(gterr.FailedPrecondition, "the event is over")
+		}
+
+		_, err = tx.GetGuest(ctx, e.ID, req.GuestEmail)
+		if err == nil {
+			return gterr.New(gterr.AlreadyExists, fmt.Sprintf("%s is already invited", req.GuestEmail))
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			return gterr.New(gterr.Internal, "", err)
+		}
+
+		ok, err := friend.AreFriends(ctx, s.friends, req.Email, req.GuestEmail)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return gterr.New(gterr.FailedPrecondition, fmt.Sprintf("%s is not a friend of %s", req.GuestEmail, req.Email))
+		}
+
+		if err := s.saveGuest(ctx, tx, e.ID, &Guest{Email: req.GuestEmail, Status: Invited, UpdatedAt: now}); err != nil {
+			return err
+		}
+		return tx.AppendEvents(ctx, GuestInvited{EventID: e.ID, Host: e.Host, Email: req.GuestEmail})
+	})
+	return lockErr(err)
+}
+
+// Uninvite removes the guest from the event, the first on the waitlist goes instead if the guest was going.
+func (s *Service) Uninvite(ctx context.Context, req GuestRequest) error {
+	err := s.storage.WithMeetupLock(ctx, req.EventID, func(ctx context.Context, tx Storage) error {
+		e, err := s.getHosted(ctx, tx, req.Email, req.EventID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.GetGuest(ctx, e.ID, req.GuestEmail)
+		if errors.Is(err, storage.ErrNotFound) {
+			return gterr.New(gterr.NotFound, fmt.Sprintf("%s is not a guest", req.GuestEmail), err)
+		}
+		if err != nil {
+			return gterr.New(gterr.Internal, "", err)
+		}
+
+		if err := tx.DeleteGuest(ctx, e.ID, req.GuestEmail); err != nil {
+			return gterr.New(gterr.Internal, "", err)
+		}
+		return s.promote(ctx, tx, e, time.Now())
+	})
+	return lockErr(err)
+}
+
+// RSVP answers the event for req.Email, who must be invited or see the event.
+// A yes to a full event puts the user on the waitlist, the first on the waitlist goes when a guest stops going.
+func (s *Service) RSVP(ctx context.Context, req RSVPRequest) (*Guest, error) {
+	switch req.Response {
+	case Yes, No, Maybe:
+	default:
+		return nil, gterr.New(gterr.InvalidArgument, fmt.Sprintf("invalid response: %s", req.Response))
+	}
+
+	var res *Guest
+	err := s.storage.WithMeetupLock(ctx, req.EventID, func(ctx context.Context, tx Storage) error {
+		e, err := s.getVisible(ctx, tx, req.Email, req.EventID)
+		if err != nil {
+			return err
+		}
+
+		if strings.EqualFold(e.Host, req.Email) {
+			return gterr.New(gterr.InvalidArgument, "the host always attends")
+		}
+
+		now := time.Now()
+		if !e.EndsAt.After(now) {
+			return gterr.New(gterr.FailedPrecondition, "the event is over")
+		}
+
+		previous := e.RSVP
+		res = &Guest{Email: req.Email, Status: req.Response, UpdatedAt: now}
+		if req.Response == Yes {
+			switch {
+			case previous == Yes || previous == Waitlisted:
+				// Answering yes again keeps the spot or the place in the waitlist
+				res.Status = previous
+			case e.full():
+				res.Status = Waitlisted
+			}
+		}
+
+		if res.Status == previous {
+			g, err := tx.GetGuest(ctx, e.ID, req.Email)
+			if err != nil {
+				return gterr.New(gterr.Internal, "", err)
+			}
+			res = g
+			return nil
+		}
+
+		if err := s.saveGuest(ctx, tx, e.ID, res); err != nil {
+			return err
+		}
+
+		if previous == Yes {
+			return s.promote(ctx, tx, e, now)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, lockErr(err)
+	}
+	return res, nil
+}
+
+func (e *Event) full() bool {
+	return e.Capacity > 0 && e.Going >= e.Capacity
+}
+
+// promote lets the guests of the waitlist go, in the order they answered, while there are spots.
+func (s *Service) promote(ctx context.Context, tx Storage, e *Event, now time.Time) error {
+	guests, err := tx.ListGuests(ctx, e.ID)
+	if err != nil {
+		return gterr.New(gterr.Internal, "", fmt.Errorf("list guests: %v", err))
+	}
+
+	going := 0
+	for _, g := range guests {
+		if g.Status == Yes {
+			going++
+		}
+	}
+
+	var events []event.Event
+	for _, g := range guests {
+		if e.Capacity > 0 && going >= e.Capacity {
+			break
+		}
+		if g.Status != Waitlisted {
+			continue
+		}
+
+		g.Status = Yes
+		g.UpdatedAt = now
+		if err := s.saveGuest(ctx, tx, e.ID, g); err != nil {
+			return err
+		}
+		going++
+		events = append(events, GuestPromoted{EventID: e.ID, Host: e.Host, Email: g.Email})
+	}
+
+	if len(events) == 0 {
+		return nil
+	}
+	return tx.AppendEvents(ctx, events...)
+}
+
+func (s *Service) saveGuest(ctx context.Context, tx Storage, id int64, g *Guest) error {
+	err := tx.SaveGuest(ctx, id, g)
+	if errors.Is(err, storage.ErrInvalidArgument) {
+		return gterr.New(gterr.NotFound, "user not found", err)
+	}
+	if err != nil {
+		return gterr.New(gterr.Internal, "", err)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package notification
+
+import (
+	"fmt"
+	"html"
+
+	"github.com/victornm/gtonline/internal/mail"
+)
+
+// subjects are the email subjects of the notification types, %s is the actor.
+var subjects = map[Type]string{
+	FriendRequestCreated:  "%s sent you a friend request",
+	FriendRequestAccepted: "%s accepted your friend request",
+	FriendRequestRejected: "%s rejected your friend request",
+	FriendBirthday:        "Today is the birthday of %s",
+	EventInvited:          "%s invited you to an event",
+	EventPromoted:         "You got a spot at the event of %s",
+}
+
+// message returns the email of n.
+func (n Notification) message() mail.Message {
+	format, ok := subjects[n.Type]
+	if !ok {
+		format = "New notification from %s"
+	}
+	subject := fmt.Sprintf(format, n.Actor)
+
+	return mail.Message{
+		To:      n.Email,
+		Subject: subject,
+		Text:    subject + ".\n\nOpen GT Online to see it.\n",
+		HTML:    fmt.Sprintf("<p>%s.</p><p>Open GT Online to see it.</p>", html.EscapeString(subject)),
+	}
+}
@@ -0,0 +1,213 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/mail"
+	"github.com/victornm/gtonline/internal/realtime"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+type (
+	Service struct {
+		storage   Storage
+		publisher Publisher
+		mailer    mail.Mailer
+	}
+
+	// Publisher pushes the new notifications to the connected users.
+	Publisher interface {
+		Publish(ctx context.Context, email string, e realtime.Event) error
+	}
+
+	Storage interface {
+		// InsertNotification inserts n and sets its ID.
+		InsertNotification(ctx context.Context, n *Notification) error
+		// ListNotifications returns the notifications of req.Email, newest first.
+		ListNotifications(ctx context.Context, req ListRequest) ([]*Notification, error)
+		CountUnreadNotifications(ctx context.Context, email string) (int, error)
+		// MarkNotificationRead returns storage.ErrNotFound if email has no notification with the given id.
+		MarkNotificationRead(ctx context.Context, email string, id int64, at time.Time) error
+		MarkAllNotificationsRead(ctx context.Context, email string, at time.Time) error
+
+		// GetNotificationPreferences returns storage.ErrNotFound if email never changed its preferences.
+		GetNotificationPreferences(ctx context.Context, email string) (*Preferences, error)
+		// SaveNotificationPreferences inserts or replaces the preferences of p.Email.
+		SaveNotificationPreferences(ctx context.Context, p *Preferences) error
+	}
+)
+
+// NewService returns the service, the push or email channel is skipped if p or m is nil.
+func NewService(s Storage, p Publisher, m mail.Mailer) *Service {
+	return &Service{storage: s, publisher: p, mailer: m}
+}
+
+type Type string
+
+const (
+	FriendRequestCreated  Type = "friend_request.created"
+	FriendRequestAccepted Type = "friend_request.accepted"
+	FriendRequestRejected Type = "friend_request.rejected"
+	// FriendBirthday reminds the user that today is the birthday of Actor.
+	FriendBirthday Type = "friend.birthday"
+	// EventInvited tells the user that Actor invited them to an event.
+	EventInvited Type = "event.invited"
+	// EventPromoted tells the user on the waitlist of an event hosted by Actor that they got a spot.
+	EventPromoted Type = "event.promoted"
+
+	// MessageCreated pushes a new message of a conversation of the user, it is never stored in the inbox.
+	MessageCreated Type = "message.created"
+	// ProfileUpdated pushes the updated profile to its user, it is never stored in the inbox.
+	ProfileUpdated Type = "profile.updated"
+)
+
+const (
+	defaultLimit = 20
+	maxLimit     = 100
+)
+
+type (
+	Notification struct {
+		ID int64 `json:"id"`
+		// Email is the recipient of the notification.
+		Email string `json:"-"`
+		Type  Type   `json:"type"`
+		// Actor is the email of the user who triggered the notification, empty once their account is purged.
+		Actor     string    `json:"actor"`
+		Read      bool      `json:"read"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+
+	ListRequest struct {
+		Email      string `form:"-"`
+		UnreadOnly bool   `form:"unread"`
+		// Before is the cursor for pagination, only notifications with smaller ID are returned.
+		Before int64 `form:"before" binding:"gte=0"`
+		Limit  int   `form:"limit" binding:"gte=0,lte=100"`
+	}
+
+	ListResponse struct {
+		Notifications []*Notification `json:"notifications"`
+		// NextBefore is the cursor of the next page, 0 if there is no more notification.
+		NextBefore int64 `json:"next_before,omitempty"`
+	}
+
+	CountUnreadResponse struct {
+		Count int `json:"count"`
+	}
+)
+
+// Notify delivers n to n.Email through the channels enabled in the preferences of n.Email.
+// During the quiet hours, n only goes to the inbox, the push and email are dropped.
+func (s *Service) Notify(ctx context.Context, n Notification) error {
+	if n.CreatedAt.IsZero() {
+		n.CreatedAt = time.Now()
+	}
+
+	p, err := s.GetPreferences(ctx, n.Email)
+	if err != nil {
+		return err
+	}
+
+	if p.Enabled(n.Type, InApp) {
+		if err := s.storage.InsertNotification(ctx, &n); err != nil {
+			return gterr.New(gterr.Internal, "", fmt.Errorf("insert notification: %v", err))
+		}
+	}
+
+	if !p.QuietHours.Until(n.CreatedAt).IsZero() {
+		return nil
+	}
+
+	// The client will see the notification in the inbox on next load
+	if s.publisher != nil && p.Enabled(n.Type, Push) {
+		if err := s.publisher.Publish(ctx, n.Email, realtime.Event{Type: string(n.Type), Data: n}); err != nil {
+			log.Printf("publish notification %d: %v", n.ID, err)
+		}
+	}
+
+	if s.mailer != nil && p.Enabled(n.Type, Email) {
+		// A retry would insert the notification again, so a failed email is only logged
+		if err := s.mailer.Send(ctx, n.message()); err != nil {
+			log.Printf("email notification %d: %v", n.ID, err)
+		}
+	}
+	return nil
+}
+
+// Publish pushes e to the connected clients of email if the push channel of its type is enabled and
+// email is not in its quiet hours, otherwise e is dropped. The other services push their real-time
+// events through it, so the events follow the preferences of the user as the notifications do.
+func (s *Service) Publish(ctx context.Context, email string, e realtime.Event) error {
+	if s.publisher == nil {
+		return nil
+	}
+
+	p, err := s.GetPreferences(ctx, email)
+	if err != nil {
+		return err
+	}
+
+	if !p.Enabled(Type(e.Type), Push) || !p.QuietHours.Until(time.Now()).IsZero() {
+		return nil
+	}
+	return s.publisher.Publish(ctx, email, e)
+}
+
+func (s *Service) List(ctx context.Context, req ListRequest) (*ListResponse, error) {
+	if req.Limit <= 0 {
+		req.Limit = defaultLimit
+	}
+	if req.Limit > maxLimit {
+		req.Limit = maxLimit
+	}
+
+	// Query 1 more to know if there is a next page
+	limit := req.Limit
+	req.Limit++
+	notifications, err := s.storage.ListNotifications(ctx, req)
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+
+	res := &ListResponse{Notifications: notifications}
+	if len(notifications) > limit {
+		res.Notifications = notifications[:limit]
+		res.NextBefore = notifications[limit-1].ID
+	}
+
+	return res, nil
+}
+
+func (s *Service) CountUnread(ctx context.Context, email string) (*CountUnreadResponse, error) {
+	n, err := s.storage.CountUnreadNotifications(ctx, email)
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+
+	return &CountUnreadResponse{Count: n}, nil
+}
+
+func (s *Service) MarkRead(ctx context.Context, email string, id int64) error {
+	err := s.storage.MarkNotificationRead(ctx, email, id, time.Now())
+	if errors.Is(err, storage.ErrNotFound) {
+		return gterr.New(gterr.NotFound, fmt.Sprintf("notification %d not found", id), err)
+	}
+
+	if err != nil {
+		return gterr.New(gterr.Internal, "", err)
+	}
+	return nil
+}
+
+func (s *Service) MarkAllRead(ctx context.Context, email string) error {
+	if err := s.storage.MarkAllNotificationsRead(ctx, email, time.Now()); err != nil {
+		return gterr.New(gterr.Internal, "", err)
+	}
+	return nil
+}
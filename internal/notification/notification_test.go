@@ -0,0 +1,71 @@
+package notification_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/notification"
+	"github.com/victornm/gtonline/internal/storage/memory"
+)
+
+func TestService_Inbox(t *testing.T) {
+	s := notification.NewService(memory.NewStorage(), nil, nil)
+	ctx := context.TODO()
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, s.Notify(ctx, notification.Notification{
+			Email: "foo@mock.com",
+			Type:  notification.FriendRequestCreated,
+			Actor: "bar@mock.com",
+		}))
+	}
+	require.NoError(t, s.Notify(ctx, notification.Notification{
+		Email: "bar@mock.com",
+		Type:  notification.FriendRequestAccepted,
+		Actor: "foo@mock.com",
+	}))
+
+	t.Run("paginate newest first", func(t *testing.T) {
+		page1, err := s.List(ctx, notification.ListRequest{Email: "foo@mock.com", Limit: 3})
+		require.NoError(t, err)
+		require.Len(t, page1.Notifications, 3)
+		assert.Greater(t, page1.Notifications[0].ID, page1.Notifications[1].ID)
+		require.NotZero(t, page1.NextBefore)
+
+		page2, err := s.List(ctx, notification.ListRequest{Email: "foo@mock.com", Limit: 3, Before: page1.NextBefore})
+		require.NoError(t, err)
+		require.Len(t, page2.Notifications, 2)
+		assert.Zero(t, page2.NextBefore)
+	})
+
+	t.Run("mark read", func(t *testing.T) {
+		res, err := s.List(ctx, notification.ListRequest{Email: "foo@mock.com"})
+		require.NoError(t, err)
+
+		err = s.MarkRead(ctx, "bar@mock.com", res.Notifications[0].ID)
+		assert.Equal(t, gterr.NotFound, gterr.Code(err), "can't mark notification of another user")
+
+		require.NoError(t, s.MarkRead(ctx, "foo@mock.com", res.Notifications[0].ID))
+
+		count, err := s.CountUnread(ctx, "foo@mock.com")
+		require.NoError(t, err)
+		assert.Equal(t, 4, count.Count)
+
+		unread, err := s.List(ctx, notification.ListRequest{Email: "foo@mock.com", UnreadOnly: true})
+		require.NoError(t, err)
+		assert.Len(t, unread.Notifications, 4)
+
+		require.NoError(t, s.MarkAllRead(ctx, "foo@mock.com"))
+		count, err = s.CountUnread(ctx, "foo@mock.com")
+		require.NoError(t, err)
+		assert.Zero(t, count.Count)
+
+		count, err = s.CountUnread(ctx, "bar@mock.com")
+		require.NoError(t, err)
+		assert.Equal(t, 1, count.Count)
+	})
+}
@@ -0,0 +1,230 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	// The time zones of the quiet hours must load on hosts without the zoneinfo database
+	_ "time/tzdata"
+
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+type Channel string
+
+const (
+	// InApp is the inbox of the user.
+	InApp Channel = "in_app"
+	// Push is pushed to the connected clients of the user.
+	Push  Channel = "push"
+	Email Channel = "email"
+)
+
+// Channels are the delivery channels of the notifications.
+var Channels = []Channel{InApp, Push, Email}
+
+// Types are the notification types the user can configure.
+// There is no profile viewed type, the users can't view the profile of another user.
+var Types = []Type{
+	FriendRequestCreated, FriendRequestAccepted, FriendRequestRejected, FriendBirthday, EventInvited, EventPromoted,
+	MessageCreated, ProfileUpdated,
+}
+
+// pushOnly are the types only delivered through the Push channel.
+var pushOnly = map[Type]bool{MessageCreated: true, ProfileUpdated: true}
+
+const clockLayout = "15:04"
+
+type (
+	// Preferences are the notification settings of a user.
+	Preferences struct {
+		Email string `json:"-"`
+		// Events tells for each type whether it is delivered through each channel.
+		Events     map[Type]map[Channel]bool `json:"events"`
+		QuietHours QuietHours                `json:"quiet_hours"`
+	}
+
+	// QuietHours suppresses the push and email notifications between Start and End of every day,
+	// they are not delivered later. The notifications still go to the inbox.
+	QuietHours struct {
+		Enabled bool `json:"enabled"`
+		// Start and End are the wall clock times HH:MM in TimeZone, End is on the next day if it is before Start.
+		Start    string `json:"start"`
+		End      string `json:"end"`
+		TimeZone string `json:"time_zone"`
+	}
+
+	// UpdatePreferencesRequest updates the given cells of the matrix, the other cells are kept.
+	UpdatePreferencesRequest struct {
+		Email      string                    `json:"-"`
+		Events     map[Type]map[Channel]bool `json:"events"`
+		QuietHours *QuietHours               `json:"quiet_hours"`
+	}
+)
+
+// DefaultPreferences delivers every type in the app and by push, but not by email. The push only types are pushed.
+func DefaultPreferences(email string) *Preferences {
+	p := &Preferences{
+		Email:      email,
+		Events:     make(map[Type]map[Channel]bool, len(Types)),
+		QuietHours: QuietHours{Start: "22:00", End: "07:00", TimeZone: "UTC"},
+	}
+	for _, t := range Types {
+		if pushOnly[t] {
+			p.Events[t] = map[Channel]bool{Push: true}
+			continue
+		}
+		p.Events[t] = map[Channel]bool{InApp: true, Push: true, Email: false}
+	}
+	return p
+}
+
+// Enabled reports whether the notifications of type t are delivered through c.
+func (p *Preferences) Enabled(t Type, c Channel) bool {
+	enabled, ok := p.Events[t][c]
+	if !ok {
+		return DefaultPreferences(p.Email).Events[t][c]
+	}
+	return enabled
+}
+
+// Until returns the end of the quiet hours if t is within them, otherwise the zero time.
+func (q QuietHours) Until(t time.Time) time.Time {
+	if !q.Enabled {
+		return time.Time{}
+	}
+
+	loc, err := time.LoadLocation(q.TimeZone)
+	if err != nil {
+		return time.Time{}
+	}
+	start, err1 := time.Parse(clockLayout, q.Start)
+	end, err2 := time.Parse(clockLayout, q.End)
+	if err1 != nil || err2 != nil {
+		return time.Time{}
+	}
+
+	local := t.In(loc)
+	at := func(day int, clock time.Time) time.Time {
+		return time.Date(local.Year(), local.Month(), day, clock.Hour(), clock.Minute(), 0, 0, loc)
+	}
+
+	// The quiet hours which may contain t started today or, if they cross midnight, yesterday
+	for _, day := range []int{local.Day(), local.Day() - 1} {
+		from, to := at(day, start), at(day, end)
+		if !to.After(from) {
+			to = at(day+1, end)
+		}
+		if !t.Before(from) && t.Before(to) {
+			return to
+		}
+	}
+	return time.Time{}
+}
+
+func (q QuietHours) validate() error {
+	if _, err := time.Parse(clockLayout, q.Start); err != nil {
+		return fmt.Errorf("invalid start %q, expect HH:MM", q.Start)
+	}
+	if _, err := time.Parse(clockLayout, q.End); err != nil {
+		return fmt.Errorf("invalid end %q, expect HH:MM", q.End)
+	}
+	if q.Start == q.End {
+		return fmt.Errorf("start and end must be different")
+	}
+	if _, err := time.LoadLocation(q.TimeZone); err != nil || q.TimeZone == "" {
+		return fmt.Errorf("invalid time zone %q", q.TimeZone)
+	}
+	return nil
+}
+
+func (s *Service) GetPreferences(ctx context.Context, email string) (*Preferences, error) {
+	p, err := s.storage.GetNotificationPreferences(ctx, email)
+	if errors.Is(err, storage.ErrNotFound) {
+		return DefaultPreferences(email), nil
+	}
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+
+	// Fill the types added after the preferences were saved
+	d := DefaultPreferences(email)
+	for t, channels := range d.Events {
+		for c, enabled := range channels {
+			if _, ok := p.Events[t][c]; !ok {
+				if p.Events[t] == nil {
+					p.Events[t] = make(map[Channel]bool, len(Channels))
+				}
+				p.Events[t][c] = enabled
+			}
+		}
+	}
+	return p, nil
+}
+
+func (s *Service) UpdatePreferences(ctx context.Context, req UpdatePreferencesRequest) (*Preferences, error) {
+	p, err := s.GetPreferences(ctx, req.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	for t, channels := range req.Events {
+		if !isType(t) {
+			return nil, gterr.New(gterr.InvalidArgument, fmt.Sprintf("unknown notification type: %s", t))
+		}
+		for c, enabled := range channels {
+			if !isChannel(c) {
+				return nil, gterr.New(gterr.InvalidArgument, fmt.Sprintf("unknown channel: %s", c))
+			}
+			if pushOnly[t] && c != Push {
+				return nil, gterr.New(gterr.InvalidArgument, fmt.Sprintf("%s is only delivered by %s", t, Push))
+			}
+			p.Events[t][c] = enabled
+		}
+	}
+
+	if req.QuietHours != nil {
+		if err := req.QuietHours.validate(); err != nil {
+			return nil, gterr.New(gterr.InvalidArgument, err.Error(), err)
+		}
+		p.QuietHours = *req.QuietHours
+	}
+
+	err = s.storage.SaveNotificationPreferences(ctx, p)
+	if errors.Is(err, storage.ErrInvalidArgument) {
+		return nil, gterr.New(gterr.NotFound, "user not found", err)
+	}
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+	return p, nil
+}
+
+// QuietUntil returns the end of the quiet hours of email if they contain t, otherwise the zero time.
+func (s *Service) QuietUntil(ctx context.Context, email string, t time.Time) (time.Time, error) {
+	p, err := s.GetPreferences(ctx, email)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return p.QuietHours.Until(t), nil
+}
+
+func isType(t Type) bool {
+	for _, known := range Types {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+func isChannel(c Channel) bool {
+	for _, known := range Channels {
+		if c == known {
+			return true
+		}
+	}
+	return false
+}
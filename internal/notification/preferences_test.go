@@ -0,0 +1,182 @@
+package notification_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/mail"
+	"github.com/victornm/gtonline/internal/notification"
+	"github.com/victornm/gtonline/internal/realtime"
+	"github.com/victornm/gtonline/internal/storage/memory"
+)
+
+type publisher struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (p *publisher) Publish(_ context.Context, email string, e realtime.Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, email+" "+e.Type)
+	return nil
+}
+
+func TestService_UpdatePreferences(t *testing.T) {
+	mock := memory.NewStorage()
+	mock.InsertUsers([]memory.User{{Email: "foo@mock.com"}})
+	s := notification.NewService(mock, nil, nil)
+	ctx := context.TODO()
+
+	p, err := s.GetPreferences(ctx, "foo@mock.com")
+	require.NoError(t, err)
+	assert.True(t, p.Enabled(notification.FriendRequestCreated, notification.InApp))
+	assert.False(t, p.Enabled(notification.FriendRequestCreated, notification.Email))
+
+	for _, req := range []notification.UpdatePreferencesRequest{
+		{Events: map[notification.Type]map[notification.Channel]bool{"profile.liked": {notification.Push: true}}},
+		{Events: map[notification.Type]map[notification.Channel]bool{notification.FriendRequestCreated: {"sms": true}}},
+		{QuietHours: &notification.QuietHours{Start: "25:00", End: "07:00", TimeZone: "UTC"}},
+		{QuietHours: &notification.QuietHours{Start: "22:00", End: "22:00", TimeZone: "UTC"}},
+		{QuietHours: &notification.QuietHours{Start: "22:00", End: "07:00", TimeZone: "Mars/Olympus"}},
+	} {
+		req.Email = "foo@mock.com"
+		_, err := s.UpdatePreferences(ctx, req)
+		assert.Equal(t, gterr.InvalidArgument, gterr.Code(err), req)
+	}
+
+	_, err = s.UpdatePreferences(ctx, notification.UpdatePreferencesRequest{Email: "tony@stark.com"})
+	assert.Equal(t, gterr.NotFound, gterr.Code(err))
+
+	_, err = s.UpdatePreferences(ctx, notification.UpdatePreferencesRequest{
+		Email: "foo@mock.com",
+		Events: map[notification.Type]map[notification.Channel]bool{
+			notification.FriendRequestCreated: {notification.Email: true},
+		},
+	})
+	require.NoError(t, err)
+
+	p, err = s.GetPreferences(ctx, "foo@mock.com")
+	require.NoError(t, err)
+	assert.True(t, p.Enabled(notification.FriendRequestCreated, notification.Email))
+	assert.True(t, p.Enabled(notification.FriendRequestCreated, notification.Push), "the other cells are kept")
+}
+
+func TestService_Notify_Preferences(t *testing.T) {
+	mock := memory.NewStorage()
+	mock.InsertUsers([]memory.User{{Email: "foo@mock.com"}})
+	pub := &publisher{}
+	mailer := mail.NewFileMailer(t.TempDir(), "no-reply@gtonline.local")
+	s := notification.NewService(mock, pub, mailer)
+	ctx := context.TODO()
+
+	_, err := s.UpdatePreferences(ctx, notification.UpdatePreferencesRequest{
+		Email: "foo@mock.com",
+		Events: map[notification.Type]map[notification.Channel]bool{
+			notification.FriendRequestCreated:  {notification.Email: true},
+			notification.FriendRequestRejected: {notification.InApp: false, notification.Push: false},
+		},
+		QuietHours: &notification.QuietHours{Enabled: true, Start: "22:00", End: "07:00", TimeZone: "UTC"},
+	})
+	require.NoError(t, err)
+
+	day := time.Date(2021, 8, 2, 12, 0, 0, 0, time.UTC)
+	night := time.Date(2021, 8, 2, 23, 0, 0, 0, time.UTC)
+	for _, n := range []notification.Notification{
+		{Type: notification.FriendRequestCreated, CreatedAt: day},
+		{Type: notification.FriendRequestRejected, CreatedAt: day},
+		{Type: notification.FriendRequestAccepted, CreatedAt: night},
+	} {
+		n.Email, n.Actor = "foo@mock.com", "bar@mock.com"
+		require.NoError(t, s.Notify(ctx, n))
+	}
+
+	inbox, err := s.List(ctx, notification.ListRequest{Email: "foo@mock.com"})
+	require.NoError(t, err)
+	var types []notification.Type
+	for _, n := range inbox.Notifications {
+		types = append(types, n.Type)
+	}
+	assert.Equal(t, []notification.Type{notification.FriendRequestAccepted, notification.FriendRequestCreated}, types)
+
+	assert.Equal(t, []string{"foo@mock.com friend_request.created"}, pub.events, "nothing is pushed in the quiet hours")
+
+	files, err := mailer.Files()
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestService_Publish(t *testing.T) {
+	mock := memory.NewStorage()
+	mock.InsertUsers([]memory.User{{Email: "foo@mock.com"}, {Email: "bar@mock.com"}})
+	pub := &publisher{}
+	s := notification.NewService(mock, pub, nil)
+	ctx := context.TODO()
+
+	_, err := s.UpdatePreferences(ctx, notification.UpdatePreferencesRequest{
+		Email: "foo@mock.com",
+		Events: map[notification.Type]map[notification.Channel]bool{
+			notification.MessageCreated: {notification.InApp: true},
+		},
+	})
+	assert.Equal(t, gterr.InvalidArgument, gterr.Code(err), "a message is never in the inbox")
+
+	_, err = s.UpdatePreferences(ctx, notification.UpdatePreferencesRequest{
+		Email: "foo@mock.com",
+		Events: map[notification.Type]map[notification.Channel]bool{
+			notification.ProfileUpdated: {notification.Push: false},
+		},
+	})
+	require.NoError(t, err)
+
+	// The quiet hours of bar are now
+	now := time.Now().UTC()
+	_, err = s.UpdatePreferences(ctx, notification.UpdatePreferencesRequest{
+		Email: "bar@mock.com",
+		QuietHours: &notification.QuietHours{
+			Enabled:  true,
+			Start:    now.Add(-time.Hour).Format("15:04"),
+			End:      now.Add(time.Hour).Format("15:04"),
+			TimeZone: "UTC",
+		},
+	})
+	require.NoError(t, err)
+
+	for _, email := range []string{"foo@mock.com", "bar@mock.com"} {
+		for _, typ := range []notification.Type{notification.MessageCreated, notification.ProfileUpdated} {
+			require.NoError(t, s.Publish(ctx, email, realtime.Event{Type: string(typ)}))
+		}
+	}
+	assert.Equal(t, []string{"foo@mock.com message.created"}, pub.events)
+}
+
+func TestQuietHours_Until(t *testing.T) {
+	q := notification.QuietHours{Enabled: true, Start: "22:00", End: "07:00", TimeZone: "America/New_York"}
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want time.Time
+	}{
+		{name: "before", at: time.Date(2021, 8, 2, 21, 59, 0, 0, time.UTC).Add(4 * time.Hour)},
+		{name: "evening", at: time.Date(2021, 8, 2, 23, 0, 0, 0, time.UTC).Add(4 * time.Hour), want: time.Date(2021, 8, 3, 7, 0, 0, 0, time.UTC).Add(4 * time.Hour)},
+		{name: "after midnight", at: time.Date(2021, 8, 3, 6, 59, 0, 0, time.UTC).Add(4 * time.Hour), want: time.Date(2021, 8, 3, 7, 0, 0, 0, time.UTC).Add(4 * time.Hour)},
+		{name: "end", at: time.Date(2021, 8, 3, 7, 0, 0, 0, time.UTC).Add(4 * time.Hour)},
+		// The clocks go back 1 hour on the night of 2021-11-07
+		{name: "daylight saving", at: time.Date(2021, 11, 6, 23, 0, 0, 0, time.UTC).Add(4 * time.Hour), want: time.Date(2021, 11, 7, 7, 0, 0, 0, time.UTC).Add(5 * time.Hour)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.True(t, test.want.Equal(q.Until(test.at)), "got %v", q.Until(test.at))
+		})
+	}
+
+	q.Enabled = false
+	assert.True(t, q.Until(time.Date(2021, 8, 2, 23, 0, 0, 0, time.UTC).Add(4*time.Hour)).IsZero())
+}
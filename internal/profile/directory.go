@@ -0,0 +1,151 @@
+package profile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+type (
+	// Person is a user listed in the directories.
+	Person struct {
+		Email     string `json:"email"`
+		FirstName string `json:"first_name"`
+		LastName  string `json:"last_name"`
+	}
+
+	// Alumnus is a person who attended a school, YearGraduated is 0 if unknown.
+	Alumnus struct {
+		Person
+		YearGraduated int
+	}
+
+	// Coworker is a person currently employed by an employer.
+	Coworker struct {
+		Person
+		JobTitle string
+	}
+
+	DirectorySettings struct {
+		// Listed tells whether the user is listed in the alumni and coworker directories.
+		Listed bool `json:"listed"`
+	}
+
+	UpdateDirectorySettingsRequest struct {
+		Email  string `json:"-"`
+		Listed *bool  `json:"listed" binding:"required"`
+	}
+
+	ListAlumniRequest struct {
+		School string `form:"-"`
+		// YearFrom and YearTo bound the graduation year, the alumni with an unknown year are left out when given.
+		YearFrom int `form:"year_from"`
+		YearTo   int `form:"year_to"`
+	}
+
+	ListAlumniResponse struct {
+		School  string    `json:"school"`
+		Cohorts []*Cohort `json:"cohorts"`
+	}
+
+	// Cohort is the alumni who graduated the same year, Year is 0 for an unknown year.
+	Cohort struct {
+		Year   int       `json:"year,omitempty"`
+		People []*Person `json:"people"`
+	}
+
+	ListPeopleRequest struct {
+		Employer string `form:"-"`
+	}
+
+	ListPeopleResponse struct {
+		Employer  string      `json:"employer"`
+		JobTitles []*JobTitle `json:"job_titles"`
+	}
+
+	JobTitle struct {
+		JobTitle string    `json:"job_title"`
+		People   []*Person `json:"people"`
+	}
+)
+
+func (s *Service) GetDirectorySettings(ctx context.Context, email string) (*DirectorySettings, error) {
+	listed, err := s.storage.GetDirectoryListing(ctx, email)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, gterr.New(gterr.NotFound, "user not found", err)
+	}
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+	return &DirectorySettings{Listed: listed}, nil
+}
+
+func (s *Service) UpdateDirectorySettings(ctx context.Context, req UpdateDirectorySettingsRequest) (*DirectorySettings, error) {
+	if req.Listed == nil {
+		return nil, gterr.New(gterr.InvalidArgument, "listed is required")
+	}
+
+	err := s.storage.UpdateDirectoryListing(ctx, req.Email, *req.Listed)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, gterr.New(gterr.NotFound, "user not found", err)
+	}
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+	return &DirectorySettings{Listed: *req.Listed}, nil
+}
+
+// ListAlumni returns the listed alumni of the school by cohort, the latest first and the unknown year last.
+func (s *Service) ListAlumni(ctx context.Context, req ListAlumniRequest) (*ListAlumniResponse, error) {
+	if req.YearFrom < 0 || req.YearTo < 0 {
+		return nil, gterr.New(gterr.InvalidArgument, "negative year")
+	}
+	if req.YearTo != 0 && req.YearFrom > req.YearTo {
+		return nil, gterr.New(gterr.InvalidArgument, "year_from after year_to")
+	}
+
+	alumni, err := s.storage.ListAlumni(ctx, req)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, gterr.New(gterr.NotFound, fmt.Sprintf("school %s not found", req.School), err)
+	}
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", fmt.Errorf("list alumni: %v", err))
+	}
+
+	res := &ListAlumniResponse{School: req.School, Cohorts: []*Cohort{}}
+	for _, a := range alumni {
+		if n := len(res.Cohorts); n == 0 || res.Cohorts[n-1].Year != a.YearGraduated {
+			res.Cohorts = append(res.Cohorts, &Cohort{Year: a.YearGraduated})
+		}
+		c := res.Cohorts[len(res.Cohorts)-1]
+		p := a.Person
+		c.People = append(c.People, &p)
+	}
+	return res, nil
+}
+
+// ListPeople returns the listed current employees of the employer by job title.
+func (s *Service) ListPeople(ctx context.Context, req ListPeopleRequest) (*ListPeopleResponse, error) {
+	coworkers, err := s.storage.ListCoworkers(ctx, req.Employer)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, gterr.New(gterr.NotFound, fmt.Sprintf("employer %s not found", req.Employer), err)
+	}
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", fmt.Errorf("list coworkers: %v", err))
+	}
+
+	res := &ListPeopleResponse{Employer: req.Employer, JobTitles: []*JobTitle{}}
+	for _, c := range coworkers {
+		if n := len(res.JobTitles); n == 0 || !strings.EqualFold(res.JobTitles[n-1].JobTitle, c.JobTitle) {
+			res.JobTitles = append(res.JobTitles, &JobTitle{JobTitle: c.JobTitle})
+		}
+		t := res.JobTitles[len(res.JobTitles)-1]
+		p := c.Person
+		t.People = append(t.People, &p)
+	}
+	return res, nil
+}
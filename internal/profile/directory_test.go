@@ -0,0 +1,145 @@
+package profile_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/profile"
+	"github.com/victornm/gtonline/internal/storage/memory"
+)
+
+func makeDirectory(t *testing.T) *profile.Service {
+	t.Helper()
+
+	mock := memory.NewStorage()
+	mock.InsertSchools(profile.School{SchoolName: "GT", Type: "University"})
+	mock.InsertEmployers(profile.Employer{EmployerName: "Acme"})
+	mock.InsertUsers([]memory.User{
+		{
+			Email: "ann@mock.com", FirstName: "Ann", LastName: "Lee",
+			Education: []profile.Attend{{School: "GT", YearGraduated: 2010}},
+			Professional: []profile.Employment{
+				{Employer: "Acme", JobTitle: "Intern", StartDate: date(2008, 6, 1), EndDate: date(2008, 9, 1)},
+				{Employer: "Acme", JobTitle: "Engineer", StartDate: date(2010, 9, 1), IsCurrent: true},
+			},
+		},
+		{
+			Email: "bob@mock.com", FirstName: "Bob", LastName: "Kim",
+			Education:    []profile.Attend{{School: "GT", YearGraduated: 2012}},
+			Professional: []profile.Employment{{Employer: "Acme", JobTitle: "Manager"}},
+		},
+		{
+			Email: "cat@mock.com", FirstName: "Cat", LastName: "Ng",
+			Education:    []profile.Attend{{School: "GT", YearGraduated: 2008}, {School: "GT", YearGraduated: 2010}},
+			Professional: []profile.Employment{{Employer: "Acme", JobTitle: "Engineer"}},
+		},
+		{
+			Email: "dan@mock.com", FirstName: "Dan", LastName: "Ho",
+			Education:    []profile.Attend{{School: "GT", YearGraduated: 2010}},
+			Professional: []profile.Employment{{Employer: "Acme", JobTitle: "Engineer"}},
+		},
+		{
+			Email: "eve@mock.com", FirstName: "Eve", LastName: "Yu",
+			Education:    []profile.Attend{{School: "GT"}},
+			Professional: []profile.Employment{{Employer: "Acme", JobTitle: "Engineer", EndDate: date(2015, 1, 1)}},
+		},
+	})
+
+	s := profile.NewService(mock, profile.DefaultConfig())
+	// dan@mock.com doesn't opt in
+	for _, email := range []string{"ann@mock.com", "bob@mock.com", "cat@mock.com", "eve@mock.com"} {
+		listed := true
+		_, err := s.UpdateDirectorySettings(context.TODO(), profile.UpdateDirectorySettingsRequest{Email: email, Listed: &listed})
+		require.NoError(t, err)
+	}
+	return s
+}
+
+func emails(people []*profile.Person) []string {
+	var res []string
+	for _, p := range people {
+		res = append(res, p.Email)
+	}
+	return res
+}
+
+func TestService_DirectorySettings(t *testing.T) {
+	s := makeDirectory(t)
+	ctx := context.TODO()
+
+	got, err := s.GetDirectorySettings(ctx, "dan@mock.com")
+	require.NoError(t, err)
+	assert.False(t, got.Listed, "not listed by default")
+
+	got, err = s.GetDirectorySettings(ctx, "ann@mock.com")
+	require.NoError(t, err)
+	assert.True(t, got.Listed)
+
+	_, err = s.UpdateDirectorySettings(ctx, profile.UpdateDirectorySettingsRequest{Email: "ann@mock.com"})
+	assert.Equal(t, gterr.InvalidArgument, gterr.Code(err))
+
+	listed := true
+	_, err = s.UpdateDirectorySettings(ctx, profile.UpdateDirectorySettingsRequest{Email: "nobody@mock.com", Listed: &listed})
+	assert.Equal(t, gterr.NotFound, gterr.Code(err))
+}
+
+func TestService_ListAlumni(t *testing.T) {
+	s := makeDirectory(t)
+	ctx := context.TODO()
+
+	res, err := s.ListAlumni(ctx, profile.ListAlumniRequest{School: "GT"})
+	require.NoError(t, err)
+	require.Len(t, res.Cohorts, 3)
+	assert.Equal(t, 2012, res.Cohorts[0].Year, "the latest first")
+	assert.Equal(t, []string{"bob@mock.com"}, emails(res.Cohorts[0].People))
+	assert.Equal(t, 2010, res.Cohorts[1].Year)
+	assert.Equal(t, []string{"ann@mock.com", "cat@mock.com"}, emails(res.Cohorts[1].People), "ordered by last name, once each")
+	assert.Equal(t, 0, res.Cohorts[2].Year, "the unknown year last")
+	assert.Equal(t, []string{"eve@mock.com"}, emails(res.Cohorts[2].People))
+
+	res, err = s.ListAlumni(ctx, profile.ListAlumniRequest{School: "GT", YearFrom: 2011})
+	require.NoError(t, err)
+	require.Len(t, res.Cohorts, 1)
+	assert.Equal(t, 2012, res.Cohorts[0].Year)
+
+	tests := []struct {
+		name string
+		req  profile.ListAlumniRequest
+		code gterr.ErrorCode
+	}{
+		{name: "unknown school", req: profile.ListAlumniRequest{School: "MIT"}, code: gterr.NotFound},
+		{name: "reversed years", req: profile.ListAlumniRequest{School: "GT", YearFrom: 2012, YearTo: 2010}, code: gterr.InvalidArgument},
+		{name: "negative year", req: profile.ListAlumniRequest{School: "GT", YearTo: -1}, code: gterr.InvalidArgument},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := s.ListAlumni(ctx, test.req)
+			assert.Equal(t, test.code, gterr.Code(err))
+		})
+	}
+}
+
+func TestService_ListPeople(t *testing.T) {
+	s := makeDirectory(t)
+	ctx := context.TODO()
+
+	res, err := s.ListPeople(ctx, profile.ListPeopleRequest{Employer: "Acme"})
+	require.NoError(t, err)
+	require.Len(t, res.JobTitles, 2)
+	assert.Equal(t, "Engineer", res.JobTitles[0].JobTitle)
+	assert.Equal(t, []string{"ann@mock.com", "cat@mock.com"}, emails(res.JobTitles[0].People), "the current employees only, once each")
+	assert.Equal(t, "Manager", res.JobTitles[1].JobTitle)
+	assert.Equal(t, []string{"bob@mock.com"}, emails(res.JobTitles[1].People))
+
+	_, err = s.ListPeople(ctx, profile.ListPeopleRequest{Employer: "Initech"})
+	assert.Equal(t, gterr.NotFound, gterr.Code(err))
+
+	employers, err := s.ListEmployers(ctx)
+	require.NoError(t, err)
+	require.Len(t, employers.Employers, 1)
+	assert.Equal(t, 3, employers.Employers[0].People, "the listed current employees only")
+}
@@ -0,0 +1,203 @@
+package profile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+const (
+	maxInterestLen = 50
+
+	defaultInterestLimit = 10
+	maxInterestLimit     = 50
+)
+
+type (
+	// InterestTag is a canonical interest of the catalog. The interests of the profiles are the names of the tags.
+	InterestTag struct {
+		// Slug identifies the tag, see Slug.
+		Slug string `json:"slug"`
+		Name string `json:"name"`
+		// Aliases are the slugs of the other spellings of the tag, like the merged tags.
+		Aliases []string `json:"aliases,omitempty"`
+		// Users is the number of users having the interest.
+		Users int `json:"users"`
+	}
+
+	ListInterestsRequest struct {
+		Prefix string `form:"prefix"`
+		Limit  int    `form:"limit"`
+	}
+
+	ListInterestsResponse struct {
+		Interests []*InterestTag `json:"interests"`
+	}
+
+	MergeInterestsRequest struct {
+		Slug string `json:"-"`
+		// Into is the slug of the tag which remains.
+		Into string `json:"into" binding:"required"`
+		// Actor is the email of the admin merging the tags.
+		Actor string `json:"-"`
+	}
+)
+
+// Slug normalizes an interest: lower case letters and digits separated by single dashes, so "Golf", "golf " and
+// "GOLF!" are the same interest.
+func Slug(interest string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(interest) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+	return b.String()
+}
+
+// ListInterests returns the interests of the catalog starting with req.Prefix for autocompletion, the most popular first.
+func (s *Service) ListInterests(ctx context.Context, req ListInterestsRequest) (*ListInterestsResponse, error) {
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultInterestLimit
+	}
+	if limit > maxInterestLimit {
+		limit = maxInterestLimit
+	}
+
+	tags, err := s.storage.ListInterestTags(ctx, Slug(req.Prefix), limit)
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", fmt.Errorf("list interest tags: %v", err))
+	}
+	if tags == nil {
+		tags = []*InterestTag{}
+	}
+	return &ListInterestsResponse{Interests: tags}, nil
+}
+
+// MergeInterests merges the tag req.Slug into the tag req.Into, the users interested in the former become interested
+// in the latter, and its spellings are mapped to the latter from now on.
+func (s *Service) MergeInterests(ctx context.Context, req MergeInterestsRequest) (*InterestTag, error) {
+	from, into := Slug(req.Slug), Slug(req.Into)
+	if from == into {
+		return nil, gterr.New(gterr.InvalidArgument, "can not merge an interest into itself")
+	}
+
+	v := &ProfileVersion{Actor: req.Actor, CreatedAt: time.Now()}
+	err := s.storage.MergeInterestTags(ctx, from, into, v)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, gterr.New(gterr.NotFound, "interest not found", err)
+	}
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", fmt.Errorf("merge interest tags: %v", err))
+	}
+
+	tags, err := s.storage.FindInterestTags(ctx, []string{into})
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", fmt.Errorf("find interest tags: %v", err))
+	}
+	for _, t := range tags {
+		if t.Slug == into {
+			return t, nil
+		}
+	}
+	return nil, gterr.New(gterr.NotFound, "interest not found")
+}
+
+// canonicalInterests maps the interests onto the names of their tags, adding the unknown ones to the catalog unless
+// the interests are fixed. The interests which are the same tag are kept once.
+func (s *Service) canonicalInterests(ctx context.Context, interests []string) ([]string, error) {
+	if len(interests) == 0 {
+		return interests, nil
+	}
+
+	slugs := make([]string, 0, len(interests))
+	for _, i := range interests {
+		slug := Slug(i)
+		if slug == "" {
+			return nil, gterr.New(gterr.InvalidArgument, fmt.Sprintf("invalid interest value: %q", i))
+		}
+		if utf8.RuneCountInString(strings.Join(strings.Fields(i), " ")) > maxInterestLen {
+			return nil, gterr.New(gterr.InvalidArgument, fmt.Sprintf("interest longer than %d characters: %q", maxInterestLen, i))
+		}
+		slugs = append(slugs, slug)
+	}
+
+	tags, err := s.findInterestTags(ctx, slugs)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]string, 0, len(interests))
+	seen := make(map[string]bool, len(interests))
+	for i, slug := range slugs {
+		t, ok := tags[slug]
+		if !ok {
+			if t, err = s.addInterestTag(ctx, slug, interests[i]); err != nil {
+				return nil, err
+			}
+			tags[slug] = t
+		}
+
+		if !seen[t.Slug] {
+			seen[t.Slug] = true
+			res = append(res, t.Name)
+		}
+	}
+	return res, nil
+}
+
+// findInterestTags returns the tags keyed by the given slugs they match.
+func (s *Service) findInterestTags(ctx context.Context, slugs []string) (map[string]*InterestTag, error) {
+	tags, err := s.storage.FindInterestTags(ctx, slugs)
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", fmt.Errorf("find interest tags: %v", err))
+	}
+
+	res := make(map[string]*InterestTag, len(slugs))
+	for _, t := range tags {
+		res[t.Slug] = t
+		for _, a := range t.Aliases {
+			res[a] = t
+		}
+	}
+	return res, nil
+}
+
+func (s *Service) addInterestTag(ctx context.Context, slug, interest string) (*InterestTag, error) {
+	if s.cfg.FixedInterests {
+		return nil, gterr.New(gterr.InvalidArgument, fmt.Sprintf("unknown interest: %q", interest))
+	}
+
+	t := &InterestTag{Slug: slug, Name: strings.Join(strings.Fields(interest), " ")}
+	err := s.storage.InsertInterestTag(ctx, t)
+	if errors.Is(err, storage.ErrAlreadyExist) {
+		// Added concurrently, or a tag has the same name under another slug
+		tags, err := s.findInterestTags(ctx, []string{slug})
+		if err != nil {
+			return nil, err
+		}
+		if t, ok := tags[slug]; ok {
+			return t, nil
+		}
+		return nil, gterr.New(gterr.AlreadyExists, fmt.Sprintf("interest %q conflicts with the catalog", interest))
+	}
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", fmt.Errorf("insert interest tag: %v", err))
+	}
+	return t, nil
+}
@@ -0,0 +1,182 @@
+package profile_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/profile"
+	"github.com/victornm/gtonline/internal/storage/memory"
+)
+
+var users = []string{"foo@mock.com", "bar@mock.com", "baz@mock.com"}
+
+func makeService(t *testing.T, cfg profile.Config) (*profile.Service, *memory.Storage) {
+	t.Helper()
+
+	mock := memory.NewStorage()
+	for _, email := range users {
+		mock.InsertUsers([]memory.User{{Email: email}})
+	}
+	return profile.NewService(mock, cfg), mock
+}
+
+func updateInterests(t *testing.T, s *profile.Service, email string, interests ...string) []string {
+	t.Helper()
+
+	p, err := s.UpdateProfile(context.TODO(), profile.UpdateProfileRequest{Email: email, Interests: interests})
+	require.NoError(t, err)
+	return p.Interests
+}
+
+func TestSlug(t *testing.T) {
+	tests := map[string]string{
+		"Golf":              "golf",
+		"  golf ":           "golf",
+		"Board  Games":      "board-games",
+		"rock & roll!":      "rock-roll",
+		"Café au lait":      "café-au-lait",
+		"C++":               "c",
+		"--":                "",
+		"Formula 1 racing.": "formula-1-racing",
+	}
+	for in, want := range tests {
+		assert.Equal(t, want, profile.Slug(in), in)
+	}
+}
+
+func TestService_UpdateProfile_Interests(t *testing.T) {
+	s, mock := makeService(t, profile.Config{})
+	ctx := context.TODO()
+
+	got := updateInterests(t, s, users[0], "Board  games", "golf ")
+	assert.Equal(t, []string{"Board games", "golf"}, got, "the first spelling names the tag")
+
+	got = updateInterests(t, s, users[1], "GOLF", "board-games", "Golf!")
+	assert.Equal(t, []string{"golf", "Board games"}, got, "the same tags are kept once")
+
+	_, err := s.UpdateProfile(ctx, profile.UpdateProfileRequest{Email: users[2], Interests: []string{"golf", "!!"}})
+	assert.Equal(t, gterr.InvalidArgument, gterr.Code(err))
+
+	res, err := s.ListInterests(ctx, profile.ListInterestsRequest{})
+	require.NoError(t, err)
+	assert.Len(t, res.Interests, 2)
+
+	assert.Len(t, mock.Events(), 2, "profile.updated of the successful updates")
+}
+
+func TestService_UpdateProfile_InvalidKeepsTags(t *testing.T) {
+	s, _ := makeService(t, profile.Config{})
+	ctx := context.TODO()
+
+	_, err := s.UpdateProfile(ctx, profile.UpdateProfileRequest{
+		Email:     users[0],
+		Interests: []string{"golf"},
+		Education: []profile.Attend{{School: ""}},
+	})
+	assert.Equal(t, gterr.InvalidArgument, gterr.Code(err))
+
+	_, err = s.UpdateProfile(ctx, profile.UpdateProfileRequest{Email: "unknown@mock.com", Interests: []string{"tennis"}})
+	assert.Equal(t, gterr.NotFound, gterr.Code(err))
+
+	res, err := s.ListInterests(ctx, profile.ListInterestsRequest{})
+	require.NoError(t, err)
+	assert.Empty(t, res.Interests, "the failed updates create no tag")
+}
+
+func TestService_UpdateProfile_FixedInterests(t *testing.T) {
+	s, mock := makeService(t, profile.Config{FixedInterests: true})
+	ctx := context.TODO()
+
+	require.NoError(t, mock.InsertInterestTag(ctx, &profile.InterestTag{Slug: "golf", Name: "Golf"}))
+
+	got := updateInterests(t, s, users[0], "golf")
+	assert.Equal(t, []string{"Golf"}, got)
+
+	_, err := s.UpdateProfile(ctx, profile.UpdateProfileRequest{Email: users[0], Interests: []string{"golf", "tennis"}})
+	assert.Equal(t, gterr.InvalidArgument, gterr.Code(err))
+}
+
+func TestService_ListInterests(t *testing.T) {
+	s, _ := makeService(t, profile.Config{})
+	ctx := context.TODO()
+
+	updateInterests(t, s, users[0], "Golf", "Gardening", "Gaming")
+	updateInterests(t, s, users[1], "Gaming", "Tennis")
+	updateInterests(t, s, users[2], "Gaming", "Gardening")
+
+	res, err := s.ListInterests(ctx, profile.ListInterestsRequest{Prefix: "G"})
+	require.NoError(t, err)
+	var names []string
+	for _, t := range res.Interests {
+		names = append(names, t.Name)
+	}
+	assert.Equal(t, []string{"Gaming", "Gardening", "Golf"}, names, "the most popular first")
+	assert.Equal(t, 3, res.Interests[0].Users)
+
+	res, err = s.ListInterests(ctx, profile.ListInterestsRequest{Prefix: "gar", Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, res.Interests, 1)
+	assert.Equal(t, "Gardening", res.Interests[0].Name)
+}
+
+func TestService_MergeInterests(t *testing.T) {
+	s, mock := makeService(t, profile.Config{})
+	ctx := context.TODO()
+
+	updateInterests(t, s, users[0], "Golf", "Tennis")
+	updateInterests(t, s, users[1], "Golfing", "Tennis")
+	updateInterests(t, s, users[2], "Golfing", "Golf")
+
+	tag, err := s.MergeInterests(ctx, profile.MergeInterestsRequest{Slug: "golfing", Into: "golf", Actor: "admin@mock.com"})
+	require.NoError(t, err)
+	assert.Equal(t, "Golf", tag.Name)
+	assert.Equal(t, []string{"golfing"}, tag.Aliases)
+	assert.Equal(t, 3, tag.Users)
+
+	p, err := mock.GetProfile(ctx, users[1])
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Golf", "Tennis"}, p.Interests)
+
+	p, err = mock.GetProfile(ctx, users[2])
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Golf"}, p.Interests, "merged into an interest already there")
+
+	versions, err := mock.ListProfileVersions(ctx, profile.ListProfileHistoryRequest{Email: users[2], Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.Equal(t, "admin@mock.com", versions[0].Actor, "a version by the admin")
+	assert.Equal(t, []string{"Golf"}, versions[0].Profile.Interests)
+
+	versions, err = mock.ListProfileVersions(ctx, profile.ListProfileHistoryRequest{Email: users[0], Limit: 10})
+	require.NoError(t, err)
+	assert.Len(t, versions, 1, "no version of the users not interested in the merged tag")
+
+	// The merged spelling maps to the remaining tag
+	got := updateInterests(t, s, users[0], "GOLFING")
+	assert.Equal(t, []string{"Golf"}, got)
+
+	res, err := s.ListInterests(ctx, profile.ListInterestsRequest{Prefix: "golfi"})
+	require.NoError(t, err)
+	require.Len(t, res.Interests, 1)
+	assert.Equal(t, "golf", res.Interests[0].Slug)
+
+	tests := []struct {
+		name     string
+		from, to string
+		code     gterr.ErrorCode
+	}{
+		{name: "merged", from: "golfing", to: "tennis", code: gterr.NotFound},
+		{name: "unknown into", from: "tennis", to: "chess", code: gterr.NotFound},
+		{name: "itself", from: "tennis", to: "Tennis", code: gterr.InvalidArgument},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := s.MergeInterests(ctx, profile.MergeInterestsRequest{Slug: test.from, Into: test.to})
+			assert.Equal(t, test.code, gterr.Code(err))
+		})
+	}
+}
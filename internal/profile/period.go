@@ -0,0 +1,170 @@
+package profile
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/victornm/gtonline/internal/gterr"
+)
+
+func (a Attend) MarshalJSON() ([]byte, error) {
+	type alias Attend
+
+	data := struct {
+		alias
+		StartDate string `json:"start_date,omitempty"`
+		EndDate   string `json:"end_date,omitempty"`
+	}{
+		alias:     alias(a),
+		StartDate: formatDate(a.StartDate),
+		EndDate:   formatDate(a.EndDate),
+	}
+	return json.Marshal(data)
+}
+
+func (a *Attend) UnmarshalJSON(bytes []byte) error {
+	if a == nil {
+		return &json.InvalidUnmarshalError{Type: reflect.TypeOf(a)}
+	}
+
+	type alias Attend
+	var data struct {
+		alias
+		StartDate string `json:"start_date,omitempty"`
+		EndDate   string `json:"end_date,omitempty"`
+	}
+	if err := json.Unmarshal(bytes, &data); err != nil {
+		return err
+	}
+
+	var err error
+	if data.alias.StartDate, err = parseDate(data.StartDate); err != nil {
+		return err
+	}
+	if data.alias.EndDate, err = parseDate(data.EndDate); err != nil {
+		return err
+	}
+
+	*a = Attend(data.alias)
+	return nil
+}
+
+func (e Employment) MarshalJSON() ([]byte, error) {
+	type alias Employment
+
+	data := struct {
+		alias
+		StartDate string `json:"start_date,omitempty"`
+		EndDate   string `json:"end_date,omitempty"`
+	}{
+		alias:     alias(e),
+		StartDate: formatDate(e.StartDate),
+		EndDate:   formatDate(e.EndDate),
+	}
+	return json.Marshal(data)
+}
+
+func (e *Employment) UnmarshalJSON(bytes []byte) error {
+	if e == nil {
+		return &json.InvalidUnmarshalError{Type: reflect.TypeOf(e)}
+	}
+
+	type alias Employment
+	var data struct {
+		alias
+		StartDate string `json:"start_date,omitempty"`
+		EndDate   string `json:"end_date,omitempty"`
+	}
+	if err := json.Unmarshal(bytes, &data); err != nil {
+		return err
+	}
+
+	var err error
+	if data.alias.StartDate, err = parseDate(data.StartDate); err != nil {
+		return err
+	}
+	if data.alias.EndDate, err = parseDate(data.EndDate); err != nil {
+		return err
+	}
+
+	*e = Employment(data.alias)
+	return nil
+}
+
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(dateLayout)
+}
+
+// parseDate parses a date of dateLayout, the empty string is the zero time.
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	d, err := time.Parse(dateLayout, s)
+	if err != nil {
+		return time.Time{}, &json.UnmarshalTypeError{Value: s, Type: reflect.TypeOf(d)}
+	}
+	return d, nil
+}
+
+func validateEducation(education []Attend) error {
+	for _, a := range education {
+		if a.School == "" {
+			return gterr.New(gterr.InvalidArgument, "empty school value")
+		}
+
+		if a.YearGraduated < 0 {
+			return gterr.New(gterr.InvalidArgument, "negative year_graduated")
+		}
+
+		if err := validatePeriod(a.StartDate, a.EndDate, a.IsCurrent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateProfessional(professional []Employment) error {
+	current := make(map[string]bool)
+	for _, e := range professional {
+		if e.Employer == "" {
+			return gterr.New(gterr.InvalidArgument, "empty employer value")
+		}
+
+		if e.JobTitle == "" {
+			return gterr.New(gterr.InvalidArgument, "empty job_title value")
+		}
+
+		if err := validatePeriod(e.StartDate, e.EndDate, e.IsCurrent); err != nil {
+			return err
+		}
+
+		if !e.IsCurrent {
+			continue
+		}
+		key := strings.ToLower(e.Employer)
+		if current[key] {
+			return gterr.New(gterr.InvalidArgument, fmt.Sprintf("more than one current job at %s", e.Employer))
+		}
+		current[key] = true
+	}
+	return nil
+}
+
+func validatePeriod(start, end time.Time, isCurrent bool) error {
+	if !start.IsZero() && !end.IsZero() && !end.After(start) {
+		return gterr.New(gterr.InvalidArgument, "end_date not after start_date")
+	}
+
+	if isCurrent && !end.IsZero() {
+		return gterr.New(gterr.InvalidArgument, "end_date of a current entry")
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package profile_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/profile"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestUpdateProfileRequest_Period(t *testing.T) {
+	var req profile.UpdateProfileRequest
+	err := json.Unmarshal([]byte(`{
+		"education": [
+			{"school": "MIT", "year_graduated": 2010},
+			{"school": "GT", "degree": "MSc", "major": "CS", "start_date": "01/09/2010", "end_date": "30/06/2012"}
+		],
+		"professional": [{"employer": "Acme", "job_title": "Engineer", "start_date": "01/07/2012", "is_current": true}]
+	}`), &req)
+	require.NoError(t, err)
+
+	assert.Equal(t, []profile.Attend{
+		{School: "MIT", YearGraduated: 2010},
+		{School: "GT", Degree: "MSc", Major: "CS", StartDate: date(2010, 9, 1), EndDate: date(2012, 6, 30)},
+	}, req.Education)
+	assert.Equal(t, []profile.Employment{
+		{Employer: "Acme", JobTitle: "Engineer", StartDate: date(2012, 7, 1), IsCurrent: true},
+	}, req.Professional)
+
+	err = json.Unmarshal([]byte(`{"professional": [{"employer": "Acme", "job_title": "Engineer", "start_date": "2012-07-01"}]}`), &req)
+	assert.Error(t, err)
+}
+
+func TestProfile_MarshalJSON_Period(t *testing.T) {
+	p := profile.Profile{
+		Education:    []profile.Attend{{School: "MIT", YearGraduated: 2010}},
+		Professional: []profile.Employment{{Employer: "Acme", JobTitle: "Engineer", StartDate: date(2012, 7, 1), IsCurrent: true}},
+	}
+
+	got, err := json.Marshal(p)
+	require.NoError(t, err)
+
+	var data struct {
+		Education    []map[string]interface{} `json:"education"`
+		Professional []map[string]interface{} `json:"professional"`
+	}
+	require.NoError(t, json.Unmarshal(got, &data))
+	assert.Equal(t, map[string]interface{}{"school": "MIT", "year_graduated": float64(2010)}, data.Education[0], "the older fields only")
+	assert.Equal(t, map[string]interface{}{
+		"employer":   "Acme",
+		"job_title":  "Engineer",
+		"start_date": "01/07/2012",
+		"is_current": true,
+	}, data.Professional[0])
+}
+
+func TestService_UpdateProfile_Period(t *testing.T) {
+	s, _ := makeService(t, profile.Config{})
+	ctx := context.TODO()
+
+	p, err := s.UpdateProfile(ctx, profile.UpdateProfileRequest{
+		Email:        users[0],
+		Education:    []profile.Attend{{School: "GT", Degree: "BSc", StartDate: date(2006, 9, 1), EndDate: date(2010, 6, 30)}},
+		Professional: []profile.Employment{{Employer: "Acme", JobTitle: "Engineer", StartDate: date(2012, 7, 1), IsCurrent: true}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "BSc", p.Education[0].Degree)
+	assert.True(t, p.Professional[0].IsCurrent)
+
+	tests := []struct {
+		name string
+		req  profile.UpdateProfileRequest
+	}{
+		{
+			name: "end before start",
+			req:  profile.UpdateProfileRequest{Education: []profile.Attend{{School: "GT", StartDate: date(2010, 9, 1), EndDate: date(2006, 6, 30)}}},
+		},
+		{
+			name: "current with end",
+			req:  profile.UpdateProfileRequest{Professional: []profile.Employment{{Employer: "Acme", JobTitle: "Engineer", EndDate: date(2012, 7, 1), IsCurrent: true}}},
+		},
+		{
+			name: "two current jobs at an employer",
+			req: profile.UpdateProfileRequest{Professional: []profile.Employment{
+				{Employer: "Acme", JobTitle: "Engineer", IsCurrent: true},
+				{Employer: "ACME", JobTitle: "Manager", IsCurrent: true},
+			}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.req.Email = users[1]
+			_, err := s.UpdateProfile(ctx, test.req)
+			assert.Equal(t, gterr.InvalidArgument, gterr.Code(err))
+		})
+	}
+
+	// A current job at each employer is fine
+	_, err = s.UpdateProfile(ctx, profile.UpdateProfileRequest{Email: users[1], Professional: []profile.Employment{
+		{Employer: "Acme", JobTitle: "Engineer", IsCurrent: true},
+		{Employer: "Initech", JobTitle: "Consultant", IsCurrent: true},
+	}})
+	assert.NoError(t, err)
+}
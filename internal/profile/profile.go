@@ -7,7 +7,9 @@ import (
 	"reflect"
 	"time"
 
+	"github.com/victornm/gtonline/internal/event"
 	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/realtime"
 	"github.com/victornm/gtonline/internal/storage"
 )
 
@@ -16,28 +18,102 @@ const dateLayout = "02/01/2006"
 type (
 	Service struct {
 		storage Storage
+		cfg     Config
+	}
+
+	Config struct {
+		// FixedInterests rejects the interests which are not in the catalog instead of adding them to it.
+		FixedInterests bool `mapstructure:"fixed_interests"`
+	}
+
+	// Publisher pushes the profile changes to the connected users.
+	Publisher interface {
+		Publish(ctx context.Context, email string, e realtime.Event) error
 	}
 
 	Storage interface {
 		GetProfile(ctx context.Context, email string) (*Profile, error)
-		UpdateProfile(ctx context.Context, req UpdateProfileRequest) (err error)
+		// UpdateProfile updates the profile, saves v as its next version if not nil and appends the events to the
+		// outbox in the same transaction. The version number is set in v.
+		UpdateProfile(ctx context.Context, req UpdateProfileRequest, v *ProfileVersion, events ...event.Event) (err error)
+		// ListProfileVersions returns the versions of the profile of req.Email, the latest first.
+		ListProfileVersions(ctx context.Context, req ListProfileHistoryRequest) ([]*ProfileVersion, error)
+		// GetProfileVersion returns storage.ErrNotFound if the version doesn't exist.
+		GetProfileVersion(ctx context.Context, email string, version int64) (*ProfileVersion, error)
+		// ListSchools returns the schools with their listed alumni counted.
 		ListSchools(ctx context.Context) ([]School, error)
+		// ListEmployers returns the employers with their listed current employees counted.
 		ListEmployers(ctx context.Context) ([]Employer, error)
+
+		GetDirectoryListing(ctx context.Context, email string) (bool, error)
+		UpdateDirectoryListing(ctx context.Context, email string, listed bool) error
+		// ListAlumni returns the listed alumni of req.School once each, in the cohort of their latest graduation,
+		// by graduation year the latest first and the unknown year last, then by name.
+		// It returns storage.ErrNotFound if the school doesn't exist.
+		ListAlumni(ctx context.Context, req ListAlumniRequest) ([]*Alumnus, error)
+		// ListCoworkers returns the listed current employees of the employer once each, under their current job,
+		// else the latest started, by job title, then by name. A job is current if IsCurrent or without EndDate.
+		// It returns storage.ErrNotFound if the employer doesn't exist.
+		ListCoworkers(ctx context.Context, employer string) ([]*Coworker, error)
+
+		// FindInterestTags returns the tags having one of the slugs as slug or alias, with their aliases.
+		FindInterestTags(ctx context.Context, slugs []string) ([]*InterestTag, error)
+		// InsertInterestTag returns storage.ErrAlreadyExist if there is a tag with the same slug or name.
+		InsertInterestTag(ctx context.Context, t *InterestTag) error
+		// ListInterestTags returns the tags whose slug or an alias starts with prefix, the most users first.
+		ListInterestTags(ctx context.Context, prefix string, limit int) ([]*InterestTag, error)
+		// MergeInterestTags replaces the tag from by the tag into in the interests of the users,
+		// then deletes from and keeps its slug and aliases as aliases of into.
+		// It saves a version of the profile of each user whose interests change, with the Actor and CreatedAt of v.
+		// It returns storage.ErrNotFound if one of the tags doesn't exist.
+		MergeInterestTags(ctx context.Context, from, into string, v *ProfileVersion) error
 	}
 )
 
-func NewService(storage Storage) *Service {
-	return &Service{storage: storage}
+func DefaultConfig() Config {
+	return Config{}
+}
+
+func NewService(storage Storage, cfg Config) *Service {
+	return &Service{storage: storage, cfg: cfg}
+}
+
+const EventProfileUpdated = "profile.updated"
+
+// ProfileUpdated is published with the new profile after the profile is updated.
+type ProfileUpdated struct {
+	Profile
+}
+
+func (ProfileUpdated) EventName() string { return EventProfileUpdated }
+
+// PushHandler pushes the updated profile to the connections of its user.
+func PushHandler(p Publisher) event.Handler {
+	return func(ctx context.Context, r *event.Record) error {
+		var e struct {
+			Email string `json:"email"`
+		}
+		if err := r.Decode(&e); err != nil {
+			return err
+		}
+
+		// The payload is already the JSON of the profile, there is no need to decode it
+		return p.Publish(ctx, e.Email, realtime.Event{Type: EventProfileUpdated, Data: r.Payload})
+	}
 }
 
 type (
 	School struct {
 		SchoolName string `json:"school_name" db:"school_name"`
 		Type       string `json:"type" db:"type"`
+		// Alumni is the number of alumni listed in the directory.
+		Alumni int `json:"alumni" db:"alumni"`
 	}
 
 	Employer struct {
 		EmployerName string `json:"employer_name" db:"employer_name"`
+		// People is the number of people listed in the directory.
+		People int `json:"people" db:"people"`
 	}
 )
 
@@ -46,14 +122,24 @@ type (
 		Email string `json:"email"`
 	}
 
+	// Attend is a school attended by the user, the fields after YearGraduated are optional.
 	Attend struct {
-		School        string `json:"school"`
-		YearGraduated int    `json:"year_graduated"`
+		School        string    `json:"school"`
+		YearGraduated int       `json:"year_graduated"`
+		Degree        string    `json:"degree,omitempty"`
+		Major         string    `json:"major,omitempty"`
+		StartDate     time.Time `json:"start_date,omitempty"`
+		EndDate       time.Time `json:"end_date,omitempty"`
+		IsCurrent     bool      `json:"is_current,omitempty"`
 	}
 
+	// Employment is a job of the user, the fields after JobTitle are optional.
 	Employment struct {
-		Employer string `json:"employer"`
-		JobTitle string `json:"job_title"`
+		Employer  string    `json:"employer"`
+		JobTitle  string    `json:"job_title"`
+		StartDate time.Time `json:"start_date,omitempty"`
+		EndDate   time.Time `json:"end_date,omitempty"`
+		IsCurrent bool      `json:"is_current,omitempty"`
 	}
 
 	Profile struct {
@@ -86,6 +172,32 @@ func (r Profile) MarshalJSON() ([]byte, error) {
 	return json.Marshal(data)
 }
 
+func (r *Profile) UnmarshalJSON(bytes []byte) error {
+	if r == nil {
+		return &json.InvalidUnmarshalError{Type: reflect.TypeOf(r)}
+	}
+
+	type alias Profile
+	var data struct {
+		alias
+		Birthdate string `json:"birthdate,omitempty"`
+	}
+	if err := json.Unmarshal(bytes, &data); err != nil {
+		return err
+	}
+
+	if data.Birthdate != "" {
+		d, err := time.Parse(dateLayout, data.Birthdate)
+		if err != nil {
+			return &json.UnmarshalTypeError{Value: data.Birthdate, Type: reflect.TypeOf(r.Birthdate)}
+		}
+		data.alias.Birthdate = d
+	}
+
+	*r = Profile(data.alias)
+	return nil
+}
+
 func (s *Service) GetProfile(ctx context.Context, req GetProfileRequest) (*Profile, error) {
 	p, err := s.storage.GetProfile(ctx, req.Email)
 	if errors.Is(err, storage.ErrNotFound) {
@@ -96,7 +208,11 @@ func (s *Service) GetProfile(ctx context.Context, req GetProfileRequest) (*Profi
 }
 
 type UpdateProfileRequest struct {
-	Email        string       `json:"-"`
+	Email string `json:"-"`
+	// Actor is the email of the user making the change, Email if empty.
+	Actor string `json:"-"`
+	// RestoredFrom is the version restored by the change, if any.
+	RestoredFrom int64        `json:"-"`
 	Sex          string       `json:"sex" binding:"oneof='' 'M' 'F'"`
 	Birthdate    time.Time    `json:"birthdate"`
 	CurrentCity  string       `json:"current_city"`
@@ -144,42 +260,45 @@ func (r *UpdateProfileRequest) UnmarshalJSON(bytes []byte) (err error) {
 }
 
 func (s *Service) UpdateProfile(ctx context.Context, req UpdateProfileRequest) (*Profile, error) {
-	// Validate interests
-	im := make(map[string]struct{})
-	for _, i := range req.Interests {
-		if i == "" {
-			return nil, gterr.New(gterr.InvalidArgument, "empty interest value")
-		}
-
-		im[i] = struct{}{}
-	}
-	if len(req.Interests) != len(im) {
-		return nil, gterr.New(gterr.InvalidArgument, "duplicate interest value")
+	if err := validateEducation(req.Education); err != nil {
+		return nil, err
 	}
 
-	// Validate education
-	for _, a := range req.Education {
-		if a.School == "" {
-			return nil, gterr.New(gterr.InvalidArgument, "empty school value")
-		}
+	if err := validateProfessional(req.Professional); err != nil {
+		return nil, err
+	}
 
-		if a.YearGraduated < 0 {
-			return nil, gterr.New(gterr.InvalidArgument, "negative year_graduated")
-		}
+	// The event carries the new profile, which is the current one with the fields of req
+	p, err := s.storage.GetProfile(ctx, req.Email)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, gterr.New(gterr.NotFound, "", err)
 	}
 
-	// Validate professional
-	for _, e := range req.Professional {
-		if e.Employer == "" {
-			return nil, gterr.New(gterr.InvalidArgument, "empty employer value")
-		}
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
 
-		if e.JobTitle == "" {
-			return nil, gterr.New(gterr.InvalidArgument, "empty job_title value")
-		}
+	// The tags are only created once the request is known to be valid
+	interests, err := s.canonicalInterests(ctx, req.Interests)
+	if err != nil {
+		return nil, err
+	}
+	req.Interests = interests
+
+	p.Sex = req.Sex
+	p.Birthdate = req.Birthdate
+	p.CurrentCity = req.CurrentCity
+	p.Hometown = req.Hometown
+	p.Interests = req.Interests
+	p.Education = req.Education
+	p.Professional = req.Professional
+
+	v := &ProfileVersion{Actor: req.Actor, RestoredFrom: req.RestoredFrom, Profile: *p, CreatedAt: time.Now()}
+	if v.Actor == "" {
+		v.Actor = req.Email
 	}
 
-	err := s.storage.UpdateProfile(ctx, req)
+	err = s.storage.UpdateProfile(ctx, req, v, ProfileUpdated{Profile: *p})
 	if errors.Is(err, storage.ErrNotFound) {
 		return nil, gterr.New(gterr.NotFound, "", err)
 	}
@@ -192,7 +311,7 @@ func (s *Service) UpdateProfile(ctx context.Context, req UpdateProfileRequest) (
 		return nil, gterr.New(gterr.Internal, "", err)
 	}
 
-	p, err := s.storage.GetProfile(ctx, req.Email)
+	p, err = s.storage.GetProfile(ctx, req.Email)
 	if err != nil {
 		return nil, gterr.New(gterr.Internal, "", err)
 	}
@@ -0,0 +1,98 @@
+package profile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+const (
+	defaultHistoryLimit = 20
+	maxHistoryLimit     = 100
+)
+
+type (
+	// ProfileVersion is a snapshot of a profile saved on every update, Version counts from 1 for each user.
+	ProfileVersion struct {
+		Version int64 `json:"version"`
+		// Actor is the email of the user who made the change, the owner or an admin, empty once their account is purged.
+		Actor string `json:"actor"`
+		// RestoredFrom is the version restored by the change, 0 for a regular update.
+		RestoredFrom int64     `json:"restored_from,omitempty"`
+		Profile      Profile   `json:"profile"`
+		CreatedAt    time.Time `json:"created_at"`
+	}
+
+	ListProfileHistoryRequest struct {
+		Email string `form:"-"`
+		// Before is the cursor for pagination, only the versions lower than it are returned.
+		Before int64 `form:"before" binding:"gte=0"`
+		Limit  int   `form:"limit" binding:"gte=0,lte=100"`
+	}
+
+	ListProfileHistoryResponse struct {
+		Versions []*ProfileVersion `json:"versions"`
+		// NextBefore is the cursor of the next page, 0 if there is no more version.
+		NextBefore int64 `json:"next_before,omitempty"`
+	}
+
+	RestoreProfileRequest struct {
+		Email   string
+		Version int64
+		// Actor is the email of the user restoring the version, Email if empty.
+		Actor string
+	}
+)
+
+func (s *Service) ListProfileHistory(ctx context.Context, req ListProfileHistoryRequest) (*ListProfileHistoryResponse, error) {
+	if req.Limit <= 0 {
+		req.Limit = defaultHistoryLimit
+	}
+	if req.Limit > maxHistoryLimit {
+		req.Limit = maxHistoryLimit
+	}
+
+	// Query 1 more to know if there is a next page
+	limit := req.Limit
+	req.Limit++
+	versions, err := s.storage.ListProfileVersions(ctx, req)
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", fmt.Errorf("list profile versions: %v", err))
+	}
+
+	res := &ListProfileHistoryResponse{Versions: versions}
+	if len(versions) > limit {
+		res.Versions = versions[:limit]
+		res.NextBefore = versions[limit-1].Version
+	}
+	return res, nil
+}
+
+// RestoreProfile updates the profile to the one of the version, which is saved as a new version.
+func (s *Service) RestoreProfile(ctx context.Context, req RestoreProfileRequest) (*Profile, error) {
+	v, err := s.storage.GetProfileVersion(ctx, req.Email, req.Version)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, gterr.New(gterr.NotFound, fmt.Sprintf("version %d not found", req.Version), err)
+	}
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", fmt.Errorf("get profile version: %v", err))
+	}
+
+	// The schools and employers may have left the catalogs since, which is an invalid argument
+	return s.UpdateProfile(ctx, UpdateProfileRequest{
+		Email:        req.Email,
+		Actor:        req.Actor,
+		RestoredFrom: v.Version,
+		Sex:          v.Profile.Sex,
+		Birthdate:    v.Profile.Birthdate,
+		CurrentCity:  v.Profile.CurrentCity,
+		Hometown:     v.Profile.Hometown,
+		Interests:    v.Profile.Interests,
+		Education:    v.Profile.Education,
+		Professional: v.Profile.Professional,
+	})
+}
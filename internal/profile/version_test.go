@@ -0,0 +1,81 @@
+package profile_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/profile"
+)
+
+func TestService_ProfileHistory(t *testing.T) {
+	s, mock := makeService(t, profile.Config{})
+	ctx := context.TODO()
+
+	for _, city := range []string{"Hanoi", "Saigon", "Hue"} {
+		_, err := s.UpdateProfile(ctx, profile.UpdateProfileRequest{
+			Email:       users[0],
+			CurrentCity: city,
+			Education:   []profile.Attend{{School: "GT", YearGraduated: 2010}},
+		})
+		require.NoError(t, err)
+	}
+
+	res, err := s.ListProfileHistory(ctx, profile.ListProfileHistoryRequest{Email: users[0], Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, res.Versions, 2)
+	assert.Equal(t, int64(3), res.Versions[0].Version, "the latest first")
+	assert.Equal(t, "Hue", res.Versions[0].Profile.CurrentCity)
+	assert.Equal(t, users[0], res.Versions[0].Actor)
+	assert.Equal(t, int64(2), res.NextBefore)
+
+	res, err = s.ListProfileHistory(ctx, profile.ListProfileHistoryRequest{Email: users[0], Before: res.NextBefore})
+	require.NoError(t, err)
+	require.Len(t, res.Versions, 1)
+	assert.Equal(t, "Hanoi", res.Versions[0].Profile.CurrentCity)
+	assert.Zero(t, res.NextBefore)
+
+	// The education disappears, then the admin restores the first version
+	_, err = s.UpdateProfile(ctx, profile.UpdateProfileRequest{Email: users[0], CurrentCity: "Hue"})
+	require.NoError(t, err)
+
+	p, err := s.RestoreProfile(ctx, profile.RestoreProfileRequest{Email: users[0], Version: 1, Actor: "admin@mock.com"})
+	require.NoError(t, err)
+	assert.Equal(t, "Hanoi", p.CurrentCity)
+	assert.Equal(t, []profile.Attend{{School: "GT", YearGraduated: 2010}}, p.Education)
+
+	res, err = s.ListProfileHistory(ctx, profile.ListProfileHistoryRequest{Email: users[0], Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), res.Versions[0].Version, "the restore is a new version")
+	assert.Equal(t, int64(1), res.Versions[0].RestoredFrom)
+	assert.Equal(t, "admin@mock.com", res.Versions[0].Actor)
+
+	assert.Len(t, mock.Events(), 5, "profile.updated of every version")
+
+	_, err = s.RestoreProfile(ctx, profile.RestoreProfileRequest{Email: users[1], Version: 1})
+	assert.Equal(t, gterr.NotFound, gterr.Code(err), "the versions are per user")
+
+	res, err = s.ListProfileHistory(ctx, profile.ListProfileHistoryRequest{Email: users[1]})
+	require.NoError(t, err)
+	assert.Empty(t, res.Versions)
+}
+
+func TestProfile_UnmarshalJSON(t *testing.T) {
+	// The versions are saved as the JSON of the profile
+	want := profile.Profile{
+		Email:        users[0],
+		Birthdate:    date(1970, 5, 29),
+		Professional: []profile.Employment{{Employer: "Acme", JobTitle: "Engineer", StartDate: date(2012, 7, 1)}},
+	}
+
+	data, err := json.Marshal(want)
+	require.NoError(t, err)
+
+	var got profile.Profile
+	require.NoError(t, json.Unmarshal(data, &got))
+	assert.Equal(t, want, got)
+}
@@ -0,0 +1,42 @@
+package realtime
+
+import (
+	"context"
+	"sync"
+)
+
+// LocalBroker is an in-process Broker, it only works when running a single server.
+type LocalBroker struct {
+	mu       sync.RWMutex
+	handlers map[int]func(payload []byte)
+	lastID   int
+}
+
+func NewLocalBroker() *LocalBroker {
+	return &LocalBroker{handlers: make(map[int]func(payload []byte))}
+}
+
+func (b *LocalBroker) Publish(_ context.Context, payload []byte) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, handle := range b.handlers {
+		handle(payload)
+	}
+	return nil
+}
+
+func (b *LocalBroker) Subscribe(ctx context.Context, handle func(payload []byte)) error {
+	b.mu.Lock()
+	b.lastID++
+	id := b.lastID
+	b.handlers[id] = handle
+	b.mu.Unlock()
+
+	<-ctx.Done()
+
+	b.mu.Lock()
+	delete(b.handlers, id)
+	b.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,178 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+type (
+	// Hub pushes events to the users connected to this server.
+	// Events are fanned out through the Broker, so users connected to other replicas receive them too.
+	Hub struct {
+		broker Broker
+		cfg    Config
+
+		mu      sync.Mutex
+		clients map[string]map[*Client]struct{}
+	}
+
+	// Broker delivers the published payloads to every subscribed Hub, including the publisher itself.
+	// LocalBroker is enough for a single node, a pub/sub backend is needed when running multiple replicas.
+	Broker interface {
+		Publish(ctx context.Context, payload []byte) error
+		// Subscribe calls handle for every published payload, it blocks until ctx is done.
+		Subscribe(ctx context.Context, handle func(payload []byte)) error
+	}
+
+	Config struct {
+		// HeartbeatInterval is how often an idle connection is pinged.
+		HeartbeatInterval time.Duration `mapstructure:"heartbeat_interval"`
+		// BufferSize is the number of events queued for a client,
+		// a client falls behind more than this is disconnected.
+		BufferSize int `mapstructure:"buffer_size"`
+		// WriteTimeout limits how long writing to a WebSocket connection can take.
+		WriteTimeout time.Duration `mapstructure:"write_timeout"`
+	}
+
+	Event struct {
+		Type string      `json:"type"`
+		Data interface{} `json:"data"`
+	}
+
+	// message is the payload sent through the Broker.
+	message struct {
+		Email string          `json:"email"`
+		Event json.RawMessage `json:"event"`
+	}
+)
+
+func DefaultConfig() Config {
+	return Config{
+		HeartbeatInterval: 30 * time.Second,
+		BufferSize:        16,
+		WriteTimeout:      10 * time.Second,
+	}
+}
+
+func (c Config) withDefaults() Config {
+	d := DefaultConfig()
+	if c.HeartbeatInterval <= 0 {
+		c.HeartbeatInterval = d.HeartbeatInterval
+	}
+	if c.BufferSize <= 0 {
+		c.BufferSize = d.BufferSize
+	}
+	if c.WriteTimeout <= 0 {
+		c.WriteTimeout = d.WriteTimeout
+	}
+	return c
+}
+
+func NewHub(b Broker, cfg Config) *Hub {
+	return &Hub{
+		broker:  b,
+		cfg:     cfg.withDefaults(),
+		clients: make(map[string]map[*Client]struct{}),
+	}
+}
+
+// Run receives the events from the Broker and delivers them to the connected clients, until ctx is done.
+func (h *Hub) Run(ctx context.Context) error {
+	return h.broker.Subscribe(ctx, func(payload []byte) {
+		var msg message
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			log.Printf("realtime: invalid payload: %v", err)
+			return
+		}
+		h.deliver(msg.Email, msg.Event)
+	})
+}
+
+// Publish sends e to all the connections of the user with the given email.
+func (h *Hub) Publish(ctx context.Context, email string, e Event) error {
+	event, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("marshal event: %v", err)
+	}
+
+	payload, err := json.Marshal(message{Email: email, Event: event})
+	if err != nil {
+		return fmt.Errorf("marshal message: %v", err)
+	}
+
+	return h.broker.Publish(ctx, payload)
+}
+
+// Subscribe registers a new connection of the user, the caller must Close the client when done.
+func (h *Hub) Subscribe(email string) *Client {
+	c := &Client{
+		hub:   h,
+		email: email,
+		send:  make(chan []byte, h.cfg.BufferSize),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.clients[email] == nil {
+		h.clients[email] = make(map[*Client]struct{})
+	}
+	h.clients[email][c] = struct{}{}
+	return c
+}
+
+func (h *Hub) deliver(email string, event []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for c := range h.clients[email] {
+		select {
+		case c.send <- event:
+		default:
+			// The client is too slow, drop it instead of blocking the others
+			log.Printf("realtime: drop slow client of %s", email)
+			h.remove(c)
+		}
+	}
+}
+
+// remove must be called with mu held.
+func (h *Hub) remove(c *Client) {
+	clients, ok := h.clients[c.email]
+	if !ok {
+		return
+	}
+
+	if _, ok := clients[c]; !ok {
+		return
+	}
+
+	delete(clients, c)
+	close(c.send)
+	if len(clients) == 0 {
+		delete(h.clients, c.email)
+	}
+}
+
+// Client is a connection of a user.
+type Client struct {
+	hub   *Hub
+	email string
+	send  chan []byte
+}
+
+// Events returns the channel of JSON encoded events, it is closed when the client is dropped or closed.
+func (c *Client) Events() <-chan []byte {
+	return c.send
+}
+
+func (c *Client) Close() {
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+
+	c.hub.remove(c)
+}
@@ -0,0 +1,101 @@
+package realtime_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/victornm/gtonline/internal/realtime"
+)
+
+func runHub(t *testing.T, cfg realtime.Config) *realtime.Hub {
+	h := realtime.NewHub(realtime.NewLocalBroker(), cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = h.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	// Wait until the hub subscribed to the broker
+	require.Eventually(t, func() bool {
+		c := h.Subscribe("probe@gt.com")
+		defer c.Close()
+		_ = h.Publish(context.Background(), "probe@gt.com", realtime.Event{Type: "probe"})
+		select {
+		case <-c.Events():
+			return true
+		case <-time.After(10 * time.Millisecond):
+			return false
+		}
+	}, time.Second, 10*time.Millisecond)
+
+	return h
+}
+
+func receive(t *testing.T, c *realtime.Client) (realtime.Event, bool) {
+	select {
+	case payload, ok := <-c.Events():
+		if !ok {
+			return realtime.Event{}, false
+		}
+		var e realtime.Event
+		require.NoError(t, json.Unmarshal(payload, &e))
+		return e, true
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+		return realtime.Event{}, false
+	}
+}
+
+func TestHub_Publish(t *testing.T) {
+	h := runHub(t, realtime.DefaultConfig())
+
+	tony1 := h.Subscribe("tony@stark.com")
+	defer tony1.Close()
+	tony2 := h.Subscribe("tony@stark.com")
+	defer tony2.Close()
+	steve := h.Subscribe("steve@rogers.com")
+	defer steve.Close()
+
+	err := h.Publish(context.Background(), "tony@stark.com", realtime.Event{Type: "profile.updated", Data: "hello"})
+	require.NoError(t, err)
+
+	for _, c := range []*realtime.Client{tony1, tony2} {
+		e, ok := receive(t, c)
+		require.True(t, ok)
+		assert.Equal(t, "profile.updated", e.Type)
+		assert.Equal(t, "hello", e.Data)
+	}
+
+	select {
+	case <-steve.Events():
+		t.Fatal("steve must not receive events of tony")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestHub_DropSlowClient(t *testing.T) {
+	h := runHub(t, realtime.Config{BufferSize: 1})
+
+	slow := h.Subscribe("tony@stark.com")
+	defer slow.Close()
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, h.Publish(context.Background(), "tony@stark.com", realtime.Event{Type: "ping"}))
+	}
+
+	_, ok := receive(t, slow)
+	require.True(t, ok, "the buffered event must be kept")
+	_, ok = receive(t, slow)
+	assert.False(t, ok, "the channel must be closed after the client is dropped")
+}
@@ -0,0 +1,113 @@
+package realtime
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var upgrader = websocket.Upgrader{
+	// Same as CORS, the API is called from any origin
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// ServeSSE streams the events of the user as Server-Sent Events until the client disconnects.
+func (h *Hub) ServeSSE(w http.ResponseWriter, r *http.Request, email string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	c := h.Subscribe(email)
+	defer c.Close()
+
+	heartbeat := time.NewTicker(h.cfg.HeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case event, ok := <-c.Events():
+			if !ok {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", event); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// ServeWebSocket upgrades the request and pushes the events of the user until the client disconnects.
+// Messages sent by the client are ignored.
+func (h *Hub) ServeWebSocket(w http.ResponseWriter, r *http.Request, email string) error {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return fmt.Errorf("upgrade: %v", err)
+	}
+	defer conn.Close()
+
+	c := h.Subscribe(email)
+	defer c.Close()
+
+	// The client is considered gone if it doesn't answer 2 heartbeats in a row
+	pongWait := 2 * h.cfg.HeartbeatInterval
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+
+		_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(pongWait))
+		})
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	heartbeat := time.NewTicker(h.cfg.HeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return nil
+
+		case event, ok := <-c.Events():
+			_ = conn.SetWriteDeadline(time.Now().Add(h.cfg.WriteTimeout))
+			if !ok {
+				_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "too slow"))
+				return nil
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, event); err != nil {
+				return nil
+			}
+
+		case <-heartbeat.C:
+			_ = conn.SetWriteDeadline(time.Now().Add(h.cfg.WriteTimeout))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package retry
+
+import "time"
+
+// Backoff returns the delay after the given number of failed attempts: initial after the first one,
+// doubled after every other one up to max.
+func Backoff(initial, max time.Duration, attempts int) time.Duration {
+	d := initial
+	for i := 1; i < attempts && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// secretQueries are the query parameters carrying credentials: the access token of the streams,
+// which browsers can't send in a header, and the signed links of the exports, digests and calendars.
+var secretQueries = []string{"access_token", "token"}
+
+// logger is the gin logger with the secret queries redacted, so the access log never holds a credential.
+func logger() gin.HandlerFunc {
+	return gin.LoggerWithConfig(gin.LoggerConfig{Formatter: func(param gin.LogFormatterParams) string {
+		param.Path = redactPath(param.Path)
+		return logFormatter(param)
+	}})
+}
+
+// redactPath replaces the values of the secret queries of path.
+func redactPath(path string) string {
+	i := strings.IndexByte(path, '?')
+	if i < 0 {
+		return path
+	}
+
+	query, err := url.ParseQuery(path[i+1:])
+	if err != nil {
+		// An unparsable query is dropped rather than logged as is
+		return path[:i] + "?REDACTED"
+	}
+
+	redacted := false
+	for _, k := range secretQueries {
+		if _, ok := query[k]; ok {
+			query.Set(k, "REDACTED")
+			redacted = true
+		}
+	}
+	if !redacted {
+		return path
+	}
+	return path[:i+1] + query.Encode()
+}
+
+// logFormatter is the default formatter of gin, which is not exported.
+func logFormatter(param gin.LogFormatterParams) string {
+	var statusColor, methodColor, resetColor string
+	if param.IsOutputColor() {
+		statusColor = param.StatusCodeColor()
+		methodColor = param.MethodColor()
+		resetColor = param.ResetColor()
+	}
+
+	if param.Latency > time.Minute {
+		param.Latency = param.Latency - param.Latency%time.Second
+	}
+	return fmt.Sprintf("[GIN] %v |%s %3d %s| %13v | %15s |%s %-7s %s %#v\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		statusColor, param.StatusCode, resetColor,
+		param.Latency,
+		param.ClientIP,
+		methodColor, param.Method, resetColor,
+		param.Path,
+		param.ErrorMessage,
+	)
+}
@@ -1,10 +1,14 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -13,9 +17,21 @@ import (
 
 	"github.com/victornm/gtonline/internal/api"
 	"github.com/victornm/gtonline/internal/auth"
+	"github.com/victornm/gtonline/internal/community"
+	"github.com/victornm/gtonline/internal/conversation"
+	"github.com/victornm/gtonline/internal/digest"
+	"github.com/victornm/gtonline/internal/event"
+	"github.com/victornm/gtonline/internal/export"
 	"github.com/victornm/gtonline/internal/friend"
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/job"
+	"github.com/victornm/gtonline/internal/mail"
+	"github.com/victornm/gtonline/internal/meetup"
+	"github.com/victornm/gtonline/internal/notification"
 	"github.com/victornm/gtonline/internal/profile"
+	"github.com/victornm/gtonline/internal/realtime"
 	"github.com/victornm/gtonline/internal/storage/mysql"
+	"github.com/victornm/gtonline/internal/webhook"
 )
 
 type (
@@ -25,16 +41,34 @@ type (
 		once    sync.Once
 		storage *mysql.Storage
 		e       *gin.Engine
+
+		events       *event.Bus
+		runner       *job.Runner
+		auth         *auth.Service
+		profile      *profile.Service
+		friend       *friend.Service
+		notification *notification.Service
+		realtime     *realtime.Hub
+		conversation *conversation.Service
+		webhook      *webhook.Service
+		digest       *digest.Service
+		meetup       *meetup.Service
+		community    *community.Service
+		export       *export.Service
+
+		// stop cancels all the background jobs
+		stop context.CancelFunc
+		jobs sync.WaitGroup
 	}
 
 	Config struct {
 		App struct {
 			Addr string
+			// ShutdownTimeout is how long the in-flight requests are waited for on shutdown.
+			ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
 		}
 
-		Auth struct {
-			Secret string
-		}
+		Auth auth.Config
 
 		DB struct {
 			Addr string
@@ -42,6 +76,28 @@ type (
 			Pass string
 			Name string
 		}
+
+		Event event.Config
+
+		Job job.Config
+
+		Friend friend.Config
+
+		Realtime realtime.Config
+
+		Conversation conversation.Config
+
+		Webhook webhook.Config
+
+		Mail mail.Config
+
+		Digest digest.Config
+
+		Meetup meetup.Config
+
+		Profile profile.Config
+
+		Export export.Config
 	}
 )
 
@@ -55,6 +111,8 @@ func DefaultConfig() Config {
 	c := Config{}
 	// App Config
 	c.App.Addr = ":8080"
+	c.App.ShutdownTimeout = 10 * time.Second
+	c.Auth = auth.DefaultConfig()
 	c.Auth.Secret = "JznqcOJCAEc1aq7Zulm83OtQt7md2gOK"
 
 	// DB config
@@ -62,6 +120,39 @@ func DefaultConfig() Config {
 	c.DB.User = "root"
 	c.DB.Pass = "root"
 	c.DB.Name = "gt-online"
+
+	// Event config
+	c.Event = event.DefaultConfig()
+
+	// Job config
+	c.Job = job.DefaultConfig()
+
+	// Friend config
+	c.Friend = friend.DefaultConfig()
+
+	// Realtime config
+	c.Realtime = realtime.DefaultConfig()
+
+	// Conversation config
+	c.Conversation = conversation.DefaultConfig()
+
+	// Webhook config
+	c.Webhook = webhook.DefaultConfig()
+
+	// Mail config
+	c.Mail = mail.DefaultConfig()
+
+	// Digest config
+	c.Digest = digest.DefaultConfig()
+
+	// Meetup config
+	c.Meetup = meetup.DefaultConfig()
+
+	// Profile config
+	c.Profile = profile.DefaultConfig()
+
+	// Export config
+	c.Export = export.DefaultConfig()
 	return c
 }
 
@@ -70,10 +161,70 @@ func (s *Server) init() {
 		if err := s.initStorage(); err != nil {
 			log.Fatalf("init storage: %v", err)
 		}
+		s.initServices()
 		s.initRouter()
+		s.initBackground()
 	})
 }
 
+func (s *Server) initServices() {
+	// Replace LocalBroker with a pub/sub broker when running multiple replicas
+	s.realtime = realtime.NewHub(realtime.NewLocalBroker(), s.cfg.Realtime)
+	mailer := mail.New(s.cfg.Mail)
+	s.webhook = webhook.NewService(s.storage, nil, s.cfg.Webhook)
+	s.auth = auth.NewService(s.storage, s.cfg.Auth)
+	s.profile = profile.NewService(s.storage, s.cfg.Profile)
+	s.notification = notification.NewService(s.storage, s.realtime, mailer)
+	s.friend = friend.NewService(s.storage, s.cfg.Friend)
+	// The real-time events of the other services follow the notification preferences
+	s.conversation = conversation.NewService(s.storage, s.storage, s.notification, s.cfg.Conversation)
+
+	digestCfg := s.cfg.Digest
+	if digestCfg.Secret == "" {
+		digestCfg.Secret = s.cfg.Auth.Secret
+	}
+	s.digest = digest.NewService(s.storage, mailer, s.notification, digestCfg)
+
+	meetupCfg := s.cfg.Meetup
+	if meetupCfg.Secret == "" {
+		meetupCfg.Secret = s.cfg.Auth.Secret
+	}
+	s.meetup = meetup.NewService(s.storage, s.storage, meetupCfg)
+
+	s.community = community.NewService(s.storage, s.storage)
+
+	s.events = event.NewBus(s.storage, s.cfg.Event)
+	s.subscribe()
+
+	s.runner = job.NewRunner(s.storage, s.cfg.Job)
+
+	exportCfg := s.cfg.Export
+	if exportCfg.Secret == "" {
+		exportCfg.Secret = s.cfg.Auth.Secret
+	}
+	s.export = export.NewService(s.storage, s.runner, exportCfg)
+
+	s.registerJobs()
+}
+
+// subscribe registers the side effects of the domain events.
+// The subscriber names are stored in the outbox, renaming one makes it handle the undispatched events again.
+func (s *Server) subscribe() {
+	s.events.Subscribe("webhook", event.AllEvents, s.webhook.HandleEvent)
+	s.events.Subscribe("realtime.profile", profile.EventProfileUpdated, profile.PushHandler(s.notification))
+	s.events.Subscribe("digest.profile", profile.EventProfileUpdated, s.digest.HandleProfileUpdated)
+
+	notify := friend.NotificationHandler(s.notification)
+	for _, e := range []string{friend.EventFriendshipCreated, friend.EventFriendshipAccepted, friend.EventFriendshipRejected} {
+		s.events.Subscribe("notification."+e, e, notify)
+	}
+
+	notifyGuest := meetup.NotificationHandler(s.notification)
+	for _, e := range []string{meetup.EventGuestInvited, meetup.EventGuestPromoted} {
+		s.events.Subscribe("notification."+e, e, notifyGuest)
+	}
+}
+
 func (s *Server) initStorage() error {
 	cfg := s.cfg.DB
 	log.Printf("DB config: addr=%s, user=%s, name=%s", cfg.Addr, cfg.User, cfg.Name)
@@ -92,7 +243,9 @@ func (s *Server) initStorage() error {
 }
 
 func (s *Server) initRouter() {
-	s.e = gin.Default()
+	// gin.Default without its logger, which would write the tokens of the queries to the access log
+	s.e = gin.New()
+	s.e.Use(logger(), gin.Recovery())
 
 	corsConfig := cors.DefaultConfig()
 	corsConfig.AllowAllOrigins = true
@@ -100,24 +253,281 @@ func (s *Server) initRouter() {
 	s.e.Use(cors.New(corsConfig))
 
 	a := &api.API{
-		Auth:    auth.NewService(s.storage, []byte(s.cfg.Auth.Secret)),
-		Profile: profile.NewService(s.storage),
-		Friend:  friend.NewService(s.storage),
+		Auth:         s.auth,
+		Profile:      s.profile,
+		Friend:       s.friend,
+		Notification: s.notification,
+		Realtime:     s.realtime,
+		Conversation: s.conversation,
+		Webhook:      s.webhook,
+		Digest:       s.digest,
+		Meetup:       s.meetup,
+		Community:    s.community,
+		Export:       s.export,
 	}
 	a.Route(s.e)
 }
 
+func (s *Server) initBackground() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stop = cancel
+
+	s.jobs.Add(1)
+	go func() {
+		defer s.jobs.Done()
+		if err := s.realtime.Run(ctx); err != nil {
+			log.Printf("realtime hub stopped: %v", err)
+		}
+	}()
+
+	// The runner drains the running jobs after ctx is done, so Close waits for them
+	s.jobs.Add(1)
+	go func() {
+		defer s.jobs.Done()
+		if err := s.runner.Run(ctx); err != nil {
+			log.Printf("job runner stopped: %v", err)
+		}
+	}()
+
+	s.runEvery(ctx, "relay events", s.cfg.Event.PollInterval, s.events.Relay)
+	s.runEvery(ctx, "delete dispatched events", s.cfg.Event.SweepInterval, func(ctx context.Context) error {
+		n, err := s.events.DeleteDispatched(ctx)
+		if n > 0 {
+			log.Printf("deleted %d dispatched event(s)", n)
+		}
+		return err
+	})
+
+	s.runEvery(ctx, "deliver webhooks", s.cfg.Webhook.PollInterval, s.webhook.DeliverDue)
+}
+
+// Job types run by the runner.
+const (
+	jobDeleteExpiredFriendRequests = "friend.delete_expired_requests"
+	jobRemindBirthdays             = "friend.remind_birthdays"
+	jobEnqueueDigests              = "digest.enqueue"
+	jobSendDigest                  = "digest.send"
+	jobDeleteExpiredExports        = "export.delete_expired"
+	jobPurgeDeletedAccounts        = "auth.purge_deleted_accounts"
+)
+
+// handOver removes the user from its groups and group conversations before its account is purged,
+// so the groups it owns get another owner instead of being left without one.
+func (s *Server) handOver(ctx context.Context, email string) error {
+	if err := s.community.RemoveUser(ctx, email); err != nil {
+		return err
+	}
+	return s.conversation.RemoveUser(ctx, email)
+}
+
+// registerJobs registers the job handlers and the schedules.
+func (s *Server) registerJobs() {
+	s.runner.Register(jobPurgeDeletedAccounts, func(ctx context.Context, _ *job.Job) error {
+		n, err := s.auth.PurgeDeletedAccounts(ctx, time.Now(), s.handOver)
+		if n > 0 {
+			log.Printf("purged %d deleted account(s)", n)
+		}
+		if err != nil {
+			return err
+		}
+
+		// The export rows are deleted with the accounts, their archives are not
+		files, err := s.export.DeleteOrphans(ctx)
+		if files > 0 {
+			log.Printf("deleted %d orphan export archive(s)", files)
+		}
+		return err
+	}, job.HandlerConfig{Concurrency: 1})
+
+	if interval := s.cfg.Auth.PurgeInterval; interval > 0 {
+		s.runner.Schedule("purge deleted accounts", job.Every(interval), jobPurgeDeletedAccounts, nil)
+	}
+
+	s.runner.Register(jobDeleteExpiredFriendRequests, func(ctx context.Context, _ *job.Job) error {
+		n, err := s.friend.DeleteExpiredRequests(ctx)
+		if n > 0 {
+			log.Printf("deleted %d expired friend request(s)", n)
+		}
+		return err
+	}, job.HandlerConfig{Concurrency: 1})
+
+	if interval := s.cfg.Friend.Request.SweepInterval; interval > 0 {
+		s.runner.Schedule("delete expired friend requests", job.Every(interval), jobDeleteExpiredFriendRequests, nil)
+	}
+
+	s.runner.Register(jobRemindBirthdays, func(ctx context.Context, _ *job.Job) error {
+		n, err := s.friend.RemindBirthdays(ctx, s.notification, time.Now())
+		if n > 0 {
+			log.Printf("sent %d birthday reminder(s)", n)
+		}
+		return err
+	}, job.HandlerConfig{Concurrency: 1})
+
+	if schedule, err := job.ParseCron(s.cfg.Friend.Birthday.Schedule); err != nil {
+		log.Printf("[WARN] birthday reminders are disabled: %v", err)
+	} else {
+		s.runner.Schedule("remind birthdays", schedule, jobRemindBirthdays, nil)
+	}
+
+	s.registerDigestJobs()
+	s.registerExportJobs()
+}
+
+// registerDigestJobs fans out the scheduled digest run to one job per user, so a failed email is retried alone.
+func (s *Server) registerDigestJobs() {
+	s.runner.Register(jobEnqueueDigests, func(ctx context.Context, _ *job.Job) error {
+		now := time.Now()
+		emails, err := s.digest.Due(ctx, now)
+		if err != nil {
+			return err
+		}
+
+		day := now.Format("2006-01-02")
+		for _, email := range emails {
+			_, err := s.runner.Enqueue(ctx, job.EnqueueRequest{
+				Type:    jobSendDigest,
+				Payload: email,
+				Key:     fmt.Sprintf("digest:%s:%s", email, day),
+			})
+			// The run is retried after a failure, the digests enqueued by the previous attempt are skipped
+			if err != nil && gterr.Code(err) != gterr.AlreadyExists {
+				return err
+			}
+		}
+
+		n, err := s.digest.DeleteExpiredChanges(ctx)
+		if n > 0 {
+			log.Printf("deleted %d expired profile change(s)", n)
+		}
+		return err
+	}, job.HandlerConfig{Concurrency: 1})
+
+	s.runner.Register(jobSendDigest, func(ctx context.Context, j *job.Job) error {
+		var email string
+		if err := j.Decode(&email); err != nil {
+			return err
+		}
+		return s.digest.Send(ctx, email, time.Now())
+	}, job.HandlerConfig{})
+
+	schedule, err := job.ParseCron(s.cfg.Digest.Schedule)
+	if err != nil {
+		log.Printf("[WARN] digest is disabled: %v", err)
+		return
+	}
+	s.runner.Schedule("send digests", schedule, jobEnqueueDigests, nil)
+}
+
+// registerExportJobs builds the requested exports and deletes the expired archives.
+func (s *Server) registerExportJobs() {
+	s.runner.Register(export.JobBuild, func(ctx context.Context, j *job.Job) error {
+		var id int64
+		if err := j.Decode(&id); err != nil {
+			return err
+		}
+
+		return s.export.Build(ctx, id, time.Now())
+	}, job.HandlerConfig{
+		Concurrency: 2,
+		// The user can request another export once this one failed for good
+		OnFail: func(ctx context.Context, j *job.Job, _ error) {
+			var id int64
+			if err := j.Decode(&id); err != nil {
+				log.Printf("decode export job %d: %v", j.ID, err)
+				return
+			}
+			if err := s.export.Fail(ctx, id); err != nil {
+				log.Printf("mark export %d failed: %v", id, err)
+			}
+		},
+	})
+
+	s.runner.Register(jobDeleteExpiredExports, func(ctx context.Context, _ *job.Job) error {
+		n, err := s.export.DeleteExpired(ctx, time.Now())
+		if n > 0 {
+			log.Printf("deleted %d expired export(s)", n)
+		}
+		return err
+	}, job.HandlerConfig{Concurrency: 1})
+
+	if interval := s.cfg.Export.SweepInterval; interval > 0 {
+		s.runner.Schedule("delete expired exports", job.Every(interval), jobDeleteExpiredExports, nil)
+	}
+}
+
+// runEvery runs f in the background every interval until the server is closed.
+func (s *Server) runEvery(ctx context.Context, name string, interval time.Duration, f func(ctx context.Context) error) {
+	if interval <= 0 {
+		log.Printf("[WARN] background job %q is disabled", name)
+		return
+	}
+
+	s.jobs.Add(1)
+	go func() {
+		defer s.jobs.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := f(ctx); err != nil {
+					log.Printf("background job %q failed: %v", name, err)
+				}
+			}
+		}
+	}()
+}
+
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.init()
 	s.e.ServeHTTP(w, r)
 }
 
+// Start serves until SIGINT or SIGTERM, then stops accepting requests and closes the server.
 func (s *Server) Start() {
 	s.init()
+
+	srv := &http.Server{Addr: s.cfg.App.Addr, Handler: s.e}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+		log.Println("Server shutting down")
+
+		ctx, cancel := context.WithTimeout(context.Background(), s.cfg.App.ShutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("shutdown server: %v", err)
+		}
+	}()
+
 	log.Println("Server start at", s.cfg.App.Addr)
-	if err := s.e.Run(s.cfg.App.Addr); err != nil && err != http.ErrServerClosed {
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Fatalf("Run server failed: %v", err)
 	}
+
+	// The background jobs are drained after the requests, which may enqueue more of them
+	if err := s.Close(); err != nil {
+		log.Printf("close server: %v", err)
+	}
+}
+
+// Close stops all the background jobs and closes the storage.
+func (s *Server) Close() error {
+	if s.stop != nil {
+		s.stop()
+		s.jobs.Wait()
+	}
+
+	if s.storage != nil {
+		return s.storage.Close()
+	}
+	return nil
 }
 
 func try(times int, f func() error) error {
@@ -0,0 +1,67 @@
+package signed
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalid = errors.New("invalid token")
+	ErrExpired = errors.New("token expired")
+)
+
+// Token returns a token of subject signed with secret for purpose, so it can't be used for another one.
+// It is valid until expiresAt, or forever if expiresAt is zero.
+func Token(secret, purpose, subject string, expiresAt time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(subject))
+	if !expiresAt.IsZero() {
+		payload += "." + strconv.FormatInt(expiresAt.Unix(), 10)
+	}
+	return payload + "." + base64.RawURLEncoding.EncodeToString(sign(secret, purpose, payload))
+}
+
+// Verify returns the subject of a token of Token signed with secret for purpose.
+// It returns ErrInvalid if the token is malformed or badly signed, and ErrExpired if it expired at now.
+func Verify(secret, purpose, token string, now time.Time) (string, error) {
+	i := strings.LastIndexByte(token, '.')
+	if i < 0 {
+		return "", ErrInvalid
+	}
+	payload := token[:i]
+
+	sig, err := base64.RawURLEncoding.DecodeString(token[i+1:])
+	if err != nil || !hmac.Equal(sig, sign(secret, purpose, payload)) {
+		return "", ErrInvalid
+	}
+
+	parts := strings.Split(payload, ".")
+	if len(parts) > 2 {
+		return "", ErrInvalid
+	}
+	if len(parts) == 2 {
+		expiresAt, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return "", ErrInvalid
+		}
+		if !now.Before(time.Unix(expiresAt, 0)) {
+			return "", ErrExpired
+		}
+	}
+
+	subject, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", ErrInvalid
+	}
+	return string(subject), nil
+}
+
+func sign(secret, purpose, payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(purpose + ":" + payload))
+	return mac.Sum(nil)
+}
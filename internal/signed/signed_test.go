@@ -0,0 +1,51 @@
+package signed_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/victornm/gtonline/internal/signed"
+)
+
+func TestVerify(t *testing.T) {
+	now := time.Now()
+
+	token := signed.Token("secret", "digest.unsubscribe", "foo@mock.com", time.Time{})
+	subject, err := signed.Verify("secret", "digest.unsubscribe", token, now.AddDate(10, 0, 0))
+	require.NoError(t, err)
+	assert.Equal(t, "foo@mock.com", subject, "no expiry")
+
+	expiring := signed.Token("secret", "export.download", "42", now.Add(time.Hour))
+	subject, err = signed.Verify("secret", "export.download", expiring, now)
+	require.NoError(t, err)
+	assert.Equal(t, "42", subject)
+
+	_, err = signed.Verify("secret", "export.download", expiring, now.Add(time.Hour))
+	assert.ErrorIs(t, err, signed.ErrExpired)
+
+	other := signed.Token("secret", "digest.unsubscribe", "bar@mock.com", time.Time{})
+	tests := []struct {
+		name    string
+		secret  string
+		purpose string
+		token   string
+	}{
+		{name: "empty", secret: "secret", purpose: "digest.unsubscribe", token: ""},
+		{name: "no signature", secret: "secret", purpose: "digest.unsubscribe", token: "foo"},
+		{name: "altered signature", secret: "secret", purpose: "digest.unsubscribe", token: token + "x"},
+		{name: "other subject", secret: "secret", purpose: "digest.unsubscribe", token: other[:10] + token[10:]},
+		{name: "other purpose", secret: "secret", purpose: "meetup.feed", token: token},
+		{name: "other secret", secret: "other", purpose: "digest.unsubscribe", token: token},
+		{name: "expiry removed", secret: "secret", purpose: "export.download", token: expiring[:len("NDI")] + expiring[strings.LastIndexByte(expiring, '.'):]},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := signed.Verify(test.secret, test.purpose, test.token, now)
+			assert.ErrorIs(t, err, signed.ErrInvalid)
+		})
+	}
+}
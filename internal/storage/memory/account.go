@@ -0,0 +1,315 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/victornm/gtonline/internal/auth"
+	"github.com/victornm/gtonline/internal/conversation"
+	"github.com/victornm/gtonline/internal/event"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+func (s *Storage) FindUserByEmail(_ context.Context, email string) (*auth.User, error) {
+	u, err := s.getUser(email)
+	if err != nil {
+		return nil, err
+	}
+
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+
+	res := &auth.User{
+		Email:          u.Email,
+		HashedPassword: s.passwords[email],
+		FirstName:      u.FirstName,
+		LastName:       u.LastName,
+	}
+	if d, ok := s.deactivatedUsers[email]; ok {
+		at := d.at
+		res.DeactivatedAt = &at
+		if d.deleteAfter != nil {
+			after := *d.deleteAfter
+			res.DeleteAfter = &after
+		}
+	}
+	return res, nil
+}
+
+func (s *Storage) CreateRegularUser(ctx context.Context, u auth.User, events ...event.Event) error {
+	if _, err := s.getUser(u.Email); err == nil {
+		return storage.ErrAlreadyExist
+	}
+
+	s.usersMu.Lock()
+	s.users = append(s.users, User{Email: u.Email, FirstName: u.FirstName, LastName: u.LastName})
+	if s.passwords == nil {
+		s.passwords = make(map[string]string)
+	}
+	s.passwords[u.Email] = u.HashedPassword
+	s.usersMu.Unlock()
+
+	return s.AppendEvents(ctx, events...)
+}
+
+// IsAdmin is always false, the mock has no admin users.
+func (s *Storage) IsAdmin(context.Context, string) (bool, error) {
+	return false, nil
+}
+
+func (s *Storage) DeactivateUser(_ context.Context, email string, at time.Time, deleteAfter *time.Time) error {
+	if _, err := s.getUser(email); err != nil {
+		return err
+	}
+
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+
+	if s.deactivatedUsers == nil {
+		s.deactivatedUsers = make(map[string]deactivation)
+	}
+	if deleteAfter != nil {
+		t := *deleteAfter
+		deleteAfter = &t
+	}
+	s.deactivatedUsers[email] = deactivation{at: at, deleteAfter: deleteAfter}
+	return nil
+}
+
+func (s *Storage) ReactivateUser(_ context.Context, email string) error {
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+
+	delete(s.deactivatedUsers, email)
+	return nil
+}
+
+func (s *Storage) IsUserDeactivated(_ context.Context, email string) (bool, error) {
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+
+	_, ok := s.deactivatedUsers[email]
+	return ok, nil
+}
+
+func (s *Storage) ListScheduledUsers(_ context.Context, now time.Time) ([]string, error) {
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+
+	var res []string
+	for _, u := range s.users {
+		if d, ok := s.deactivatedUsers[u.Email]; ok && d.deleteAfter != nil && !d.deleteAfter.After(now) {
+			res = append(res, u.Email)
+		}
+	}
+	return res, nil
+}
+
+// DeleteScheduledUsers deletes the users with all their data, like the ON DELETE CASCADE of mysql.
+func (s *Storage) DeleteScheduledUsers(_ context.Context, now time.Time) (int64, error) {
+	s.usersMu.Lock()
+	deleted := make(map[string]bool)
+	users := s.users[:0]
+	for _, u := range s.users {
+		if d, ok := s.deactivatedUsers[u.Email]; ok && d.deleteAfter != nil && !d.deleteAfter.After(now) {
+			deleted[u.Email] = true
+			continue
+		}
+		users = append(users, u)
+	}
+	s.users = users
+	for email := range deleted {
+		delete(s.deactivatedUsers, email)
+		delete(s.passwords, email)
+		delete(s.hiddenBirthdays, email)
+		delete(s.listedUsers, email)
+		delete(s.profileVersions, email)
+	}
+	// The changes made by the deleted users, e.g. as admins, are kept without their author
+	for _, versions := range s.profileVersions {
+		for i := range versions {
+			if deleted[versions[i].Actor] {
+				versions[i].Actor = ""
+			}
+		}
+	}
+	s.usersMu.Unlock()
+
+	if len(deleted) > 0 {
+		s.deleteUserData(deleted)
+	}
+	return int64(len(deleted)), nil
+}
+
+// deleteUserData deletes the data of the deleted users in the other domains.
+func (s *Storage) deleteUserData(deleted map[string]bool) {
+	s.friendshipsMu.Lock()
+	friendships := s.friendships[:0]
+	for _, f := range s.friendships {
+		if !deleted[f.Email] && !deleted[f.FriendEmail] {
+			friendships = append(friendships, f)
+		}
+	}
+	s.friendships = friendships
+	s.friendshipsMu.Unlock()
+
+	s.friendListsMu.Lock()
+	lists := s.friendLists[:0]
+	for _, l := range s.friendLists {
+		if deleted[l.Owner] {
+			continue
+		}
+		l.Members = keepEmails(l.Members, deleted)
+		lists = append(lists, l)
+	}
+	s.friendLists = lists
+	s.friendListsMu.Unlock()
+
+	s.notificationsMu.Lock()
+	notifications := s.notifications[:0]
+	for _, n := range s.notifications {
+		if deleted[n.Email] {
+			continue
+		}
+		if deleted[n.Actor] {
+			n.Actor = ""
+		}
+		notifications = append(notifications, n)
+	}
+	s.notifications = notifications
+	for email := range deleted {
+		delete(s.notificationPreferences, email)
+	}
+	s.notificationsMu.Unlock()
+
+	s.conversationsMu.Lock()
+	for i, c := range s.conversations {
+		var participants []*conversation.Participant
+		for _, p := range c.Participants {
+			if !deleted[p.Email] {
+				participants = append(participants, p)
+			}
+		}
+		s.conversations[i].Participants = participants
+	}
+	messages := s.messages[:0]
+	for _, m := range s.messages {
+		if deleted[m.Sender] {
+			delete(s.hiddenMessages, m.ID)
+			continue
+		}
+		messages = append(messages, m)
+	}
+	s.messages = messages
+	for _, hidden := range s.hiddenMessages {
+		for email := range deleted {
+			delete(hidden, email)
+		}
+	}
+	s.conversationsMu.Unlock()
+
+	s.digestMu.Lock()
+	subscriptions := s.digestSubscriptions[:0]
+	for _, sub := range s.digestSubscriptions {
+		if !deleted[sub.Email] {
+			subscriptions = append(subscriptions, sub)
+		}
+	}
+	s.digestSubscriptions = subscriptions
+	snapshots := s.profileSnapshots[:0]
+	for _, snap := range s.profileSnapshots {
+		if !deleted[snap.Email] {
+			snapshots = append(snapshots, snap)
+		}
+	}
+	s.profileSnapshots = snapshots
+	changes := s.profileChanges[:0]
+	for _, c := range s.profileChanges {
+		if !deleted[c.Email] {
+			changes = append(changes, c)
+		}
+	}
+	s.profileChanges = changes
+	s.digestMu.Unlock()
+
+	s.meetupsMu.Lock()
+	meetups := s.meetups[:0]
+	for _, e := range s.meetups {
+		if deleted[e.Host] {
+			delete(s.meetupGuests, e.ID)
+			continue
+		}
+		meetups = append(meetups, e)
+	}
+	s.meetups = meetups
+	for id, guests := range s.meetupGuests {
+		kept := guests[:0]
+		for _, g := range guests {
+			if !deleted[g.Email] {
+				kept = append(kept, g)
+			}
+		}
+		s.meetupGuests[id] = kept
+	}
+	s.meetupsMu.Unlock()
+
+	s.groupsMu.Lock()
+	for id, members := range s.groupMembers {
+		kept := members[:0]
+		for _, m := range members {
+			if !deleted[m.Email] {
+				kept = append(kept, m)
+			}
+		}
+		s.groupMembers[id] = kept
+	}
+	for id, requests := range s.joinRequests {
+		kept := requests[:0]
+		for _, r := range requests {
+			if !deleted[r.Email] {
+				kept = append(kept, r)
+			}
+		}
+		s.joinRequests[id] = kept
+	}
+	posts := s.groupPosts[:0]
+	for _, p := range s.groupPosts {
+		if !deleted[p.Author] {
+			posts = append(posts, p)
+		}
+	}
+	s.groupPosts = posts
+	s.groupsMu.Unlock()
+
+	s.exportsMu.Lock()
+	exports := s.exports[:0]
+	for _, e := range s.exports {
+		if !deleted[e.Email] {
+			exports = append(exports, e)
+		}
+	}
+	s.exports = exports
+	s.exportsMu.Unlock()
+}
+
+func keepEmails(emails []string, deleted map[string]bool) []string {
+	var res []string
+	for _, e := range emails {
+		if !deleted[e] {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// deactivated returns the set of the deactivated users.
+func (s *Storage) deactivated() map[string]bool {
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+
+	res := make(map[string]bool, len(s.deactivatedUsers))
+	for email := range s.deactivatedUsers {
+		res[email] = true
+	}
+	return res
+}
@@ -0,0 +1,113 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/victornm/gtonline/internal/friend"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+func (s *Storage) ListFriendBirthdays(ctx context.Context, email string, from time.Time, days int) ([]*friend.Birthday, error) {
+	friendships, err := s.ListFriends(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	until := friend.Date(from).AddDate(0, 0, days)
+
+	var res []*friend.Birthday
+	for _, f := range friendships {
+		u, ok := s.sharedBirthday(f.FriendEmail)
+		if !ok {
+			continue
+		}
+
+		next := friend.NextBirthday(u.Birthdate, from)
+		if !next.Before(until) {
+			continue
+		}
+		res = append(res, &friend.Birthday{
+			Email:     u.Email,
+			FirstName: u.FirstName,
+			LastName:  u.LastName,
+			Birthdate: u.Birthdate,
+			Next:      next,
+		})
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if !res[i].Next.Equal(res[j].Next) {
+			return res[i].Next.Before(res[j].Next)
+		}
+		return res[i].Email < res[j].Email
+	})
+	return res, nil
+}
+
+func (s *Storage) ListBirthdayReminders(_ context.Context, on time.Time) ([]friend.BirthdayReminder, error) {
+	deactivated := s.deactivated()
+
+	s.friendshipsMu.Lock()
+	friendships := append([]friend.Friendship(nil), s.friendships...)
+	s.friendshipsMu.Unlock()
+
+	day := friend.Date(on)
+	celebrates := func(email string) bool {
+		u, ok := s.sharedBirthday(email)
+		return ok && friend.NextBirthday(u.Birthdate, day).Equal(day)
+	}
+
+	var res []friend.BirthdayReminder
+	for _, f := range friendships {
+		if f.DateConnected.IsZero() || deactivated[f.Email] || deactivated[f.FriendEmail] {
+			continue
+		}
+		if celebrates(f.FriendEmail) {
+			res = append(res, friend.BirthdayReminder{Email: f.Email, FriendEmail: f.FriendEmail})
+		}
+		if celebrates(f.Email) {
+			res = append(res, friend.BirthdayReminder{Email: f.FriendEmail, FriendEmail: f.Email})
+		}
+	}
+	return res, nil
+}
+
+// sharedBirthday returns the user of email if it has a birthdate, shares it and is not deactivated.
+func (s *Storage) sharedBirthday(email string) (*User, bool) {
+	u, err := s.getUser(email)
+	if err != nil || u.Birthdate.IsZero() {
+		return nil, false
+	}
+
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+	_, deactivated := s.deactivatedUsers[email]
+	return u, !s.hiddenBirthdays[email] && !deactivated
+}
+
+func (s *Storage) GetBirthdaySharing(_ context.Context, email string) (bool, error) {
+	if _, err := s.getUser(email); err != nil {
+		return false, err
+	}
+
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+	return !s.hiddenBirthdays[email], nil
+}
+
+func (s *Storage) UpdateBirthdaySharing(_ context.Context, email string, share bool) error {
+	if _, err := s.getUser(email); err != nil {
+		return storage.ErrNotFound
+	}
+
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+
+	if s.hiddenBirthdays == nil {
+		s.hiddenBirthdays = make(map[string]bool)
+	}
+	s.hiddenBirthdays[email] = !share
+	return nil
+}
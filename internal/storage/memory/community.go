@@ -0,0 +1,341 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/victornm/gtonline/internal/community"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+func (s *Storage) InsertGroup(_ context.Context, g *community.Group, owner *community.Member) error {
+	if _, err := s.getUser(owner.Email); err != nil {
+		return storage.ErrInvalidArgument
+	}
+
+	s.groupsMu.Lock()
+	defer s.groupsMu.Unlock()
+
+	if s.hasGroupName(g.Name, 0) {
+		return storage.ErrAlreadyExist
+	}
+
+	s.lastGroupID++
+	g.ID = s.lastGroupID
+	s.groups = append(s.groups, *g)
+
+	if s.groupMembers == nil {
+		s.groupMembers = make(map[int64][]community.Member)
+	}
+	s.groupMembers[g.ID] = []community.Member{*owner}
+	return nil
+}
+
+func (s *Storage) GetGroup(_ context.Context, id int64) (*community.Group, error) {
+	s.groupsMu.Lock()
+	defer s.groupsMu.Unlock()
+
+	for _, g := range s.groups {
+		if g.ID == id {
+			g.MemberCount = len(s.groupMembers[id])
+			return &g, nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (s *Storage) UpdateGroup(_ context.Context, g *community.Group) error {
+	s.groupsMu.Lock()
+	defer s.groupsMu.Unlock()
+
+	if s.hasGroupName(g.Name, g.ID) {
+		return storage.ErrAlreadyExist
+	}
+
+	for i, other := range s.groups {
+		if other.ID == g.ID {
+			s.groups[i] = *g
+			// Role and Requested are of the user who asked for the group
+			s.groups[i].Role, s.groups[i].Requested = "", false
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+func (s *Storage) DeleteGroup(_ context.Context, id int64) error {
+	s.groupsMu.Lock()
+	defer s.groupsMu.Unlock()
+
+	for i, g := range s.groups {
+		if g.ID == id {
+			s.groups = append(s.groups[:i], s.groups[i+1:]...)
+			delete(s.groupMembers, id)
+			delete(s.joinRequests, id)
+
+			posts := s.groupPosts[:0]
+			for _, p := range s.groupPosts {
+				if p.GroupID != id {
+					posts = append(posts, p)
+				}
+			}
+			s.groupPosts = posts
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+func (s *Storage) ListGroups(_ context.Context, filter community.GroupFilter) ([]*community.Group, error) {
+	s.groupsMu.Lock()
+	defer s.groupsMu.Unlock()
+
+	keys := make(map[string]bool, len(filter.TopicKeys))
+	for _, k := range filter.TopicKeys {
+		keys[k] = true
+	}
+
+	var res []*community.Group
+	for _, g := range s.groups {
+		if len(keys) > 0 && !keys[community.TopicKey(g.Topic)] {
+			continue
+		}
+		if filter.Exclude != "" && findMember(s.groupMembers[g.ID], filter.Exclude) != nil {
+			continue
+		}
+
+		if filter.Member != "" {
+			m := findMember(s.groupMembers[g.ID], filter.Member)
+			if m == nil {
+				continue
+			}
+			g.Role = m.Role
+		}
+
+		g.MemberCount = len(s.groupMembers[g.ID])
+		out := g
+		res = append(res, &out)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].MemberCount != res[j].MemberCount {
+			return res[i].MemberCount > res[j].MemberCount
+		}
+		return res[i].ID > res[j].ID
+	})
+	if len(res) > filter.Limit {
+		res = res[:filter.Limit]
+	}
+	return res, nil
+}
+
+func (s *Storage) GetGroupMember(_ context.Context, id int64, email string) (*community.Member, error) {
+	s.groupsMu.Lock()
+	defer s.groupsMu.Unlock()
+
+	if m := findMember(s.groupMembers[id], email); m != nil {
+		out := *m
+		return &out, nil
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (s *Storage) ListGroupMembers(_ context.Context, id int64) ([]*community.Member, error) {
+	deactivated := s.deactivated()
+
+	s.groupsMu.Lock()
+	defer s.groupsMu.Unlock()
+
+	var res []*community.Member
+	for _, m := range s.groupMembers[id] {
+		out := m
+		out.Deactivated = deactivated[m.Email]
+		res = append(res, &out)
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].JoinedAt.Before(res[j].JoinedAt)
+	})
+	return res, nil
+}
+
+func (s *Storage) SaveGroupMember(_ context.Context, id int64, m *community.Member) error {
+	if _, err := s.getUser(m.Email); err != nil {
+		return storage.ErrInvalidArgument
+	}
+
+	s.groupsMu.Lock()
+	defer s.groupsMu.Unlock()
+
+	if !s.hasGroup(id) {
+		return storage.ErrInvalidArgument
+	}
+
+	if other := findMember(s.groupMembers[id], m.Email); other != nil {
+		*other = *m
+		return nil
+	}
+	s.groupMembers[id] = append(s.groupMembers[id], *m)
+	return nil
+}
+
+func (s *Storage) DeleteGroupMember(_ context.Context, id int64, email string) error {
+	s.groupsMu.Lock()
+	defer s.groupsMu.Unlock()
+
+	members := s.groupMembers[id]
+	for i, m := range members {
+		if m.Email == email {
+			s.groupMembers[id] = append(members[:i], members[i+1:]...)
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+func (s *Storage) InsertJoinRequest(_ context.Context, id int64, r *community.JoinRequest) error {
+	if _, err := s.getUser(r.Email); err != nil {
+		return storage.ErrInvalidArgument
+	}
+
+	s.groupsMu.Lock()
+	defer s.groupsMu.Unlock()
+
+	if !s.hasGroup(id) {
+		return storage.ErrInvalidArgument
+	}
+
+	for _, other := range s.joinRequests[id] {
+		if other.Email == r.Email {
+			return storage.ErrAlreadyExist
+		}
+	}
+
+	if s.joinRequests == nil {
+		s.joinRequests = make(map[int64][]community.JoinRequest)
+	}
+	s.joinRequests[id] = append(s.joinRequests[id], *r)
+	return nil
+}
+
+func (s *Storage) ListJoinRequests(_ context.Context, id int64) ([]*community.JoinRequest, error) {
+	deactivated := s.deactivated()
+
+	s.groupsMu.Lock()
+	defer s.groupsMu.Unlock()
+
+	var res []*community.JoinRequest
+	for _, r := range s.joinRequests[id] {
+		out := r
+		out.Deactivated = deactivated[r.Email]
+		res = append(res, &out)
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].RequestedAt.Before(res[j].RequestedAt)
+	})
+	return res, nil
+}
+
+func (s *Storage) DeleteJoinRequest(_ context.Context, id int64, email string) error {
+	s.groupsMu.Lock()
+	defer s.groupsMu.Unlock()
+
+	requests := s.joinRequests[id]
+	for i, r := range requests {
+		if r.Email == email {
+			s.joinRequests[id] = append(requests[:i], requests[i+1:]...)
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+func (s *Storage) InsertGroupPost(_ context.Context, p *community.Post) error {
+	s.groupsMu.Lock()
+	defer s.groupsMu.Unlock()
+
+	s.lastPostID++
+	p.ID = s.lastPostID
+	s.groupPosts = append(s.groupPosts, *p)
+	return nil
+}
+
+func (s *Storage) GetGroupPost(_ context.Context, id int64) (*community.Post, error) {
+	s.groupsMu.Lock()
+	defer s.groupsMu.Unlock()
+
+	for _, p := range s.groupPosts {
+		if p.ID == id {
+			return &p, nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (s *Storage) ListGroupPosts(_ context.Context, req community.ListPostsRequest) ([]*community.Post, error) {
+	deactivated := s.deactivated()
+
+	s.groupsMu.Lock()
+	defer s.groupsMu.Unlock()
+
+	var res []*community.Post
+	// The posts are appended in the order of their ID
+	for i := len(s.groupPosts) - 1; i >= 0 && len(res) < req.Limit; i-- {
+		p := s.groupPosts[i]
+		if p.GroupID != req.GroupID || (req.Before > 0 && p.ID >= req.Before) || deactivated[p.Author] {
+			continue
+		}
+		res = append(res, &p)
+	}
+	return res, nil
+}
+
+func (s *Storage) DeleteGroupPost(_ context.Context, id int64) error {
+	s.groupsMu.Lock()
+	defer s.groupsMu.Unlock()
+
+	for i, p := range s.groupPosts {
+		if p.ID == id {
+			s.groupPosts = append(s.groupPosts[:i], s.groupPosts[i+1:]...)
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+// hasGroupName reports whether a group other than id has the name, the same as the case insensitive unique key of mysql.
+func (s *Storage) hasGroupName(name string, id int64) bool {
+	for _, g := range s.groups {
+		if g.ID != id && strings.EqualFold(g.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Storage) WithGroupLock(ctx context.Context, _ int64, f func(ctx context.Context, tx community.Storage) error) error {
+	s.groupLockMu.Lock()
+	defer s.groupLockMu.Unlock()
+
+	return f(ctx, s)
+}
+
+func (s *Storage) hasGroup(id int64) bool {
+	for _, g := range s.groups {
+		if g.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func findMember(members []community.Member, email string) *community.Member {
+	for i := range members {
+		if members[i].Email == email {
+			return &members[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/victornm/gtonline/internal/conversation"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+func (s *Storage) GetDirectConversation(_ context.Context, directKey string) (*conversation.Conversation, error) {
+	s.conversationsMu.Lock()
+	defer s.conversationsMu.Unlock()
+
+	for _, c := range s.conversations {
+		if c.DirectKey != "" && c.DirectKey == directKey {
+			return copyConversation(c), nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (s *Storage) InsertConversation(_ context.Context, c *conversation.Conversation) error {
+	s.conversationsMu.Lock()
+	defer s.conversationsMu.Unlock()
+
+	for _, other := range s.conversations {
+		if c.DirectKey != "" && other.DirectKey == c.DirectKey {
+			return storage.ErrAlreadyExist
+		}
+	}
+
+	s.lastConversationID++
+	c.ID = s.lastConversationID
+	s.conversations = append(s.conversations, *copyConversation(*c))
+	return nil
+}
+
+func (s *Storage) GetConversation(_ context.Context, id int64) (*conversation.Conversation, error) {
+	s.conversationsMu.Lock()
+	defer s.conversationsMu.Unlock()
+
+	for _, c := range s.conversations {
+		if c.ID == id {
+			return copyConversation(c), nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (s *Storage) ListConversations(_ context.Context, email string) ([]*conversation.Conversation, error) {
+	s.conversationsMu.Lock()
+	defer s.conversationsMu.Unlock()
+
+	var res []*conversation.Conversation
+	for _, c := range s.conversations {
+		me := findParticipant(c.Participants, email)
+		if me == nil {
+			continue
+		}
+
+		out := copyConversation(c)
+		for _, m := range s.visibleMessages(c.ID, me) {
+			if out.LastMessage == nil || m.ID > out.LastMessage.ID {
+				last := m
+				out.LastMessage = &last
+			}
+			if m.ID > me.LastReadMessageID && !strings.EqualFold(m.Sender, email) {
+				out.UnreadCount++
+			}
+		}
+
+		if out.LastMessage != nil {
+			res = append(res, out)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].LastMessage.ID > res[j].LastMessage.ID
+	})
+	return res, nil
+}
+
+func (s *Storage) ListGroupConversationIDs(_ context.Context, email string) ([]int64, error) {
+	s.conversationsMu.Lock()
+	defer s.conversationsMu.Unlock()
+
+	var res []int64
+	for _, c := range s.conversations {
+		if c.IsGroup() && findParticipant(c.Participants, email) != nil {
+			res = append(res, c.ID)
+		}
+	}
+	return res, nil
+}
+
+func (s *Storage) InsertMessage(_ context.Context, m *conversation.Message) error {
+	s.conversationsMu.Lock()
+	defer s.conversationsMu.Unlock()
+
+	s.lastMessageID++
+	m.ID = s.lastMessageID
+	s.messages = append(s.messages, *m)
+	return nil
+}
+
+func (s *Storage) GetMessage(_ context.Context, id int64) (*conversation.Message, error) {
+	s.conversationsMu.Lock()
+	defer s.conversationsMu.Unlock()
+
+	for _, m := range s.messages {
+		if m.ID == id {
+			out := m
+			return &out, nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (s *Storage) ListMessages(_ context.Context, req conversation.ListMessagesRequest) ([]*conversation.Message, error) {
+	s.conversationsMu.Lock()
+	defer s.conversationsMu.Unlock()
+
+	var me *conversation.Participant
+	for _, c := range s.conversations {
+		if c.ID == req.ConversationID {
+			me = findParticipant(c.Participants, req.Email)
+		}
+	}
+	if me == nil {
+		return nil, nil
+	}
+
+	var res []*conversation.Message
+	for _, m := range s.visibleMessages(req.ConversationID, me) {
+		if req.Before > 0 && m.ID >= req.Before {
+			continue
+		}
+		out := m
+		res = append(res, &out)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID > res[j].ID
+	})
+
+	if len(res) > req.Limit {
+		res = res[:req.Limit]
+	}
+	return res, nil
+}
+
+func (s *Storage) UpdateParticipant(_ context.Context, conversationID int64, p *conversation.Participant) error {
+	s.conversationsMu.Lock()
+	defer s.conversationsMu.Unlock()
+
+	for _, c := range s.conversations {
+		if c.ID != conversationID {
+			continue
+		}
+
+		if me := findParticipant(c.Participants, p.Email); me != nil {
+			*me = *p
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+func (s *Storage) HideMessage(_ context.Context, messageID int64, email string) error {
+	s.conversationsMu.Lock()
+	defer s.conversationsMu.Unlock()
+
+	if s.hiddenMessages == nil {
+		s.hiddenMessages = make(map[int64]map[string]bool)
+	}
+	if s.hiddenMessages[messageID] == nil {
+		s.hiddenMessages[messageID] = make(map[string]bool)
+	}
+	s.hiddenMessages[messageID][strings.ToLower(email)] = true
+	return nil
+}
+
+// visibleMessages must be called with conversationsMu held.
+func (s *Storage) visibleMessages(conversationID int64, p *conversation.Participant) []conversation.Message {
+	var res []conversation.Message
+	for _, m := range s.messages {
+		if m.ConversationID != conversationID || m.ID <= p.ClearedMessageID || s.hiddenMessages[m.ID][strings.ToLower(p.Email)] {
+			continue
+		}
+		res = append(res, m)
+	}
+	return res
+}
+
+func findParticipant(participants []*conversation.Participant, email string) *conversation.Participant {
+	for _, p := range participants {
+		if strings.EqualFold(p.Email, email) {
+			return p
+		}
+	}
+	return nil
+}
+
+// copyConversation copies the participants too, so the caller can't modify the stored ones.
+func copyConversation(c conversation.Conversation) *conversation.Conversation {
+	out := c
+	out.Participants = make([]*conversation.Participant, 0, len(c.Participants))
+	for _, p := range c.Participants {
+		cp := *p
+		out.Participants = append(out.Participants, &cp)
+	}
+	return &out
+}
+
+func (s *Storage) UpdateConversation(_ context.Context, c *conversation.Conversation) error {
+	s.conversationsMu.Lock()
+	defer s.conversationsMu.Unlock()
+
+	for i := range s.conversations {
+		if s.conversations[i].ID == c.ID {
+			s.conversations[i].Name = c.Name
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+func (s *Storage) InsertParticipant(_ context.Context, conversationID int64, p *conversation.Participant) error {
+	s.conversationsMu.Lock()
+	defer s.conversationsMu.Unlock()
+
+	for i := range s.conversations {
+		c := &s.conversations[i]
+		if c.ID != conversationID {
+			continue
+		}
+
+		if findParticipant(c.Participants, p.Email) != nil {
+			return storage.ErrAlreadyExist
+		}
+		cp := *p
+		c.Participants = append(c.Participants, &cp)
+		return nil
+	}
+	return storage.ErrInvalidArgument
+}
+
+func (s *Storage) DeleteParticipant(_ context.Context, conversationID int64, email string) error {
+	s.conversationsMu.Lock()
+	defer s.conversationsMu.Unlock()
+
+	for i := range s.conversations {
+		c := &s.conversations[i]
+		if c.ID != conversationID {
+			continue
+		}
+
+		var participants []*conversation.Participant
+		for _, p := range c.Participants {
+			if !strings.EqualFold(p.Email, email) {
+				participants = append(participants, p)
+			}
+		}
+		c.Participants = participants
+	}
+	return nil
+}
+
+func (s *Storage) WithConversationLock(ctx context.Context, _ int64, f func(ctx context.Context, tx conversation.Storage) error) error {
+	s.conversationLockMu.Lock()
+	defer s.conversationLockMu.Unlock()
+
+	return f(ctx, s)
+}
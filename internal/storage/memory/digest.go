@@ -0,0 +1,130 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/victornm/gtonline/internal/digest"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+func (s *Storage) GetDigestSubscription(_ context.Context, email string) (*digest.Subscription, error) {
+	s.digestMu.Lock()
+	defer s.digestMu.Unlock()
+
+	for _, sub := range s.digestSubscriptions {
+		if sub.Email == email {
+			out := sub
+			return &out, nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (s *Storage) SaveDigestSubscription(_ context.Context, sub *digest.Subscription) error {
+	if _, err := s.getUser(sub.Email); err != nil {
+		return storage.ErrInvalidArgument
+	}
+
+	s.digestMu.Lock()
+	defer s.digestMu.Unlock()
+
+	for i, other := range s.digestSubscriptions {
+		if other.Email == sub.Email {
+			s.digestSubscriptions[i] = *sub
+			return nil
+		}
+	}
+	s.digestSubscriptions = append(s.digestSubscriptions, *sub)
+	return nil
+}
+
+func (s *Storage) ListDigestSubscriptions(_ context.Context) ([]*digest.Subscription, error) {
+	deactivated := s.deactivated()
+
+	s.digestMu.Lock()
+	defer s.digestMu.Unlock()
+
+	var res []*digest.Subscription
+	for _, sub := range s.digestSubscriptions {
+		if sub.Frequency != digest.Off && !deactivated[sub.Email] {
+			out := sub
+			res = append(res, &out)
+		}
+	}
+	return res, nil
+}
+
+func (s *Storage) GetProfileSnapshot(_ context.Context, email string) (*digest.Snapshot, error) {
+	s.digestMu.Lock()
+	defer s.digestMu.Unlock()
+
+	for _, snap := range s.profileSnapshots {
+		if snap.Email == email {
+			out := snap
+			out.Employers = append([]string(nil), snap.Employers...)
+			return &out, nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (s *Storage) SaveProfileSnapshot(_ context.Context, snap *digest.Snapshot, changes []*digest.Change) error {
+	s.digestMu.Lock()
+	defer s.digestMu.Unlock()
+
+	for _, c := range changes {
+		s.lastChangeID++
+		c.ID = s.lastChangeID
+		s.profileChanges = append(s.profileChanges, *c)
+	}
+
+	saved := *snap
+	saved.Employers = append([]string(nil), snap.Employers...)
+	for i, other := range s.profileSnapshots {
+		if other.Email == snap.Email {
+			s.profileSnapshots[i] = saved
+			return nil
+		}
+	}
+	s.profileSnapshots = append(s.profileSnapshots, saved)
+	return nil
+}
+
+func (s *Storage) ListProfileChanges(_ context.Context, emails []string, since time.Time) ([]*digest.Change, error) {
+	s.digestMu.Lock()
+	defer s.digestMu.Unlock()
+
+	in := make(map[string]bool, len(emails))
+	for _, e := range emails {
+		in[e] = true
+	}
+
+	var res []*digest.Change
+	for _, c := range s.profileChanges {
+		if in[c.Email] && !c.ChangedAt.Before(since) {
+			out := c
+			res = append(res, &out)
+		}
+	}
+	return res, nil
+}
+
+func (s *Storage) DeleteProfileChanges(_ context.Context, before time.Time) (int64, error) {
+	s.digestMu.Lock()
+	defer s.digestMu.Unlock()
+
+	var (
+		kept    []digest.Change
+		deleted int64
+	)
+	for _, c := range s.profileChanges {
+		if c.ChangedAt.Before(before) {
+			deleted++
+			continue
+		}
+		kept = append(kept, c)
+	}
+	s.profileChanges = kept
+	return deleted, nil
+}
@@ -0,0 +1,237 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/victornm/gtonline/internal/profile"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+func (s *Storage) InsertSchools(schools ...profile.School) {
+	s.usersMu.Lock()
+	s.schools = append(s.schools, schools...)
+	s.usersMu.Unlock()
+}
+
+func (s *Storage) InsertEmployers(employers ...profile.Employer) {
+	s.usersMu.Lock()
+	s.employers = append(s.employers, employers...)
+	s.usersMu.Unlock()
+}
+
+func (s *Storage) ListSchools(context.Context) ([]profile.School, error) {
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+
+	res := make([]profile.School, 0, len(s.schools))
+	for _, school := range s.schools {
+		school.Alumni = 0
+		for _, u := range s.users {
+			if s.isListed(u.Email) && attended(u, school.SchoolName) {
+				school.Alumni++
+			}
+		}
+		res = append(res, school)
+	}
+	return res, nil
+}
+
+func (s *Storage) ListEmployers(context.Context) ([]profile.Employer, error) {
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+
+	res := make([]profile.Employer, 0, len(s.employers))
+	for _, e := range s.employers {
+		e.People = 0
+		for _, u := range s.users {
+			if s.isListed(u.Email) && employed(u, e.EmployerName) {
+				e.People++
+			}
+		}
+		res = append(res, e)
+	}
+	return res, nil
+}
+
+func (s *Storage) GetDirectoryListing(_ context.Context, email string) (bool, error) {
+	if _, err := s.getUser(email); err != nil {
+		return false, err
+	}
+
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+	return s.listedUsers[email], nil
+}
+
+func (s *Storage) UpdateDirectoryListing(_ context.Context, email string, listed bool) error {
+	if _, err := s.getUser(email); err != nil {
+		return storage.ErrNotFound
+	}
+
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+
+	if s.listedUsers == nil {
+		s.listedUsers = make(map[string]bool)
+	}
+	s.listedUsers[email] = listed
+	return nil
+}
+
+func (s *Storage) ListAlumni(_ context.Context, req profile.ListAlumniRequest) ([]*profile.Alumnus, error) {
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+
+	if !s.hasSchool(req.School) {
+		return nil, storage.ErrNotFound
+	}
+
+	var res []*profile.Alumnus
+	for _, u := range s.users {
+		if !s.isListed(u.Email) {
+			continue
+		}
+
+		// An alumnus with several degrees of the school is in the cohort of the latest one
+		var latest *profile.Alumnus
+		for _, a := range u.Education {
+			year := a.YearGraduated
+			if !strings.EqualFold(a.School, req.School) ||
+				(req.YearFrom != 0 && (year == 0 || year < req.YearFrom)) ||
+				(req.YearTo != 0 && (year == 0 || year > req.YearTo)) {
+				continue
+			}
+			if latest == nil {
+				latest = &profile.Alumnus{Person: person(u), YearGraduated: year}
+			} else if year > latest.YearGraduated {
+				latest.YearGraduated = year
+			}
+		}
+		if latest != nil {
+			res = append(res, latest)
+		}
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+		if a.YearGraduated != b.YearGraduated {
+			// The unknown year, 0, is last
+			return b.YearGraduated == 0 || (a.YearGraduated != 0 && a.YearGraduated > b.YearGraduated)
+		}
+		return lessPerson(a.Person, b.Person)
+	})
+	return res, nil
+}
+
+func (s *Storage) ListCoworkers(_ context.Context, employer string) ([]*profile.Coworker, error) {
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+
+	if !s.hasEmployer(employer) {
+		return nil, storage.ErrNotFound
+	}
+
+	var res []*profile.Coworker
+	for _, u := range s.users {
+		if !s.isListed(u.Email) {
+			continue
+		}
+
+		// A coworker with several jobs at the employer is under the current one, else the latest started
+		var latest *profile.Employment
+		for i, e := range u.Professional {
+			if !strings.EqualFold(e.Employer, employer) || !isCurrent(e) {
+				continue
+			}
+			if latest == nil || laterJob(e, *latest) {
+				latest = &u.Professional[i]
+			}
+		}
+		if latest != nil {
+			res = append(res, &profile.Coworker{Person: person(u), JobTitle: latest.JobTitle})
+		}
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+		if a.JobTitle != b.JobTitle {
+			return a.JobTitle < b.JobTitle
+		}
+		return lessPerson(a.Person, b.Person)
+	})
+	return res, nil
+}
+
+// isListed tells if the user of email is in the directories, usersMu must be held.
+func (s *Storage) isListed(email string) bool {
+	_, deactivated := s.deactivatedUsers[email]
+	return s.listedUsers[email] && !deactivated
+}
+
+func (s *Storage) hasSchool(name string) bool {
+	for _, school := range s.schools {
+		if strings.EqualFold(school.SchoolName, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Storage) hasEmployer(name string) bool {
+	for _, e := range s.employers {
+		if strings.EqualFold(e.EmployerName, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func attended(u User, school string) bool {
+	for _, a := range u.Education {
+		if strings.EqualFold(a.School, school) {
+			return true
+		}
+	}
+	return false
+}
+
+func employed(u User, employer string) bool {
+	for _, e := range u.Professional {
+		if strings.EqualFold(e.Employer, employer) && isCurrent(e) {
+			return true
+		}
+	}
+	return false
+}
+
+// isCurrent tells if the job isn't ended, a job without an end date is current even if IsCurrent isn't set.
+func isCurrent(e profile.Employment) bool {
+	return e.IsCurrent || e.EndDate.IsZero()
+}
+
+// laterJob tells if the job a is listed before b: the current one, then the latest started, then by job title.
+func laterJob(a, b profile.Employment) bool {
+	if a.IsCurrent != b.IsCurrent {
+		return a.IsCurrent
+	}
+	if !a.StartDate.Equal(b.StartDate) {
+		return a.StartDate.After(b.StartDate)
+	}
+	return a.JobTitle < b.JobTitle
+}
+
+func person(u User) profile.Person {
+	return profile.Person{Email: u.Email, FirstName: u.FirstName, LastName: u.LastName}
+}
+
+func lessPerson(a, b profile.Person) bool {
+	if a.LastName != b.LastName {
+		return a.LastName < b.LastName
+	}
+	if a.FirstName != b.FirstName {
+		return a.FirstName < b.FirstName
+	}
+	return a.Email < b.Email
+}
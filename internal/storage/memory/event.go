@@ -0,0 +1,114 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/victornm/gtonline/internal/event"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+// AppendEvents appends the events to the outbox, there is no transaction to join in memory.
+func (s *Storage) AppendEvents(_ context.Context, events ...event.Event) error {
+	s.outboxMu.Lock()
+	defer s.outboxMu.Unlock()
+
+	now := time.Now()
+	for _, e := range events {
+		r, err := event.NewRecord(e, now)
+		if err != nil {
+			return err
+		}
+
+		s.lastEventID++
+		r.ID = s.lastEventID
+		s.outbox = append(s.outbox, *r)
+	}
+	return nil
+}
+
+// Events returns the events of the outbox, the oldest first.
+func (s *Storage) Events() []event.Record {
+	s.outboxMu.Lock()
+	defer s.outboxMu.Unlock()
+
+	return append([]event.Record(nil), s.outbox...)
+}
+
+func (s *Storage) ClaimEvents(_ context.Context, now, leaseUntil time.Time, limit int) ([]*event.Record, error) {
+	s.outboxMu.Lock()
+	defer s.outboxMu.Unlock()
+
+	var res []*event.Record
+	for i := range s.outbox {
+		r := &s.outbox[i]
+		if len(res) >= limit {
+			break
+		}
+		if r.DispatchedAt != nil || r.NextAttemptAt.After(now) {
+			continue
+		}
+
+		r.NextAttemptAt = leaseUntil
+		out := *r
+		res = append(res, &out)
+	}
+	return res, nil
+}
+
+func (s *Storage) UpdateEvent(_ context.Context, r *event.Record) error {
+	s.outboxMu.Lock()
+	defer s.outboxMu.Unlock()
+
+	for i := range s.outbox {
+		if s.outbox[i].ID == r.ID {
+			s.outbox[i] = *r
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+func (s *Storage) DeleteDispatchedEvents(_ context.Context, before time.Time) (int64, error) {
+	s.outboxMu.Lock()
+	defer s.outboxMu.Unlock()
+
+	var (
+		kept []event.Record
+		n    int64
+	)
+	for _, r := range s.outbox {
+		if r.DispatchedAt != nil && r.DispatchedAt.Before(before) {
+			delete(s.eventHandlers, r.ID)
+			n++
+			continue
+		}
+		kept = append(kept, r)
+	}
+	s.outbox = kept
+	return n, nil
+}
+
+func (s *Storage) ListEventHandlers(_ context.Context, id int64) ([]string, error) {
+	s.outboxMu.Lock()
+	defer s.outboxMu.Unlock()
+
+	return append([]string(nil), s.eventHandlers[id]...), nil
+}
+
+func (s *Storage) InsertEventHandler(_ context.Context, id int64, subscriber string) error {
+	s.outboxMu.Lock()
+	defer s.outboxMu.Unlock()
+
+	for _, h := range s.eventHandlers[id] {
+		if h == subscriber {
+			return nil
+		}
+	}
+
+	if s.eventHandlers == nil {
+		s.eventHandlers = make(map[int64][]string)
+	}
+	s.eventHandlers[id] = append(s.eventHandlers[id], subscriber)
+	return nil
+}
@@ -0,0 +1,195 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/victornm/gtonline/internal/community"
+	"github.com/victornm/gtonline/internal/conversation"
+	"github.com/victornm/gtonline/internal/export"
+	"github.com/victornm/gtonline/internal/friend"
+	"github.com/victornm/gtonline/internal/meetup"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+func (s *Storage) InsertExport(_ context.Context, e *export.Export, since time.Time) error {
+	s.exportsMu.Lock()
+	defer s.exportsMu.Unlock()
+
+	for _, other := range s.exports {
+		if other.Email == e.Email && other.Status != export.StatusFailed && other.CreatedAt.After(since) {
+			return storage.ErrAlreadyExist
+		}
+	}
+
+	s.lastExportID++
+	e.ID = s.lastExportID
+	s.exports = append(s.exports, copyExport(*e))
+	return nil
+}
+
+func (s *Storage) GetExport(_ context.Context, id int64) (*export.Export, error) {
+	s.exportsMu.Lock()
+	defer s.exportsMu.Unlock()
+
+	for _, e := range s.exports {
+		if e.ID == id {
+			out := copyExport(e)
+			return &out, nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (s *Storage) ListExports(_ context.Context, email string, limit int) ([]*export.Export, error) {
+	s.exportsMu.Lock()
+	defer s.exportsMu.Unlock()
+
+	var res []*export.Export
+	for _, e := range s.exports {
+		if e.Email == email {
+			out := copyExport(e)
+			res = append(res, &out)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID > res[j].ID
+	})
+	if len(res) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+func (s *Storage) UpdateExport(_ context.Context, e *export.Export) error {
+	s.exportsMu.Lock()
+	defer s.exportsMu.Unlock()
+
+	for i := range s.exports {
+		if s.exports[i].ID == e.ID {
+			s.exports[i].Status = e.Status
+			s.exports[i].ExpiresAt = copyExport(*e).ExpiresAt
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+func (s *Storage) ListExpiredExports(_ context.Context, now time.Time) ([]*export.Export, error) {
+	s.exportsMu.Lock()
+	defer s.exportsMu.Unlock()
+
+	var res []*export.Export
+	for _, e := range s.exports {
+		if e.Status == export.StatusReady && e.ExpiresAt != nil && !e.ExpiresAt.After(now) {
+			out := copyExport(e)
+			res = append(res, &out)
+		}
+	}
+	return res, nil
+}
+
+func copyExport(e export.Export) export.Export {
+	if e.ExpiresAt != nil {
+		t := *e.ExpiresAt
+		e.ExpiresAt = &t
+	}
+	return e
+}
+
+// The reads below gather the data of a user for its archive, the deactivated users it refers to included.
+
+func (s *Storage) ListAllFriendships(_ context.Context, email string) ([]*friend.Friendship, error) {
+	s.friendshipsMu.Lock()
+	defer s.friendshipsMu.Unlock()
+
+	var res []*friend.Friendship
+	for _, f := range s.friendships {
+		if f.Email == email || f.FriendEmail == email {
+			out := f
+			res = append(res, &out)
+		}
+	}
+	return res, nil
+}
+
+func (s *Storage) ListSentMessages(_ context.Context, email string) ([]*conversation.Message, error) {
+	s.conversationsMu.Lock()
+	defer s.conversationsMu.Unlock()
+
+	var res []*conversation.Message
+	for _, m := range s.messages {
+		if m.Sender == email {
+			out := m
+			res = append(res, &out)
+		}
+	}
+	return res, nil
+}
+
+func (s *Storage) ListMemberGroups(_ context.Context, email string) ([]*community.Group, error) {
+	s.groupsMu.Lock()
+	defer s.groupsMu.Unlock()
+
+	var (
+		res    []*community.Group
+		joined = make(map[int64]time.Time)
+	)
+	for _, g := range s.groups {
+		m := findMember(s.groupMembers[g.ID], email)
+		if m == nil {
+			continue
+		}
+
+		out := g
+		out.Role = m.Role
+		out.MemberCount = len(s.groupMembers[g.ID])
+		joined[g.ID] = m.JoinedAt
+		res = append(res, &out)
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		return joined[res[i].ID].Before(joined[res[j].ID])
+	})
+	return res, nil
+}
+
+func (s *Storage) ListAuthoredPosts(_ context.Context, email string) ([]*community.Post, error) {
+	s.groupsMu.Lock()
+	defer s.groupsMu.Unlock()
+
+	var res []*community.Post
+	for _, p := range s.groupPosts {
+		if p.Author == email {
+			out := p
+			res = append(res, &out)
+		}
+	}
+	return res, nil
+}
+
+func (s *Storage) ListUserMeetups(_ context.Context, email string) ([]*meetup.Event, error) {
+	s.meetupsMu.Lock()
+	defer s.meetupsMu.Unlock()
+
+	var res []*meetup.Event
+	for _, e := range s.meetups {
+		out := s.countGuests(e)
+		if g := findGuest(s.meetupGuests[e.ID], email); g != nil {
+			out.RSVP = g.Status
+		} else if e.Host != email {
+			continue
+		}
+		res = append(res, out)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if !res[i].StartsAt.Equal(res[j].StartsAt) {
+			return res[i].StartsAt.Before(res[j].StartsAt)
+		}
+		return res[i].ID < res[j].ID
+	})
+	return res, nil
+}
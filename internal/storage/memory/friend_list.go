@@ -0,0 +1,157 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/victornm/gtonline/internal/friend"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+func (s *Storage) ListFriendLists(_ context.Context, owner string) ([]*friend.List, error) {
+	s.friendListsMu.Lock()
+	defer s.friendListsMu.Unlock()
+
+	var res []*friend.List
+	for _, l := range s.friendLists {
+		if l.Owner == owner {
+			res = append(res, copyList(l))
+		}
+	}
+	return res, nil
+}
+
+func (s *Storage) GetFriendList(_ context.Context, id int64) (*friend.List, error) {
+	s.friendListsMu.Lock()
+	defer s.friendListsMu.Unlock()
+
+	i := s.findList(id)
+	if i < 0 {
+		return nil, storage.ErrNotFound
+	}
+	return copyList(s.friendLists[i]), nil
+}
+
+func (s *Storage) InsertFriendList(_ context.Context, l *friend.List) error {
+	if _, err := s.getUser(l.Owner); err != nil {
+		return storage.ErrInvalidArgument
+	}
+
+	s.friendListsMu.Lock()
+	defer s.friendListsMu.Unlock()
+
+	for _, l1 := range s.friendLists {
+		if l1.Owner == l.Owner && l1.Name == l.Name {
+			return storage.ErrAlreadyExist
+		}
+	}
+
+	s.lastListID++
+	l.ID = s.lastListID
+	s.friendLists = append(s.friendLists, *copyList(*l))
+	return nil
+}
+
+func (s *Storage) UpdateFriendList(_ context.Context, l *friend.List) error {
+	s.friendListsMu.Lock()
+	defer s.friendListsMu.Unlock()
+
+	i := s.findList(l.ID)
+	if i < 0 {
+		return storage.ErrNotFound
+	}
+
+	for _, l1 := range s.friendLists {
+		if l1.ID != l.ID && l1.Owner == s.friendLists[i].Owner && l1.Name == l.Name {
+			return storage.ErrAlreadyExist
+		}
+	}
+
+	s.friendLists[i].Name = l.Name
+	return nil
+}
+
+func (s *Storage) DeleteFriendList(_ context.Context, id int64) error {
+	s.friendListsMu.Lock()
+	defer s.friendListsMu.Unlock()
+
+	if i := s.findList(id); i >= 0 {
+		s.friendLists = append(s.friendLists[:i], s.friendLists[i+1:]...)
+	}
+	return nil
+}
+
+func (s *Storage) InsertFriendListMember(_ context.Context, id int64, email string) error {
+	if _, err := s.getUser(email); err != nil {
+		return storage.ErrInvalidArgument
+	}
+
+	s.friendListsMu.Lock()
+	defer s.friendListsMu.Unlock()
+
+	i := s.findList(id)
+	if i < 0 {
+		return storage.ErrInvalidArgument
+	}
+
+	for _, m := range s.friendLists[i].Members {
+		if m == email {
+			return storage.ErrAlreadyExist
+		}
+	}
+
+	s.friendLists[i].Members = append(s.friendLists[i].Members, email)
+	return nil
+}
+
+func (s *Storage) DeleteFriendListMember(_ context.Context, id int64, email string) error {
+	s.friendListsMu.Lock()
+	defer s.friendListsMu.Unlock()
+
+	i := s.findList(id)
+	if i < 0 {
+		return nil
+	}
+
+	members := s.friendLists[i].Members[:0]
+	for _, m := range s.friendLists[i].Members {
+		if m != email {
+			members = append(members, m)
+		}
+	}
+	s.friendLists[i].Members = members
+	return nil
+}
+
+func (s *Storage) IsFriendListMember(_ context.Context, owner string, ids []int64, email string) (bool, error) {
+	s.friendListsMu.Lock()
+	defer s.friendListsMu.Unlock()
+
+	for _, id := range ids {
+		i := s.findList(id)
+		if i < 0 || s.friendLists[i].Owner != owner {
+			continue
+		}
+
+		for _, m := range s.friendLists[i].Members {
+			if m == email {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// findList must be called with friendListsMu held.
+func (s *Storage) findList(id int64) int {
+	for i, l := range s.friendLists {
+		if l.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func copyList(l friend.List) *friend.List {
+	l.Members = append([]string(nil), l.Members...)
+	return &l
+}
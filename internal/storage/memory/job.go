@@ -0,0 +1,89 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/victornm/gtonline/internal/job"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+func (s *Storage) InsertJob(_ context.Context, j *job.Job) error {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+
+	if j.Key != "" {
+		for _, other := range s.jobs {
+			if other.Key == j.Key {
+				return storage.ErrAlreadyExist
+			}
+		}
+	}
+
+	s.lastJobID++
+	j.ID = s.lastJobID
+	s.jobs = append(s.jobs, *j)
+	return nil
+}
+
+func (s *Storage) ClaimJobs(_ context.Context, jobType string, now, leaseUntil time.Time, limit int) ([]*job.Job, error) {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+
+	var res []*job.Job
+	for i := range s.jobs {
+		j := &s.jobs[i]
+		if len(res) >= limit {
+			break
+		}
+		if j.Type != jobType || j.Status != job.StatusPending || j.RunAt.After(now) {
+			continue
+		}
+
+		j.Attempts++
+		j.RunAt = leaseUntil
+		out := *j
+		res = append(res, &out)
+	}
+	return res, nil
+}
+
+func (s *Storage) UpdateJob(_ context.Context, j *job.Job) error {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+
+	for i := range s.jobs {
+		if s.jobs[i].ID == j.ID {
+			s.jobs[i] = *j
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+func (s *Storage) DeleteFinishedJobs(_ context.Context, before time.Time) (int64, error) {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+
+	var (
+		kept []job.Job
+		n    int64
+	)
+	for _, j := range s.jobs {
+		if j.FinishedAt != nil && j.FinishedAt.Before(before) {
+			n++
+			continue
+		}
+		kept = append(kept, j)
+	}
+	s.jobs = kept
+	return n, nil
+}
+
+// Jobs returns the jobs of the queue, the oldest first.
+func (s *Storage) Jobs() []job.Job {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+
+	return append([]job.Job(nil), s.jobs...)
+}
@@ -0,0 +1,205 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/victornm/gtonline/internal/meetup"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+func (s *Storage) InsertMeetup(_ context.Context, e *meetup.Event) error {
+	if _, err := s.getUser(e.Host); err != nil {
+		return storage.ErrInvalidArgument
+	}
+
+	s.meetupsMu.Lock()
+	defer s.meetupsMu.Unlock()
+
+	s.lastMeetupID++
+	e.ID = s.lastMeetupID
+	s.meetups = append(s.meetups, *e)
+	return nil
+}
+
+func (s *Storage) GetMeetup(_ context.Context, id int64) (*meetup.Event, error) {
+	s.meetupsMu.Lock()
+	defer s.meetupsMu.Unlock()
+
+	for _, e := range s.meetups {
+		if e.ID == id {
+			return s.countGuests(e), nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (s *Storage) UpdateMeetup(_ context.Context, e *meetup.Event) error {
+	s.meetupsMu.Lock()
+	defer s.meetupsMu.Unlock()
+
+	for i, other := range s.meetups {
+		if other.ID == e.ID {
+			s.meetups[i] = *e
+			// The RSVP is of the user who asked for the event
+			s.meetups[i].RSVP = ""
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+func (s *Storage) DeleteMeetup(_ context.Context, id int64) error {
+	s.meetupsMu.Lock()
+	defer s.meetupsMu.Unlock()
+
+	for i, e := range s.meetups {
+		if e.ID == id {
+			s.meetups = append(s.meetups[:i], s.meetups[i+1:]...)
+			delete(s.meetupGuests, id)
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+func (s *Storage) ListUpcomingMeetups(_ context.Context, email string, from time.Time, limit int) ([]*meetup.Event, error) {
+	s.meetupsMu.Lock()
+	defer s.meetupsMu.Unlock()
+
+	var res []*meetup.Event
+	for _, e := range s.meetups {
+		if !e.EndsAt.After(from) {
+			continue
+		}
+
+		out := s.countGuests(e)
+		if e.Host != email {
+			g := findGuest(s.meetupGuests[e.ID], email)
+			if g == nil || g.Status == meetup.No {
+				continue
+			}
+			out.RSVP = g.Status
+		}
+		res = append(res, out)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if !res[i].StartsAt.Equal(res[j].StartsAt) {
+			return res[i].StartsAt.Before(res[j].StartsAt)
+		}
+		return res[i].ID < res[j].ID
+	})
+	if len(res) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+func (s *Storage) ListGuests(_ context.Context, id int64) ([]*meetup.Guest, error) {
+	deactivated := s.deactivated()
+
+	s.meetupsMu.Lock()
+	defer s.meetupsMu.Unlock()
+
+	var res []*meetup.Guest
+	for _, g := range s.meetupGuests[id] {
+		out := g
+		out.Deactivated = deactivated[g.Email]
+		res = append(res, &out)
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].UpdatedAt.Before(res[j].UpdatedAt)
+	})
+	return res, nil
+}
+
+func (s *Storage) GetGuest(_ context.Context, id int64, email string) (*meetup.Guest, error) {
+	s.meetupsMu.Lock()
+	defer s.meetupsMu.Unlock()
+
+	if g := findGuest(s.meetupGuests[id], email); g != nil {
+		out := *g
+		return &out, nil
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (s *Storage) SaveGuest(_ context.Context, id int64, g *meetup.Guest) error {
+	if _, err := s.getUser(g.Email); err != nil {
+		return storage.ErrInvalidArgument
+	}
+
+	s.meetupsMu.Lock()
+	defer s.meetupsMu.Unlock()
+
+	if !s.hasMeetup(id) {
+		return storage.ErrInvalidArgument
+	}
+
+	if other := findGuest(s.meetupGuests[id], g.Email); other != nil {
+		*other = *g
+		return nil
+	}
+
+	if s.meetupGuests == nil {
+		s.meetupGuests = make(map[int64][]meetup.Guest)
+	}
+	s.meetupGuests[id] = append(s.meetupGuests[id], *g)
+	return nil
+}
+
+func (s *Storage) DeleteGuest(_ context.Context, id int64, email string) error {
+	s.meetupsMu.Lock()
+	defer s.meetupsMu.Unlock()
+
+	guests := s.meetupGuests[id]
+	for i, g := range guests {
+		if g.Email == email {
+			s.meetupGuests[id] = append(guests[:i], guests[i+1:]...)
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+func (s *Storage) WithMeetupLock(ctx context.Context, _ int64, f func(ctx context.Context, tx meetup.Storage) error) error {
+	s.meetupLockMu.Lock()
+	defer s.meetupLockMu.Unlock()
+
+	return f(ctx, s)
+}
+
+// countGuests returns a copy of e with Going and Waitlisted counted, meetupsMu must be held.
+func (s *Storage) countGuests(e meetup.Event) *meetup.Event {
+	e.Going, e.Waitlisted = 0, 0
+	for _, g := range s.meetupGuests[e.ID] {
+		switch g.Status {
+		case meetup.Yes:
+			e.Going++
+		case meetup.Waitlisted:
+			e.Waitlisted++
+		}
+	}
+	return &e
+}
+
+func (s *Storage) hasMeetup(id int64) bool {
+	for _, e := range s.meetups {
+		if e.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func findGuest(guests []meetup.Guest, email string) *meetup.Guest {
+	for i := range guests {
+		if guests[i].Email == email {
+			return &guests[i]
+		}
+	}
+	return nil
+}
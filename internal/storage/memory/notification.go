@@ -0,0 +1,119 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/victornm/gtonline/internal/notification"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+func (s *Storage) InsertNotification(_ context.Context, n *notification.Notification) error {
+	s.notificationsMu.Lock()
+	defer s.notificationsMu.Unlock()
+
+	s.lastNotificationID++
+	n.ID = s.lastNotificationID
+	s.notifications = append(s.notifications, *n)
+	return nil
+}
+
+func (s *Storage) ListNotifications(_ context.Context, req notification.ListRequest) ([]*notification.Notification, error) {
+	s.notificationsMu.Lock()
+	defer s.notificationsMu.Unlock()
+
+	var res []*notification.Notification
+	for _, n := range s.notifications {
+		if n.Email != req.Email || (req.UnreadOnly && n.Read) || (req.Before > 0 && n.ID >= req.Before) {
+			continue
+		}
+		out := n
+		res = append(res, &out)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID > res[j].ID
+	})
+
+	if len(res) > req.Limit {
+		res = res[:req.Limit]
+	}
+	return res, nil
+}
+
+func (s *Storage) CountUnreadNotifications(_ context.Context, email string) (int, error) {
+	s.notificationsMu.Lock()
+	defer s.notificationsMu.Unlock()
+
+	count := 0
+	for _, n := range s.notifications {
+		if n.Email == email && !n.Read {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *Storage) MarkNotificationRead(_ context.Context, email string, id int64, _ time.Time) error {
+	s.notificationsMu.Lock()
+	defer s.notificationsMu.Unlock()
+
+	for i, n := range s.notifications {
+		if n.ID == id && n.Email == email {
+			s.notifications[i].Read = true
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+func (s *Storage) MarkAllNotificationsRead(_ context.Context, email string, _ time.Time) error {
+	s.notificationsMu.Lock()
+	defer s.notificationsMu.Unlock()
+
+	for i, n := range s.notifications {
+		if n.Email == email {
+			s.notifications[i].Read = true
+		}
+	}
+	return nil
+}
+
+func (s *Storage) GetNotificationPreferences(_ context.Context, email string) (*notification.Preferences, error) {
+	s.notificationsMu.Lock()
+	defer s.notificationsMu.Unlock()
+
+	p, ok := s.notificationPreferences[email]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return copyPreferences(p), nil
+}
+
+func (s *Storage) SaveNotificationPreferences(_ context.Context, p *notification.Preferences) error {
+	if _, err := s.getUser(p.Email); err != nil {
+		return storage.ErrInvalidArgument
+	}
+
+	s.notificationsMu.Lock()
+	defer s.notificationsMu.Unlock()
+
+	if s.notificationPreferences == nil {
+		s.notificationPreferences = make(map[string]notification.Preferences)
+	}
+	s.notificationPreferences[p.Email] = *copyPreferences(*p)
+	return nil
+}
+
+func copyPreferences(p notification.Preferences) *notification.Preferences {
+	events := make(map[notification.Type]map[notification.Channel]bool, len(p.Events))
+	for t, channels := range p.Events {
+		events[t] = make(map[notification.Channel]bool, len(channels))
+		for c, enabled := range channels {
+			events[t][c] = enabled
+		}
+	}
+	p.Events = events
+	return &p
+}
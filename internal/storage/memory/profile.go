@@ -0,0 +1,229 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/victornm/gtonline/internal/community"
+	"github.com/victornm/gtonline/internal/event"
+	"github.com/victornm/gtonline/internal/profile"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+func (s *Storage) UpdateProfile(ctx context.Context, req profile.UpdateProfileRequest, v *profile.ProfileVersion, events ...event.Event) error {
+	s.usersMu.Lock()
+	found := false
+	for i := range s.users {
+		u := &s.users[i]
+		if u.Email != req.Email {
+			continue
+		}
+
+		u.Sex = req.Sex
+		u.Birthdate = req.Birthdate
+		u.CurrentCity = req.CurrentCity
+		u.Hometown = req.Hometown
+		u.Interests = append([]string(nil), req.Interests...)
+		u.Education = append([]profile.Attend(nil), req.Education...)
+		u.Professional = append([]profile.Employment(nil), req.Professional...)
+		found = true
+	}
+	if found && v != nil {
+		if s.profileVersions == nil {
+			s.profileVersions = make(map[string][]profile.ProfileVersion)
+		}
+		v.Version = int64(len(s.profileVersions[req.Email]) + 1)
+		s.profileVersions[req.Email] = append(s.profileVersions[req.Email], *v)
+	}
+	s.usersMu.Unlock()
+
+	if !found {
+		return storage.ErrNotFound
+	}
+	return s.AppendEvents(ctx, events...)
+}
+
+func (s *Storage) ListProfileVersions(_ context.Context, req profile.ListProfileHistoryRequest) ([]*profile.ProfileVersion, error) {
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+
+	versions := s.profileVersions[req.Email]
+	res := make([]*profile.ProfileVersion, 0, req.Limit)
+	for i := len(versions) - 1; i >= 0 && len(res) < req.Limit; i-- {
+		v := versions[i]
+		if req.Before > 0 && v.Version >= req.Before {
+			continue
+		}
+		res = append(res, &v)
+	}
+	return res, nil
+}
+
+func (s *Storage) GetProfileVersion(_ context.Context, email string, version int64) (*profile.ProfileVersion, error) {
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+
+	versions := s.profileVersions[email]
+	if version < 1 || version > int64(len(versions)) {
+		return nil, storage.ErrNotFound
+	}
+	v := versions[version-1]
+	return &v, nil
+}
+
+func (s *Storage) FindInterestTags(_ context.Context, slugs []string) ([]*profile.InterestTag, error) {
+	s.interestTagsMu.Lock()
+	defer s.interestTagsMu.Unlock()
+
+	want := make(map[string]bool, len(slugs))
+	for _, slug := range slugs {
+		want[slug] = true
+	}
+
+	var res []*profile.InterestTag
+	for _, t := range s.interestTags {
+		if want[t.Slug] || anyOf(t.Aliases, want) {
+			res = append(res, s.countedInterestTag(t))
+		}
+	}
+	return res, nil
+}
+
+func (s *Storage) InsertInterestTag(_ context.Context, t *profile.InterestTag) error {
+	s.interestTagsMu.Lock()
+	defer s.interestTagsMu.Unlock()
+
+	for _, other := range s.interestTags {
+		if other.Slug == t.Slug || strings.EqualFold(other.Name, t.Name) {
+			return storage.ErrAlreadyExist
+		}
+	}
+
+	s.interestTags = append(s.interestTags, profile.InterestTag{Slug: t.Slug, Name: t.Name})
+	return nil
+}
+
+func (s *Storage) ListInterestTags(_ context.Context, prefix string, limit int) ([]*profile.InterestTag, error) {
+	s.interestTagsMu.Lock()
+	defer s.interestTagsMu.Unlock()
+
+	var res []*profile.InterestTag
+	for _, t := range s.interestTags {
+		match := strings.HasPrefix(t.Slug, prefix)
+		for _, a := range t.Aliases {
+			match = match || strings.HasPrefix(a, prefix)
+		}
+		if match {
+			res = append(res, s.countedInterestTag(t))
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Users != res[j].Users {
+			return res[i].Users > res[j].Users
+		}
+		return res[i].Name < res[j].Name
+	})
+	if len(res) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+func (s *Storage) MergeInterestTags(_ context.Context, from, into string, v *profile.ProfileVersion) error {
+	s.interestTagsMu.Lock()
+	defer s.interestTagsMu.Unlock()
+
+	fromIdx, intoIdx := -1, -1
+	for i, t := range s.interestTags {
+		switch t.Slug {
+		case from:
+			fromIdx = i
+		case into:
+			intoIdx = i
+		}
+	}
+	if fromIdx < 0 || intoIdx < 0 {
+		return storage.ErrNotFound
+	}
+
+	fromTag, intoTag := s.interestTags[fromIdx], &s.interestTags[intoIdx]
+
+	s.usersMu.Lock()
+	for i := range s.users {
+		u := &s.users[i]
+		interests := u.Interests[:0:0]
+		has, merged := false, false
+		for _, interest := range u.Interests {
+			if strings.EqualFold(interest, fromTag.Name) {
+				interest = intoTag.Name
+				merged = true
+			}
+			if strings.EqualFold(interest, intoTag.Name) {
+				if has {
+					continue
+				}
+				has = true
+			}
+			interests = append(interests, interest)
+		}
+		if !merged {
+			continue
+		}
+		u.Interests = interests
+
+		if s.profileVersions == nil {
+			s.profileVersions = make(map[string][]profile.ProfileVersion)
+		}
+		p := profile.Profile(*u)
+		p.Interests = append([]string(nil), u.Interests...)
+		p.Education = append([]profile.Attend(nil), u.Education...)
+		p.Professional = append([]profile.Employment(nil), u.Professional...)
+		s.profileVersions[u.Email] = append(s.profileVersions[u.Email], profile.ProfileVersion{
+			Version:   int64(len(s.profileVersions[u.Email]) + 1),
+			Actor:     v.Actor,
+			Profile:   p,
+			CreatedAt: v.CreatedAt,
+		})
+	}
+	s.usersMu.Unlock()
+
+	// The groups about from are about into now
+	s.groupsMu.Lock()
+	for i := range s.groups {
+		if community.TopicKey(s.groups[i].Topic) == from {
+			s.groups[i].Topic = intoTag.Name
+		}
+	}
+	s.groupsMu.Unlock()
+
+	intoTag.Aliases = append(append(intoTag.Aliases, fromTag.Slug), fromTag.Aliases...)
+	s.interestTags = append(s.interestTags[:fromIdx], s.interestTags[fromIdx+1:]...)
+	return nil
+}
+
+// countedInterestTag returns a copy of t with its users counted.
+func (s *Storage) countedInterestTag(t profile.InterestTag) *profile.InterestTag {
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+
+	t.Aliases = append([]string(nil), t.Aliases...)
+	for _, u := range s.users {
+		for _, interest := range u.Interests {
+			if strings.EqualFold(interest, t.Name) {
+				t.Users++
+			}
+		}
+	}
+	return &t
+}
+
+func anyOf(values []string, set map[string]bool) bool {
+	for _, v := range values {
+		if set[v] {
+			return true
+		}
+	}
+	return false
+}
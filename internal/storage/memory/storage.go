@@ -2,48 +2,178 @@ package memory
 
 import (
 	"context"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/victornm/gtonline/internal/community"
+	"github.com/victornm/gtonline/internal/conversation"
+	"github.com/victornm/gtonline/internal/digest"
+	"github.com/victornm/gtonline/internal/event"
+	"github.com/victornm/gtonline/internal/export"
 	"github.com/victornm/gtonline/internal/friend"
+	"github.com/victornm/gtonline/internal/job"
+	"github.com/victornm/gtonline/internal/meetup"
+	"github.com/victornm/gtonline/internal/notification"
 	"github.com/victornm/gtonline/internal/profile"
 	"github.com/victornm/gtonline/internal/storage"
+	"github.com/victornm/gtonline/internal/webhook"
 )
 
 type (
 	Storage struct {
 		usersMu sync.Mutex
 		users   []User
+		// hiddenBirthdays are the users who opted out of birthday sharing.
+		hiddenBirthdays map[string]bool
+		// listedUsers are the users who opted in the directories.
+		listedUsers map[string]bool
+		// passwords are the hashed passwords of the users registered through auth.
+		passwords map[string]string
+		// deactivatedUsers are keyed by email.
+		deactivatedUsers map[string]deactivation
+		// profileVersions are the versions of the profiles by email, the oldest first.
+		profileVersions map[string][]profile.ProfileVersion
+		// schools and employers are the catalogs, guarded by usersMu.
+		schools   []profile.School
+		employers []profile.Employer
 
 		friendshipsMu sync.Mutex
 		friendships   []friend.Friendship
+
+		// lockMu serializes WithFriendshipLock, it is coarser than the per pair lock of mysql.
+		lockMu sync.Mutex
+
+		relationshipTypesMu sync.Mutex
+		relationshipTypes   []friend.RelationshipType
+
+		friendListsMu sync.Mutex
+		friendLists   []friend.List
+		lastListID    int64
+
+		notificationsMu    sync.Mutex
+		notifications      []notification.Notification
+		lastNotificationID int64
+		// notificationPreferences are keyed by email.
+		notificationPreferences map[string]notification.Preferences
+
+		conversationsMu    sync.Mutex
+		conversations      []conversation.Conversation
+		lastConversationID int64
+		messages           []conversation.Message
+		lastMessageID      int64
+		// hiddenMessages are the messages deleted by a participant, keyed by message ID then email.
+		hiddenMessages map[int64]map[string]bool
+		// conversationLockMu serializes WithConversationLock, it is coarser than the per conversation lock of mysql.
+		conversationLockMu sync.Mutex
+
+		webhooksMu     sync.Mutex
+		webhooks       []webhook.Webhook
+		lastWebhookID  int64
+		deliveries     []webhook.Delivery
+		lastDeliveryID int64
+		deadLetters    []webhook.DeadLetter
+		lastLetterID   int64
+
+		outboxMu    sync.Mutex
+		outbox      []event.Record
+		lastEventID int64
+		// eventHandlers are the subscribers which handled an event, keyed by event ID.
+		eventHandlers map[int64][]string
+
+		jobsMu    sync.Mutex
+		jobs      []job.Job
+		lastJobID int64
+
+		digestMu            sync.Mutex
+		digestSubscriptions []digest.Subscription
+		profileSnapshots    []digest.Snapshot
+		profileChanges      []digest.Change
+		lastChangeID        int64
+
+		meetupsMu    sync.Mutex
+		meetups      []meetup.Event
+		lastMeetupID int64
+		// meetupGuests are keyed by event ID.
+		meetupGuests map[int64][]meetup.Guest
+		// meetupLockMu serializes WithMeetupLock, it is coarser than the per event lock of mysql.
+		meetupLockMu sync.Mutex
+
+		groupsMu    sync.Mutex
+		groups      []community.Group
+		lastGroupID int64
+		// groupMembers and joinRequests are keyed by group ID.
+		groupMembers map[int64][]community.Member
+		joinRequests map[int64][]community.JoinRequest
+		groupPosts   []community.Post
+		lastPostID   int64
+		// groupLockMu serializes WithGroupLock, it is coarser than the per group lock of mysql.
+		groupLockMu sync.Mutex
+
+		interestTagsMu sync.Mutex
+		interestTags   []profile.InterestTag
+
+		exportsMu    sync.Mutex
+		exports      []export.Export
+		lastExportID int64
 	}
 
 	User profile.Profile
+
+	// deactivation is when a user was deactivated, and when it is deleted if its deletion is scheduled.
+	deactivation struct {
+		at          time.Time
+		deleteAfter *time.Time
+	}
 )
 
 func (s *Storage) ListFriends(_ context.Context, email string) ([]*friend.Friendship, error) {
+	deactivated := s.deactivated()
+
 	s.friendshipsMu.Lock()
 	defer s.friendshipsMu.Unlock()
 
 	var res []*friend.Friendship
 
 	for _, f := range s.friendships {
-		if f.Email == email && !f.DateConnected.IsZero() {
+		if f.DateConnected.IsZero() {
+			continue
+		}
+
+		if f.Email == email && !deactivated[f.FriendEmail] {
 			out := f
 			res = append(res, &out)
 		}
+
+		if f.FriendEmail == email && !deactivated[f.Email] {
+			res = append(res, &friend.Friendship{
+				Email:              f.FriendEmail,
+				FriendEmail:        f.Email,
+				Relationship:       f.FriendRelationship,
+				FriendRelationship: f.Relationship,
+				DateConnected:      f.DateConnected,
+				RequestedAt:        f.RequestedAt,
+			})
+		}
 	}
 
 	return res, nil
 }
 
 func (s *Storage) ListPendingFriendships(_ context.Context, email string) ([]*friend.Friendship, error) {
+	deactivated := s.deactivated()
+
 	s.friendshipsMu.Lock()
 	defer s.friendshipsMu.Unlock()
 
 	var res []*friend.Friendship
 
 	for _, f := range s.friendships {
+		if deactivated[f.Email] || deactivated[f.FriendEmail] {
+			continue
+		}
+
 		if (f.Email == email || f.FriendEmail == email) && f.DateConnected.IsZero() {
 			out := f
 			res = append(res, &out)
@@ -64,10 +194,175 @@ func (s *Storage) InsertUsers(users []User) {
 }
 
 func (s *Storage) SearchUsers(ctx context.Context, req friend.SearchFriendsRequest) (*friend.SearchFriendsResponse, error) {
-	panic("implement me")
+	var interests []string
+	if req.Interest != "" {
+		tags, err := s.FindInterestTags(ctx, []string{profile.Slug(req.Interest)})
+		if err != nil {
+			return nil, err
+		}
+		interests = append(interests, req.Interest)
+		for _, t := range tags {
+			interests = append(interests, t.Name)
+		}
+	}
+
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+
+	var matched []User
+	for _, u := range s.users {
+		if _, ok := s.deactivatedUsers[u.Email]; ok || !matchUser(u, req, interests) {
+			continue
+		}
+		matched = append(matched, u)
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].Email < matched[j].Email })
+
+	res := &friend.SearchFriendsResponse{Count: len(matched), Facets: searchFacets(matched)}
+	for _, u := range matched {
+		if u.Email <= req.After {
+			continue
+		}
+		if req.Limit > 0 && len(res.Users) == req.Limit {
+			break
+		}
+
+		res.Users = append(res.Users, friend.User{
+			Email:     u.Email,
+			FirstName: u.FirstName,
+			LastName:  u.LastName,
+			Hometown:  u.Hometown,
+		})
+	}
+	return res, nil
+}
+
+// matchUser matches u the same as the mysql search: the email, name or hometown, narrowed down by the other fields.
+// interests are the interest and the names of its tags.
+func matchUser(u User, req friend.SearchFriendsRequest, interests []string) bool {
+	contains := func(s, substr string) bool {
+		return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+	}
+
+	if req.Email != "" || req.Name != "" || req.Hometown != "" {
+		match := (req.Email != "" && strings.EqualFold(u.Email, req.Email)) ||
+			(req.Name != "" && (contains(u.FirstName, req.Name) || contains(u.LastName, req.Name))) ||
+			(req.Hometown != "" && u.Hometown != "" && contains(u.Hometown, req.Hometown))
+		if !match {
+			return false
+		}
+	}
+
+	if req.CurrentCity != "" && (u.CurrentCity == "" || !contains(u.CurrentCity, req.CurrentCity)) {
+		return false
+	}
+
+	if req.School != "" || req.YearFrom != 0 || req.YearTo != 0 {
+		match := false
+		for _, a := range u.Education {
+			match = match || ((req.School == "" || strings.EqualFold(a.School, req.School)) &&
+				(req.YearFrom == 0 || (a.YearGraduated != 0 && a.YearGraduated >= req.YearFrom)) &&
+				(req.YearTo == 0 || (a.YearGraduated != 0 && a.YearGraduated <= req.YearTo)))
+		}
+		if !match {
+			return false
+		}
+	}
+
+	if req.Employer != "" || req.JobTitle != "" {
+		match := false
+		for _, e := range u.Professional {
+			match = match || ((req.Employer == "" || strings.EqualFold(e.Employer, req.Employer)) &&
+				(req.JobTitle == "" || contains(e.JobTitle, req.JobTitle)))
+		}
+		if !match {
+			return false
+		}
+	}
+
+	if req.Interest != "" {
+		match := false
+		for _, i := range u.Interests {
+			for _, want := range interests {
+				match = match || strings.EqualFold(i, want)
+			}
+		}
+		if !match {
+			return false
+		}
+	}
+	return true
+}
+
+func searchFacets(users []User) friend.SearchFacets {
+	set := func(values ...string) map[string]bool {
+		m := make(map[string]bool, len(values))
+		for _, v := range values {
+			if v != "" {
+				m[v] = true
+			}
+		}
+		return m
+	}
+
+	var schools, employers, interests, cities []map[string]bool
+	for _, u := range users {
+		var s, e []string
+		for _, a := range u.Education {
+			s = append(s, a.School)
+		}
+		for _, p := range u.Professional {
+			e = append(e, p.Employer)
+		}
+		schools = append(schools, set(s...))
+		employers = append(employers, set(e...))
+		interests = append(interests, set(u.Interests...))
+		cities = append(cities, set(u.CurrentCity))
+	}
+
+	return friend.SearchFacets{
+		Schools:       facetCounts(schools),
+		Employers:     facetCounts(employers),
+		Interests:     facetCounts(interests),
+		CurrentCities: facetCounts(cities),
+	}
 }
 
+// facetCounts counts the users by value, given the set of values of each user.
+func facetCounts(users []map[string]bool) []friend.FacetCount {
+	counts := make(map[string]int)
+	for _, values := range users {
+		for v := range values {
+			counts[v]++
+		}
+	}
+
+	res := make([]friend.FacetCount, 0, len(counts))
+	for v, n := range counts {
+		res = append(res, friend.FacetCount{Value: v, Count: n})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Count != res[j].Count {
+			return res[i].Count > res[j].Count
+		}
+		return res[i].Value < res[j].Value
+	})
+	if len(res) > friend.FacetSize {
+		res = res[:friend.FacetSize]
+	}
+	return res
+}
+
+// GetFriendship hides the friendships of the deactivated users.
 func (s *Storage) GetFriendship(_ context.Context, email, friendEmail string) (*friend.Friendship, error) {
+	deactivated := s.deactivated()
+	if deactivated[email] || deactivated[friendEmail] {
+		return nil, storage.ErrNotFound
+	}
+	return s.findFriendship(email, friendEmail)
+}
+
+func (s *Storage) findFriendship(email, friendEmail string) (*friend.Friendship, error) {
 	s.friendshipsMu.Lock()
 	defer s.friendshipsMu.Unlock()
 
@@ -90,7 +385,7 @@ func (s *Storage) InsertFriendship(ctx context.Context, f *friend.Friendship) er
 		return storage.ErrInvalidArgument
 	}
 
-	if _, err := s.GetFriendship(ctx, f.Email, f.FriendEmail); err == nil {
+	if _, err := s.findFriendship(f.Email, f.FriendEmail); err == nil {
 		return storage.ErrAlreadyExist
 	}
 
@@ -114,6 +409,147 @@ func (s *Storage) UpdateFriendship(_ context.Context, f *friend.Friendship) erro
 	return storage.ErrNotFound
 }
 
+func (s *Storage) DeleteFriendRequest(_ context.Context, email, friendEmail string) error {
+	s.friendshipsMu.Lock()
+	defer s.friendshipsMu.Unlock()
+
+	for i, f := range s.friendships {
+		if f.Email == email && f.FriendEmail == friendEmail && f.DateConnected.IsZero() {
+			s.friendships = append(s.friendships[:i], s.friendships[i+1:]...)
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) DeleteExpiredFriendRequests(_ context.Context, before time.Time) (int64, error) {
+	s.friendshipsMu.Lock()
+	defer s.friendshipsMu.Unlock()
+
+	var (
+		kept    []friend.Friendship
+		deleted int64
+	)
+	for _, f := range s.friendships {
+		if f.DateConnected.IsZero() && f.RequestedAt.Before(before) {
+			deleted++
+			continue
+		}
+		kept = append(kept, f)
+	}
+	s.friendships = kept
+
+	return deleted, nil
+}
+
+func (s *Storage) ListConnections(_ context.Context, emails []string, after friend.Connection, limit int) ([]friend.Connection, error) {
+	deactivated := s.deactivated()
+
+	s.friendshipsMu.Lock()
+	defer s.friendshipsMu.Unlock()
+
+	in := make(map[string]bool, len(emails))
+	for _, e := range emails {
+		in[e] = true
+	}
+
+	var (
+		res  []friend.Connection
+		seen = make(map[friend.Connection]bool)
+	)
+	for _, f := range s.friendships {
+		if f.DateConnected.IsZero() || deactivated[f.Email] || deactivated[f.FriendEmail] {
+			continue
+		}
+
+		for _, c := range []friend.Connection{
+			{Email: f.Email, FriendEmail: f.FriendEmail},
+			{Email: f.FriendEmail, FriendEmail: f.Email},
+		} {
+			if in[c.Email] && lessConnection(after, c) && !seen[c] {
+				seen[c] = true
+				res = append(res, c)
+			}
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return lessConnection(res[i], res[j])
+	})
+
+	if len(res) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+func (s *Storage) WithFriendshipLock(ctx context.Context, _, _ string, f func(ctx context.Context, tx friend.Storage) error) error {
+	s.lockMu.Lock()
+	defer s.lockMu.Unlock()
+
+	return f(ctx, s)
+}
+
+func (s *Storage) ListRelationshipTypes(_ context.Context) ([]friend.RelationshipType, error) {
+	s.relationshipTypesMu.Lock()
+	defer s.relationshipTypesMu.Unlock()
+
+	res := append([]friend.RelationshipType(nil), s.relationshipTypes...)
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res, nil
+}
+
+func (s *Storage) InsertRelationshipType(_ context.Context, t friend.RelationshipType) error {
+	s.relationshipTypesMu.Lock()
+	defer s.relationshipTypesMu.Unlock()
+
+	for _, t1 := range s.relationshipTypes {
+		if t1.Name == t.Name {
+			return storage.ErrAlreadyExist
+		}
+	}
+
+	s.relationshipTypes = append(s.relationshipTypes, t)
+	return nil
+}
+
+func (s *Storage) DeleteRelationshipType(_ context.Context, name string) error {
+	s.relationshipTypesMu.Lock()
+	defer s.relationshipTypesMu.Unlock()
+
+	for i, t := range s.relationshipTypes {
+		if t.Name == name {
+			s.relationshipTypes = append(s.relationshipTypes[:i], s.relationshipTypes[i+1:]...)
+			return nil
+		}
+	}
+
+	return storage.ErrNotFound
+}
+
+func lessConnection(a, b friend.Connection) bool {
+	if a.Email != b.Email {
+		return a.Email < b.Email
+	}
+	return a.FriendEmail < b.FriendEmail
+}
+
+func (s *Storage) GetProfile(_ context.Context, email string) (*profile.Profile, error) {
+	u, err := s.getUser(email)
+	if err != nil {
+		return nil, err
+	}
+
+	p := profile.Profile(*u)
+	p.Interests = append([]string(nil), u.Interests...)
+	p.Education = append([]profile.Attend(nil), u.Education...)
+	p.Professional = append([]profile.Employment(nil), u.Professional...)
+	return &p, nil
+}
+
 func (s *Storage) getUser(email string) (*User, error) {
 	s.usersMu.Lock()
 	defer s.usersMu.Unlock()
@@ -0,0 +1,254 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/victornm/gtonline/internal/storage"
+	"github.com/victornm/gtonline/internal/webhook"
+)
+
+func (s *Storage) ListWebhooks(_ context.Context) ([]*webhook.Webhook, error) {
+	s.webhooksMu.Lock()
+	defer s.webhooksMu.Unlock()
+
+	res := make([]*webhook.Webhook, 0, len(s.webhooks))
+	for _, w := range s.webhooks {
+		res = append(res, copyWebhook(w))
+	}
+	return res, nil
+}
+
+func (s *Storage) GetWebhook(_ context.Context, id int64) (*webhook.Webhook, error) {
+	s.webhooksMu.Lock()
+	defer s.webhooksMu.Unlock()
+
+	for _, w := range s.webhooks {
+		if w.ID == id {
+			return copyWebhook(w), nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (s *Storage) InsertWebhook(_ context.Context, w *webhook.Webhook) error {
+	s.webhooksMu.Lock()
+	defer s.webhooksMu.Unlock()
+
+	s.lastWebhookID++
+	w.ID = s.lastWebhookID
+	s.webhooks = append(s.webhooks, *copyWebhook(*w))
+	return nil
+}
+
+func (s *Storage) UpdateWebhook(_ context.Context, w *webhook.Webhook) error {
+	s.webhooksMu.Lock()
+	defer s.webhooksMu.Unlock()
+
+	for i := range s.webhooks {
+		if s.webhooks[i].ID == w.ID {
+			secret := s.webhooks[i].Secret
+			s.webhooks[i] = *copyWebhook(*w)
+			s.webhooks[i].Secret = secret
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+func (s *Storage) DeleteWebhook(_ context.Context, id int64) error {
+	s.webhooksMu.Lock()
+	defer s.webhooksMu.Unlock()
+
+	var webhooks []webhook.Webhook
+	for _, w := range s.webhooks {
+		if w.ID != id {
+			webhooks = append(webhooks, w)
+		}
+	}
+	s.webhooks = webhooks
+
+	var deliveries []webhook.Delivery
+	for _, d := range s.deliveries {
+		if d.WebhookID != id {
+			deliveries = append(deliveries, d)
+		}
+	}
+	s.deliveries = deliveries
+
+	var letters []webhook.DeadLetter
+	for _, l := range s.deadLetters {
+		if l.WebhookID != id {
+			letters = append(letters, l)
+		}
+	}
+	s.deadLetters = letters
+	return nil
+}
+
+func (s *Storage) InsertDeliveries(_ context.Context, deliveries []*webhook.Delivery) error {
+	s.webhooksMu.Lock()
+	defer s.webhooksMu.Unlock()
+
+	for _, d := range deliveries {
+		if s.hasDelivery(d.WebhookID, d.EventID) {
+			continue
+		}
+
+		s.lastDeliveryID++
+		d.ID = s.lastDeliveryID
+		s.deliveries = append(s.deliveries, *d)
+	}
+	return nil
+}
+
+func (s *Storage) ClaimDueDeliveries(_ context.Context, now, leaseUntil time.Time, limit int) ([]*webhook.Delivery, error) {
+	s.webhooksMu.Lock()
+	defer s.webhooksMu.Unlock()
+
+	active := make(map[int64]bool, len(s.webhooks))
+	for _, w := range s.webhooks {
+		active[w.ID] = w.Active
+	}
+
+	var res []*webhook.Delivery
+	for i := range s.deliveries {
+		d := &s.deliveries[i]
+		if len(res) >= limit {
+			break
+		}
+		if d.Status != webhook.StatusPending || d.NextAttemptAt.After(now) || !active[d.WebhookID] {
+			continue
+		}
+
+		d.NextAttemptAt = leaseUntil
+		out := *d
+		res = append(res, &out)
+	}
+	return res, nil
+}
+
+func (s *Storage) UpdateDelivery(_ context.Context, d *webhook.Delivery) error {
+	s.webhooksMu.Lock()
+	defer s.webhooksMu.Unlock()
+
+	for i := range s.deliveries {
+		if s.deliveries[i].ID == d.ID {
+			s.deliveries[i] = *d
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+func (s *Storage) GetDelivery(_ context.Context, id int64) (*webhook.Delivery, error) {
+	s.webhooksMu.Lock()
+	defer s.webhooksMu.Unlock()
+
+	for _, d := range s.deliveries {
+		if d.ID == id {
+			out := d
+			return &out, nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (s *Storage) ListDeliveries(_ context.Context, req webhook.ListDeliveriesRequest) ([]*webhook.Delivery, error) {
+	s.webhooksMu.Lock()
+	defer s.webhooksMu.Unlock()
+
+	var res []*webhook.Delivery
+	for _, d := range s.deliveries {
+		if d.WebhookID != req.WebhookID || (req.Before > 0 && d.ID >= req.Before) {
+			continue
+		}
+		out := d
+		res = append(res, &out)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID > res[j].ID
+	})
+
+	if len(res) > req.Limit {
+		res = res[:req.Limit]
+	}
+	return res, nil
+}
+
+func (s *Storage) InsertDeadLetter(_ context.Context, l *webhook.DeadLetter) error {
+	s.webhooksMu.Lock()
+	defer s.webhooksMu.Unlock()
+
+	s.lastLetterID++
+	l.ID = s.lastLetterID
+	s.deadLetters = append(s.deadLetters, *l)
+	return nil
+}
+
+func (s *Storage) GetDeadLetter(_ context.Context, id int64) (*webhook.DeadLetter, error) {
+	s.webhooksMu.Lock()
+	defer s.webhooksMu.Unlock()
+
+	for _, l := range s.deadLetters {
+		if l.ID == id {
+			out := l
+			return &out, nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (s *Storage) ListDeadLetters(_ context.Context, req webhook.ListDeadLettersRequest) ([]*webhook.DeadLetter, error) {
+	s.webhooksMu.Lock()
+	defer s.webhooksMu.Unlock()
+
+	var res []*webhook.DeadLetter
+	for _, l := range s.deadLetters {
+		if req.Before > 0 && l.ID >= req.Before {
+			continue
+		}
+		out := l
+		res = append(res, &out)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID > res[j].ID
+	})
+
+	if len(res) > req.Limit {
+		res = res[:req.Limit]
+	}
+	return res, nil
+}
+
+func (s *Storage) DeleteDeadLetter(_ context.Context, id int64) error {
+	s.webhooksMu.Lock()
+	defer s.webhooksMu.Unlock()
+
+	var letters []webhook.DeadLetter
+	for _, l := range s.deadLetters {
+		if l.ID != id {
+			letters = append(letters, l)
+		}
+	}
+	s.deadLetters = letters
+	return nil
+}
+
+func copyWebhook(w webhook.Webhook) *webhook.Webhook {
+	out := w
+	out.Events = append([]string(nil), w.Events...)
+	return &out
+}
+
+func (s *Storage) hasDelivery(webhookID int64, eventID string) bool {
+	for _, d := range s.deliveries {
+		if d.WebhookID == webhookID && d.EventID == eventID {
+			return true
+		}
+	}
+	return false
+}
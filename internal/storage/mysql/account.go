@@ -0,0 +1,71 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+func (s *Storage) DeactivateUser(ctx context.Context, email string, at time.Time, deleteAfter *time.Time) error {
+	var after sql.NullTime
+	if deleteAfter != nil {
+		after = sql.NullTime{Time: *deleteAfter, Valid: true}
+	}
+
+	r, err := s.db.ExecContext(ctx, `UPDATE users SET deactivated_at=?, delete_after=? WHERE email=?;`, at, after, email)
+	if err != nil {
+		return err
+	}
+
+	n, err := r.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+func (s *Storage) ReactivateUser(ctx context.Context, email string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE users SET deactivated_at=NULL, delete_after=NULL WHERE email=?;`, email)
+	return err
+}
+
+func (s *Storage) IsUserDeactivated(ctx context.Context, email string) (bool, error) {
+	var deactivated bool
+	err := s.db.GetContext(ctx, &deactivated, `SELECT EXISTS(SELECT 1 FROM users WHERE email=? AND deactivated_at IS NOT NULL);`, email)
+	return deactivated, err
+}
+
+func (s *Storage) ListScheduledUsers(ctx context.Context, now time.Time) ([]string, error) {
+	var emails []string
+	err := s.db.SelectContext(ctx, &emails, `SELECT email FROM users WHERE delete_after<=? ORDER BY email;`, now)
+	return emails, err
+}
+
+func (s *Storage) DeleteScheduledUsers(ctx context.Context, now time.Time) (int64, error) {
+	var n int64
+	err := s.withTx(ctx, func(tx *Storage) error {
+		// The actor columns have no foreign key, the changes made by the deleted users are kept without their author
+		for _, stmt := range []string{
+			`UPDATE notifications SET actor_email='' WHERE actor_email IN (SELECT email FROM users WHERE delete_after<=?);`,
+			`UPDATE profile_versions SET actor_email='' WHERE actor_email IN (SELECT email FROM users WHERE delete_after<=?);`,
+		} {
+			if _, err := tx.db.ExecContext(ctx, stmt, now); err != nil {
+				return err
+			}
+		}
+
+		// The data of the users is deleted by the ON DELETE CASCADE of their tables
+		r, err := tx.db.ExecContext(ctx, `DELETE FROM users WHERE delete_after<=?;`, now)
+		if err != nil {
+			return err
+		}
+		n, err = r.RowsAffected()
+		return err
+	})
+	return n, err
+}
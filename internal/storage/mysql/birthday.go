@@ -0,0 +1,117 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/victornm/gtonline/internal/friend"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+type birthdayRow struct {
+	Email        string    `db:"email"`
+	FirstName    string    `db:"first_name"`
+	LastName     string    `db:"last_name"`
+	Birthdate    time.Time `db:"birthdate"`
+	NextBirthday time.Time `db:"next_birthday"`
+}
+
+const dateLayout = "2006-01-02"
+
+// The anniversary of birthdate in the year of d, DATE_ADD turns Feb 29 into Feb 28 in the common years.
+const anniversarySQL = `DATE_ADD(r.birthdate, INTERVAL YEAR(d.day) - YEAR(r.birthdate) YEAR)`
+
+func (s *Storage) ListFriendBirthdays(ctx context.Context, email string, from time.Time, days int) ([]*friend.Birthday, error) {
+	// The next birthday is the anniversary of this year, or of the next year if it is already past
+	stmt := `
+SELECT email, first_name, last_name, birthdate, next_birthday
+FROM (
+    SELECT u.email, u.first_name, u.last_name, r.birthdate,
+           CAST(IF(` + anniversarySQL + ` >= d.day,
+                   ` + anniversarySQL + `,
+                   DATE_ADD(r.birthdate, INTERVAL YEAR(d.day) - YEAR(r.birthdate) + 1 YEAR)) AS DATE) AS next_birthday
+    FROM (
+        SELECT friend_email AS email FROM friendships WHERE email = ? AND date_connected IS NOT NULL
+        UNION ALL
+        SELECT email FROM friendships WHERE friend_email = ? AND date_connected IS NOT NULL
+    ) f
+    JOIN regular_users r ON r.email = f.email
+    JOIN users u ON u.email = f.email AND u.deactivated_at IS NULL
+    CROSS JOIN (SELECT CAST(? AS DATE) AS day) d
+    WHERE r.birthdate IS NOT NULL
+      AND r.share_birthday
+) b
+WHERE next_birthday < DATE_ADD(CAST(? AS DATE), INTERVAL ? DAY)
+ORDER BY next_birthday, email;`
+
+	day := from.Format(dateLayout)
+	var rows []birthdayRow
+	if err := s.db.SelectContext(ctx, &rows, stmt, email, email, day, day, days); err != nil {
+		return nil, err
+	}
+
+	res := make([]*friend.Birthday, 0, len(rows))
+	for _, r := range rows {
+		res = append(res, &friend.Birthday{
+			Email:     r.Email,
+			FirstName: r.FirstName,
+			LastName:  r.LastName,
+			Birthdate: r.Birthdate,
+			Next:      friend.Date(r.NextBirthday),
+		})
+	}
+	return res, nil
+}
+
+func (s *Storage) ListBirthdayReminders(ctx context.Context, on time.Time) ([]friend.BirthdayReminder, error) {
+	stmt := `
+SELECT f.email, f.friend_email
+FROM regular_users r
+CROSS JOIN (SELECT CAST(? AS DATE) AS day) d
+JOIN (
+    SELECT email, friend_email FROM friendships WHERE date_connected IS NOT NULL
+    UNION ALL
+    SELECT friend_email AS email, email AS friend_email FROM friendships WHERE date_connected IS NOT NULL
+) f ON f.friend_email = r.email
+-- Neither the deactivated users nor their friends are reminded
+JOIN users u ON u.email = f.email AND u.deactivated_at IS NULL
+JOIN users fu ON fu.email = f.friend_email AND fu.deactivated_at IS NULL
+WHERE r.birthdate IS NOT NULL
+  AND r.share_birthday
+  AND ` + anniversarySQL + ` = d.day
+ORDER BY f.email, f.friend_email;`
+
+	var rows []struct {
+		Email       string `db:"email"`
+		FriendEmail string `db:"friend_email"`
+	}
+	if err := s.db.SelectContext(ctx, &rows, stmt, on.Format(dateLayout)); err != nil {
+		return nil, err
+	}
+
+	res := make([]friend.BirthdayReminder, 0, len(rows))
+	for _, r := range rows {
+		res = append(res, friend.BirthdayReminder{Email: r.Email, FriendEmail: r.FriendEmail})
+	}
+	return res, nil
+}
+
+func (s *Storage) GetBirthdaySharing(ctx context.Context, email string) (bool, error) {
+	var share bool
+	err := s.db.GetContext(ctx, &share, `SELECT share_birthday FROM regular_users WHERE email=?;`, email)
+	if err == sql.ErrNoRows {
+		return false, storage.ErrNotFound
+	}
+	return share, err
+}
+
+func (s *Storage) UpdateBirthdaySharing(ctx context.Context, email string, share bool) error {
+	// RowsAffected is 0 when the value doesn't change, so the user is checked separately
+	if _, err := s.GetBirthdaySharing(ctx, email); err != nil {
+		return err
+	}
+
+	_, err := s.db.ExecContext(ctx, `UPDATE regular_users SET share_birthday=? WHERE email=?;`, share, email)
+	return err
+}
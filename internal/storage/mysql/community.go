@@ -0,0 +1,421 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/victornm/gtonline/internal/community"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+type (
+	groupRow struct {
+		ID          int64     `db:"id"`
+		Name        string    `db:"name"`
+		Topic       string    `db:"topic"`
+		TopicKey    string    `db:"topic_key"`
+		Description string    `db:"description"`
+		Privacy     string    `db:"privacy"`
+		CreatedAt   time.Time `db:"created_at"`
+	}
+
+	// countedGroupRow is a group with the members counted and the role of the member who asked for it.
+	countedGroupRow struct {
+		groupRow
+		MemberCount int            `db:"member_count"`
+		Role        sql.NullString `db:"role"`
+	}
+
+	groupMemberRow struct {
+		GroupID     int64     `db:"group_id"`
+		Email       string    `db:"email"`
+		Role        string    `db:"role"`
+		JoinedAt    time.Time `db:"joined_at"`
+		Deactivated bool      `db:"deactivated"`
+	}
+
+	groupPostRow struct {
+		ID          int64     `db:"id"`
+		GroupID     int64     `db:"group_id"`
+		AuthorEmail string    `db:"author_email"`
+		Body        string    `db:"body"`
+		CreatedAt   time.Time `db:"created_at"`
+	}
+)
+
+// groupColumns selects a countedGroupRow without role from the community_groups g.
+const groupColumns = `g.id, g.name, g.topic, g.topic_key, g.description, g.privacy, g.created_at,
+       (SELECT COUNT(*) FROM community_members c WHERE c.group_id = g.id) AS member_count`
+
+func newGroupRow(g *community.Group) groupRow {
+	return groupRow{
+		ID:          g.ID,
+		Name:        g.Name,
+		Topic:       g.Topic,
+		TopicKey:    community.TopicKey(g.Topic),
+		Description: g.Description,
+		Privacy:     string(g.Privacy),
+		CreatedAt:   g.CreatedAt,
+	}
+}
+
+func (r countedGroupRow) group() *community.Group {
+	return &community.Group{
+		ID:          r.ID,
+		Name:        r.Name,
+		Topic:       r.Topic,
+		Description: r.Description,
+		Privacy:     community.Privacy(r.Privacy),
+		MemberCount: r.MemberCount,
+		Role:        community.Role(r.Role.String),
+		CreatedAt:   r.CreatedAt,
+	}
+}
+
+func (s *Storage) InsertGroup(ctx context.Context, g *community.Group, owner *community.Member) error {
+	return s.withTx(ctx, func(tx *Storage) error {
+		r, err := tx.db.NamedExecContext(ctx, `
+INSERT INTO community_groups (name, topic, topic_key, description, privacy, created_at)
+VALUES (:name, :topic, :topic_key, :description, :privacy, :created_at);`, newGroupRow(g))
+		if isDuplicate(err) {
+			return fmt.Errorf("%w: %v", storage.ErrAlreadyExist, err)
+		}
+		if err != nil {
+			return err
+		}
+
+		id, err := r.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		if err := tx.SaveGroupMember(ctx, id, owner); err != nil {
+			return err
+		}
+
+		g.ID = id
+		return nil
+	})
+}
+
+func (s *Storage) GetGroup(ctx context.Context, id int64) (*community.Group, error) {
+	var row countedGroupRow
+	err := s.db.GetContext(ctx, &row, `SELECT `+groupColumns+`, NULL AS role FROM community_groups g WHERE g.id=?;`, id)
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return row.group(), nil
+}
+
+func (s *Storage) UpdateGroup(ctx context.Context, g *community.Group) error {
+	r, err := s.db.NamedExecContext(ctx, `
+UPDATE community_groups
+SET name=:name, topic=:topic, topic_key=:topic_key, description=:description, privacy=:privacy
+WHERE id=:id;`, newGroupRow(g))
+	if isDuplicate(err) {
+		return fmt.Errorf("%w: %v", storage.ErrAlreadyExist, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	// RowsAffected counts the changed rows only, an update without change must not be ErrNotFound
+	n, err := r.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		if _, err := s.GetGroup(ctx, g.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Storage) DeleteGroup(ctx context.Context, id int64) error {
+	r, err := s.db.ExecContext(ctx, `DELETE FROM community_groups WHERE id=?;`, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := r.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+func (s *Storage) WithGroupLock(ctx context.Context, id int64, f func(ctx context.Context, tx community.Storage) error) error {
+	return s.withTx(ctx, func(tx *Storage) error {
+		// A missing group locks nothing, f finds it missing too
+		var locked []int64
+		if err := tx.db.SelectContext(ctx, &locked, `SELECT id FROM community_groups WHERE id=? FOR UPDATE;`, id); err != nil {
+			return fmt.Errorf("lock group: %v", err)
+		}
+
+		return f(ctx, tx)
+	})
+}
+
+func (s *Storage) ListGroups(ctx context.Context, filter community.GroupFilter) ([]*community.Group, error) {
+	var (
+		conds []string
+		args  = []interface{}{filter.Member}
+	)
+	if filter.Member != "" {
+		conds = append(conds, `me.email IS NOT NULL`)
+	}
+	if len(filter.TopicKeys) > 0 {
+		conds = append(conds, `g.topic_key IN (?)`)
+		args = append(args, filter.TopicKeys)
+	}
+	if filter.Exclude != "" {
+		conds = append(conds, `NOT EXISTS(SELECT 1 FROM community_members x WHERE x.group_id = g.id AND x.email = ?)`)
+		args = append(args, filter.Exclude)
+	}
+
+	where := ""
+	if len(conds) > 0 {
+		where = "WHERE " + strings.Join(conds, " AND ")
+	}
+	args = append(args, filter.Limit)
+
+	query, args, err := sqlx.In(`
+SELECT `+groupColumns+`, me.role AS role
+FROM community_groups g
+         LEFT JOIN community_members me ON me.group_id = g.id AND me.email = ?
+`+where+`
+ORDER BY member_count DESC, g.id DESC
+LIMIT ?;`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("build query: %v", err)
+	}
+
+	var rows []countedGroupRow
+	if err := s.db.SelectContext(ctx, &rows, s.db.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+
+	res := make([]*community.Group, 0, len(rows))
+	for _, row := range rows {
+		res = append(res, row.group())
+	}
+	return res, nil
+}
+
+func (s *Storage) GetGroupMember(ctx context.Context, id int64, email string) (*community.Member, error) {
+	var row groupMemberRow
+	err := s.db.GetContext(ctx, &row, `
+SELECT group_id, email, role, joined_at
+FROM community_members
+WHERE group_id=? AND email=?;`, id, email)
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return row.member(), nil
+}
+
+func (s *Storage) ListGroupMembers(ctx context.Context, id int64) ([]*community.Member, error) {
+	var rows []groupMemberRow
+	err := s.db.SelectContext(ctx, &rows, `
+SELECT c.group_id, c.email, c.role, c.joined_at, u.deactivated_at IS NOT NULL AS deactivated
+FROM community_members c
+         JOIN users u ON u.email = c.email
+WHERE c.group_id=?
+ORDER BY c.joined_at, c.email;`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*community.Member, 0, len(rows))
+	for _, row := range rows {
+		res = append(res, row.member())
+	}
+	return res, nil
+}
+
+func (s *Storage) SaveGroupMember(ctx context.Context, id int64, m *community.Member) error {
+	row := groupMemberRow{GroupID: id, Email: m.Email, Role: string(m.Role), JoinedAt: m.JoinedAt}
+	_, err := s.db.NamedExecContext(ctx, `
+INSERT INTO community_members (group_id, email, role, joined_at)
+VALUES (:group_id, :email, :role, :joined_at)
+ON DUPLICATE KEY UPDATE role=VALUES(role);`, row)
+	if isErrForeignKeyConstraint(err) {
+		return fmt.Errorf("%w: %v", storage.ErrInvalidArgument, err)
+	}
+	return err
+}
+
+func (s *Storage) DeleteGroupMember(ctx context.Context, id int64, email string) error {
+	r, err := s.db.ExecContext(ctx, `DELETE FROM community_members WHERE group_id=? AND email=?;`, id, email)
+	if err != nil {
+		return err
+	}
+
+	n, err := r.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+func (s *Storage) InsertJoinRequest(ctx context.Context, id int64, r *community.JoinRequest) error {
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO community_join_requests (group_id, email, requested_at)
+VALUES (?, ?, ?);`, id, r.Email, r.RequestedAt)
+	if isDuplicate(err) {
+		return fmt.Errorf("%w: %v", storage.ErrAlreadyExist, err)
+	}
+	if isErrForeignKeyConstraint(err) {
+		return fmt.Errorf("%w: %v", storage.ErrInvalidArgument, err)
+	}
+	return err
+}
+
+func (s *Storage) ListJoinRequests(ctx context.Context, id int64) ([]*community.JoinRequest, error) {
+	var rows []struct {
+		Email       string    `db:"email"`
+		RequestedAt time.Time `db:"requested_at"`
+		Deactivated bool      `db:"deactivated"`
+	}
+	err := s.db.SelectContext(ctx, &rows, `
+SELECT r.email, r.requested_at, u.deactivated_at IS NOT NULL AS deactivated
+FROM community_join_requests r
+         JOIN users u ON u.email = r.email
+WHERE r.group_id=?
+ORDER BY r.requested_at, r.email;`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*community.JoinRequest, 0, len(rows))
+	for _, row := range rows {
+		res = append(res, &community.JoinRequest{Email: row.Email, RequestedAt: row.RequestedAt, Deactivated: row.Deactivated})
+	}
+	return res, nil
+}
+
+func (s *Storage) DeleteJoinRequest(ctx context.Context, id int64, email string) error {
+	r, err := s.db.ExecContext(ctx, `DELETE FROM community_join_requests WHERE group_id=? AND email=?;`, id, email)
+	if err != nil {
+		return err
+	}
+
+	n, err := r.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+func (s *Storage) InsertGroupPost(ctx context.Context, p *community.Post) error {
+	r, err := s.db.NamedExecContext(ctx, `
+INSERT INTO community_posts (group_id, author_email, body, created_at)
+VALUES (:group_id, :author_email, :body, :created_at);`, groupPostRow{
+		GroupID:     p.GroupID,
+		AuthorEmail: p.Author,
+		Body:        p.Body,
+		CreatedAt:   p.CreatedAt,
+	})
+	if isErrForeignKeyConstraint(err) {
+		return fmt.Errorf("%w: %v", storage.ErrInvalidArgument, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	id, err := r.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	p.ID = id
+	return nil
+}
+
+func (s *Storage) GetGroupPost(ctx context.Context, id int64) (*community.Post, error) {
+	var row groupPostRow
+	err := s.db.GetContext(ctx, &row, `
+SELECT id, group_id, author_email, body, created_at
+FROM community_posts
+WHERE id=?;`, id)
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return row.post(), nil
+}
+
+func (s *Storage) ListGroupPosts(ctx context.Context, req community.ListPostsRequest) ([]*community.Post, error) {
+	stmt := `
+SELECT p.id, p.group_id, p.author_email, p.body, p.created_at
+FROM community_posts p
+         JOIN users u ON u.email = p.author_email AND u.deactivated_at IS NULL
+WHERE p.group_id=?`
+	args := []interface{}{req.GroupID}
+	if req.Before > 0 {
+		stmt += ` AND p.id < ?`
+		args = append(args, req.Before)
+	}
+	stmt += `
+ORDER BY p.id DESC
+LIMIT ?;`
+	args = append(args, req.Limit)
+
+	var rows []groupPostRow
+	if err := s.db.SelectContext(ctx, &rows, stmt, args...); err != nil {
+		return nil, err
+	}
+
+	res := make([]*community.Post, 0, len(rows))
+	for _, row := range rows {
+		res = append(res, row.post())
+	}
+	return res, nil
+}
+
+func (s *Storage) DeleteGroupPost(ctx context.Context, id int64) error {
+	r, err := s.db.ExecContext(ctx, `DELETE FROM community_posts WHERE id=?;`, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := r.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+func (r groupMemberRow) member() *community.Member {
+	return &community.Member{Email: r.Email, Role: community.Role(r.Role), JoinedAt: r.JoinedAt, Deactivated: r.Deactivated}
+}
+
+func (r groupPostRow) post() *community.Post {
+	return &community.Post{ID: r.ID, GroupID: r.GroupID, Author: r.AuthorEmail, Body: r.Body, CreatedAt: r.CreatedAt}
+}
@@ -0,0 +1,394 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/victornm/gtonline/internal/conversation"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+type (
+	conversationRow struct {
+		ID        int64          `db:"id"`
+		DirectKey sql.NullString `db:"direct_key"`
+		Name      sql.NullString `db:"name"`
+		CreatedAt time.Time      `db:"created_at"`
+	}
+
+	participantRow struct {
+		ConversationID    int64          `db:"conversation_id"`
+		Email             string         `db:"email"`
+		Role              sql.NullString `db:"role"`
+		JoinedAt          time.Time      `db:"joined_at"`
+		LastReadMessageID int64          `db:"last_read_message_id"`
+		ClearedMessageID  int64          `db:"cleared_message_id"`
+	}
+
+	messageRow struct {
+		ID             int64     `db:"id"`
+		ConversationID int64     `db:"conversation_id"`
+		Type           string    `db:"type"`
+		SenderEmail    string    `db:"sender_email"`
+		Body           string    `db:"body"`
+		CreatedAt      time.Time `db:"created_at"`
+	}
+)
+
+func (s *Storage) GetDirectConversation(ctx context.Context, directKey string) (*conversation.Conversation, error) {
+	var row conversationRow
+	err := s.db.GetContext(ctx, &row, `SELECT id, direct_key, name, created_at FROM conversations WHERE direct_key=?;`, directKey)
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return s.withParticipants(ctx, row)
+}
+
+func (s *Storage) InsertConversation(ctx context.Context, c *conversation.Conversation) error {
+	return s.withTx(ctx, func(tx *Storage) error {
+		row := conversationRow{
+			DirectKey: newNullString(c.DirectKey),
+			Name:      newNullString(c.Name),
+			CreatedAt: c.CreatedAt,
+		}
+		r, err := tx.db.NamedExecContext(ctx, `
+INSERT INTO conversations (direct_key, name, created_at)
+VALUES (:direct_key, :name, :created_at);`, row)
+		if isDuplicate(err) {
+			return fmt.Errorf("%w: %v", storage.ErrAlreadyExist, err)
+		}
+		if err != nil {
+			return err
+		}
+
+		id, err := r.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		for _, p := range c.Participants {
+			if err := tx.InsertParticipant(ctx, id, p); err != nil {
+				return err
+			}
+		}
+
+		c.ID = id
+		return nil
+	})
+}
+
+func (s *Storage) GetConversation(ctx context.Context, id int64) (*conversation.Conversation, error) {
+	var row conversationRow
+	err := s.db.GetContext(ctx, &row, `SELECT id, direct_key, name, created_at FROM conversations WHERE id=?;`, id)
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return s.withParticipants(ctx, row)
+}
+
+func (s *Storage) WithConversationLock(ctx context.Context, id int64, f func(ctx context.Context, tx conversation.Storage) error) error {
+	return s.withTx(ctx, func(tx *Storage) error {
+		// A missing conversation locks nothing, f finds it missing too
+		var locked []int64
+		if err := tx.db.SelectContext(ctx, &locked, `SELECT id FROM conversations WHERE id=? FOR UPDATE;`, id); err != nil {
+			return fmt.Errorf("lock conversation: %v", err)
+		}
+
+		return f(ctx, tx)
+	})
+}
+
+func (s *Storage) ListConversations(ctx context.Context, email string) ([]*conversation.Conversation, error) {
+	var rows []struct {
+		conversationRow
+		LastMessageID int64 `db:"last_message_id"`
+		UnreadCount   int   `db:"unread_count"`
+	}
+
+	// visible is the condition of a message m seen by the participant p
+	const visible = `m.conversation_id = p.conversation_id
+  AND m.id > p.cleared_message_id
+  AND NOT EXISTS(SELECT message_id FROM hidden_messages h WHERE h.message_id = m.id AND h.email = p.email)`
+
+	err := s.db.SelectContext(ctx, &rows, `
+SELECT c.id, c.direct_key, c.name, c.created_at, t.last_message_id, t.unread_count
+FROM (SELECT p.conversation_id,
+             (SELECT MAX(m.id) FROM messages m WHERE `+visible+`) AS last_message_id,
+             (SELECT COUNT(*)
+              FROM messages m
+              WHERE `+visible+`
+                AND m.id > p.last_read_message_id
+                AND m.sender_email <> p.email)                      AS unread_count
+      FROM conversation_participants p
+      WHERE p.email = ?) t
+         JOIN conversations c ON c.id = t.conversation_id
+WHERE t.last_message_id IS NOT NULL
+ORDER BY t.last_message_id DESC;`, email)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]int64, 0, len(rows))
+	messageIDs := make([]int64, 0, len(rows))
+	for _, r := range rows {
+		ids = append(ids, r.ID)
+		messageIDs = append(messageIDs, r.LastMessageID)
+	}
+
+	participants, err := s.listParticipants(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	query, args, err := sqlx.In(`
+SELECT id, conversation_id, type, sender_email, body, created_at
+FROM messages
+WHERE id IN (?);`, messageIDs)
+	if err != nil {
+		return nil, fmt.Errorf("build query: %v", err)
+	}
+
+	var messages []messageRow
+	if err := s.db.SelectContext(ctx, &messages, s.db.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+
+	lastMessages := make(map[int64]*conversation.Message, len(messages))
+	for _, m := range messages {
+		lastMessages[m.ConversationID] = newMessage(m)
+	}
+
+	res := make([]*conversation.Conversation, 0, len(rows))
+	for _, r := range rows {
+		c := newConversation(r.conversationRow, participants[r.ID])
+		c.LastMessage = lastMessages[r.ID]
+		c.UnreadCount = r.UnreadCount
+		res = append(res, c)
+	}
+	return res, nil
+}
+
+func (s *Storage) ListGroupConversationIDs(ctx context.Context, email string) ([]int64, error) {
+	var ids []int64
+	err := s.db.SelectContext(ctx, &ids, `
+SELECT c.id
+FROM conversations c
+         JOIN conversation_participants p ON p.conversation_id = c.id
+WHERE p.email = ?
+  AND c.direct_key IS NULL
+ORDER BY c.id;`, email)
+	return ids, err
+}
+
+func (s *Storage) InsertMessage(ctx context.Context, m *conversation.Message) error {
+	row := messageRow{
+		ConversationID: m.ConversationID,
+		Type:           string(m.Type),
+		SenderEmail:    m.Sender,
+		Body:           m.Body,
+		CreatedAt:      m.CreatedAt,
+	}
+
+	stmt := `
+INSERT INTO messages (conversation_id, type, sender_email, body, created_at)
+VALUES (:conversation_id, :type, :sender_email, :body, :created_at);`
+	r, err := s.db.NamedExecContext(ctx, stmt, row)
+	if isErrForeignKeyConstraint(err) {
+		return fmt.Errorf("%w: %v", storage.ErrInvalidArgument, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	m.ID, err = r.LastInsertId()
+	return err
+}
+
+func (s *Storage) GetMessage(ctx context.Context, id int64) (*conversation.Message, error) {
+	var row messageRow
+	err := s.db.GetContext(ctx, &row, `
+SELECT id, conversation_id, type, sender_email, body, created_at
+FROM messages
+WHERE id=?;`, id)
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newMessage(row), nil
+}
+
+func (s *Storage) ListMessages(ctx context.Context, req conversation.ListMessagesRequest) ([]*conversation.Message, error) {
+	args := []interface{}{req.Email, req.ConversationID, req.Email}
+
+	// Page by (created_at, id) so the (conversation_id, created_at, id) index serves the query
+	cursor := ""
+	if req.Before > 0 {
+		cursor = `
+  AND (m.created_at, m.id) < (SELECT b.created_at, b.id FROM messages b WHERE b.id = ?)`
+		args = append(args, req.Before)
+	}
+	args = append(args, req.Limit)
+
+	var rows []messageRow
+	err := s.db.SelectContext(ctx, &rows, `
+SELECT m.id, m.conversation_id, m.type, m.sender_email, m.body, m.created_at
+FROM messages m
+         JOIN conversation_participants p ON p.conversation_id = m.conversation_id AND p.email = ?
+WHERE m.conversation_id = ?
+  AND m.id > p.cleared_message_id
+  AND NOT EXISTS(SELECT message_id FROM hidden_messages h WHERE h.message_id = m.id AND h.email = ?)`+cursor+`
+ORDER BY m.created_at DESC, m.id DESC
+LIMIT ?;`, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*conversation.Message, 0, len(rows))
+	for _, r := range rows {
+		res = append(res, newMessage(r))
+	}
+	return res, nil
+}
+
+func (s *Storage) UpdateParticipant(ctx context.Context, conversationID int64, p *conversation.Participant) error {
+	r, err := s.db.ExecContext(ctx, `
+UPDATE conversation_participants
+SET role=?, last_read_message_id=?, cleared_message_id=?
+WHERE conversation_id=? AND email=?;`, newNullString(string(p.Role)), p.LastReadMessageID, p.ClearedMessageID, conversationID, p.Email)
+	if err != nil {
+		return err
+	}
+
+	n, err := r.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	// RowsAffected is 0 too if nothing changed, so only report not found when the participant doesn't exist
+	if n == 0 {
+		var exist bool
+		err := s.db.GetContext(ctx, &exist, `
+SELECT EXISTS(SELECT email FROM conversation_participants WHERE conversation_id=? AND email=?);`, conversationID, p.Email)
+		if err != nil {
+			return err
+		}
+		if !exist {
+			return storage.ErrNotFound
+		}
+	}
+	return nil
+}
+
+func (s *Storage) UpdateConversation(ctx context.Context, c *conversation.Conversation) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE conversations SET name=? WHERE id=?;`, newNullString(c.Name), c.ID)
+	return err
+}
+
+func (s *Storage) InsertParticipant(ctx context.Context, conversationID int64, p *conversation.Participant) error {
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO conversation_participants (conversation_id, email, role, joined_at, last_read_message_id, cleared_message_id)
+VALUES (?, ?, ?, ?, ?, ?);`, conversationID, p.Email, newNullString(string(p.Role)), p.JoinedAt, p.LastReadMessageID, p.ClearedMessageID)
+	if isDuplicate(err) {
+		return fmt.Errorf("%w: %v", storage.ErrAlreadyExist, err)
+	}
+	if isErrForeignKeyConstraint(err) {
+		return fmt.Errorf("%w: %v", storage.ErrInvalidArgument, err)
+	}
+	return err
+}
+
+func (s *Storage) DeleteParticipant(ctx context.Context, conversationID int64, email string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM conversation_participants WHERE conversation_id=? AND email=?;`, conversationID, email)
+	return err
+}
+
+func (s *Storage) HideMessage(ctx context.Context, messageID int64, email string) error {
+	_, err := s.db.ExecContext(ctx, `INSERT IGNORE INTO hidden_messages (message_id, email) VALUES (?, ?);`, messageID, email)
+	return err
+}
+
+func (s *Storage) withParticipants(ctx context.Context, row conversationRow) (*conversation.Conversation, error) {
+	participants, err := s.listParticipants(ctx, []int64{row.ID})
+	if err != nil {
+		return nil, err
+	}
+
+	return newConversation(row, participants[row.ID]), nil
+}
+
+// listParticipants returns the participants grouped by conversation ID.
+func (s *Storage) listParticipants(ctx context.Context, conversationIDs []int64) (map[int64][]*conversation.Participant, error) {
+	query, args, err := sqlx.In(`
+SELECT conversation_id, email, role, joined_at, last_read_message_id, cleared_message_id
+FROM conversation_participants
+WHERE conversation_id IN (?)
+ORDER BY conversation_id, joined_at, email;`, conversationIDs)
+	if err != nil {
+		return nil, fmt.Errorf("build query: %v", err)
+	}
+
+	var rows []participantRow
+	if err := s.db.SelectContext(ctx, &rows, s.db.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+
+	res := make(map[int64][]*conversation.Participant)
+	for _, r := range rows {
+		res[r.ConversationID] = append(res[r.ConversationID], &conversation.Participant{
+			Email:             r.Email,
+			Role:              conversation.Role(r.Role.String),
+			JoinedAt:          r.JoinedAt,
+			LastReadMessageID: r.LastReadMessageID,
+			ClearedMessageID:  r.ClearedMessageID,
+		})
+	}
+	return res, nil
+}
+
+func newConversation(row conversationRow, participants []*conversation.Participant) *conversation.Conversation {
+	return &conversation.Conversation{
+		ID:           row.ID,
+		DirectKey:    row.DirectKey.String,
+		Name:         row.Name.String,
+		Participants: participants,
+		CreatedAt:    row.CreatedAt,
+	}
+}
+
+func newMessage(row messageRow) *conversation.Message {
+	return &conversation.Message{
+		ID:             row.ID,
+		ConversationID: row.ConversationID,
+		Type:           conversation.MessageType(row.Type),
+		Sender:         row.SenderEmail,
+		Body:           row.Body,
+		CreatedAt:      row.CreatedAt,
+	}
+}
+
+// newNullString stores the empty string as NULL.
+func newNullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
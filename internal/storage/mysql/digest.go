@@ -0,0 +1,197 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/victornm/gtonline/internal/digest"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+type (
+	digestSubscriptionRow struct {
+		Email      string       `db:"email"`
+		Frequency  string       `db:"frequency"`
+		LastSentAt sql.NullTime `db:"last_sent_at"`
+	}
+
+	profileSnapshotRow struct {
+		Email       string `db:"email"`
+		CurrentCity string `db:"current_city"`
+		// Employers is the JSON array of the employer names.
+		Employers string `db:"employers"`
+	}
+
+	profileChangeRow struct {
+		ID        int64     `db:"id"`
+		Email     string    `db:"email"`
+		Field     string    `db:"field"`
+		OldValue  string    `db:"old_value"`
+		NewValue  string    `db:"new_value"`
+		ChangedAt time.Time `db:"changed_at"`
+	}
+)
+
+func (r digestSubscriptionRow) subscription() *digest.Subscription {
+	sub := &digest.Subscription{Email: r.Email, Frequency: digest.Frequency(r.Frequency)}
+	if r.LastSentAt.Valid {
+		t := r.LastSentAt.Time
+		sub.LastSentAt = &t
+	}
+	return sub
+}
+
+func (s *Storage) GetDigestSubscription(ctx context.Context, email string) (*digest.Subscription, error) {
+	var row digestSubscriptionRow
+	err := s.db.GetContext(ctx, &row, `SELECT email, frequency, last_sent_at FROM digest_subscriptions WHERE email=?;`, email)
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return row.subscription(), nil
+}
+
+func (s *Storage) SaveDigestSubscription(ctx context.Context, sub *digest.Subscription) error {
+	row := digestSubscriptionRow{Email: sub.Email, Frequency: string(sub.Frequency)}
+	if sub.LastSentAt != nil {
+		row.LastSentAt = sql.NullTime{Time: *sub.LastSentAt, Valid: true}
+	}
+
+	_, err := s.db.NamedExecContext(ctx, `
+INSERT INTO digest_subscriptions (email, frequency, last_sent_at)
+VALUES (:email, :frequency, :last_sent_at)
+ON DUPLICATE KEY UPDATE frequency=VALUES(frequency), last_sent_at=VALUES(last_sent_at);`, row)
+	if isErrForeignKeyConstraint(err) {
+		return fmt.Errorf("%w: %v", storage.ErrInvalidArgument, err)
+	}
+	return err
+}
+
+func (s *Storage) ListDigestSubscriptions(ctx context.Context) ([]*digest.Subscription, error) {
+	var rows []digestSubscriptionRow
+	err := s.db.SelectContext(ctx, &rows, `
+SELECT d.email, d.frequency, d.last_sent_at
+FROM digest_subscriptions d
+         JOIN users u ON u.email = d.email AND u.deactivated_at IS NULL
+WHERE d.frequency <> ?
+ORDER BY d.email;`, string(digest.Off))
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*digest.Subscription, 0, len(rows))
+	for _, r := range rows {
+		res = append(res, r.subscription())
+	}
+	return res, nil
+}
+
+func (s *Storage) GetProfileSnapshot(ctx context.Context, email string) (*digest.Snapshot, error) {
+	var row profileSnapshotRow
+	err := s.db.GetContext(ctx, &row, `SELECT email, current_city, employers FROM digest_profile_snapshots WHERE email=?;`, email)
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	snap := &digest.Snapshot{Email: row.Email, CurrentCity: row.CurrentCity}
+	if err := json.Unmarshal([]byte(row.Employers), &snap.Employers); err != nil {
+		return nil, fmt.Errorf("decode employers: %v", err)
+	}
+	return snap, nil
+}
+
+func (s *Storage) SaveProfileSnapshot(ctx context.Context, snap *digest.Snapshot, changes []*digest.Change) error {
+	employers, err := json.Marshal(snap.Employers)
+	if err != nil {
+		return fmt.Errorf("encode employers: %v", err)
+	}
+
+	return s.withTx(ctx, func(tx *Storage) error {
+		_, err := tx.db.NamedExecContext(ctx, `
+INSERT INTO digest_profile_snapshots (email, current_city, employers)
+VALUES (:email, :current_city, :employers)
+ON DUPLICATE KEY UPDATE current_city=VALUES(current_city), employers=VALUES(employers);`, profileSnapshotRow{
+			Email:       snap.Email,
+			CurrentCity: snap.CurrentCity,
+			Employers:   string(employers),
+		})
+		if isErrForeignKeyConstraint(err) {
+			return fmt.Errorf("%w: %v", storage.ErrInvalidArgument, err)
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, c := range changes {
+			r, err := tx.db.NamedExecContext(ctx, `
+INSERT INTO digest_profile_changes (email, field, old_value, new_value, changed_at)
+VALUES (:email, :field, :old_value, :new_value, :changed_at);`, profileChangeRow{
+				Email:     c.Email,
+				Field:     string(c.Field),
+				OldValue:  c.OldValue,
+				NewValue:  c.NewValue,
+				ChangedAt: c.ChangedAt,
+			})
+			if err != nil {
+				return err
+			}
+
+			if c.ID, err = r.LastInsertId(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Storage) ListProfileChanges(ctx context.Context, emails []string, since time.Time) ([]*digest.Change, error) {
+	if len(emails) == 0 {
+		return nil, nil
+	}
+
+	query, args, err := sqlx.In(`
+SELECT id, email, field, old_value, new_value, changed_at
+FROM digest_profile_changes
+WHERE email IN (?)
+  AND changed_at >= ?
+ORDER BY changed_at, id;`, emails, since)
+	if err != nil {
+		return nil, fmt.Errorf("build query: %v", err)
+	}
+
+	var rows []profileChangeRow
+	if err := s.db.SelectContext(ctx, &rows, s.db.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+
+	res := make([]*digest.Change, 0, len(rows))
+	for _, r := range rows {
+		res = append(res, &digest.Change{
+			ID:        r.ID,
+			Email:     r.Email,
+			Field:     digest.Field(r.Field),
+			OldValue:  r.OldValue,
+			NewValue:  r.NewValue,
+			ChangedAt: r.ChangedAt,
+		})
+	}
+	return res, nil
+}
+
+func (s *Storage) DeleteProfileChanges(ctx context.Context, before time.Time) (int64, error) {
+	r, err := s.db.ExecContext(ctx, `DELETE FROM digest_profile_changes WHERE changed_at < ?;`, before)
+	if err != nil {
+		return 0, err
+	}
+	return r.RowsAffected()
+}
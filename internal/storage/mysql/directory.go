@@ -0,0 +1,136 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/victornm/gtonline/internal/profile"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+func (s *Storage) GetDirectoryListing(ctx context.Context, email string) (bool, error) {
+	var listed bool
+	err := s.db.GetContext(ctx, &listed, `SELECT list_in_directory FROM regular_users WHERE email=?;`, email)
+	if err == sql.ErrNoRows {
+		return false, storage.ErrNotFound
+	}
+	return listed, err
+}
+
+func (s *Storage) UpdateDirectoryListing(ctx context.Context, email string, listed bool) error {
+	// RowsAffected is 0 when the value doesn't change, so the user is checked separately
+	if _, err := s.GetDirectoryListing(ctx, email); err != nil {
+		return err
+	}
+
+	_, err := s.db.ExecContext(ctx, `UPDATE regular_users SET list_in_directory=? WHERE email=?;`, listed, email)
+	return err
+}
+
+func (s *Storage) ListAlumni(ctx context.Context, req profile.ListAlumniRequest) ([]*profile.Alumnus, error) {
+	if err := s.checkExists(ctx, `SELECT EXISTS(SELECT 1 FROM schools WHERE school_name=?);`, req.School); err != nil {
+		return nil, err
+	}
+
+	conds := []string{"a.school_name=?"}
+	args := []interface{}{req.School}
+	if req.YearFrom != 0 {
+		conds = append(conds, "a.year_graduated>=?")
+		args = append(args, req.YearFrom)
+	}
+	if req.YearTo != 0 {
+		conds = append(conds, "a.year_graduated<=?")
+		args = append(args, req.YearTo)
+	}
+
+	var rows []struct {
+		Email         string        `db:"email"`
+		FirstName     string        `db:"first_name"`
+		LastName      string        `db:"last_name"`
+		YearGraduated sql.NullInt32 `db:"year_graduated"`
+	}
+	// An alumnus with several degrees of the school is in the cohort of the latest one
+	err := s.db.SelectContext(ctx, &rows, `
+WITH latest AS (
+    SELECT a.email, a.year_graduated,
+           ROW_NUMBER() OVER (PARTITION BY a.email ORDER BY a.year_graduated IS NULL, a.year_graduated DESC) AS n
+    FROM attends a
+    WHERE `+strings.Join(conds, " AND ")+`
+)
+SELECT u.email, u.first_name, u.last_name, l.year_graduated
+FROM latest l
+         JOIN regular_users ru ON ru.email = l.email
+         JOIN users u ON u.email = l.email
+WHERE l.n = 1 AND ru.list_in_directory AND u.deactivated_at IS NULL
+ORDER BY l.year_graduated IS NULL, l.year_graduated DESC, u.last_name, u.first_name, u.email;`, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*profile.Alumnus, 0, len(rows))
+	for _, r := range rows {
+		res = append(res, &profile.Alumnus{
+			Person:        profile.Person{Email: r.Email, FirstName: r.FirstName, LastName: r.LastName},
+			YearGraduated: int(r.YearGraduated.Int32),
+		})
+	}
+	return res, nil
+}
+
+func (s *Storage) ListCoworkers(ctx context.Context, employer string) ([]*profile.Coworker, error) {
+	if err := s.checkExists(ctx, `SELECT EXISTS(SELECT 1 FROM employers WHERE employer_name=?);`, employer); err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		Email     string `db:"email"`
+		FirstName string `db:"first_name"`
+		LastName  string `db:"last_name"`
+		JobTitle  string `db:"job_title"`
+	}
+	// A coworker with several jobs at the employer is under the current one, else the latest started
+	err := s.db.SelectContext(ctx, &rows, `
+WITH latest AS (
+    SELECT e.email, e.job_title,
+           ROW_NUMBER() OVER (PARTITION BY e.email ORDER BY e.is_current DESC, e.start_date DESC, e.job_title) AS n
+    FROM employments e
+    WHERE e.employer_name=? AND `+currentEmployment("e")+`
+)
+SELECT u.email, u.first_name, u.last_name, l.job_title
+FROM latest l
+         JOIN regular_users ru ON ru.email = l.email
+         JOIN users u ON u.email = l.email
+WHERE l.n = 1 AND ru.list_in_directory AND u.deactivated_at IS NULL
+ORDER BY l.job_title, u.last_name, u.first_name, u.email;`, employer)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*profile.Coworker, 0, len(rows))
+	for _, r := range rows {
+		res = append(res, &profile.Coworker{
+			Person:   profile.Person{Email: r.Email, FirstName: r.FirstName, LastName: r.LastName},
+			JobTitle: r.JobTitle,
+		})
+	}
+	return res, nil
+}
+
+// currentEmployment is the condition of the jobs not ended of the employments alias,
+// a job without an end date is current even if is_current isn't set.
+func currentEmployment(alias string) string {
+	return "(" + alias + ".is_current OR " + alias + ".end_date IS NULL)"
+}
+
+// checkExists returns storage.ErrNotFound if the EXISTS query is false.
+func (s *Storage) checkExists(ctx context.Context, query string, args ...interface{}) error {
+	var exists bool
+	if err := s.db.GetContext(ctx, &exists, query, args...); err != nil {
+		return err
+	}
+	if !exists {
+		return storage.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/victornm/gtonline/internal/event"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+type outboxRow struct {
+	ID            int64        `db:"id"`
+	Name          string       `db:"name"`
+	Payload       string       `db:"payload"`
+	Attempts      int          `db:"attempts"`
+	NextAttemptAt time.Time    `db:"next_attempt_at"`
+	LastError     string       `db:"last_error"`
+	CreatedAt     time.Time    `db:"created_at"`
+	DispatchedAt  sql.NullTime `db:"dispatched_at"`
+}
+
+// AppendEvents inserts the events to the outbox,
+// they are part of the transaction when s is the Storage given by withTx.
+func (s *Storage) AppendEvents(ctx context.Context, events ...event.Event) error {
+	now := time.Now()
+	for _, e := range events {
+		r, err := event.NewRecord(e, now)
+		if err != nil {
+			return err
+		}
+
+		_, err = s.db.NamedExecContext(ctx, `
+INSERT INTO outbox (name, payload, attempts, next_attempt_at, created_at)
+VALUES (:name, :payload, :attempts, :next_attempt_at, :created_at);`, newOutboxRow(r))
+		if err != nil {
+			return fmt.Errorf("insert %s to outbox: %v", r.Name, err)
+		}
+	}
+	return nil
+}
+
+func (s *Storage) ClaimEvents(ctx context.Context, now, leaseUntil time.Time, limit int) ([]*event.Record, error) {
+	var res []*event.Record
+
+	err := s.withTx(ctx, func(tx *Storage) error {
+		// SKIP LOCKED lets the other replicas claim the next events instead of waiting
+		var rows []outboxRow
+		err := tx.db.SelectContext(ctx, &rows, `
+SELECT id, name, payload, attempts, next_attempt_at, last_error, created_at, dispatched_at
+FROM outbox
+WHERE dispatched_at IS NULL
+  AND next_attempt_at <= ?
+ORDER BY id
+LIMIT ?
+FOR UPDATE SKIP LOCKED;`, now, limit)
+		if err != nil {
+			return err
+		}
+
+		if len(rows) == 0 {
+			return nil
+		}
+
+		ids := make([]int64, 0, len(rows))
+		for _, r := range rows {
+			ids = append(ids, r.ID)
+		}
+
+		query, args, err := sqlx.In(`UPDATE outbox SET next_attempt_at=? WHERE id IN (?);`, leaseUntil, ids)
+		if err != nil {
+			return fmt.Errorf("build query: %v", err)
+		}
+
+		if _, err := tx.db.ExecContext(ctx, tx.db.Rebind(query), args...); err != nil {
+			return err
+		}
+
+		for _, r := range rows {
+			r.NextAttemptAt = leaseUntil
+			res = append(res, newRecord(r))
+		}
+		return nil
+	})
+	return res, err
+}
+
+func (s *Storage) UpdateEvent(ctx context.Context, r *event.Record) error {
+	res, err := s.db.NamedExecContext(ctx, `
+UPDATE outbox
+SET attempts=:attempts,
+    next_attempt_at=:next_attempt_at,
+    last_error=:last_error,
+    dispatched_at=:dispatched_at
+WHERE id = :id;`, newOutboxRow(r))
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return fmt.Errorf("%w: event %d", storage.ErrNotFound, r.ID)
+	}
+	return nil
+}
+
+func (s *Storage) DeleteDispatchedEvents(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM outbox WHERE dispatched_at < ?;`, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *Storage) ListEventHandlers(ctx context.Context, id int64) ([]string, error) {
+	var res []string
+	if err := s.db.SelectContext(ctx, &res, `SELECT subscriber FROM outbox_handlers WHERE event_id=?;`, id); err != nil {
+		return nil, fmt.Errorf("query outbox_handlers: %v", err)
+	}
+	return res, nil
+}
+
+func (s *Storage) InsertEventHandler(ctx context.Context, id int64, subscriber string) error {
+	_, err := s.db.ExecContext(ctx, `INSERT IGNORE INTO outbox_handlers (event_id, subscriber, handled_at) VALUES (?, ?, ?);`,
+		id, subscriber, time.Now())
+	return err
+}
+
+func newOutboxRow(r *event.Record) outboxRow {
+	row := outboxRow{
+		ID:            r.ID,
+		Name:          r.Name,
+		Payload:       string(r.Payload),
+		Attempts:      r.Attempts,
+		NextAttemptAt: r.NextAttemptAt,
+		LastError:     truncate(r.LastError, 1000),
+		CreatedAt:     r.CreatedAt,
+	}
+	if r.DispatchedAt != nil {
+		row.DispatchedAt = sql.NullTime{Time: *r.DispatchedAt, Valid: true}
+	}
+	return row
+}
+
+func newRecord(row outboxRow) *event.Record {
+	r := &event.Record{
+		ID:            row.ID,
+		Name:          row.Name,
+		Payload:       []byte(row.Payload),
+		Attempts:      row.Attempts,
+		NextAttemptAt: row.NextAttemptAt,
+		LastError:     row.LastError,
+		CreatedAt:     row.CreatedAt,
+	}
+	if row.DispatchedAt.Valid {
+		t := row.DispatchedAt.Time
+		r.DispatchedAt = &t
+	}
+	return r
+}
@@ -0,0 +1,230 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/victornm/gtonline/internal/community"
+	"github.com/victornm/gtonline/internal/conversation"
+	"github.com/victornm/gtonline/internal/export"
+	"github.com/victornm/gtonline/internal/friend"
+	"github.com/victornm/gtonline/internal/meetup"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+type exportRow struct {
+	ID        int64        `db:"id"`
+	Email     string       `db:"email"`
+	Status    string       `db:"status"`
+	CreatedAt time.Time    `db:"created_at"`
+	ExpiresAt sql.NullTime `db:"expires_at"`
+}
+
+func (r *exportRow) toExport() *export.Export {
+	e := &export.Export{
+		ID:        r.ID,
+		Email:     r.Email,
+		Status:    export.Status(r.Status),
+		CreatedAt: r.CreatedAt,
+	}
+	if r.ExpiresAt.Valid {
+		t := r.ExpiresAt.Time
+		e.ExpiresAt = &t
+	}
+	return e
+}
+
+const exportColumns = `id, email, status, created_at, expires_at`
+
+func (s *Storage) InsertExport(ctx context.Context, e *export.Export, since time.Time) error {
+	return s.withTx(ctx, func(tx *Storage) error {
+		// Locking the user serializes the concurrent requests, so only one of them passes the check
+		var email string
+		err := tx.db.GetContext(ctx, &email, `SELECT email FROM users WHERE email=? FOR UPDATE;`, e.Email)
+		if err == sql.ErrNoRows {
+			return storage.ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("lock user: %v", err)
+		}
+
+		var recent bool
+		if err := tx.db.GetContext(ctx, &recent, `
+SELECT EXISTS(SELECT 1 FROM exports WHERE email=? AND status<>? AND created_at>?);`, e.Email, string(export.StatusFailed), since); err != nil {
+			return fmt.Errorf("query recent exports: %v", err)
+		}
+		if recent {
+			return storage.ErrAlreadyExist
+		}
+
+		r, err := tx.db.ExecContext(ctx, `INSERT INTO exports (email, status, created_at) VALUES (?, ?, ?);`,
+			e.Email, string(e.Status), e.CreatedAt)
+		if err != nil {
+			return err
+		}
+
+		e.ID, err = r.LastInsertId()
+		return err
+	})
+}
+
+func (s *Storage) GetExport(ctx context.Context, id int64) (*export.Export, error) {
+	var row exportRow
+	err := s.db.GetContext(ctx, &row, `SELECT `+exportColumns+` FROM exports WHERE id=?;`, id)
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return row.toExport(), nil
+}
+
+func (s *Storage) ListExports(ctx context.Context, email string, limit int) ([]*export.Export, error) {
+	var rows []exportRow
+	if err := s.db.SelectContext(ctx, &rows, `SELECT `+exportColumns+` FROM exports WHERE email=? ORDER BY id DESC LIMIT ?;`, email, limit); err != nil {
+		return nil, err
+	}
+	return exports(rows), nil
+}
+
+func (s *Storage) UpdateExport(ctx context.Context, e *export.Export) error {
+	var expiresAt sql.NullTime
+	if e.ExpiresAt != nil {
+		expiresAt = sql.NullTime{Time: *e.ExpiresAt, Valid: true}
+	}
+
+	r, err := s.db.ExecContext(ctx, `UPDATE exports SET status=?, expires_at=? WHERE id=?;`, string(e.Status), expiresAt, e.ID)
+	if err != nil {
+		return err
+	}
+
+	// The row may be unchanged, e.g. a failed export marked failed again
+	if n, err := r.RowsAffected(); err == nil && n == 0 {
+		_, err := s.GetExport(ctx, e.ID)
+		return err
+	}
+	return nil
+}
+
+func (s *Storage) ListExpiredExports(ctx context.Context, now time.Time) ([]*export.Export, error) {
+	var rows []exportRow
+	if err := s.db.SelectContext(ctx, &rows, `
+SELECT `+exportColumns+`
+FROM exports
+WHERE status=? AND expires_at<=?
+ORDER BY id;`, string(export.StatusReady), now); err != nil {
+		return nil, err
+	}
+	return exports(rows), nil
+}
+
+func exports(rows []exportRow) []*export.Export {
+	res := make([]*export.Export, 0, len(rows))
+	for i := range rows {
+		res = append(res, rows[i].toExport())
+	}
+	return res
+}
+
+// The reads below gather the data of a user for its archive, the deactivated users it refers to included.
+
+func (s *Storage) ListAllFriendships(ctx context.Context, email string) ([]*friend.Friendship, error) {
+	var rows []friendship
+	err := s.db.SelectContext(ctx, &rows, `
+SELECT email, friend_email, relationship, friend_relationship, date_connected, requested_at
+FROM friendships
+WHERE email=? OR friend_email=?
+ORDER BY requested_at, email, friend_email;`, email, email)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*friend.Friendship, 0, len(rows))
+	for _, r := range rows {
+		res = append(res, &friend.Friendship{
+			Email:              r.Email,
+			FriendEmail:        r.FriendEmail,
+			Relationship:       r.Relationship.String,
+			FriendRelationship: r.FriendRelationship.String,
+			DateConnected:      r.DateConnected.Time,
+			RequestedAt:        r.RequestedAt.Time,
+		})
+	}
+	return res, nil
+}
+
+func (s *Storage) ListSentMessages(ctx context.Context, email string) ([]*conversation.Message, error) {
+	var rows []messageRow
+	err := s.db.SelectContext(ctx, &rows, `
+SELECT id, conversation_id, type, sender_email, body, created_at
+FROM messages
+WHERE sender_email=?
+ORDER BY created_at, id;`, email)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*conversation.Message, 0, len(rows))
+	for _, r := range rows {
+		res = append(res, newMessage(r))
+	}
+	return res, nil
+}
+
+func (s *Storage) ListMemberGroups(ctx context.Context, email string) ([]*community.Group, error) {
+	var rows []countedGroupRow
+	err := s.db.SelectContext(ctx, &rows, `
+SELECT `+groupColumns+`, me.role AS role
+FROM community_groups g
+         JOIN community_members me ON me.group_id = g.id AND me.email = ?
+ORDER BY me.joined_at, g.id;`, email)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*community.Group, 0, len(rows))
+	for _, r := range rows {
+		res = append(res, r.group())
+	}
+	return res, nil
+}
+
+func (s *Storage) ListAuthoredPosts(ctx context.Context, email string) ([]*community.Post, error) {
+	var rows []groupPostRow
+	err := s.db.SelectContext(ctx, &rows, `
+SELECT id, group_id, author_email, body, created_at
+FROM community_posts
+WHERE author_email=?
+ORDER BY id;`, email)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*community.Post, 0, len(rows))
+	for _, r := range rows {
+		res = append(res, r.post())
+	}
+	return res, nil
+}
+
+func (s *Storage) ListUserMeetups(ctx context.Context, email string) ([]*meetup.Event, error) {
+	var rows []countedMeetupRow
+	err := s.db.SelectContext(ctx, &rows, `
+SELECT `+meetupColumns+`, me.status AS rsvp
+FROM meetups m
+         LEFT JOIN meetup_guests me ON me.meetup_id = m.id AND me.email = ?
+WHERE m.host_email = ? OR me.email IS NOT NULL
+ORDER BY m.starts_at, m.id;`, email, email)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*meetup.Event, 0, len(rows))
+	for _, r := range rows {
+		res = append(res, r.event())
+	}
+	return res, nil
+}
@@ -4,26 +4,38 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
 
 	"github.com/victornm/gtonline/internal/friend"
 	"github.com/victornm/gtonline/internal/storage"
 )
 
 type friendship struct {
-	Email         string         `db:"email"`
-	FriendEmail   string         `db:"friend_email"`
-	Relationship  sql.NullString `db:"relationship"`
-	DateConnected sql.NullTime   `db:"date_connected"`
+	Email              string         `db:"email"`
+	FriendEmail        string         `db:"friend_email"`
+	Relationship       sql.NullString `db:"relationship"`
+	FriendRelationship sql.NullString `db:"friend_relationship"`
+	DateConnected      sql.NullTime   `db:"date_connected"`
+	RequestedAt        sql.NullTime   `db:"requested_at"`
 }
 
 func (s *Storage) ListFriends(ctx context.Context, email string) ([]*friend.Friendship, error) {
+	// The deactivated friends are hidden
 	stmt := `
-SELECT email, friend_email, relationship, date_connected
-FROM friendships
-WHERE email=? AND date_connected IS NOT NULL;
+SELECT f.email, f.friend_email, f.relationship, f.friend_relationship, f.date_connected
+FROM friendships f
+         JOIN users u ON u.email = f.friend_email AND u.deactivated_at IS NULL
+WHERE f.email=? AND f.date_connected IS NOT NULL
+UNION ALL
+SELECT f.friend_email AS email, f.email AS friend_email, f.friend_relationship AS relationship, f.relationship AS friend_relationship, f.date_connected
+FROM friendships f
+         JOIN users u ON u.email = f.email AND u.deactivated_at IS NULL
+WHERE f.friend_email=? AND f.date_connected IS NOT NULL;
 `
 	var rows []friendship
-	err := s.db.SelectContext(ctx, &rows, stmt, email)
+	err := s.db.SelectContext(ctx, &rows, stmt, email, email)
 	if err != nil {
 		return nil, err
 	}
@@ -31,23 +43,26 @@ WHERE email=? AND date_connected IS NOT NULL;
 	res := make([]*friend.Friendship, 0, len(rows))
 	for _, r := range rows {
 		res = append(res, &friend.Friendship{
-			Email:         r.Email,
-			FriendEmail:   r.FriendEmail,
-			Relationship:  r.Relationship.String,
-			DateConnected: r.DateConnected.Time,
+			Email:              r.Email,
+			FriendEmail:        r.FriendEmail,
+			Relationship:       r.Relationship.String,
+			FriendRelationship: r.FriendRelationship.String,
+			DateConnected:      r.DateConnected.Time,
 		})
 	}
 	return res, nil
 }
 
 func (s *Storage) ListPendingFriendships(ctx context.Context, email string) ([]*friend.Friendship, error) {
+	// The requests from and to the deactivated users are hidden
 	stmt := `
-SELECT email, friend_email, relationship
-FROM friendships
-WHERE (email=? OR friend_email=?) AND date_connected IS NULL;
+SELECT f.email, f.friend_email, f.relationship, f.requested_at
+FROM friendships f
+         JOIN users u ON u.email = IF(f.email = ?, f.friend_email, f.email) AND u.deactivated_at IS NULL
+WHERE (f.email=? OR f.friend_email=?) AND f.date_connected IS NULL;
 `
 	var rows []friendship
-	err := s.db.SelectContext(ctx, &rows, stmt, email, email)
+	err := s.db.SelectContext(ctx, &rows, stmt, email, email, email)
 	if err != nil {
 		return nil, err
 	}
@@ -58,6 +73,7 @@ WHERE (email=? OR friend_email=?) AND date_connected IS NULL;
 			Email:        r.Email,
 			FriendEmail:  r.FriendEmail,
 			Relationship: r.Relationship.String,
+			RequestedAt:  r.RequestedAt.Time,
 		})
 	}
 	return res, nil
@@ -67,10 +83,12 @@ func (s *Storage) GetFriendship(ctx context.Context, email, friendEmail string)
 	var row friendship
 
 	err := s.db.GetContext(ctx, &row, `
-SELECT email, friend_email, relationship, date_connected 
-FROM friendships 
-WHERE email=? 
-  AND friend_email=?;`, email, friendEmail)
+SELECT f.email, f.friend_email, f.relationship, f.friend_relationship, f.date_connected, f.requested_at
+FROM friendships f
+         JOIN users u ON u.email = f.email AND u.deactivated_at IS NULL
+         JOIN users fu ON fu.email = f.friend_email AND fu.deactivated_at IS NULL
+WHERE f.email=?
+  AND f.friend_email=?;`, email, friendEmail)
 	if err == sql.ErrNoRows {
 		return nil, storage.ErrNotFound
 	}
@@ -80,28 +98,21 @@ WHERE email=?
 	}
 
 	return &friend.Friendship{
-		Email:         row.Email,
-		FriendEmail:   row.FriendEmail,
-		Relationship:  row.Relationship.String,
-		DateConnected: row.DateConnected.Time,
+		Email:              row.Email,
+		FriendEmail:        row.FriendEmail,
+		Relationship:       row.Relationship.String,
+		FriendRelationship: row.FriendRelationship.String,
+		DateConnected:      row.DateConnected.Time,
+		RequestedAt:        row.RequestedAt.Time,
 	}, nil
 }
 
 func (s *Storage) InsertFriendship(ctx context.Context, f *friend.Friendship) error {
-	row := friendship{
-		Email:       f.Email,
-		FriendEmail: f.FriendEmail,
-	}
-	if f.Relationship != "" {
-		row.Relationship = sql.NullString{
-			String: f.Relationship,
-			Valid:  true,
-		}
-	}
+	row := newFriendship(f)
 
 	stmt := `
-INSERT INTO friendships (email, friend_email, relationship)
-VALUES (:email, :friend_email, :relationship);`
+INSERT INTO friendships (email, friend_email, relationship, friend_relationship, date_connected, requested_at)
+VALUES (:email, :friend_email, :relationship, :friend_relationship, :date_connected, COALESCE(:requested_at, CURRENT_TIMESTAMP));`
 
 	_, err := s.db.NamedExecContext(ctx, stmt, row)
 	if isDuplicate(err) {
@@ -114,6 +125,22 @@ VALUES (:email, :friend_email, :relationship);`
 }
 
 func (s *Storage) UpdateFriendship(ctx context.Context, f *friend.Friendship) error {
+	row := newFriendship(f)
+
+	stmt := `
+UPDATE friendships 
+SET relationship=:relationship, friend_relationship=:friend_relationship, date_connected=:date_connected, 
+    requested_at=COALESCE(:requested_at, requested_at)
+WHERE email=:email AND friend_email=:friend_email;
+`
+	_, err := s.db.NamedExecContext(ctx, stmt, row)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func newFriendship(f *friend.Friendship) friendship {
 	row := friendship{
 		Email:       f.Email,
 		FriendEmail: f.FriendEmail,
@@ -125,6 +152,13 @@ func (s *Storage) UpdateFriendship(ctx context.Context, f *friend.Friendship) er
 		}
 	}
 
+	if f.FriendRelationship != "" {
+		row.FriendRelationship = sql.NullString{
+			String: f.FriendRelationship,
+			Valid:  true,
+		}
+	}
+
 	if !f.DateConnected.IsZero() {
 		row.DateConnected = sql.NullTime{
 			Time:  f.DateConnected,
@@ -132,16 +166,13 @@ func (s *Storage) UpdateFriendship(ctx context.Context, f *friend.Friendship) er
 		}
 	}
 
-	stmt := `
-UPDATE friendships 
-SET relationship=:relationship, date_connected=:date_connected
-WHERE email=:email AND friend_email=:friend_email;
-`
-	_, err := s.db.NamedExecContext(ctx, stmt, row)
-	if err != nil {
-		return err
+	if !f.RequestedAt.IsZero() {
+		row.RequestedAt = sql.NullTime{
+			Time:  f.RequestedAt,
+			Valid: true,
+		}
 	}
-	return nil
+	return row
 }
 
 func (s *Storage) DeleteFriendRequest(ctx context.Context, email, friendEmail string) error {
@@ -152,3 +183,116 @@ AND email=? AND friend_email=?;`
 	_, err := s.db.ExecContext(ctx, stmt, email, friendEmail)
 	return err
 }
+
+func (s *Storage) DeleteExpiredFriendRequests(ctx context.Context, before time.Time) (int64, error) {
+	stmt := `
+DELETE FROM friendships
+WHERE date_connected IS NULL
+AND requested_at < ?;`
+	r, err := s.db.ExecContext(ctx, stmt, before)
+	if err != nil {
+		return 0, err
+	}
+	return r.RowsAffected()
+}
+
+func (s *Storage) ListConnections(ctx context.Context, emails []string, after friend.Connection, limit int) ([]friend.Connection, error) {
+	if len(emails) == 0 {
+		return nil, nil
+	}
+
+	// Each direction reads at most limit rows after the cursor through its index, instead of the whole UNION.
+	// The deactivated users are not connected to anyone.
+	stmt := `
+(SELECT f.email, f.friend_email
+ FROM friendships f
+	JOIN users u ON u.email = f.friend_email AND u.deactivated_at IS NULL
+ WHERE f.email IN (?) AND f.date_connected IS NOT NULL AND (f.email, f.friend_email) > (?, ?)
+ ORDER BY f.email, f.friend_email
+ LIMIT ?)
+UNION
+(SELECT f.friend_email AS email, f.email AS friend_email
+ FROM friendships f
+	JOIN users u ON u.email = f.email AND u.deactivated_at IS NULL
+ WHERE f.friend_email IN (?) AND f.date_connected IS NOT NULL AND (f.friend_email, f.email) > (?, ?)
+ ORDER BY f.friend_email, f.email
+ LIMIT ?)
+ORDER BY email, friend_email
+LIMIT ?;`
+
+	query, args, err := sqlx.In(stmt,
+		emails, after.Email, after.FriendEmail, limit,
+		emails, after.Email, after.FriendEmail, limit,
+		limit)
+	if err != nil {
+		return nil, fmt.Errorf("build query: %v", err)
+	}
+
+	var rows []friendship
+	if err := s.db.SelectContext(ctx, &rows, s.db.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+
+	res := make([]friend.Connection, 0, len(rows))
+	for _, r := range rows {
+		res = append(res, friend.Connection{
+			Email:       r.Email,
+			FriendEmail: r.FriendEmail,
+		})
+	}
+	return res, nil
+}
+
+func (s *Storage) WithFriendshipLock(ctx context.Context, email, friendEmail string, f func(ctx context.Context, tx friend.Storage) error) error {
+	return s.withTx(ctx, func(tx *Storage) error {
+		// Lock both users in the order of the primary key,
+		// so concurrent requests between the same 2 users wait for each other instead of deadlocking.
+		query, args, err := sqlx.In(`SELECT email FROM regular_users WHERE email IN (?) ORDER BY email FOR UPDATE;`, []string{email, friendEmail})
+		if err != nil {
+			return fmt.Errorf("build query: %v", err)
+		}
+
+		var locked []string
+		if err := tx.db.SelectContext(ctx, &locked, tx.db.Rebind(query), args...); err != nil {
+			return fmt.Errorf("lock users: %v", err)
+		}
+
+		return f(ctx, tx)
+	})
+}
+
+func (s *Storage) ListRelationshipTypes(ctx context.Context) ([]friend.RelationshipType, error) {
+	var types []friend.RelationshipType
+
+	stmt := `SELECT name FROM relationship_types ORDER BY name;`
+	if err := s.db.SelectContext(ctx, &types, stmt); err != nil {
+		return nil, fmt.Errorf("query relationship_types: %v", err)
+	}
+
+	return types, nil
+}
+
+func (s *Storage) InsertRelationshipType(ctx context.Context, t friend.RelationshipType) error {
+	_, err := s.db.NamedExecContext(ctx, `INSERT INTO relationship_types (name) VALUES (:name);`, t)
+	if isDuplicate(err) {
+		return fmt.Errorf("%w: %v", storage.ErrAlreadyExist, err)
+	}
+	return err
+}
+
+func (s *Storage) DeleteRelationshipType(ctx context.Context, name string) error {
+	r, err := s.db.ExecContext(ctx, `DELETE FROM relationship_types WHERE name=?;`, name)
+	if err != nil {
+		return err
+	}
+
+	n, err := r.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
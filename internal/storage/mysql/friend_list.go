@@ -0,0 +1,147 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/victornm/gtonline/internal/friend"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+type (
+	friendList struct {
+		ID         int64  `db:"id"`
+		OwnerEmail string `db:"owner_email"`
+		Name       string `db:"name"`
+	}
+
+	friendListMember struct {
+		ListID      int64  `db:"list_id"`
+		MemberEmail string `db:"member_email"`
+	}
+)
+
+func (s *Storage) ListFriendLists(ctx context.Context, owner string) ([]*friend.List, error) {
+	var rows []friendList
+	stmt := `SELECT id, owner_email, name FROM friend_lists WHERE owner_email=? ORDER BY id;`
+	if err := s.db.SelectContext(ctx, &rows, stmt, owner); err != nil {
+		return nil, fmt.Errorf("query friend_lists: %v", err)
+	}
+
+	var members []friendListMember
+	stmt = `
+SELECT m.list_id, m.member_email 
+FROM friend_list_members AS m 
+JOIN friend_lists AS l ON l.id = m.list_id 
+WHERE l.owner_email=?;`
+	if err := s.db.SelectContext(ctx, &members, stmt, owner); err != nil {
+		return nil, fmt.Errorf("query friend_list_members: %v", err)
+	}
+
+	byID := make(map[int64]*friend.List, len(rows))
+	res := make([]*friend.List, 0, len(rows))
+	for _, r := range rows {
+		l := &friend.List{ID: r.ID, Owner: r.OwnerEmail, Name: r.Name}
+		byID[r.ID] = l
+		res = append(res, l)
+	}
+
+	for _, m := range members {
+		if l, ok := byID[m.ListID]; ok {
+			l.Members = append(l.Members, m.MemberEmail)
+		}
+	}
+
+	return res, nil
+}
+
+func (s *Storage) GetFriendList(ctx context.Context, id int64) (*friend.List, error) {
+	var row friendList
+	err := s.db.GetContext(ctx, &row, `SELECT id, owner_email, name FROM friend_lists WHERE id=?;`, id)
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	l := &friend.List{ID: row.ID, Owner: row.OwnerEmail, Name: row.Name}
+	stmt := `SELECT member_email FROM friend_list_members WHERE list_id=?;`
+	if err := s.db.SelectContext(ctx, &l.Members, stmt, id); err != nil {
+		return nil, fmt.Errorf("query friend_list_members: %v", err)
+	}
+
+	return l, nil
+}
+
+func (s *Storage) InsertFriendList(ctx context.Context, l *friend.List) error {
+	row := friendList{OwnerEmail: l.Owner, Name: l.Name}
+	r, err := s.db.NamedExecContext(ctx, `INSERT INTO friend_lists (owner_email, name) VALUES (:owner_email, :name);`, row)
+	if isDuplicate(err) {
+		return fmt.Errorf("%w: %v", storage.ErrAlreadyExist, err)
+	}
+	if isErrForeignKeyConstraint(err) {
+		return fmt.Errorf("%w: %v", storage.ErrInvalidArgument, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	l.ID, err = r.LastInsertId()
+	return err
+}
+
+func (s *Storage) UpdateFriendList(ctx context.Context, l *friend.List) error {
+	row := friendList{ID: l.ID, Name: l.Name}
+	_, err := s.db.NamedExecContext(ctx, `UPDATE friend_lists SET name=:name WHERE id=:id;`, row)
+	if isDuplicate(err) {
+		return fmt.Errorf("%w: %v", storage.ErrAlreadyExist, err)
+	}
+	return err
+}
+
+func (s *Storage) DeleteFriendList(ctx context.Context, id int64) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM friend_lists WHERE id=?;`, id)
+	return err
+}
+
+func (s *Storage) InsertFriendListMember(ctx context.Context, id int64, email string) error {
+	row := friendListMember{ListID: id, MemberEmail: email}
+	_, err := s.db.NamedExecContext(ctx, `INSERT INTO friend_list_members (list_id, member_email) VALUES (:list_id, :member_email);`, row)
+	if isDuplicate(err) {
+		return fmt.Errorf("%w: %v", storage.ErrAlreadyExist, err)
+	}
+	if isErrForeignKeyConstraint(err) {
+		return fmt.Errorf("%w: %v", storage.ErrInvalidArgument, err)
+	}
+	return err
+}
+
+func (s *Storage) DeleteFriendListMember(ctx context.Context, id int64, email string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM friend_list_members WHERE list_id=? AND member_email=?;`, id, email)
+	return err
+}
+
+func (s *Storage) IsFriendListMember(ctx context.Context, owner string, ids []int64, email string) (bool, error) {
+	stmt := `
+SELECT EXISTS(
+	SELECT 1
+	FROM friend_list_members AS m
+	JOIN friend_lists AS l ON l.id = m.list_id
+	WHERE l.owner_email=? AND l.id IN (?) AND m.member_email=?
+);`
+	query, args, err := sqlx.In(stmt, owner, ids, email)
+	if err != nil {
+		return false, fmt.Errorf("build query: %v", err)
+	}
+
+	var exist bool
+	if err := s.db.GetContext(ctx, &exist, s.db.Rebind(query), args...); err != nil {
+		return false, err
+	}
+	return exist, nil
+}
@@ -6,6 +6,7 @@ package mysql_test
 import (
 	"context"
 	"errors"
+	"fmt"
 	"os"
 	"sync"
 	"testing"
@@ -16,6 +17,11 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/victornm/gtonline/internal/auth"
+	"github.com/victornm/gtonline/internal/community"
+	"github.com/victornm/gtonline/internal/digest"
+	"github.com/victornm/gtonline/internal/friend"
+	"github.com/victornm/gtonline/internal/meetup"
+	"github.com/victornm/gtonline/internal/notification"
 	"github.com/victornm/gtonline/internal/profile"
 	"github.com/victornm/gtonline/internal/server"
 	"github.com/victornm/gtonline/internal/storage"
@@ -74,7 +80,7 @@ func TestUpdateProfile(t *testing.T) {
 		Education:    []profile.Attend{{School: "University of Oxford", YearGraduated: 2021}},
 		Professional: []profile.Employment{{Employer: "Microsoft", JobTitle: "CEO"}},
 	}
-	err = s.UpdateProfile(ctx, req)
+	err = s.UpdateProfile(ctx, req, nil)
 	require.NoError(t, err)
 	p, err = s.GetProfile(ctx, email)
 	require.NoError(t, err)
@@ -88,7 +94,7 @@ func TestUpdateProfile(t *testing.T) {
 	req.Interests = []string{"Technology"}
 	req.Education = nil
 	req.Professional = append(req.Professional, profile.Employment{Employer: "Apple", JobTitle: "CTO"})
-	err = s.UpdateProfile(ctx, req)
+	err = s.UpdateProfile(ctx, req, nil)
 	require.NoError(t, err)
 	p, err = s.GetProfile(ctx, email)
 	require.NoError(t, err)
@@ -130,12 +136,469 @@ func TestUpdateProfileInvalidEmployer(t *testing.T) {
 		Hometown:     "BarCity",
 		Professional: []profile.Employment{{Employer: "Tiki", JobTitle: "CEO"}},
 	}
-	err = s.UpdateProfile(ctx, req)
+	err = s.UpdateProfile(ctx, req, nil)
 	require.Error(t, err)
 
 	assert.True(t, errors.Is(err, storage.ErrInvalidArgument), err)
 }
 
+func TestUpdateProfile_EducationAndProfessional(t *testing.T) {
+	s := makeStorage(t)
+
+	ctx := context.Background()
+	email := "period@bar.com"
+	require.NoError(t, s.CreateRegularUser(ctx, auth.User{Email: email, HashedPassword: "123", FirstName: "foo", LastName: "bar"}))
+	t.Cleanup(func() {
+		if err := s.DeleteUser(ctx, email); err != nil {
+			t.Errorf("delete user failed: %v", err)
+		}
+	})
+
+	date := func(year int, month time.Month) time.Time {
+		return time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	// Several entries at the same school and employer differ by their optional columns
+	education := []profile.Attend{
+		{School: "University of Oxford", YearGraduated: 2015, Degree: "BSc", Major: "Physics", StartDate: date(2012, 9), EndDate: date(2015, 6)},
+		{School: "University of Oxford", Degree: "PhD", StartDate: date(2016, 9), IsCurrent: true},
+		{School: "University of Oxford"},
+	}
+	professional := []profile.Employment{
+		{Employer: "Microsoft", JobTitle: "Engineer", StartDate: date(2015, 7), EndDate: date(2016, 8)},
+		{Employer: "Microsoft", JobTitle: "Engineer", StartDate: date(2020, 1), IsCurrent: true},
+		{Employer: "Microsoft", JobTitle: "Engineer"},
+	}
+	req := profile.UpdateProfileRequest{Email: email, Education: education, Professional: professional}
+	require.NoError(t, s.UpdateProfile(ctx, req, nil))
+
+	p, err := s.GetProfile(ctx, email)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, education, p.Education)
+	assert.ElementsMatch(t, professional, p.Professional)
+
+	t.Run("duplicate entries without the optional columns", func(t *testing.T) {
+		dup := req
+		dup.Education = []profile.Attend{{School: "University of Oxford"}, {School: "University of Oxford"}}
+		err := s.UpdateProfile(ctx, dup, nil)
+		assert.True(t, errors.Is(err, storage.ErrInvalidArgument), err)
+
+		dup = req
+		dup.Professional = []profile.Employment{{Employer: "Apple", JobTitle: "CTO"}, {Employer: "Apple", JobTitle: "CTO"}}
+		err = s.UpdateProfile(ctx, dup, nil)
+		assert.True(t, errors.Is(err, storage.ErrInvalidArgument), err)
+
+		p, err := s.GetProfile(ctx, email)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, education, p.Education, "the failed updates are rolled back")
+	})
+}
+
+func TestCreateRegularUser_Outbox(t *testing.T) {
+	s := makeStorage(t)
+
+	ctx := context.Background()
+	email := "outbox@bar.com"
+	u := auth.User{Email: email, HashedPassword: "123", FirstName: "foo", LastName: "bar"}
+	e := auth.UserRegistered{Email: email, FirstName: "foo", LastName: "bar"}
+
+	require.NoError(t, s.CreateRegularUser(ctx, u, e))
+	t.Cleanup(func() {
+		if err := s.DeleteUser(ctx, email); err != nil {
+			t.Errorf("delete user failed: %v", err)
+		}
+	})
+
+	err := s.CreateRegularUser(ctx, u, e)
+	require.True(t, errors.Is(err, storage.ErrAlreadyExist))
+
+	// Claim far in the future so the events appended by the other tests are claimed too
+	now := time.Now().Add(time.Hour)
+	records, err := s.ClaimEvents(ctx, now, now, 1000)
+	require.NoError(t, err)
+
+	var found int
+	for _, r := range records {
+		var got auth.UserRegistered
+		require.NoError(t, r.Decode(&got))
+		if r.Name == auth.EventUserRegistered && got.Email == email {
+			found++
+		}
+	}
+	assert.Equal(t, 1, found, "the event of the failed creation is rolled back")
+}
+
+func TestSaveProfileSnapshot(t *testing.T) {
+	s := makeStorage(t)
+
+	ctx := context.Background()
+	email := "digest@bar.com"
+	require.NoError(t, s.CreateRegularUser(ctx, auth.User{Email: email, HashedPassword: "123", FirstName: "foo", LastName: "bar"}))
+	t.Cleanup(func() {
+		if err := s.DeleteUser(ctx, email); err != nil {
+			t.Errorf("delete user failed: %v", err)
+		}
+	})
+
+	_, err := s.GetProfileSnapshot(ctx, email)
+	require.True(t, errors.Is(err, storage.ErrNotFound))
+
+	at := time.Now().Truncate(time.Second)
+	snap := &digest.Snapshot{Email: email, CurrentCity: "Saigon", Employers: []string{"Stark Industries"}}
+	changes := []*digest.Change{{Email: email, Field: digest.FieldCurrentCity, OldValue: "Hanoi", NewValue: "Saigon", ChangedAt: at}}
+	require.NoError(t, s.SaveProfileSnapshot(ctx, snap, changes))
+	assert.NotZero(t, changes[0].ID)
+
+	got, err := s.GetProfileSnapshot(ctx, email)
+	require.NoError(t, err)
+	assert.Equal(t, snap, got)
+
+	listed, err := s.ListProfileChanges(ctx, []string{email}, at)
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	assert.Equal(t, "Saigon", listed[0].NewValue)
+}
+
+func TestProfileVersions(t *testing.T) {
+	s := makeStorage(t)
+
+	ctx := context.Background()
+	email := "versions@bar.com"
+	require.NoError(t, s.CreateRegularUser(ctx, auth.User{Email: email, HashedPassword: "123", FirstName: "foo", LastName: "bar"}))
+	t.Cleanup(func() {
+		if err := s.DeleteUser(ctx, email); err != nil {
+			t.Errorf("delete user failed: %v", err)
+		}
+	})
+
+	// The concurrent updates wait for each other on the user row, so they get consecutive versions
+	const updates = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, updates)
+	for i := 0; i < updates; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := profile.UpdateProfileRequest{Email: email, CurrentCity: fmt.Sprintf("City %d", i)}
+			v := &profile.ProfileVersion{Actor: email, Profile: profile.Profile{Email: email, CurrentCity: req.CurrentCity}, CreatedAt: time.Now()}
+			errs <- s.UpdateProfile(ctx, req, v)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	versions, err := s.ListProfileVersions(ctx, profile.ListProfileHistoryRequest{Email: email, Limit: 100})
+	require.NoError(t, err)
+	require.Len(t, versions, updates)
+	for i, v := range versions {
+		assert.Equal(t, int64(updates-i), v.Version, "the newest first")
+	}
+
+	page, err := s.ListProfileVersions(ctx, profile.ListProfileHistoryRequest{Email: email, Before: 4, Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	assert.Equal(t, int64(3), page[0].Version)
+	assert.Equal(t, int64(2), page[1].Version)
+
+	_, err = s.GetProfileVersion(ctx, email, updates+1)
+	assert.True(t, errors.Is(err, storage.ErrNotFound))
+
+	t.Run("restore", func(t *testing.T) {
+		old, err := s.GetProfileVersion(ctx, email, 3)
+		require.NoError(t, err)
+
+		p, err := profile.NewService(s, profile.Config{}).RestoreProfile(ctx, profile.RestoreProfileRequest{Email: email, Version: 3, Actor: "admin@bar.com"})
+		require.NoError(t, err)
+		assert.Equal(t, old.Profile.CurrentCity, p.CurrentCity)
+
+		latest, err := s.GetProfileVersion(ctx, email, updates+1)
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), latest.RestoredFrom)
+		assert.Equal(t, "admin@bar.com", latest.Actor)
+		assert.Equal(t, old.Profile.CurrentCity, latest.Profile.CurrentCity)
+	})
+}
+
+func TestListFriendBirthdays(t *testing.T) {
+	s := makeStorage(t)
+
+	ctx := context.Background()
+	birthdates := map[string]time.Time{
+		"leap@bar.com":   time.Date(1992, 2, 29, 0, 0, 0, 0, time.UTC),
+		"march@bar.com":  time.Date(1990, 3, 10, 0, 0, 0, 0, time.UTC),
+		"hidden@bar.com": time.Date(1990, 3, 1, 0, 0, 0, 0, time.UTC),
+	}
+	emails := []string{"birthday@bar.com", "leap@bar.com", "march@bar.com", "hidden@bar.com"}
+	for _, email := range emails {
+		email := email
+		require.NoError(t, s.CreateRegularUser(ctx, auth.User{Email: email, HashedPassword: "123", FirstName: "foo", LastName: "bar"}))
+		t.Cleanup(func() {
+			if err := s.DeleteUser(ctx, email); err != nil {
+				t.Errorf("delete user failed: %v", err)
+			}
+		})
+
+		if b, ok := birthdates[email]; ok {
+			require.NoError(t, s.UpdateProfile(ctx, profile.UpdateProfileRequest{Email: email, Birthdate: b}, nil))
+			require.NoError(t, s.InsertFriendship(ctx, &friend.Friendship{Email: email, FriendEmail: emails[0], DateConnected: time.Now()}))
+		}
+	}
+	require.NoError(t, s.UpdateBirthdaySharing(ctx, "hidden@bar.com", false))
+
+	// Feb 29 is on Feb 28 in 2021
+	birthdays, err := s.ListFriendBirthdays(ctx, emails[0], time.Date(2021, 2, 28, 0, 0, 0, 0, time.UTC), 30)
+	require.NoError(t, err)
+	require.Len(t, birthdays, 2)
+	assert.Equal(t, "leap@bar.com", birthdays[0].Email)
+	assert.Equal(t, time.Date(2021, 2, 28, 0, 0, 0, 0, time.UTC), birthdays[0].Next)
+	assert.Equal(t, time.Date(2021, 3, 10, 0, 0, 0, 0, time.UTC), birthdays[1].Next)
+
+	birthdays, err = s.ListFriendBirthdays(ctx, emails[0], time.Date(2021, 12, 20, 0, 0, 0, 0, time.UTC), 366)
+	require.NoError(t, err)
+	require.Len(t, birthdays, 2)
+	assert.Equal(t, time.Date(2022, 2, 28, 0, 0, 0, 0, time.UTC), birthdays[0].Next)
+
+	reminders, err := s.ListBirthdayReminders(ctx, time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Contains(t, reminders, friend.BirthdayReminder{Email: emails[0], FriendEmail: "leap@bar.com"})
+}
+
+func TestListConnections_Pages(t *testing.T) {
+	s := makeStorage(t)
+
+	ctx := context.Background()
+	emails := []string{"conn-a@bar.com", "conn-b@bar.com", "conn-c@bar.com", "conn-d@bar.com", "conn-e@bar.com"}
+	for _, email := range emails {
+		email := email
+		require.NoError(t, s.CreateRegularUser(ctx, auth.User{Email: email, HashedPassword: "123", FirstName: "foo", LastName: "bar"}))
+		t.Cleanup(func() {
+			if err := s.DeleteUser(ctx, email); err != nil {
+				t.Errorf("delete user failed: %v", err)
+			}
+		})
+	}
+
+	// Some connections are only found in the reverse direction of the table, e only sent a request
+	now := time.Now()
+	for _, f := range []*friend.Friendship{
+		{Email: emails[0], FriendEmail: emails[1], DateConnected: now},
+		{Email: emails[3], FriendEmail: emails[0], DateConnected: now},
+		{Email: emails[2], FriendEmail: emails[1], DateConnected: now},
+		{Email: emails[4], FriendEmail: emails[0]},
+	} {
+		require.NoError(t, s.InsertFriendship(ctx, f))
+	}
+
+	var got []friend.Connection
+	var after friend.Connection
+	for {
+		page, err := s.ListConnections(ctx, emails[:3], after, 2)
+		require.NoError(t, err)
+		got = append(got, page...)
+		if len(page) < 2 {
+			break
+		}
+		after = page[len(page)-1]
+	}
+
+	assert.Equal(t, []friend.Connection{
+		{Email: emails[0], FriendEmail: emails[1]},
+		{Email: emails[0], FriendEmail: emails[3]},
+		{Email: emails[1], FriendEmail: emails[0]},
+		{Email: emails[1], FriendEmail: emails[2]},
+		{Email: emails[2], FriendEmail: emails[1]},
+	}, got)
+}
+
+func TestCreateFriend_ConcurrentCrossingRequests(t *testing.T) {
+	s := makeStorage(t)
+
+	ctx := context.Background()
+	foo, bar := "foo@bar.com", "bar@foo.com"
+	for _, email := range []string{foo, bar} {
+		email := email
+		err := s.CreateRegularUser(ctx, auth.User{
+			Email:          email,
+			HashedPassword: "123",
+			FirstName:      "foo",
+			LastName:       "bar",
+		})
+		require.NoError(t, err, "create user failed")
+		t.Cleanup(func() {
+			if err := s.DeleteUser(ctx, email); err != nil {
+				t.Errorf("delete user failed: %v", err)
+			}
+		})
+	}
+
+	svc := friend.NewService(s, friend.DefaultConfig())
+	errs := make(chan error, 2)
+	for _, req := range []friend.CreateFriendRequest{
+		{Email: foo, FriendEmail: bar, Relationship: "Co-worker"},
+		{Email: bar, FriendEmail: foo, Relationship: "Co-worker"},
+	} {
+		go func(req friend.CreateFriendRequest) {
+			errs <- svc.CreateFriend(ctx, req)
+		}(req)
+	}
+	require.NoError(t, <-errs)
+	require.NoError(t, <-errs)
+
+	pending, err := s.ListPendingFriendships(ctx, foo)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	for _, email := range []string{foo, bar} {
+		friends, err := s.ListFriends(ctx, email)
+		require.NoError(t, err)
+		require.Len(t, friends, 1)
+		assert.Equal(t, "Co-worker", friends[0].Relationship)
+		assert.Equal(t, "Co-worker", friends[0].FriendRelationship)
+	}
+}
+
+func TestRSVP_ConcurrentCapacity(t *testing.T) {
+	s := makeStorage(t)
+
+	ctx := context.Background()
+	host := "host@bar.com"
+	guests := []string{"one@bar.com", "two@bar.com", "three@bar.com", "four@bar.com"}
+	for _, email := range append([]string{host}, guests...) {
+		email := email
+		require.NoError(t, s.CreateRegularUser(ctx, auth.User{Email: email, HashedPassword: "123", FirstName: "foo", LastName: "bar"}))
+		t.Cleanup(func() {
+			if err := s.DeleteUser(ctx, email); err != nil {
+				t.Errorf("delete user failed: %v", err)
+			}
+		})
+	}
+
+	svc := meetup.NewService(s, s, meetup.Config{Secret: "secret"})
+	startsAt := time.Now().Add(time.Hour)
+	e, err := svc.CreateEvent(ctx, meetup.CreateEventRequest{
+		Email:      host,
+		Title:      "Picnic",
+		City:       "Atlanta",
+		StartsAt:   startsAt,
+		EndsAt:     startsAt.Add(time.Hour),
+		Visibility: meetup.Public,
+		Capacity:   2,
+	})
+	require.NoError(t, err)
+
+	errs := make(chan error, len(guests))
+	for _, email := range guests {
+		go func(email string) {
+			_, err := svc.RSVP(ctx, meetup.RSVPRequest{Email: email, EventID: e.ID, Response: meetup.Yes})
+			errs <- err
+		}(email)
+	}
+	for range guests {
+		require.NoError(t, <-errs)
+	}
+
+	got, err := s.GetMeetup(ctx, e.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, got.Going)
+	assert.Equal(t, 2, got.Waitlisted)
+
+	upcoming, err := s.ListUpcomingMeetups(ctx, guests[0], time.Now(), 10)
+	require.NoError(t, err)
+	require.Len(t, upcoming, 1)
+	assert.NotEmpty(t, upcoming[0].RSVP)
+}
+
+func TestLeaveGroup_Concurrent(t *testing.T) {
+	s := makeStorage(t)
+
+	ctx := context.Background()
+	emails := []string{"group-owner@bar.com", "group-mod@bar.com", "group-member@bar.com"}
+	for _, email := range emails {
+		email := email
+		require.NoError(t, s.CreateRegularUser(ctx, auth.User{Email: email, HashedPassword: "123", FirstName: "foo", LastName: "bar"}))
+		t.Cleanup(func() {
+			if err := s.DeleteUser(ctx, email); err != nil {
+				t.Errorf("delete user failed: %v", err)
+			}
+		})
+	}
+
+	svc := community.NewService(s, s)
+	g, err := svc.CreateGroup(ctx, community.CreateGroupRequest{Email: emails[0], Name: "Leaving together", Topic: "Chess", Privacy: community.Public})
+	require.NoError(t, err)
+	for _, email := range emails[1:] {
+		_, err := svc.Join(ctx, community.GetGroupRequest{Email: email, ID: g.ID})
+		require.NoError(t, err)
+	}
+	require.NoError(t, svc.UpdateMemberRole(ctx, community.UpdateMemberRoleRequest{Email: emails[0], GroupID: g.ID, MemberEmail: emails[1], Role: community.RoleModerator}))
+
+	leave := func(emails ...string) {
+		errs := make(chan error, len(emails))
+		for _, email := range emails {
+			go func(email string) {
+				errs <- svc.Leave(ctx, community.GetGroupRequest{Email: email, ID: g.ID})
+			}(email)
+		}
+		for range emails {
+			require.NoError(t, <-errs)
+		}
+	}
+
+	// The owner and the moderator who would take over leave together
+	leave(emails[0], emails[1])
+	members, err := s.ListGroupMembers(ctx, g.ID)
+	require.NoError(t, err)
+	require.Len(t, members, 1)
+	assert.Equal(t, emails[2], members[0].Email)
+	assert.Equal(t, community.RoleOwner, members[0].Role)
+
+	// The last 2 members leave together
+	_, err = svc.Join(ctx, community.GetGroupRequest{Email: emails[0], ID: g.ID})
+	require.NoError(t, err)
+	leave(emails[0], emails[2])
+	_, err = s.GetGroup(ctx, g.ID)
+	assert.True(t, errors.Is(err, storage.ErrNotFound), "the group is deleted with its last members")
+}
+
+func TestDeleteScheduledUsers_Actors(t *testing.T) {
+	s := makeStorage(t)
+
+	ctx := context.Background()
+	email, actor := "purge-kept@bar.com", "purge-actor@bar.com"
+	for _, e := range []string{email, actor} {
+		require.NoError(t, s.CreateRegularUser(ctx, auth.User{Email: e, HashedPassword: "123", FirstName: "foo", LastName: "bar"}))
+	}
+	t.Cleanup(func() {
+		if err := s.DeleteUser(ctx, email); err != nil {
+			t.Errorf("delete user failed: %v", err)
+		}
+	})
+
+	req := profile.UpdateProfileRequest{Email: email, CurrentCity: "Saigon"}
+	v := &profile.ProfileVersion{Actor: actor, Profile: profile.Profile{Email: email, CurrentCity: req.CurrentCity}, CreatedAt: time.Now()}
+	require.NoError(t, s.UpdateProfile(ctx, req, v))
+	require.NoError(t, s.InsertNotification(ctx, &notification.Notification{Email: email, Type: notification.FriendRequestCreated, Actor: actor, CreatedAt: time.Now()}))
+
+	now := time.Now()
+	require.NoError(t, s.DeactivateUser(ctx, actor, now, &now))
+	n, err := s.DeleteScheduledUsers(ctx, now)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, n, int64(1))
+
+	// The changes and notifications caused by the purged user are kept without their author
+	got, err := s.GetProfileVersion(ctx, email, v.Version)
+	require.NoError(t, err)
+	assert.Empty(t, got.Actor)
+
+	notifications, err := s.ListNotifications(ctx, notification.ListRequest{Email: email, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, notifications, 1)
+	assert.Empty(t, notifications[0].Actor)
+}
+
 func makeStorage(t *testing.T) *mysql.Storage {
 	once.Do(func() {
 		var err error
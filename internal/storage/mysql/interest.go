@@ -0,0 +1,180 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/victornm/gtonline/internal/profile"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+type (
+	interestTagRow struct {
+		Slug  string `db:"slug"`
+		Name  string `db:"name"`
+		Users int    `db:"users"`
+	}
+
+	interestAliasRow struct {
+		Alias string `db:"alias"`
+		Slug  string `db:"slug"`
+	}
+)
+
+// interestTagColumns selects an interestTagRow from the interest_tags t.
+const interestTagColumns = `t.slug, t.name, (SELECT COUNT(*) FROM interests i WHERE i.interest = t.name) AS users`
+
+func (s *Storage) FindInterestTags(ctx context.Context, slugs []string) ([]*profile.InterestTag, error) {
+	if len(slugs) == 0 {
+		return nil, nil
+	}
+
+	query, args, err := sqlx.In(`
+SELECT `+interestTagColumns+`
+FROM interest_tags t
+WHERE t.slug IN (?)
+   OR t.slug IN (SELECT a.slug FROM interest_tag_aliases a WHERE a.alias IN (?));`, slugs, slugs)
+	if err != nil {
+		return nil, fmt.Errorf("build query: %v", err)
+	}
+
+	var rows []interestTagRow
+	if err := s.db.SelectContext(ctx, &rows, s.db.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+	return s.interestTags(ctx, rows)
+}
+
+func (s *Storage) InsertInterestTag(ctx context.Context, t *profile.InterestTag) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO interest_tags (slug, name, created_at) VALUES (?, ?, ?);`, t.Slug, t.Name, time.Now())
+	if isDuplicate(err) {
+		return fmt.Errorf("%w: %v", storage.ErrAlreadyExist, err)
+	}
+	return err
+}
+
+func (s *Storage) ListInterestTags(ctx context.Context, prefix string, limit int) ([]*profile.InterestTag, error) {
+	// The slugs have no LIKE wildcard
+	like := prefix + "%"
+
+	var rows []interestTagRow
+	err := s.db.SelectContext(ctx, &rows, `
+SELECT `+interestTagColumns+`
+FROM interest_tags t
+WHERE t.slug LIKE ?
+   OR t.slug IN (SELECT a.slug FROM interest_tag_aliases a WHERE a.alias LIKE ?)
+ORDER BY users DESC, t.name
+LIMIT ?;`, like, like, limit)
+	if err != nil {
+		return nil, err
+	}
+	return s.interestTags(ctx, rows)
+}
+
+func (s *Storage) MergeInterestTags(ctx context.Context, from, into string, v *profile.ProfileVersion) error {
+	return s.withTx(ctx, func(tx *Storage) error {
+		var rows []interestTagRow
+		query, args, err := sqlx.In(`SELECT slug, name FROM interest_tags WHERE slug IN (?) ORDER BY slug FOR UPDATE;`, []string{from, into})
+		if err != nil {
+			return fmt.Errorf("build query: %v", err)
+		}
+		if err := tx.db.SelectContext(ctx, &rows, tx.db.Rebind(query), args...); err != nil {
+			return fmt.Errorf("lock interest tags: %v", err)
+		}
+		if len(rows) != 2 {
+			return storage.ErrNotFound
+		}
+
+		names := make(map[string]string, 2)
+		for _, r := range rows {
+			names[r.Slug] = r.Name
+		}
+
+		// The lock on the regular_users rows serializes the versions of the users, see insertProfileVersion
+		var emails []string
+		if err := tx.db.SelectContext(ctx, &emails, `
+SELECT email
+FROM regular_users
+WHERE email IN (SELECT email FROM interests WHERE interest = ?)
+ORDER BY email
+FOR UPDATE;`, names[from]); err != nil {
+			return fmt.Errorf("lock users: %v", err)
+		}
+
+		// The users interested in both keep into only
+		if _, err := tx.db.ExecContext(ctx, `
+DELETE f
+FROM interests f
+         JOIN interests i ON i.email = f.email AND i.interest = ?
+WHERE f.interest = ?;`, names[into], names[from]); err != nil {
+			return fmt.Errorf("delete interests: %v", err)
+		}
+
+		if _, err := tx.db.ExecContext(ctx, `UPDATE interests SET interest=? WHERE interest=?;`, names[into], names[from]); err != nil {
+			return fmt.Errorf("update interests: %v", err)
+		}
+
+		for _, email := range emails {
+			p, err := tx.GetProfile(ctx, email)
+			if err != nil {
+				return fmt.Errorf("get profile: %v", err)
+			}
+			if err := tx.insertProfileVersion(ctx, email, &profile.ProfileVersion{Actor: v.Actor, Profile: *p, CreatedAt: v.CreatedAt}); err != nil {
+				return fmt.Errorf("insert profile version: %w", err)
+			}
+		}
+
+		// The groups about from are about into now
+		if _, err := tx.db.ExecContext(ctx, `UPDATE community_groups SET topic=?, topic_key=? WHERE topic_key=?;`,
+			names[into], into, from); err != nil {
+			return fmt.Errorf("update group topics: %v", err)
+		}
+
+		if _, err := tx.db.ExecContext(ctx, `UPDATE interest_tag_aliases SET slug=? WHERE slug=?;`, into, from); err != nil {
+			return fmt.Errorf("move aliases: %v", err)
+		}
+
+		if _, err := tx.db.ExecContext(ctx, `DELETE FROM interest_tags WHERE slug=?;`, from); err != nil {
+			return fmt.Errorf("delete interest tag: %v", err)
+		}
+
+		if _, err := tx.db.ExecContext(ctx, `INSERT INTO interest_tag_aliases (alias, slug) VALUES (?, ?);`, from, into); err != nil {
+			return fmt.Errorf("insert alias: %v", err)
+		}
+		return nil
+	})
+}
+
+// interestTags returns the tags of the rows with their aliases.
+func (s *Storage) interestTags(ctx context.Context, rows []interestTagRow) ([]*profile.InterestTag, error) {
+	res := make([]*profile.InterestTag, 0, len(rows))
+	if len(rows) == 0 {
+		return res, nil
+	}
+
+	slugs := make([]string, 0, len(rows))
+	tags := make(map[string]*profile.InterestTag, len(rows))
+	for _, r := range rows {
+		t := &profile.InterestTag{Slug: r.Slug, Name: r.Name, Users: r.Users}
+		res = append(res, t)
+		tags[r.Slug] = t
+		slugs = append(slugs, r.Slug)
+	}
+
+	query, args, err := sqlx.In(`SELECT alias, slug FROM interest_tag_aliases WHERE slug IN (?) ORDER BY alias;`, slugs)
+	if err != nil {
+		return nil, fmt.Errorf("build query: %v", err)
+	}
+
+	var aliases []interestAliasRow
+	if err := s.db.SelectContext(ctx, &aliases, s.db.Rebind(query), args...); err != nil {
+		return nil, fmt.Errorf("query aliases: %v", err)
+	}
+	for _, a := range aliases {
+		tags[a.Slug].Aliases = append(tags[a.Slug].Aliases, a.Alias)
+	}
+	return res, nil
+}
@@ -0,0 +1,148 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/victornm/gtonline/internal/job"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+type jobRow struct {
+	ID          int64          `db:"id"`
+	Type        string         `db:"type"`
+	Payload     string         `db:"payload"`
+	Key         sql.NullString `db:"unique_key"`
+	Status      string         `db:"status"`
+	Attempts    int            `db:"attempts"`
+	MaxAttempts int            `db:"max_attempts"`
+	RunAt       time.Time      `db:"run_at"`
+	LastError   string         `db:"last_error"`
+	CreatedAt   time.Time      `db:"created_at"`
+	FinishedAt  sql.NullTime   `db:"finished_at"`
+}
+
+func (s *Storage) InsertJob(ctx context.Context, j *job.Job) error {
+	r, err := s.db.NamedExecContext(ctx, `
+INSERT INTO jobs (type, payload, unique_key, status, attempts, max_attempts, run_at, last_error, created_at)
+VALUES (:type, :payload, :unique_key, :status, :attempts, :max_attempts, :run_at, :last_error, :created_at);`, newJobRow(j))
+	if isDuplicate(err) {
+		return fmt.Errorf("%w: %v", storage.ErrAlreadyExist, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	j.ID, err = r.LastInsertId()
+	return err
+}
+
+func (s *Storage) ClaimJobs(ctx context.Context, jobType string, now, leaseUntil time.Time, limit int) ([]*job.Job, error) {
+	var res []*job.Job
+
+	err := s.withTx(ctx, func(tx *Storage) error {
+		// SKIP LOCKED lets the other workers claim the next jobs instead of waiting
+		var rows []jobRow
+		err := tx.db.SelectContext(ctx, &rows, `
+SELECT id, type, payload, unique_key, status, attempts, max_attempts, run_at, last_error, created_at, finished_at
+FROM jobs
+WHERE status = ?
+  AND type = ?
+  AND run_at <= ?
+ORDER BY run_at
+LIMIT ?
+FOR UPDATE SKIP LOCKED;`, string(job.StatusPending), jobType, now, limit)
+		if err != nil {
+			return err
+		}
+
+		if len(rows) == 0 {
+			return nil
+		}
+
+		ids := make([]int64, 0, len(rows))
+		for _, r := range rows {
+			ids = append(ids, r.ID)
+		}
+
+		query, args, err := sqlx.In(`UPDATE jobs SET attempts=attempts+1, run_at=? WHERE id IN (?);`, leaseUntil, ids)
+		if err != nil {
+			return fmt.Errorf("build query: %v", err)
+		}
+
+		if _, err := tx.db.ExecContext(ctx, tx.db.Rebind(query), args...); err != nil {
+			return err
+		}
+
+		for _, r := range rows {
+			r.Attempts++
+			r.RunAt = leaseUntil
+			res = append(res, newJob(r))
+		}
+		return nil
+	})
+	return res, err
+}
+
+func (s *Storage) UpdateJob(ctx context.Context, j *job.Job) error {
+	_, err := s.db.NamedExecContext(ctx, `
+UPDATE jobs
+SET status=:status,
+    attempts=:attempts,
+    run_at=:run_at,
+    last_error=:last_error,
+    finished_at=:finished_at
+WHERE id = :id;`, newJobRow(j))
+	return err
+}
+
+func (s *Storage) DeleteFinishedJobs(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM jobs WHERE finished_at < ?;`, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func newJobRow(j *job.Job) jobRow {
+	row := jobRow{
+		ID:          j.ID,
+		Type:        j.Type,
+		Payload:     string(j.Payload),
+		Key:         newNullString(j.Key),
+		Status:      string(j.Status),
+		Attempts:    j.Attempts,
+		MaxAttempts: j.MaxAttempts,
+		RunAt:       j.RunAt,
+		LastError:   truncate(j.LastError, 1000),
+		CreatedAt:   j.CreatedAt,
+	}
+	if j.FinishedAt != nil {
+		row.FinishedAt = sql.NullTime{Time: *j.FinishedAt, Valid: true}
+	}
+	return row
+}
+
+func newJob(row jobRow) *job.Job {
+	j := &job.Job{
+		ID:          row.ID,
+		Type:        row.Type,
+		Payload:     []byte(row.Payload),
+		Key:         row.Key.String,
+		Status:      job.Status(row.Status),
+		Attempts:    row.Attempts,
+		MaxAttempts: row.MaxAttempts,
+		RunAt:       row.RunAt,
+		LastError:   row.LastError,
+		CreatedAt:   row.CreatedAt,
+	}
+	if row.FinishedAt.Valid {
+		t := row.FinishedAt.Time
+		j.FinishedAt = &t
+	}
+	return j
+}
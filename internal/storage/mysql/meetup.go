@@ -0,0 +1,251 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/victornm/gtonline/internal/meetup"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+type (
+	meetupRow struct {
+		ID          int64     `db:"id"`
+		HostEmail   string    `db:"host_email"`
+		Title       string    `db:"title"`
+		Description string    `db:"description"`
+		City        string    `db:"city"`
+		StartsAt    time.Time `db:"starts_at"`
+		EndsAt      time.Time `db:"ends_at"`
+		Visibility  string    `db:"visibility"`
+		Capacity    int       `db:"capacity"`
+		CreatedAt   time.Time `db:"created_at"`
+		UpdatedAt   time.Time `db:"updated_at"`
+	}
+
+	// countedMeetupRow is a meetup with the guests counted and the status of the user who asked for it.
+	countedMeetupRow struct {
+		meetupRow
+		Going      int            `db:"going"`
+		Waitlisted int            `db:"waitlisted"`
+		RSVP       sql.NullString `db:"rsvp"`
+	}
+
+	meetupGuestRow struct {
+		MeetupID    int64     `db:"meetup_id"`
+		Email       string    `db:"email"`
+		Status      string    `db:"status"`
+		UpdatedAt   time.Time `db:"updated_at"`
+		Deactivated bool      `db:"deactivated"`
+	}
+)
+
+// meetupColumns selects a countedMeetupRow from the meetups m.
+const meetupColumns = `m.id, m.host_email, m.title, m.description, m.city, m.starts_at, m.ends_at, m.visibility, m.capacity,
+       m.created_at, m.updated_at,
+       (SELECT COUNT(*) FROM meetup_guests g WHERE g.meetup_id = m.id AND g.status = 'yes')        AS going,
+       (SELECT COUNT(*) FROM meetup_guests g WHERE g.meetup_id = m.id AND g.status = 'waitlisted') AS waitlisted`
+
+func newMeetupRow(e *meetup.Event) meetupRow {
+	return meetupRow{
+		ID:          e.ID,
+		HostEmail:   e.Host,
+		Title:       e.Title,
+		Description: e.Description,
+		City:        e.City,
+		StartsAt:    e.StartsAt,
+		EndsAt:      e.EndsAt,
+		Visibility:  string(e.Visibility),
+		Capacity:    e.Capacity,
+		CreatedAt:   e.CreatedAt,
+		UpdatedAt:   e.UpdatedAt,
+	}
+}
+
+func (r countedMeetupRow) event() *meetup.Event {
+	return &meetup.Event{
+		ID:          r.ID,
+		Host:        r.HostEmail,
+		Title:       r.Title,
+		Description: r.Description,
+		City:        r.City,
+		StartsAt:    r.StartsAt,
+		EndsAt:      r.EndsAt,
+		Visibility:  meetup.Visibility(r.Visibility),
+		Capacity:    r.Capacity,
+		Going:       r.Going,
+		Waitlisted:  r.Waitlisted,
+		CreatedAt:   r.CreatedAt,
+		UpdatedAt:   r.UpdatedAt,
+		RSVP:        meetup.Status(r.RSVP.String),
+	}
+}
+
+func (s *Storage) InsertMeetup(ctx context.Context, e *meetup.Event) error {
+	r, err := s.db.NamedExecContext(ctx, `
+INSERT INTO meetups (host_email, title, description, city, starts_at, ends_at, visibility, capacity, created_at, updated_at)
+VALUES (:host_email, :title, :description, :city, :starts_at, :ends_at, :visibility, :capacity, :created_at, :updated_at);`, newMeetupRow(e))
+	if isErrForeignKeyConstraint(err) {
+		return fmt.Errorf("%w: %v", storage.ErrInvalidArgument, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	id, err := r.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	e.ID = id
+	return nil
+}
+
+func (s *Storage) GetMeetup(ctx context.Context, id int64) (*meetup.Event, error) {
+	var row countedMeetupRow
+	err := s.db.GetContext(ctx, &row, `SELECT `+meetupColumns+`, NULL AS rsvp FROM meetups m WHERE m.id=?;`, id)
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return row.event(), nil
+}
+
+func (s *Storage) UpdateMeetup(ctx context.Context, e *meetup.Event) error {
+	r, err := s.db.NamedExecContext(ctx, `
+UPDATE meetups
+SET title=:title, description=:description, city=:city, starts_at=:starts_at, ends_at=:ends_at,
+    visibility=:visibility, capacity=:capacity, updated_at=:updated_at
+WHERE id=:id;`, newMeetupRow(e))
+	if err != nil {
+		return err
+	}
+
+	n, err := r.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+func (s *Storage) DeleteMeetup(ctx context.Context, id int64) error {
+	r, err := s.db.ExecContext(ctx, `DELETE FROM meetups WHERE id=?;`, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := r.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+func (s *Storage) ListUpcomingMeetups(ctx context.Context, email string, from time.Time, limit int) ([]*meetup.Event, error) {
+	var rows []countedMeetupRow
+	err := s.db.SelectContext(ctx, &rows, `
+SELECT `+meetupColumns+`, me.status AS rsvp
+FROM meetups m
+         LEFT JOIN meetup_guests me ON me.meetup_id = m.id AND me.email = ?
+WHERE m.ends_at > ?
+  AND (m.host_email = ? OR me.status <> 'no')
+ORDER BY m.starts_at, m.id
+LIMIT ?;`, email, from, email, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*meetup.Event, 0, len(rows))
+	for _, row := range rows {
+		res = append(res, row.event())
+	}
+	return res, nil
+}
+
+func (s *Storage) ListGuests(ctx context.Context, id int64) ([]*meetup.Guest, error) {
+	var rows []meetupGuestRow
+	err := s.db.SelectContext(ctx, &rows, `
+SELECT g.meetup_id, g.email, g.status, g.updated_at, u.deactivated_at IS NOT NULL AS deactivated
+FROM meetup_guests g
+         JOIN users u ON u.email = g.email
+WHERE g.meetup_id=?
+ORDER BY g.updated_at, g.email;`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*meetup.Guest, 0, len(rows))
+	for _, row := range rows {
+		res = append(res, row.guest())
+	}
+	return res, nil
+}
+
+func (s *Storage) GetGuest(ctx context.Context, id int64, email string) (*meetup.Guest, error) {
+	var row meetupGuestRow
+	err := s.db.GetContext(ctx, &row, `
+SELECT meetup_id, email, status, updated_at
+FROM meetup_guests
+WHERE meetup_id=? AND email=?;`, id, email)
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return row.guest(), nil
+}
+
+func (s *Storage) SaveGuest(ctx context.Context, id int64, g *meetup.Guest) error {
+	row := meetupGuestRow{MeetupID: id, Email: g.Email, Status: string(g.Status), UpdatedAt: g.UpdatedAt}
+	_, err := s.db.NamedExecContext(ctx, `
+INSERT INTO meetup_guests (meetup_id, email, status, updated_at)
+VALUES (:meetup_id, :email, :status, :updated_at)
+ON DUPLICATE KEY UPDATE status=VALUES(status), updated_at=VALUES(updated_at);`, row)
+	if isErrForeignKeyConstraint(err) {
+		return fmt.Errorf("%w: %v", storage.ErrInvalidArgument, err)
+	}
+	return err
+}
+
+func (s *Storage) DeleteGuest(ctx context.Context, id int64, email string) error {
+	r, err := s.db.ExecContext(ctx, `DELETE FROM meetup_guests WHERE meetup_id=? AND email=?;`, id, email)
+	if err != nil {
+		return err
+	}
+
+	n, err := r.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+func (s *Storage) WithMeetupLock(ctx context.Context, id int64, f func(ctx context.Context, tx meetup.Storage) error) error {
+	return s.withTx(ctx, func(tx *Storage) error {
+		// A missing event locks nothing, f finds it missing too
+		var locked []int64
+		if err := tx.db.SelectContext(ctx, &locked, `SELECT id FROM meetups WHERE id=? FOR UPDATE;`, id); err != nil {
+			return fmt.Errorf("lock meetup: %v", err)
+		}
+
+		return f(ctx, tx)
+	})
+}
+
+func (r meetupGuestRow) guest() *meetup.Guest {
+	return &meetup.Guest{Email: r.Email, Status: meetup.Status(r.Status), UpdatedAt: r.UpdatedAt, Deactivated: r.Deactivated}
+}
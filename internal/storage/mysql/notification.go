@@ -0,0 +1,176 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/victornm/gtonline/internal/notification"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+type notificationRow struct {
+	ID         int64        `db:"id"`
+	Email      string       `db:"email"`
+	Type       string       `db:"type"`
+	ActorEmail string       `db:"actor_email"`
+	CreatedAt  time.Time    `db:"created_at"`
+	ReadAt     sql.NullTime `db:"read_at"`
+}
+
+func (s *Storage) InsertNotification(ctx context.Context, n *notification.Notification) error {
+	row := notificationRow{
+		Email:      n.Email,
+		Type:       string(n.Type),
+		ActorEmail: n.Actor,
+		CreatedAt:  n.CreatedAt,
+	}
+
+	stmt := `
+INSERT INTO notifications (email, type, actor_email, created_at) 
+VALUES (:email, :type, :actor_email, :created_at);`
+	r, err := s.db.NamedExecContext(ctx, stmt, row)
+	if isErrForeignKeyConstraint(err) {
+		return fmt.Errorf("%w: %v", storage.ErrInvalidArgument, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	n.ID, err = r.LastInsertId()
+	return err
+}
+
+func (s *Storage) ListNotifications(ctx context.Context, req notification.ListRequest) ([]*notification.Notification, error) {
+	condition := []string{"email=?"}
+	args := []interface{}{req.Email}
+
+	if req.UnreadOnly {
+		condition = append(condition, "read_at IS NULL")
+	}
+
+	if req.Before > 0 {
+		condition = append(condition, "id<?")
+		args = append(args, req.Before)
+	}
+
+	stmt := `
+SELECT id, email, type, actor_email, created_at, read_at
+FROM notifications
+WHERE ` + strings.Join(condition, " AND ") + `
+ORDER BY id DESC
+LIMIT ?;`
+	args = append(args, req.Limit)
+
+	var rows []notificationRow
+	if err := s.db.SelectContext(ctx, &rows, stmt, args...); err != nil {
+		return nil, err
+	}
+
+	res := make([]*notification.Notification, 0, len(rows))
+	for _, r := range rows {
+		res = append(res, &notification.Notification{
+			ID:        r.ID,
+			Email:     r.Email,
+			Type:      notification.Type(r.Type),
+			Actor:     r.ActorEmail,
+			Read:      r.ReadAt.Valid,
+			CreatedAt: r.CreatedAt,
+		})
+	}
+	return res, nil
+}
+
+func (s *Storage) CountUnreadNotifications(ctx context.Context, email string) (int, error) {
+	var n int
+	err := s.db.GetContext(ctx, &n, `SELECT COUNT(*) FROM notifications WHERE email=? AND read_at IS NULL;`, email)
+	return n, err
+}
+
+func (s *Storage) MarkNotificationRead(ctx context.Context, email string, id int64, at time.Time) error {
+	var exist bool
+	err := s.db.GetContext(ctx, &exist, `SELECT EXISTS(SELECT id FROM notifications WHERE id=? AND email=?);`, id, email)
+	if err != nil {
+		return err
+	}
+
+	if !exist {
+		return storage.ErrNotFound
+	}
+
+	_, err = s.db.ExecContext(ctx, `UPDATE notifications SET read_at=? WHERE id=? AND read_at IS NULL;`, at, id)
+	return err
+}
+
+func (s *Storage) MarkAllNotificationsRead(ctx context.Context, email string, at time.Time) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE notifications SET read_at=? WHERE email=? AND read_at IS NULL;`, at, email)
+	return err
+}
+
+type notificationPreferencesRow struct {
+	Email string `db:"email"`
+	// Events is the JSON of the type x channel matrix.
+	Events            string `db:"events"`
+	QuietHoursEnabled bool   `db:"quiet_hours_enabled"`
+	QuietHoursStart   string `db:"quiet_hours_start"`
+	QuietHoursEnd     string `db:"quiet_hours_end"`
+	TimeZone          string `db:"time_zone"`
+}
+
+func (s *Storage) GetNotificationPreferences(ctx context.Context, email string) (*notification.Preferences, error) {
+	var row notificationPreferencesRow
+	err := s.db.GetContext(ctx, &row, `
+SELECT email, events, quiet_hours_enabled, quiet_hours_start, quiet_hours_end, time_zone
+FROM notification_preferences
+WHERE email=?;`, email)
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p := &notification.Preferences{
+		Email: row.Email,
+		QuietHours: notification.QuietHours{
+			Enabled:  row.QuietHoursEnabled,
+			Start:    row.QuietHoursStart,
+			End:      row.QuietHoursEnd,
+			TimeZone: row.TimeZone,
+		},
+	}
+	if err := json.Unmarshal([]byte(row.Events), &p.Events); err != nil {
+		return nil, fmt.Errorf("decode events: %v", err)
+	}
+	return p, nil
+}
+
+func (s *Storage) SaveNotificationPreferences(ctx context.Context, p *notification.Preferences) error {
+	events, err := json.Marshal(p.Events)
+	if err != nil {
+		return fmt.Errorf("encode events: %v", err)
+	}
+
+	_, err = s.db.NamedExecContext(ctx, `
+INSERT INTO notification_preferences (email, events, quiet_hours_enabled, quiet_hours_start, quiet_hours_end, time_zone)
+VALUES (:email, :events, :quiet_hours_enabled, :quiet_hours_start, :quiet_hours_end, :time_zone)
+ON DUPLICATE KEY UPDATE events=VALUES(events),
+                        quiet_hours_enabled=VALUES(quiet_hours_enabled),
+                        quiet_hours_start=VALUES(quiet_hours_start),
+                        quiet_hours_end=VALUES(quiet_hours_end),
+                        time_zone=VALUES(time_zone);`, notificationPreferencesRow{
+		Email:             p.Email,
+		Events:            string(events),
+		QuietHoursEnabled: p.QuietHours.Enabled,
+		QuietHoursStart:   p.QuietHours.Start,
+		QuietHoursEnd:     p.QuietHours.End,
+		TimeZone:          p.QuietHours.TimeZone,
+	})
+	if isErrForeignKeyConstraint(err) {
+		return fmt.Errorf("%w: %v", storage.ErrInvalidArgument, err)
+	}
+	return err
+}
@@ -7,8 +7,8 @@ import (
 	"fmt"
 
 	"github.com/go-sql-driver/mysql"
-	"github.com/jmoiron/sqlx"
 
+	"github.com/victornm/gtonline/internal/event"
 	"github.com/victornm/gtonline/internal/profile"
 	"github.com/victornm/gtonline/internal/storage"
 )
@@ -28,15 +28,23 @@ type (
 	}
 
 	attend struct {
-		Email         string        `db:"email"`
-		SchoolName    string        `db:"school_name"`
-		YearGraduated sql.NullInt32 `db:"year_graduated"`
+		Email         string         `db:"email"`
+		SchoolName    string         `db:"school_name"`
+		YearGraduated sql.NullInt32  `db:"year_graduated"`
+		Degree        sql.NullString `db:"degree"`
+		Major         sql.NullString `db:"major"`
+		StartDate     sql.NullTime   `db:"start_date"`
+		EndDate       sql.NullTime   `db:"end_date"`
+		IsCurrent     bool           `db:"is_current"`
 	}
 
 	employment struct {
-		Email        string `db:"email"`
-		EmployerName string `db:"employer_name"`
-		JobTitle     string `db:"job_title"`
+		Email        string       `db:"email"`
+		EmployerName string       `db:"employer_name"`
+		JobTitle     string       `db:"job_title"`
+		StartDate    sql.NullTime `db:"start_date"`
+		EndDate      sql.NullTime `db:"end_date"`
+		IsCurrent    bool         `db:"is_current"`
 	}
 )
 
@@ -92,24 +100,42 @@ func (s *Storage) GetProfile(ctx context.Context, email string) (*profile.Profil
 		p.Education = append(p.Education, profile.Attend{
 			School:        a.SchoolName,
 			YearGraduated: int(a.YearGraduated.Int32),
+			Degree:        a.Degree.String,
+			Major:         a.Major.String,
+			StartDate:     a.StartDate.Time,
+			EndDate:       a.EndDate.Time,
+			IsCurrent:     a.IsCurrent,
 		})
 	}
 
 	for _, e := range employments {
 		p.Professional = append(p.Professional, profile.Employment{
-			Employer: e.EmployerName,
-			JobTitle: e.JobTitle,
+			Employer:  e.EmployerName,
+			JobTitle:  e.JobTitle,
+			StartDate: e.StartDate.Time,
+			EndDate:   e.EndDate.Time,
+			IsCurrent: e.IsCurrent,
 		})
 	}
 
 	return p, nil
 }
 
-func (s *Storage) UpdateProfile(ctx context.Context, req profile.UpdateProfileRequest) (err error) {
-	return updateProfile(ctx, s.db, req)
+func (s *Storage) UpdateProfile(ctx context.Context, req profile.UpdateProfileRequest, v *profile.ProfileVersion, events ...event.Event) (err error) {
+	return s.withTx(ctx, func(tx *Storage) error {
+		if err := updateProfile(ctx, tx.db, req); err != nil {
+			return err
+		}
+		if v != nil {
+			if err := tx.insertProfileVersion(ctx, req.Email, v); err != nil {
+				return fmt.Errorf("insert profile version: %w", err)
+			}
+		}
+		return tx.AppendEvents(ctx, events...)
+	})
 }
 
-func updateProfile(ctx context.Context, tx *sqlx.DB, req profile.UpdateProfileRequest) error {
+func updateProfile(ctx context.Context, tx queryer, req profile.UpdateProfileRequest) error {
 	if err := updateRegularUser(ctx, tx, req); err != nil {
 		return fmt.Errorf("update regular_users: %v", err)
 	}
@@ -153,14 +179,14 @@ func newRegularUser(req profile.UpdateProfileRequest) regularUser {
 	return row
 }
 
-func updateRegularUser(ctx context.Context, tx *sqlx.DB, req profile.UpdateProfileRequest) error {
+func updateRegularUser(ctx context.Context, tx queryer, req profile.UpdateProfileRequest) error {
 	row := newRegularUser(req)
 	stmt := `UPDATE regular_users SET birthdate=:birthdate, sex=:sex, current_city=:current_city, hometown=:hometown WHERE email=:email;`
 	_, err := tx.NamedExecContext(ctx, stmt, row)
 	return err
 }
 
-func replaceInterests(ctx context.Context, tx *sqlx.DB, req profile.UpdateProfileRequest) error {
+func replaceInterests(ctx context.Context, tx queryer, req profile.UpdateProfileRequest) error {
 	if _, err := tx.ExecContext(ctx, `DELETE FROM interests WHERE email=?`, req.Email); err != nil {
 		return fmt.Errorf("delete interests: %v", err)
 	}
@@ -186,7 +212,7 @@ func replaceInterests(ctx context.Context, tx *sqlx.DB, req profile.UpdateProfil
 	return nil
 }
 
-func replaceAttends(ctx context.Context, tx *sqlx.DB, req profile.UpdateProfileRequest) error {
+func replaceAttends(ctx context.Context, tx queryer, req profile.UpdateProfileRequest) error {
 	if _, err := tx.ExecContext(ctx, `DELETE FROM attends WHERE email=?`, req.Email); err != nil {
 		return fmt.Errorf("delete attends: %v", err)
 	}
@@ -196,6 +222,11 @@ func replaceAttends(ctx context.Context, tx *sqlx.DB, req profile.UpdateProfileR
 		row := &attend{
 			Email:      req.Email,
 			SchoolName: a.School,
+			Degree:     sql.NullString{String: a.Degree, Valid: a.Degree != ""},
+			Major:      sql.NullString{String: a.Major, Valid: a.Major != ""},
+			StartDate:  sql.NullTime{Time: a.StartDate, Valid: !a.StartDate.IsZero()},
+			EndDate:    sql.NullTime{Time: a.EndDate, Valid: !a.EndDate.IsZero()},
+			IsCurrent:  a.IsCurrent,
 		}
 		if a.YearGraduated != 0 {
 			row.YearGraduated = sql.NullInt32{Int32: int32(a.YearGraduated), Valid: true}
@@ -207,7 +238,9 @@ func replaceAttends(ctx context.Context, tx *sqlx.DB, req profile.UpdateProfileR
 		return nil
 	}
 
-	_, err := tx.NamedExecContext(ctx, `INSERT INTO attends (email, school_name, year_graduated) VALUES (:email, :school_name, :year_graduated)`, rows)
+	_, err := tx.NamedExecContext(ctx, `
+INSERT INTO attends (email, school_name, year_graduated, degree, major, start_date, end_date, is_current)
+VALUES (:email, :school_name, :year_graduated, :degree, :major, :start_date, :end_date, :is_current)`, rows)
 	if err == nil {
 		return nil
 	}
@@ -216,10 +249,14 @@ func replaceAttends(ctx context.Context, tx *sqlx.DB, req profile.UpdateProfileR
 		return storage.ErrInvalidArgument
 	}
 
+	if isDuplicate(err) {
+		return fmt.Errorf("%w: duplicate education entry", storage.ErrInvalidArgument)
+	}
+
 	return fmt.Errorf("insert attends: %v", err)
 }
 
-func replaceEmployments(ctx context.Context, tx *sqlx.DB, req profile.UpdateProfileRequest) error {
+func replaceEmployments(ctx context.Context, tx queryer, req profile.UpdateProfileRequest) error {
 	if _, err := tx.ExecContext(ctx, `DELETE FROM employments WHERE email=?`, req.Email); err != nil {
 		return fmt.Errorf("delete employments: %v", err)
 	}
@@ -230,6 +267,9 @@ func replaceEmployments(ctx context.Context, tx *sqlx.DB, req profile.UpdateProf
 			Email:        req.Email,
 			EmployerName: e.Employer,
 			JobTitle:     e.JobTitle,
+			StartDate:    sql.NullTime{Time: e.StartDate, Valid: !e.StartDate.IsZero()},
+			EndDate:      sql.NullTime{Time: e.EndDate, Valid: !e.EndDate.IsZero()},
+			IsCurrent:    e.IsCurrent,
 		})
 	}
 
@@ -237,7 +277,9 @@ func replaceEmployments(ctx context.Context, tx *sqlx.DB, req profile.UpdateProf
 		return nil
 	}
 
-	_, err := tx.NamedExecContext(ctx, `INSERT INTO employments (email, employer_name, job_title) VALUES (:email, :employer_name, :job_title)`, rows)
+	_, err := tx.NamedExecContext(ctx, `
+INSERT INTO employments (email, employer_name, job_title, start_date, end_date, is_current)
+VALUES (:email, :employer_name, :job_title, :start_date, :end_date, :is_current)`, rows)
 
 	if err == nil {
 		return nil
@@ -247,7 +289,11 @@ func replaceEmployments(ctx context.Context, tx *sqlx.DB, req profile.UpdateProf
 		return storage.ErrInvalidArgument
 	}
 
-	return fmt.Errorf("insert attends: %v", err)
+	if isDuplicate(err) {
+		return fmt.Errorf("%w: duplicate professional entry", storage.ErrInvalidArgument)
+	}
+
+	return fmt.Errorf("insert employments: %v", err)
 }
 
 func (s *Storage) DeleteUser(ctx context.Context, email string) error {
@@ -276,7 +322,13 @@ func (s *Storage) isEmailExist(ctx context.Context, table string, email string)
 func (s *Storage) ListSchools(ctx context.Context) ([]profile.School, error) {
 	var schools []profile.School
 
-	stmt := `SELECT school_name, type FROM schools;`
+	stmt := `
+SELECT s.school_name, s.type, COUNT(DISTINCT u.email) AS alumni
+FROM schools s
+         LEFT JOIN attends a ON a.school_name = s.school_name
+         LEFT JOIN regular_users ru ON ru.email = a.email AND ru.list_in_directory
+         LEFT JOIN users u ON u.email = ru.email AND u.deactivated_at IS NULL
+GROUP BY s.school_name, s.type;`
 	if err := s.db.SelectContext(ctx, &schools, stmt); err != nil {
 		return nil, fmt.Errorf("query schools: %v", err)
 	}
@@ -287,7 +339,13 @@ func (s *Storage) ListSchools(ctx context.Context) ([]profile.School, error) {
 func (s *Storage) ListEmployers(ctx context.Context) ([]profile.Employer, error) {
 	var employers []profile.Employer
 
-	stmt := `SELECT employer_name FROM employers;`
+	stmt := `
+SELECT e.employer_name, COUNT(DISTINCT u.email) AS people
+FROM employers e
+         LEFT JOIN employments em ON em.employer_name = e.employer_name AND ` + currentEmployment("em") + `
+         LEFT JOIN regular_users ru ON ru.email = em.email AND ru.list_in_directory
+         LEFT JOIN users u ON u.email = ru.email AND u.deactivated_at IS NULL
+GROUP BY e.employer_name;`
 	if err := s.db.SelectContext(ctx, &employers, stmt); err != nil {
 		return nil, fmt.Errorf("query employers: %v", err)
 	}
@@ -9,13 +9,27 @@ import (
 	"github.com/jmoiron/sqlx"
 
 	"github.com/victornm/gtonline/internal/auth"
+	"github.com/victornm/gtonline/internal/event"
 	"github.com/victornm/gtonline/internal/friend"
+	"github.com/victornm/gtonline/internal/profile"
 	"github.com/victornm/gtonline/internal/storage"
 )
 
 type (
 	Storage struct {
-		db *sqlx.DB
+		conn *sqlx.DB
+		// db is conn, or the transaction when the Storage is created by withTx.
+		db queryer
+	}
+
+	// queryer is implemented by both *sqlx.DB and *sqlx.Tx.
+	queryer interface {
+		sqlx.ExtContext
+		Get(dest interface{}, query string, args ...interface{}) error
+		Select(dest interface{}, query string, args ...interface{}) error
+		GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+		SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+		NamedExecContext(ctx context.Context, query string, arg interface{}) (sql.Result, error)
 	}
 
 	Config struct {
@@ -31,20 +45,41 @@ func New(cfg Config) (*Storage, error) {
 	if err != nil {
 		return nil, fmt.Errorf("open db: %v", err)
 	}
-	return &Storage{db: db}, nil
+	return &Storage{conn: db, db: db}, nil
 }
 
 func (s *Storage) Ping() error {
-	return s.db.Ping()
+	return s.conn.Ping()
 }
 
 func (s *Storage) Close() error {
-	return s.db.Close()
+	return s.conn.Close()
+}
+
+// withTx runs f with a Storage bound to a new transaction.
+// The transaction is committed if f returns nil, otherwise rolled back.
+func (s *Storage) withTx(ctx context.Context, f func(tx *Storage) error) (err error) {
+	tx, err := s.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %v", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	return f(&Storage{conn: s.conn, db: tx})
 }
 
 func (s *Storage) FindUserByEmail(ctx context.Context, email string) (*auth.User, error) {
 	u := new(auth.User)
-	err := s.db.GetContext(ctx, u, `SELECT email, password, first_name, last_name FROM users WHERE email=?;`, email)
+	err := s.db.GetContext(ctx, u, `
+SELECT email, password, first_name, last_name, deactivated_at, delete_after
+FROM users
+WHERE email=?;`, email)
 	if err == sql.ErrNoRows {
 		return nil, storage.ErrNotFound
 	}
@@ -56,33 +91,31 @@ func (s *Storage) FindUserByEmail(ctx context.Context, email string) (*auth.User
 	return u, nil
 }
 
-func (s *Storage) CreateRegularUser(ctx context.Context, u auth.User) (err error) {
-	tx, err := s.db.BeginTxx(ctx, nil)
-	if err != nil {
-		return fmt.Errorf("begin transaction: %v", err)
-	}
-	defer func() {
+func (s *Storage) IsAdmin(ctx context.Context, email string) (bool, error) {
+	return s.isEmailExist(ctx, "admin_users", email)
+}
+
+func (s *Storage) CreateRegularUser(ctx context.Context, u auth.User, events ...event.Event) error {
+	return s.withTx(ctx, func(tx *Storage) error {
+		stmt := `INSERT INTO users (email, password, first_name, last_name) VALUES(:email, :password, :first_name, :last_name);`
+		_, err := tx.db.NamedExecContext(ctx, stmt, u)
+		if isDuplicate(err) {
+			return fmt.Errorf("%w: %v", storage.ErrAlreadyExist, err)
+		}
 		if err != nil {
-			_ = tx.Rollback()
-			return
+			return err
 		}
-		err = tx.Commit()
-	}()
 
-	stmt := `INSERT INTO users (email, password, first_name, last_name) VALUES(:email, :password, :first_name, :last_name);`
-	_, err = tx.NamedExecContext(ctx, stmt, u)
-	if isDuplicate(err) {
-		return fmt.Errorf("%w: %v", storage.ErrAlreadyExist, err)
-	}
-	if err != nil {
-		return err
-	}
+		_, err = tx.db.ExecContext(ctx, `INSERT INTO regular_users (email) VALUES(?)`, u.Email)
+		if isDuplicate(err) {
+			return fmt.Errorf("%w: %v", storage.ErrAlreadyExist, err)
+		}
+		if err != nil {
+			return err
+		}
 
-	_, err = tx.ExecContext(ctx, `INSERT INTO regular_users (email) VALUES(?)`, u.Email)
-	if isDuplicate(err) {
-		return fmt.Errorf("%w: %v", storage.ErrAlreadyExist, err)
-	}
-	return err
+		return tx.AppendEvents(ctx, events...)
+	})
 }
 
 func (s *Storage) SearchUsers(ctx context.Context, req friend.SearchFriendsRequest) (*friend.SearchFriendsResponse, error) {
@@ -115,27 +148,94 @@ func (s *Storage) SearchUsers(ctx context.Context, req friend.SearchFriendsReque
 			args = append(args, "%"+req.Hometown+"%")
 		}
 
-		return strings.Join(condition, " OR "), args
+		// The filters narrow down the users matched by the conditions above
+		var filter []string
+		if len(condition) > 0 {
+			filter = append(filter, "("+strings.Join(condition, " OR ")+")")
+		}
+
+		if req.CurrentCity != "" {
+			filter = append(filter, "current_city LIKE ?")
+			args = append(args, "%"+req.CurrentCity+"%")
+		}
+
+		if req.School != "" || req.YearFrom != 0 || req.YearTo != 0 {
+			attends := []string{"a.email = u.email"}
+			if req.School != "" {
+				attends = append(attends, "a.school_name=?")
+				args = append(args, req.School)
+			}
+			if req.YearFrom != 0 {
+				attends = append(attends, "a.year_graduated>=?")
+				args = append(args, req.YearFrom)
+			}
+			if req.YearTo != 0 {
+				attends = append(attends, "a.year_graduated<=?")
+				args = append(args, req.YearTo)
+			}
+			filter = append(filter, "EXISTS(SELECT 1 FROM attends a WHERE "+strings.Join(attends, " AND ")+")")
+		}
+
+		if req.Employer != "" || req.JobTitle != "" {
+			employments := []string{"e.email = u.email"}
+			if req.Employer != "" {
+				employments = append(employments, "e.employer_name=?")
+				args = append(args, req.Employer)
+			}
+			if req.JobTitle != "" {
+				employments = append(employments, "e.job_title LIKE ?")
+				args = append(args, "%"+req.JobTitle+"%")
+			}
+			filter = append(filter, "EXISTS(SELECT 1 FROM employments e WHERE "+strings.Join(employments, " AND ")+")")
+		}
+
+		if req.Interest != "" {
+			// The interest matches the tag of its slug or alias, or the interests saved before the catalog
+			filter = append(filter, `
+EXISTS(SELECT 1
+       FROM interests i
+       WHERE i.email = u.email
+         AND (i.interest=? OR i.interest IN (SELECT t.name
+                                            FROM interest_tags t
+                                            WHERE t.slug=?
+                                               OR t.slug IN (SELECT slug FROM interest_tag_aliases WHERE alias=?))))`)
+			slug := profile.Slug(req.Interest)
+			args = append(args, req.Interest, slug, slug)
+		}
+
+		// The deactivated users are hidden
+		filter = append(filter, "u.deactivated_at IS NULL")
+
+		return strings.Join(filter, " AND "), args
 	}
 
 	where, args := buildWhere(req)
+	// matched selects the emails of the matched users, the count and the facets are computed by the database
+	matched := `
+WITH matched AS (SELECT u.email
+                 FROM users AS u
+                          JOIN regular_users AS ru USING (email)
+                 WHERE ` + where + `)
+`
 
-	stmt := `
-SELECT u.email, first_name, last_name, hometown 
-FROM users as u 
-JOIN regular_users as ru 
-USING (email)
-WHERE ` + where + ";"
+	res := &friend.SearchFriendsResponse{}
+	if err := s.db.GetContext(ctx, &res.Count, matched+`SELECT COUNT(*) FROM matched;`, args...); err != nil {
+		return nil, fmt.Errorf("count users: %v", err)
+	}
 
 	var rows []row
-	err := s.db.SelectContext(ctx, &rows, stmt, args...)
+	err := s.db.SelectContext(ctx, &rows, matched+`
+SELECT u.email, first_name, last_name, hometown
+FROM matched AS m
+         JOIN users AS u ON u.email = m.email
+         JOIN regular_users AS ru ON ru.email = m.email
+WHERE m.email > ?
+ORDER BY m.email
+LIMIT ?;`, append(args, req.After, req.Limit)...)
 	if err != nil {
 		return nil, err
 	}
 
-	res := &friend.SearchFriendsResponse{
-		Count: len(rows),
-	}
 	for _, r := range rows {
 		res.Users = append(res.Users, friend.User{
 			Email:     r.Email,
@@ -144,5 +244,47 @@ WHERE ` + where + ";"
 			Hometown:  r.Hometown.String,
 		})
 	}
+
+	if res.Facets, err = s.searchFacets(ctx, matched, args); err != nil {
+		return nil, err
+	}
 	return res, nil
 }
+
+// searchFacets counts the users selected by the matched CTE by school, employer, interest and current city.
+func (s *Storage) searchFacets(ctx context.Context, matched string, args []interface{}) (friend.SearchFacets, error) {
+	facets := friend.SearchFacets{
+		Schools:       []friend.FacetCount{},
+		Employers:     []friend.FacetCount{},
+		Interests:     []friend.FacetCount{},
+		CurrentCities: []friend.FacetCount{},
+	}
+
+	for _, f := range []struct {
+		name   string
+		table  string
+		column string
+		dest   *[]friend.FacetCount
+	}{
+		{name: "schools", table: "attends", column: "school_name", dest: &facets.Schools},
+		{name: "employers", table: "employments", column: "employer_name", dest: &facets.Employers},
+		{name: "interests", table: "interests", column: "interest", dest: &facets.Interests},
+		{name: "current cities", table: "regular_users", column: "current_city", dest: &facets.CurrentCities},
+	} {
+		stmt := matched + fmt.Sprintf(`
+SELECT t.%[2]s AS value, COUNT(DISTINCT t.email) AS count
+FROM %[1]s AS t
+         JOIN matched AS m ON m.email = t.email
+WHERE t.%[2]s IS NOT NULL
+GROUP BY t.%[2]s
+ORDER BY count DESC, value
+LIMIT ?;`, f.table, f.column)
+
+		// The args are copied, so the appends of the facets don't share the backing array
+		facetArgs := append(append([]interface{}{}, args...), friend.FacetSize)
+		if err := s.db.SelectContext(ctx, f.dest, stmt, facetArgs...); err != nil {
+			return facets, fmt.Errorf("count %s: %v", f.name, err)
+		}
+	}
+	return facets, nil
+}
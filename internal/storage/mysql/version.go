@@ -0,0 +1,118 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/victornm/gtonline/internal/profile"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+type profileVersionRow struct {
+	Email        string        `db:"email"`
+	Version      int64         `db:"version"`
+	ActorEmail   string        `db:"actor_email"`
+	RestoredFrom sql.NullInt64 `db:"restored_from"`
+	Profile      []byte        `db:"profile"`
+	CreatedAt    time.Time     `db:"created_at"`
+}
+
+func (r *profileVersionRow) toProfileVersion() (*profile.ProfileVersion, error) {
+	v := &profile.ProfileVersion{
+		Version:      r.Version,
+		Actor:        r.ActorEmail,
+		RestoredFrom: r.RestoredFrom.Int64,
+		CreatedAt:    r.CreatedAt,
+	}
+	if err := json.Unmarshal(r.Profile, &v.Profile); err != nil {
+		return nil, fmt.Errorf("decode profile of version %d: %v", r.Version, err)
+	}
+	return v, nil
+}
+
+// insertProfileVersion must run in the transaction of the update, whose lock on the regular_users row
+// serializes the version numbers of the user.
+func (s *Storage) insertProfileVersion(ctx context.Context, email string, v *profile.ProfileVersion) error {
+	data, err := json.Marshal(v.Profile)
+	if err != nil {
+		return fmt.Errorf("encode profile: %v", err)
+	}
+
+	var version int64
+	if err := s.db.GetContext(ctx, &version, `SELECT COALESCE(MAX(version), 0) + 1 FROM profile_versions WHERE email=?;`, email); err != nil {
+		return err
+	}
+
+	row := profileVersionRow{
+		Email:        email,
+		Version:      version,
+		ActorEmail:   v.Actor,
+		RestoredFrom: sql.NullInt64{Int64: v.RestoredFrom, Valid: v.RestoredFrom != 0},
+		Profile:      data,
+		CreatedAt:    v.CreatedAt,
+	}
+	if _, err := s.db.NamedExecContext(ctx, `
+INSERT INTO profile_versions (email, version, actor_email, restored_from, profile, created_at)
+VALUES (:email, :version, :actor_email, :restored_from, :profile, :created_at);`, row); err != nil {
+		if isErrForeignKeyConstraint(err) {
+			// Not a regular user
+			return fmt.Errorf("%w: %v", storage.ErrNotFound, err)
+		}
+		return err
+	}
+
+	v.Version = version
+	return nil
+}
+
+func (s *Storage) ListProfileVersions(ctx context.Context, req profile.ListProfileHistoryRequest) ([]*profile.ProfileVersion, error) {
+	condition := []string{"email=?"}
+	args := []interface{}{req.Email}
+
+	if req.Before > 0 {
+		condition = append(condition, "version<?")
+		args = append(args, req.Before)
+	}
+
+	stmt := `
+SELECT email, version, actor_email, restored_from, profile, created_at
+FROM profile_versions
+WHERE ` + strings.Join(condition, " AND ") + `
+ORDER BY version DESC
+LIMIT ?;`
+	args = append(args, req.Limit)
+
+	var rows []profileVersionRow
+	if err := s.db.SelectContext(ctx, &rows, stmt, args...); err != nil {
+		return nil, err
+	}
+
+	res := make([]*profile.ProfileVersion, 0, len(rows))
+	for i := range rows {
+		v, err := rows[i].toProfileVersion()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, v)
+	}
+	return res, nil
+}
+
+func (s *Storage) GetProfileVersion(ctx context.Context, email string, version int64) (*profile.ProfileVersion, error) {
+	var row profileVersionRow
+	err := s.db.GetContext(ctx, &row, `
+SELECT email, version, actor_email, restored_from, profile, created_at
+FROM profile_versions
+WHERE email=? AND version=?;`, email, version)
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return row.toProfileVersion()
+}
@@ -0,0 +1,384 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/victornm/gtonline/internal/storage"
+	"github.com/victornm/gtonline/internal/webhook"
+)
+
+type (
+	webhookRow struct {
+		ID        int64     `db:"id"`
+		URL       string    `db:"url"`
+		Secret    string    `db:"secret"`
+		Events    []byte    `db:"events"`
+		Active    bool      `db:"active"`
+		CreatedAt time.Time `db:"created_at"`
+	}
+
+	deliveryRow struct {
+		ID             int64        `db:"id"`
+		WebhookID      int64        `db:"webhook_id"`
+		EventID        string       `db:"event_id"`
+		Event          string       `db:"event"`
+		Payload        string       `db:"payload"`
+		Status         string       `db:"status"`
+		Attempts       int          `db:"attempts"`
+		NextAttemptAt  time.Time    `db:"next_attempt_at"`
+		ResponseStatus int          `db:"response_status"`
+		LastError      string       `db:"last_error"`
+		CreatedAt      time.Time    `db:"created_at"`
+		DeliveredAt    sql.NullTime `db:"delivered_at"`
+	}
+
+	deadLetterRow struct {
+		ID         int64     `db:"id"`
+		DeliveryID int64     `db:"delivery_id"`
+		WebhookID  int64     `db:"webhook_id"`
+		Event      string    `db:"event"`
+		Payload    string    `db:"payload"`
+		Attempts   int       `db:"attempts"`
+		LastError  string    `db:"last_error"`
+		CreatedAt  time.Time `db:"created_at"`
+	}
+)
+
+const deliveryColumns = `id, webhook_id, event_id, event, payload, status, attempts, next_attempt_at,
+       response_status, last_error, created_at, delivered_at`
+
+const deadLetterColumns = `id, delivery_id, webhook_id, event, payload, attempts, last_error, created_at`
+
+func (s *Storage) ListWebhooks(ctx context.Context) ([]*webhook.Webhook, error) {
+	var rows []webhookRow
+	if err := s.db.SelectContext(ctx, &rows, `SELECT id, url, secret, events, active, created_at FROM webhooks ORDER BY id;`); err != nil {
+		return nil, err
+	}
+
+	res := make([]*webhook.Webhook, 0, len(rows))
+	for _, r := range rows {
+		w, err := newWebhook(r)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, w)
+	}
+	return res, nil
+}
+
+func (s *Storage) GetWebhook(ctx context.Context, id int64) (*webhook.Webhook, error) {
+	var row webhookRow
+	err := s.db.GetContext(ctx, &row, `SELECT id, url, secret, events, active, created_at FROM webhooks WHERE id=?;`, id)
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newWebhook(row)
+}
+
+func (s *Storage) InsertWebhook(ctx context.Context, w *webhook.Webhook) error {
+	events, err := json.Marshal(w.Events)
+	if err != nil {
+		return fmt.Errorf("marshal events: %v", err)
+	}
+
+	r, err := s.db.ExecContext(ctx, `
+INSERT INTO webhooks (url, secret, events, active, created_at)
+VALUES (?, ?, ?, ?, ?);`, w.URL, w.Secret, events, w.Active, w.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	w.ID, err = r.LastInsertId()
+	return err
+}
+
+// UpdateWebhook keeps the secret, it can't be changed.
+func (s *Storage) UpdateWebhook(ctx context.Context, w *webhook.Webhook) error {
+	events, err := json.Marshal(w.Events)
+	if err != nil {
+		return fmt.Errorf("marshal events: %v", err)
+	}
+
+	_, err = s.db.ExecContext(ctx, `UPDATE webhooks SET url=?, events=?, active=? WHERE id=?;`, w.URL, events, w.Active, w.ID)
+	return err
+}
+
+func (s *Storage) DeleteWebhook(ctx context.Context, id int64) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM webhooks WHERE id=?;`, id)
+	return err
+}
+
+func (s *Storage) InsertDeliveries(ctx context.Context, deliveries []*webhook.Delivery) error {
+	return s.withTx(ctx, func(tx *Storage) error {
+		for _, d := range deliveries {
+			r, err := tx.db.NamedExecContext(ctx, `
+INSERT INTO webhook_deliveries (webhook_id, event_id, event, payload, status, attempts, next_attempt_at, created_at)
+VALUES (:webhook_id, :event_id, :event, :payload, :status, :attempts, :next_attempt_at, :created_at);`, newDeliveryRow(d))
+			// The event is already queued for the webhook
+			if isDuplicate(err) {
+				continue
+			}
+			if isErrForeignKeyConstraint(err) {
+				return fmt.Errorf("%w: %v", storage.ErrInvalidArgument, err)
+			}
+			if err != nil {
+				return err
+			}
+
+			if d.ID, err = r.LastInsertId(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Storage) ClaimDueDeliveries(ctx context.Context, now, leaseUntil time.Time, limit int) ([]*webhook.Delivery, error) {
+	var res []*webhook.Delivery
+
+	err := s.withTx(ctx, func(tx *Storage) error {
+		// SKIP LOCKED lets the other replicas claim the next deliveries instead of waiting
+		var rows []deliveryRow
+		err := tx.db.SelectContext(ctx, &rows, `
+SELECT d.id, d.webhook_id, d.event_id, d.event, d.payload, d.status, d.attempts, d.next_attempt_at,
+       d.response_status, d.last_error, d.created_at, d.delivered_at
+FROM webhook_deliveries d
+         JOIN webhooks w ON w.id = d.webhook_id
+WHERE d.status = ?
+  AND d.next_attempt_at <= ?
+  AND w.active
+ORDER BY d.next_attempt_at
+LIMIT ?
+FOR UPDATE OF d SKIP LOCKED;`, string(webhook.StatusPending), now, limit)
+		if err != nil {
+			return err
+		}
+
+		if len(rows) == 0 {
+			return nil
+		}
+
+		ids := make([]int64, 0, len(rows))
+		for _, r := range rows {
+			ids = append(ids, r.ID)
+		}
+
+		query, args, err := sqlx.In(`UPDATE webhook_deliveries SET next_attempt_at=? WHERE id IN (?);`, leaseUntil, ids)
+		if err != nil {
+			return fmt.Errorf("build query: %v", err)
+		}
+
+		if _, err := tx.db.ExecContext(ctx, tx.db.Rebind(query), args...); err != nil {
+			return err
+		}
+
+		for _, r := range rows {
+			r.NextAttemptAt = leaseUntil
+			res = append(res, newDelivery(r))
+		}
+		return nil
+	})
+	return res, err
+}
+
+func (s *Storage) UpdateDelivery(ctx context.Context, d *webhook.Delivery) error {
+	_, err := s.db.NamedExecContext(ctx, `
+UPDATE webhook_deliveries
+SET status=:status,
+    attempts=:attempts,
+    next_attempt_at=:next_attempt_at,
+    response_status=:response_status,
+    last_error=:last_error,
+    delivered_at=:delivered_at
+WHERE id = :id;`, newDeliveryRow(d))
+	return err
+}
+
+func (s *Storage) GetDelivery(ctx context.Context, id int64) (*webhook.Delivery, error) {
+	var row deliveryRow
+	err := s.db.GetContext(ctx, &row, `SELECT `+deliveryColumns+` FROM webhook_deliveries WHERE id=?;`, id)
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newDelivery(row), nil
+}
+
+func (s *Storage) ListDeliveries(ctx context.Context, req webhook.ListDeliveriesRequest) ([]*webhook.Delivery, error) {
+	stmt := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries WHERE webhook_id=?`
+	args := []interface{}{req.WebhookID}
+	if req.Before > 0 {
+		stmt += ` AND id<?`
+		args = append(args, req.Before)
+	}
+	stmt += ` ORDER BY id DESC LIMIT ?;`
+	args = append(args, req.Limit)
+
+	var rows []deliveryRow
+	if err := s.db.SelectContext(ctx, &rows, stmt, args...); err != nil {
+		return nil, err
+	}
+
+	res := make([]*webhook.Delivery, 0, len(rows))
+	for _, r := range rows {
+		res = append(res, newDelivery(r))
+	}
+	return res, nil
+}
+
+func (s *Storage) InsertDeadLetter(ctx context.Context, l *webhook.DeadLetter) error {
+	row := deadLetterRow{
+		DeliveryID: l.DeliveryID,
+		WebhookID:  l.WebhookID,
+		Event:      l.Event,
+		Payload:    string(l.Payload),
+		Attempts:   l.Attempts,
+		LastError:  truncate(l.LastError, 1000),
+		CreatedAt:  l.CreatedAt,
+	}
+
+	r, err := s.db.NamedExecContext(ctx, `
+INSERT INTO webhook_dead_letters (delivery_id, webhook_id, event, payload, attempts, last_error, created_at)
+VALUES (:delivery_id, :webhook_id, :event, :payload, :attempts, :last_error, :created_at);`, row)
+	if isDuplicate(err) {
+		return fmt.Errorf("%w: %v", storage.ErrAlreadyExist, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	l.ID, err = r.LastInsertId()
+	return err
+}
+
+func (s *Storage) GetDeadLetter(ctx context.Context, id int64) (*webhook.DeadLetter, error) {
+	var row deadLetterRow
+	err := s.db.GetContext(ctx, &row, `SELECT `+deadLetterColumns+` FROM webhook_dead_letters WHERE id=?;`, id)
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newDeadLetter(row), nil
+}
+
+func (s *Storage) ListDeadLetters(ctx context.Context, req webhook.ListDeadLettersRequest) ([]*webhook.DeadLetter, error) {
+	stmt := `SELECT ` + deadLetterColumns + ` FROM webhook_dead_letters`
+	var args []interface{}
+	if req.Before > 0 {
+		stmt += ` WHERE id<?`
+		args = append(args, req.Before)
+	}
+	stmt += ` ORDER BY id DESC LIMIT ?;`
+	args = append(args, req.Limit)
+
+	var rows []deadLetterRow
+	if err := s.db.SelectContext(ctx, &rows, stmt, args...); err != nil {
+		return nil, err
+	}
+
+	res := make([]*webhook.DeadLetter, 0, len(rows))
+	for _, r := range rows {
+		res = append(res, newDeadLetter(r))
+	}
+	return res, nil
+}
+
+func (s *Storage) DeleteDeadLetter(ctx context.Context, id int64) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM webhook_dead_letters WHERE id=?;`, id)
+	return err
+}
+
+func newWebhook(row webhookRow) (*webhook.Webhook, error) {
+	w := &webhook.Webhook{
+		ID:        row.ID,
+		URL:       row.URL,
+		Secret:    row.Secret,
+		Active:    row.Active,
+		CreatedAt: row.CreatedAt,
+	}
+	if err := json.Unmarshal(row.Events, &w.Events); err != nil {
+		return nil, fmt.Errorf("unmarshal events of webhook %d: %v", row.ID, err)
+	}
+	return w, nil
+}
+
+func newDeliveryRow(d *webhook.Delivery) deliveryRow {
+	row := deliveryRow{
+		ID:             d.ID,
+		WebhookID:      d.WebhookID,
+		EventID:        d.EventID,
+		Event:          d.Event,
+		Payload:        string(d.Payload),
+		Status:         string(d.Status),
+		Attempts:       d.Attempts,
+		NextAttemptAt:  d.NextAttemptAt,
+		ResponseStatus: d.ResponseStatus,
+		LastError:      truncate(d.LastError, 1000),
+		CreatedAt:      d.CreatedAt,
+	}
+	if d.DeliveredAt != nil {
+		row.DeliveredAt = sql.NullTime{Time: *d.DeliveredAt, Valid: true}
+	}
+	return row
+}
+
+func newDelivery(row deliveryRow) *webhook.Delivery {
+	d := &webhook.Delivery{
+		ID:             row.ID,
+		WebhookID:      row.WebhookID,
+		EventID:        row.EventID,
+		Event:          row.Event,
+		Payload:        []byte(row.Payload),
+		Status:         webhook.Status(row.Status),
+		Attempts:       row.Attempts,
+		NextAttemptAt:  row.NextAttemptAt,
+		ResponseStatus: row.ResponseStatus,
+		LastError:      row.LastError,
+		CreatedAt:      row.CreatedAt,
+	}
+	if row.DeliveredAt.Valid {
+		d.DeliveredAt = &row.DeliveredAt.Time
+	}
+	return d
+}
+
+func newDeadLetter(row deadLetterRow) *webhook.DeadLetter {
+	return &webhook.DeadLetter{
+		ID:         row.ID,
+		DeliveryID: row.DeliveryID,
+		WebhookID:  row.WebhookID,
+		Event:      row.Event,
+		Payload:    []byte(row.Payload),
+		Attempts:   row.Attempts,
+		LastError:  row.LastError,
+		CreatedAt:  row.CreatedAt,
+	}
+}
+
+// truncate cuts s to at most n runes, so it fits the column.
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n])
+}
@@ -0,0 +1,241 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/victornm/gtonline/internal/event"
+	"github.com/victornm/gtonline/internal/retry"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+type (
+	// Doer sends the deliveries, *http.Client implements it.
+	Doer interface {
+		Do(req *http.Request) (*http.Response, error)
+	}
+
+	Status string
+
+	Delivery struct {
+		ID        int64  `json:"id"`
+		WebhookID int64  `json:"webhook_id"`
+		EventID   string `json:"event_id"`
+		Event     string `json:"event"`
+		// Payload is the exact body sent to the webhook.
+		Payload       json.RawMessage `json:"payload"`
+		Status        Status          `json:"status"`
+		Attempts      int             `json:"attempts"`
+		NextAttemptAt time.Time       `json:"next_attempt_at"`
+		// ResponseStatus is the HTTP status of the last attempt, 0 if the request failed.
+		ResponseStatus int        `json:"response_status,omitempty"`
+		LastError      string     `json:"last_error,omitempty"`
+		CreatedAt      time.Time  `json:"created_at"`
+		DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+	}
+
+	// DeadLetter is a delivery which failed all its attempts.
+	DeadLetter struct {
+		ID         int64           `json:"id"`
+		DeliveryID int64           `json:"delivery_id"`
+		WebhookID  int64           `json:"webhook_id"`
+		Event      string          `json:"event"`
+		Payload    json.RawMessage `json:"payload"`
+		Attempts   int             `json:"attempts"`
+		LastError  string          `json:"last_error"`
+		CreatedAt  time.Time       `json:"created_at"`
+	}
+
+	// payload is the body of a delivery.
+	payload struct {
+		ID        string          `json:"id"`
+		Event     string          `json:"event"`
+		CreatedAt time.Time       `json:"created_at"`
+		Data      json.RawMessage `json:"data"`
+	}
+)
+
+const (
+	StatusPending   Status = "pending"
+	StatusSucceeded Status = "succeeded"
+	StatusDead      Status = "dead"
+)
+
+// Headers of a delivery. The signature is the hex encoded HMAC-SHA256 of the body with the webhook secret,
+// prefixed by "sha256=". Receivers should compare it in constant time and dedupe the events by the event ID.
+const (
+	HeaderEvent     = "X-GT-Event"
+	HeaderEventID   = "X-GT-Event-ID"
+	HeaderDelivery  = "X-GT-Delivery"
+	HeaderSignature = "X-GT-Signature"
+)
+
+func newHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout}
+}
+
+// HandleEvent queues a delivery of the event to every active webhook subscribed to it.
+// The ID of the event is the event ID of the deliveries, so handling the same event again queues nothing.
+func (s *Service) HandleEvent(ctx context.Context, r *event.Record) error {
+	webhooks, err := s.storage.ListWebhooks(ctx)
+	if err != nil {
+		return fmt.Errorf("list webhooks: %v", err)
+	}
+
+	var subscribed []*Webhook
+	for _, w := range webhooks {
+		if w.Active && w.subscribes(r.Name) {
+			subscribed = append(subscribed, w)
+		}
+	}
+	if len(subscribed) == 0 {
+		return nil
+	}
+
+	id := strconv.FormatInt(r.ID, 10)
+	body, err := json.Marshal(payload{ID: id, Event: r.Name, CreatedAt: r.CreatedAt, Data: r.Payload})
+	if err != nil {
+		return fmt.Errorf("marshal %s: %v", r.Name, err)
+	}
+
+	now := time.Now()
+	deliveries := make([]*Delivery, 0, len(subscribed))
+	for _, w := range subscribed {
+		deliveries = append(deliveries, &Delivery{
+			WebhookID:     w.ID,
+			EventID:       id,
+			Event:         r.Name,
+			Payload:       body,
+			Status:        StatusPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+	}
+
+	if err := s.storage.InsertDeliveries(ctx, deliveries); err != nil {
+		return fmt.Errorf("insert deliveries: %v", err)
+	}
+	return nil
+}
+
+// DeliverDue sends the due deliveries, it is meant to be run periodically in the background.
+// It keeps going until there is no more due delivery or ctx is done.
+func (s *Service) DeliverDue(ctx context.Context) error {
+	for ctx.Err() == nil {
+		now := time.Now()
+		// The lease must be longer than an attempt, so the delivery is not claimed again while being sent
+		deliveries, err := s.storage.ClaimDueDeliveries(ctx, now, now.Add(2*s.cfg.Timeout), s.cfg.BatchSize)
+		if err != nil {
+			return fmt.Errorf("claim deliveries: %v", err)
+		}
+
+		if len(deliveries) == 0 {
+			return nil
+		}
+
+		for _, d := range deliveries {
+			if err := s.deliver(ctx, d); err != nil {
+				return err
+			}
+		}
+
+		if len(deliveries) < s.cfg.BatchSize {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *Service) deliver(ctx context.Context, d *Delivery) error {
+	w, err := s.storage.GetWebhook(ctx, d.WebhookID)
+	// The deliveries are deleted with the webhook
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("get webhook %d: %v", d.WebhookID, err)
+	}
+
+	d.Attempts++
+	status, err := s.send(ctx, w, d)
+	d.ResponseStatus = status
+
+	if err == nil {
+		now := time.Now()
+		d.Status = StatusSucceeded
+		d.LastError = ""
+		d.DeliveredAt = &now
+		return s.storage.UpdateDelivery(ctx, d)
+	}
+
+	d.LastError = err.Error()
+	if d.Attempts < s.cfg.MaxAttempts {
+		d.NextAttemptAt = time.Now().Add(retry.Backoff(s.cfg.InitialBackoff, s.cfg.MaxBackoff, d.Attempts))
+		return s.storage.UpdateDelivery(ctx, d)
+	}
+
+	log.Printf("webhook delivery %d to %s is dead after %d attempts: %v", d.ID, w.URL, d.Attempts, err)
+	d.Status = StatusDead
+	if err := s.storage.UpdateDelivery(ctx, d); err != nil {
+		return err
+	}
+
+	return s.storage.InsertDeadLetter(ctx, &DeadLetter{
+		DeliveryID: d.ID,
+		WebhookID:  d.WebhookID,
+		Event:      d.Event,
+		Payload:    d.Payload,
+		Attempts:   d.Attempts,
+		LastError:  d.LastError,
+		CreatedAt:  time.Now(),
+	})
+}
+
+// send returns the response status, and an error if the delivery failed.
+func (s *Service) send(ctx context.Context, w *Webhook, d *Delivery) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("new request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, d.Event)
+	req.Header.Set(HeaderEventID, d.EventID)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(d.ID, 10))
+	req.Header.Set(HeaderSignature, Sign(w.Secret, d.Payload))
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	// Drain the body so the connection can be reused
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return res.StatusCode, fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+	return res.StatusCode, nil
+}
+
+// Sign returns the signature header of body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
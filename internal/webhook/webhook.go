@@ -0,0 +1,360 @@
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/storage"
+)
+
+type (
+	Service struct {
+		storage Storage
+		client  Doer
+		cfg     Config
+	}
+
+	Storage interface {
+		ListWebhooks(ctx context.Context) ([]*Webhook, error)
+		GetWebhook(ctx context.Context, id int64) (*Webhook, error)
+		// InsertWebhook inserts w and sets its ID.
+		InsertWebhook(ctx context.Context, w *Webhook) error
+		UpdateWebhook(ctx context.Context, w *Webhook) error
+		// DeleteWebhook deletes the webhook with its deliveries.
+		DeleteWebhook(ctx context.Context, id int64) error
+
+		// InsertDeliveries inserts the deliveries and sets their ID.
+		// A delivery with the same webhook and event ID as an existing one is skipped.
+		InsertDeliveries(ctx context.Context, deliveries []*Delivery) error
+		// ClaimDueDeliveries returns at most limit pending deliveries of active webhooks with NextAttemptAt before now,
+		// and moves their NextAttemptAt to leaseUntil, so other replicas don't deliver them at the same time.
+		// Deliveries of inactive webhooks stay pending until the webhook is active again.
+		ClaimDueDeliveries(ctx context.Context, now, leaseUntil time.Time, limit int) ([]*Delivery, error)
+		UpdateDelivery(ctx context.Context, d *Delivery) error
+		GetDelivery(ctx context.Context, id int64) (*Delivery, error)
+		// ListDeliveries returns the deliveries of req.WebhookID, the newest first.
+		ListDeliveries(ctx context.Context, req ListDeliveriesRequest) ([]*Delivery, error)
+
+		// InsertDeadLetter inserts l and sets its ID.
+		InsertDeadLetter(ctx context.Context, l *DeadLetter) error
+		GetDeadLetter(ctx context.Context, id int64) (*DeadLetter, error)
+		// ListDeadLetters returns the dead letters, the newest first.
+		ListDeadLetters(ctx context.Context, req ListDeadLettersRequest) ([]*DeadLetter, error)
+		DeleteDeadLetter(ctx context.Context, id int64) error
+	}
+
+	Config struct {
+		// MaxAttempts is the number of attempts before a delivery goes to the dead letters.
+		MaxAttempts int `mapstructure:"max_attempts"`
+		// InitialBackoff is the delay before the first retry, it doubles after every failed attempt up to MaxBackoff.
+		InitialBackoff time.Duration `mapstructure:"initial_backoff"`
+		MaxBackoff     time.Duration `mapstructure:"max_backoff"`
+		// Timeout limits a single attempt.
+		Timeout time.Duration `mapstructure:"timeout"`
+		// PollInterval is how often the due deliveries are sent.
+		PollInterval time.Duration `mapstructure:"poll_interval"`
+		BatchSize    int           `mapstructure:"batch_size"`
+	}
+)
+
+func NewService(s Storage, client Doer, cfg Config) *Service {
+	cfg = cfg.withDefaults()
+	if client == nil {
+		client = newHTTPClient(cfg.Timeout)
+	}
+	return &Service{storage: s, client: client, cfg: cfg}
+}
+
+func DefaultConfig() Config {
+	return Config{
+		MaxAttempts:    8,
+		InitialBackoff: 10 * time.Second,
+		MaxBackoff:     time.Hour,
+		Timeout:        10 * time.Second,
+		PollInterval:   5 * time.Second,
+		BatchSize:      50,
+	}
+}
+
+func (c Config) withDefaults() Config {
+	d := DefaultConfig()
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = d.MaxAttempts
+	}
+	if c.InitialBackoff <= 0 {
+		c.InitialBackoff = d.InitialBackoff
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = d.MaxBackoff
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = d.Timeout
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = d.BatchSize
+	}
+	return c
+}
+
+// AllEvents subscribes a webhook to every event.
+const AllEvents = "*"
+
+const (
+	defaultLimit = 20
+	maxLimit     = 100
+)
+
+type (
+	Webhook struct {
+		ID  int64  `json:"id"`
+		URL string `json:"url"`
+		// Secret signs the deliveries, it is only returned when the webhook is created.
+		Secret    string    `json:"secret,omitempty"`
+		Events    []string  `json:"events"`
+		Active    bool      `json:"active"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+
+	ListWebhooksResponse struct {
+		Webhooks []*Webhook `json:"webhooks"`
+	}
+
+	CreateWebhookRequest struct {
+		URL    string   `json:"url" binding:"required,url,max=2000"`
+		Events []string `json:"events" binding:"required,min=1,dive,required,max=100"`
+	}
+
+	UpdateWebhookRequest struct {
+		ID     int64    `json:"-"`
+		URL    string   `json:"url" binding:"required,url,max=2000"`
+		Events []string `json:"events" binding:"required,min=1,dive,required,max=100"`
+		Active bool     `json:"active"`
+	}
+
+	ListDeliveriesRequest struct {
+		WebhookID int64 `form:"-"`
+		// Before is the cursor for pagination, only deliveries with smaller ID are returned.
+		Before int64 `form:"before" binding:"gte=0"`
+		Limit  int   `form:"limit" binding:"gte=0,lte=100"`
+	}
+
+	ListDeliveriesResponse struct {
+		Deliveries []*Delivery `json:"deliveries"`
+		NextBefore int64       `json:"next_before,omitempty"`
+	}
+
+	ListDeadLettersRequest struct {
+		Before int64 `form:"before" binding:"gte=0"`
+		Limit  int   `form:"limit" binding:"gte=0,lte=100"`
+	}
+
+	ListDeadLettersResponse struct {
+		DeadLetters []*DeadLetter `json:"dead_letters"`
+		NextBefore  int64         `json:"next_before,omitempty"`
+	}
+)
+
+func (s *Service) ListWebhooks(ctx context.Context) (*ListWebhooksResponse, error) {
+	webhooks, err := s.storage.ListWebhooks(ctx)
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+
+	for _, w := range webhooks {
+		w.Secret = ""
+	}
+	return &ListWebhooksResponse{Webhooks: webhooks}, nil
+}
+
+// CreateWebhook creates an active webhook with a random secret.
+func (s *Service) CreateWebhook(ctx context.Context, req CreateWebhookRequest) (*Webhook, error) {
+	if err := validateURL(req.URL); err != nil {
+		return nil, err
+	}
+
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", fmt.Errorf("generate secret: %v", err))
+	}
+
+	w := &Webhook{
+		URL:       req.URL,
+		Secret:    secret,
+		Events:    normalizeEvents(req.Events),
+		Active:    true,
+		CreatedAt: time.Now(),
+	}
+	if err := s.storage.InsertWebhook(ctx, w); err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+
+	return w, nil
+}
+
+func (s *Service) UpdateWebhook(ctx context.Context, req UpdateWebhookRequest) (*Webhook, error) {
+	if err := validateURL(req.URL); err != nil {
+		return nil, err
+	}
+
+	w, err := s.getWebhook(ctx, req.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	w.URL = req.URL
+	w.Events = normalizeEvents(req.Events)
+	w.Active = req.Active
+	if err := s.storage.UpdateWebhook(ctx, w); err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+
+	w.Secret = ""
+	return w, nil
+}
+
+func (s *Service) DeleteWebhook(ctx context.Context, id int64) error {
+	if _, err := s.getWebhook(ctx, id); err != nil {
+		return err
+	}
+
+	if err := s.storage.DeleteWebhook(ctx, id); err != nil {
+		return gterr.New(gterr.Internal, "", err)
+	}
+	return nil
+}
+
+func (s *Service) ListDeliveries(ctx context.Context, req ListDeliveriesRequest) (*ListDeliveriesResponse, error) {
+	if _, err := s.getWebhook(ctx, req.WebhookID); err != nil {
+		return nil, err
+	}
+
+	limit := normalizeLimit(req.Limit)
+	// Query 1 more to know if there is a next page
+	req.Limit = limit + 1
+	deliveries, err := s.storage.ListDeliveries(ctx, req)
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+
+	res := &ListDeliveriesResponse{Deliveries: deliveries}
+	if len(deliveries) > limit {
+		res.Deliveries = deliveries[:limit]
+		res.NextBefore = deliveries[limit-1].ID
+	}
+	return res, nil
+}
+
+func (s *Service) ListDeadLetters(ctx context.Context, req ListDeadLettersRequest) (*ListDeadLettersResponse, error) {
+	limit := normalizeLimit(req.Limit)
+	req.Limit = limit + 1
+	letters, err := s.storage.ListDeadLetters(ctx, req)
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+
+	res := &ListDeadLettersResponse{DeadLetters: letters}
+	if len(letters) > limit {
+		res.DeadLetters = letters[:limit]
+		res.NextBefore = letters[limit-1].ID
+	}
+	return res, nil
+}
+
+// RetryDeadLetter puts the delivery of the dead letter back to the queue, with a fresh attempt budget.
+func (s *Service) RetryDeadLetter(ctx context.Context, id int64) error {
+	l, err := s.storage.GetDeadLetter(ctx, id)
+	if errors.Is(err, storage.ErrNotFound) {
+		return gterr.New(gterr.NotFound, fmt.Sprintf("dead letter %d not found", id), err)
+	}
+	if err != nil {
+		return gterr.New(gterr.Internal, "", err)
+	}
+
+	d, err := s.storage.GetDelivery(ctx, l.DeliveryID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return gterr.New(gterr.FailedPrecondition, fmt.Sprintf("the webhook of dead letter %d was deleted", id), err)
+	}
+	if err != nil {
+		return gterr.New(gterr.Internal, "", err)
+	}
+
+	d.Status = StatusPending
+	d.Attempts = 0
+	d.NextAttemptAt = time.Now()
+	if err := s.storage.UpdateDelivery(ctx, d); err != nil {
+		return gterr.New(gterr.Internal, "", err)
+	}
+
+	if err := s.storage.DeleteDeadLetter(ctx, id); err != nil {
+		return gterr.New(gterr.Internal, "", err)
+	}
+	return nil
+}
+
+func (s *Service) getWebhook(ctx context.Context, id int64) (*Webhook, error) {
+	w, err := s.storage.GetWebhook(ctx, id)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, gterr.New(gterr.NotFound, fmt.Sprintf("webhook %d not found", id), err)
+	}
+
+	if err != nil {
+		return nil, gterr.New(gterr.Internal, "", err)
+	}
+	return w, nil
+}
+
+func (w *Webhook) subscribes(event string) bool {
+	for _, e := range w.Events {
+		if e == AllEvents || e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func validateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return gterr.New(gterr.InvalidArgument, fmt.Sprintf("invalid webhook url: %s", raw))
+	}
+	return nil
+}
+
+func normalizeEvents(events []string) []string {
+	seen := make(map[string]bool, len(events))
+	res := make([]string, 0, len(events))
+	for _, e := range events {
+		e = strings.TrimSpace(e)
+		if e == "" || seen[e] {
+			continue
+		}
+		seen[e] = true
+		res = append(res, e)
+	}
+	return res
+}
+
+func normalizeLimit(limit int) int {
+	if limit <= 0 {
+		return defaultLimit
+	}
+	if limit > maxLimit {
+		return maxLimit
+	}
+	return limit
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
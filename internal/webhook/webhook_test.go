@@ -0,0 +1,176 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/victornm/gtonline/internal/event"
+	"github.com/victornm/gtonline/internal/gterr"
+	"github.com/victornm/gtonline/internal/storage/memory"
+	"github.com/victornm/gtonline/internal/webhook"
+)
+
+// receiver records the requests, the first `failures` requests are answered with 500.
+type receiver struct {
+	mu       sync.Mutex
+	failures int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+	if r.failures > 0 {
+		r.failures--
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *receiver) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.requests)
+}
+
+func makeService(_ *testing.T) *webhook.Service {
+	cfg := webhook.DefaultConfig()
+	cfg.MaxAttempts = 3
+	cfg.InitialBackoff = time.Millisecond
+	cfg.MaxBackoff = time.Millisecond
+	return webhook.NewService(memory.NewStorage(), nil, cfg)
+}
+
+func record(t *testing.T, id int64, name string, data interface{}) *event.Record {
+	payload, err := json.Marshal(data)
+	require.NoError(t, err)
+	return &event.Record{ID: id, Name: name, Payload: payload, CreatedAt: time.Now()}
+}
+
+func TestService_Deliver(t *testing.T) {
+	s := makeService(t)
+	r := &receiver{}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	w, err := s.CreateWebhook(context.TODO(), webhook.CreateWebhookRequest{URL: srv.URL, Events: []string{"user.registered"}})
+	require.NoError(t, err)
+	require.NotEmpty(t, w.Secret)
+
+	registered := record(t, 1, "user.registered", map[string]string{"email": "tony@stark.com"})
+	require.NoError(t, s.HandleEvent(context.TODO(), registered))
+	require.NoError(t, s.HandleEvent(context.TODO(), record(t, 2, "profile.updated", map[string]string{"email": "tony@stark.com"})))
+	require.NoError(t, s.HandleEvent(context.TODO(), registered), "handling an event again queues nothing")
+	require.NoError(t, s.DeliverDue(context.TODO()))
+
+	require.Equal(t, 1, r.count(), "only the subscribed events are delivered")
+	req, body := r.requests[0], r.bodies[0]
+	assert.Equal(t, "user.registered", req.Header.Get(webhook.HeaderEvent))
+	assert.Equal(t, "1", req.Header.Get(webhook.HeaderEventID))
+	assert.Equal(t, webhook.Sign(w.Secret, body), req.Header.Get(webhook.HeaderSignature))
+
+	var payload struct {
+		Event string            `json:"event"`
+		Data  map[string]string `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(body, &payload))
+	assert.Equal(t, "user.registered", payload.Event)
+	assert.Equal(t, "tony@stark.com", payload.Data["email"])
+
+	res, err := s.ListDeliveries(context.TODO(), webhook.ListDeliveriesRequest{WebhookID: w.ID})
+	require.NoError(t, err)
+	require.Len(t, res.Deliveries, 1)
+	assert.Equal(t, webhook.StatusSucceeded, res.Deliveries[0].Status)
+	assert.Equal(t, http.StatusNoContent, res.Deliveries[0].ResponseStatus)
+}
+
+func TestService_Retry(t *testing.T) {
+	t.Run("retry until success", func(t *testing.T) {
+		s := makeService(t)
+		r := &receiver{failures: 2}
+		srv := httptest.NewServer(r)
+		defer srv.Close()
+
+		w, err := s.CreateWebhook(context.TODO(), webhook.CreateWebhookRequest{URL: srv.URL, Events: []string{webhook.AllEvents}})
+		require.NoError(t, err)
+		require.NoError(t, s.HandleEvent(context.TODO(), record(t, 1, "user.registered", nil)))
+
+		deliverAll(t, s)
+
+		assert.Equal(t, 3, r.count())
+		res, err := s.ListDeliveries(context.TODO(), webhook.ListDeliveriesRequest{WebhookID: w.ID})
+		require.NoError(t, err)
+		assert.Equal(t, webhook.StatusSucceeded, res.Deliveries[0].Status)
+		assert.Equal(t, 3, res.Deliveries[0].Attempts)
+	})
+
+	t.Run("dead letter after max attempts", func(t *testing.T) {
+		s := makeService(t)
+		r := &receiver{failures: 100}
+		srv := httptest.NewServer(r)
+		defer srv.Close()
+
+		_, err := s.CreateWebhook(context.TODO(), webhook.CreateWebhookRequest{URL: srv.URL, Events: []string{webhook.AllEvents}})
+		require.NoError(t, err)
+		require.NoError(t, s.HandleEvent(context.TODO(), record(t, 1, "user.registered", nil)))
+
+		deliverAll(t, s)
+		assert.Equal(t, 3, r.count())
+
+		letters, err := s.ListDeadLetters(context.TODO(), webhook.ListDeadLettersRequest{})
+		require.NoError(t, err)
+		require.Len(t, letters.DeadLetters, 1)
+		assert.Equal(t, "user.registered", letters.DeadLetters[0].Event)
+
+		r.mu.Lock()
+		r.failures = 0
+		r.mu.Unlock()
+
+		require.NoError(t, s.RetryDeadLetter(context.TODO(), letters.DeadLetters[0].ID))
+		deliverAll(t, s)
+		assert.Equal(t, 4, r.count())
+
+		letters, err = s.ListDeadLetters(context.TODO(), webhook.ListDeadLettersRequest{})
+		require.NoError(t, err)
+		assert.Empty(t, letters.DeadLetters)
+	})
+}
+
+func TestService_CreateWebhook(t *testing.T) {
+	s := makeService(t)
+
+	_, err := s.CreateWebhook(context.TODO(), webhook.CreateWebhookRequest{URL: "ftp://example.com", Events: []string{"*"}})
+	assert.Equal(t, gterr.InvalidArgument, gterr.Code(err))
+
+	_, err = s.CreateWebhook(context.TODO(), webhook.CreateWebhookRequest{URL: "https://example.com/hook", Events: []string{"*"}})
+	require.NoError(t, err)
+
+	res, err := s.ListWebhooks(context.TODO())
+	require.NoError(t, err)
+	require.Len(t, res.Webhooks, 1)
+	assert.Empty(t, res.Webhooks[0].Secret, "the secret is only returned on creation")
+}
+
+// deliverAll delivers until there is no more pending delivery, the backoff of the test config is tiny.
+func deliverAll(t *testing.T, s *webhook.Service) {
+	for i := 0; i < 10; i++ {
+		require.NoError(t, s.DeliverDue(context.TODO()))
+		time.Sleep(5 * time.Millisecond)
+	}
+}